@@ -0,0 +1,141 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// alertTemplateNameRegex keeps template names usable in the names of the alerts.
+	alertTemplateNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+	alertOperators         = []string{"GreaterThan", "GreaterThanOrEqual", "LessThan", "LessThanOrEqual", "Equals"}
+	alertAggregations      = []string{"Average", "Minimum", "Maximum", "Total", "Count"}
+	// alertWindows and alertFrequencies are the durations, in minutes, accepted by Azure
+	// Monitor.
+	alertWindows     = []uint{1, 5, 15, 30, 60, 360, 720, 1440}
+	alertFrequencies = []uint{1, 5, 15, 30, 60}
+)
+
+// AlertTemplate is a static threshold metric alert, scoped to a runner VM.
+type AlertTemplate struct {
+	// Description is shown in the alert, and in the notifications.
+	Description string `toml:"description"`
+	// MetricNamespace defaults to the VM platform metrics. Guest metrics, like the disk
+	// usage, need the Azure Monitor agent.
+	MetricNamespace string `toml:"metric_namespace"`
+	// Metric is the name of the metric, like "Percentage CPU".
+	Metric string `toml:"metric"`
+	// Aggregation is how the metric is aggregated over the window. Defaults to Average.
+	Aggregation string `toml:"aggregation"`
+	// Operator compares the aggregated metric to the threshold. Defaults to GreaterThan.
+	Operator  string  `toml:"operator"`
+	Threshold float64 `toml:"threshold"`
+	// WindowMinutes is the window the metric is aggregated over. Defaults to 15.
+	WindowMinutes uint `toml:"window_minutes"`
+	// FrequencyMinutes is how often the alert is evaluated. Defaults to 5.
+	FrequencyMinutes uint `toml:"frequency_minutes"`
+	// Severity goes from 0 (critical) to 4 (verbose). Defaults to 3.
+	Severity *uint `toml:"severity"`
+	// ActionGroupIDs are the resource IDs of the action groups notified when the alert fires.
+	ActionGroupIDs []string `toml:"action_group_ids"`
+}
+
+func (a AlertTemplate) Validate() error {
+	if a.Metric == "" {
+		return fmt.Errorf("missing metric")
+	}
+	if a.Aggregation != "" && !containsString(alertAggregations, a.Aggregation) {
+		return fmt.Errorf("invalid aggregation %q (expected one of %s)", a.Aggregation, strings.Join(alertAggregations, ", "))
+	}
+	if a.Operator != "" && !containsString(alertOperators, a.Operator) {
+		return fmt.Errorf("invalid operator %q (expected one of %s)", a.Operator, strings.Join(alertOperators, ", "))
+	}
+	if a.WindowMinutes != 0 && !containsUint(alertWindows, a.WindowMinutes) {
+		return fmt.Errorf("invalid window_minutes %d (expected one of %v)", a.WindowMinutes, alertWindows)
+	}
+	if a.FrequencyMinutes != 0 && !containsUint(alertFrequencies, a.FrequencyMinutes) {
+		return fmt.Errorf("invalid frequency_minutes %d (expected one of %v)", a.FrequencyMinutes, alertFrequencies)
+	}
+	if a.GetFrequencyMinutes() > a.GetWindowMinutes() {
+		return fmt.Errorf("frequency_minutes can't be longer than window_minutes")
+	}
+	if a.Severity != nil && *a.Severity > 4 {
+		return fmt.Errorf("invalid severity %d (expected 0 to 4)", *a.Severity)
+	}
+	return nil
+}
+
+func (a AlertTemplate) GetMetricNamespace() string {
+	if a.MetricNamespace == "" {
+		return "Microsoft.Compute/virtualMachines"
+	}
+	return a.MetricNamespace
+}
+
+func (a AlertTemplate) GetAggregation() string {
+	if a.Aggregation == "" {
+		return "Average"
+	}
+	return a.Aggregation
+}
+
+func (a AlertTemplate) GetOperator() string {
+	if a.Operator == "" {
+		return "GreaterThan"
+	}
+	return a.Operator
+}
+
+func (a AlertTemplate) GetWindowMinutes() uint {
+	if a.WindowMinutes == 0 {
+		return 15
+	}
+	return a.WindowMinutes
+}
+
+func (a AlertTemplate) GetFrequencyMinutes() uint {
+	if a.FrequencyMinutes == 0 {
+		return 5
+	}
+	return a.FrequencyMinutes
+}
+
+func (a AlertTemplate) GetSeverity() uint {
+	if a.Severity == nil {
+		return 3
+	}
+	return *a.Severity
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsUint(values []uint, value uint) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+// defaultChaosCapabilities are the fault capabilities enabled on runner VMs by default.
+var defaultChaosCapabilities = []string{"Shutdown-1.0"}
+
+type ChaosStudio struct {
+	// Capabilities are the service-direct virtual machine faults enabled on enrolled
+	// runners, like Shutdown-1.0 or Redeploy-1.0. Defaults to Shutdown-1.0.
+	Capabilities []string `toml:"capabilities"`
+}
+
+// GetCapabilities returns the fault capabilities enabled on enrolled runners.
+func (c ChaosStudio) GetCapabilities() []string {
+	if len(c.Capabilities) == 0 {
+		return defaultChaosCapabilities
+	}
+	return c.Capabilities
+}
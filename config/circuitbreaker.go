@@ -0,0 +1,88 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCircuitBreakerCooldownMinutes is the default time creates are refused for, once
+// the circuit breaker opened.
+const defaultCircuitBreakerCooldownMinutes = 10
+
+// defaultCircuitBreakerStepRetries is the default number of retries of each create step.
+const defaultCircuitBreakerStepRetries = 2
+
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures of the same kind (auth or outage)
+	// after which creates are refused. A value of 0 disables the circuit breaker.
+	Threshold int `toml:"threshold"`
+	// CooldownMinutes is how long creates are refused for, counted from the last failure.
+	// The first create after that is attempted, and opens the circuit breaker again if it
+	// fails the same way. Defaults to 10.
+	CooldownMinutes int `toml:"cooldown_minutes"`
+	// StateFile records the consecutive failures. It must be shared by all provider
+	// processes. Defaults to a file in the system temp dir.
+	StateFile string `toml:"state_file"`
+	// StepRetries is the number of times each step of a create (like creating the NIC or
+	// the VM) is retried after a throttled request or an outage, before the create fails
+	// and counts as a failure. Every step has its own budget. Defaults to 2, and -1
+	// disables the retries.
+	StepRetries int `toml:"step_retries"`
+}
+
+func (b CircuitBreaker) Validate() error {
+	if b.Threshold < 0 {
+		return fmt.Errorf("invalid threshold: %d", b.Threshold)
+	}
+	if b.CooldownMinutes < 0 {
+		return fmt.Errorf("invalid cooldown_minutes: %d", b.CooldownMinutes)
+	}
+	if b.StepRetries < -1 {
+		return fmt.Errorf("invalid step_retries: %d", b.StepRetries)
+	}
+	return nil
+}
+
+// GetStepRetries returns how often each step of a create is retried after a transient
+// failure.
+func (b CircuitBreaker) GetStepRetries() int {
+	switch b.StepRetries {
+	case 0:
+		return defaultCircuitBreakerStepRetries
+	case -1:
+		return 0
+	}
+	return b.StepRetries
+}
+
+// GetCooldown returns how long creates are refused for, once the circuit breaker opened.
+func (b CircuitBreaker) GetCooldown() time.Duration {
+	if b.CooldownMinutes == 0 {
+		return defaultCircuitBreakerCooldownMinutes * time.Minute
+	}
+	return time.Duration(b.CooldownMinutes) * time.Minute
+}
+
+// GetStateFile returns the file holding the consecutive failures.
+func (b CircuitBreaker) GetStateFile() string {
+	if b.StateFile != "" {
+		return b.StateFile
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-circuit-breaker.json")
+}
@@ -15,14 +15,75 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/BurntSushi/toml"
 )
 
+type CreationMode string
+
+const (
+	CreationModeSDK        CreationMode = "sdk"
+	CreationModeDeployment CreationMode = "deployment"
+)
+
+// Region is a region runners may be created in.
+type Region struct {
+	Name string `toml:"name"`
+	// Weight is the share of the runners of a pool created in this region, relative to
+	// the other regions. Defaults to 1.
+	Weight int `toml:"weight"`
+}
+
+// GetWeight returns the weight of the region.
+func (r Region) GetWeight() int {
+	if r.Weight == 0 {
+		return 1
+	}
+	return r.Weight
+}
+
+// BurstablePolicy controls what happens when a pool uses a burstable (B-series) VM size.
+type BurstablePolicy string
+
+const (
+	BurstablePolicyAllow  BurstablePolicy = "allow"
+	BurstablePolicyWarn   BurstablePolicy = "warn"
+	BurstablePolicyRefuse BurstablePolicy = "refuse"
+)
+
+// TrustedLaunchPolicy controls whether runners are created with trusted launch.
+type TrustedLaunchPolicy string
+
+const (
+	// TrustedLaunchPolicyOptIn only uses trusted launch for pools that request it.
+	TrustedLaunchPolicyOptIn TrustedLaunchPolicy = "opt-in"
+	// TrustedLaunchPolicyDefault uses trusted launch unless a pool disables it.
+	TrustedLaunchPolicyDefault TrustedLaunchPolicy = "default"
+	// TrustedLaunchPolicyRequired uses trusted launch for all pools, and refuses pools that
+	// disable it.
+	TrustedLaunchPolicyRequired TrustedLaunchPolicy = "required"
+)
+
+// ZonePlacement controls how runners are spread across availability zones.
+type ZonePlacement string
+
+const (
+	// ZonePlacementLeastUsed places runners in the zone with the fewest runners of their pool.
+	ZonePlacementLeastUsed ZonePlacement = "least_used"
+	// ZonePlacementRandom places runners in a random zone.
+	ZonePlacementRandom ZonePlacement = "random"
+)
+
 // NewConfig returns a new Config
 func NewConfig(cfgFile string) (*Config, error) {
 	var config Config
@@ -38,15 +99,276 @@ func NewConfig(cfgFile string) (*Config, error) {
 
 type Config struct {
 	Credentials Credentials `toml:"credentials"`
-	Location    string      `toml:"location"`
+	// NetworkCredentials are used for the network resources of the runners, when they are
+	// owned by a different subscription (or identity) than the VMs. Defaults to Credentials.
+	NetworkCredentials *Credentials `toml:"network_credentials"`
+	// SideEffectCredentials are used for optional side effects outside of the runner
+	// resources, like DNS records, so the main credentials don't need access to them.
+	// Defaults to Credentials.
+	SideEffectCredentials *Credentials `toml:"side_effect_credentials"`
+	Location              string       `toml:"location"`
 	// UseEphemeralStorage is a flag that indicates whether the provider should use
 	// ephemeral storage for the VMs it creates. If true, the provider will use the
 	// ephemeral OS disk feature to create the VMs. Note, the size of the ephemeral
 	// OS disk is determined by the VM size, and the VM size must accomodate the size
 	// of the image.
-	UseEphemeralStorage      bool   `toml:"use_ephemeral_storage"`
+	UseEphemeralStorage bool `toml:"use_ephemeral_storage"`
+	// EphemeralDiskMinSizeGB and EphemeralDiskMaxSizeGB bound the size of ephemeral OS
+	// disks, when the size is not set in the pool extra specs and is automatically set to
+	// the maximum the VM size allows. Creating a runner fails if the size is below the
+	// minimum, and the size is capped to the maximum. A value of 0 disables the check.
+	EphemeralDiskMinSizeGB int32 `toml:"ephemeral_disk_min_size_gb"`
+	EphemeralDiskMaxSizeGB int32 `toml:"ephemeral_disk_max_size_gb"`
+	// EphemeralDiskFallback creates runners with a managed OS disk when the VM size can't
+	// hold an ephemeral OS disk of the requested size, instead of failing the create.
+	EphemeralDiskFallback bool `toml:"ephemeral_disk_fallback"`
+	// SkipNetworkSecurityGroup creates runners without a network security group, for
+	// networks where security groups are managed by policy at the subnet level.
+	SkipNetworkSecurityGroup bool   `toml:"skip_network_security_group"`
 	VirtualNetworkCIDR       string `toml:"virtual_network_cidr"`
 	UseAcceleratedNetworking bool   `toml:"use_accelerated_networking"`
+	// CloudInitStatusCheck enables polling cloud-init on Linux runners (via Run Command)
+	// in the background checks of the pool, until it reports a final status. A failed
+	// bootstrap is then reported as an instance error, instead of waiting for the garm
+	// timeout.
+	CloudInitStatusCheck bool `toml:"cloud_init_status_check"`
+	// SubnetCIDR is the address prefix of the subnet runners are attached to. It must be
+	// part of the virtual network CIDR. If not set, the subnet will span the entire
+	// virtual network.
+	SubnetCIDR string `toml:"subnet_cidr"`
+	// ExtraSubnets is a map of subnet name to CIDR. These subnets are created alongside
+	// the runner subnet in every provider created virtual network, and are otherwise
+	// left alone.
+	ExtraSubnets map[string]string `toml:"extra_subnets"`
+	// SubnetID is the resource ID of an existing subnet runners are attached to. The
+	// provider then doesn't create a virtual network and subnet for each runner. The
+	// virtual network is the one in the subnet ID.
+	SubnetID string `toml:"subnet_id"`
+	// NetworkTags are set on the network resources of runners (virtual network, security
+	// group, public IP and NIC), separately from the tags of the VM. Pools can add to them
+	// with the network_tags extra spec.
+	NetworkTags map[string]string `toml:"network_tags"`
+	// Zones is the list of availability zones runners may be placed in. When more than one
+	// zone is set, new runners go to the zone with the fewest runners of their pool.
+	Zones []string `toml:"zones"`
+	// ZonePlacement controls how the zone of a runner is picked, when more than one zone is
+	// allowed. Defaults to least_used.
+	ZonePlacement ZonePlacement `toml:"zone_placement"`
+	// Regions distributes runners across several regions, instead of only creating them in
+	// Location. Each create goes to the region with the fewest runners of the pool, relative
+	// to its weight.
+	Regions []Region `toml:"regions"`
+	// CreationMode controls how instance resources are created. The default (sdk) mode
+	// creates each resource with a separate API call. The deployment mode submits a
+	// single ARM template deployment per instance.
+	CreationMode CreationMode `toml:"creation_mode"`
+	// DryRun makes CreateInstance run a What-If operation for the ARM deployment of the
+	// instance and fail with the predicted changes, instead of creating anything. This is
+	// useful for catching policy denials and quota issues when setting up a new pool.
+	// Requires the deployment creation mode.
+	DryRun bool `toml:"dry_run"`
+	// AsyncCreate makes CreateInstance return as soon as the deployment of the instance is
+	// submitted. A background process waits for the deployment, and records the result in
+	// the tags of the instance resource group. Requires the deployment creation mode.
+	AsyncCreate bool `toml:"async_create"`
+	// ReportProgress enables sending progress messages (creating network, creating VM, etc)
+	// to the garm callback URL of the instance while it is being created. The provider
+	// needs to be able to reach the callback URL for this to work.
+	ReportProgress bool `toml:"report_progress"`
+	// CreateQueue throttles concurrent instance creates across all provider processes
+	// running on this host.
+	CreateQueue CreateQueue `toml:"create_queue"`
+	// DeleteOptions controls what happens to the OS disk, NIC and public IP of a runner
+	// when its VM is deleted. All of them are deleted by default. Detaching is useful to
+	// keep a disk around for debugging.
+	DeleteOptions DeleteOptions `toml:"delete_options"`
+	// LockInstances places a CanNotDelete management lock on the resource group of every
+	// runner, so it can't be deleted by accident outside of garm. The provider removes the
+	// lock when garm deletes the runner.
+	LockInstances bool `toml:"lock_instances"`
+	// ManagedIdentity are the managed identities assigned to the runners of every pool.
+	// Pools can replace them with the managed_identity extra spec.
+	ManagedIdentity *ManagedIdentity `toml:"managed_identity"`
+	// RefreshAddresses makes the provider report the current private and public addresses
+	// of running runners when garm fetches them, instead of only returning the public
+	// address when they are created. Dynamic addresses change when a runner is stopped and
+	// started again.
+	RefreshAddresses bool `toml:"refresh_addresses"`
+	// RemoveLocksOnDelete makes the provider remove any management lock that blocks the
+	// deletion of a runner resource group, not just the locks it placed itself. Locks
+	// inherited from the subscription are never removed.
+	RemoveLocksOnDelete bool `toml:"remove_locks_on_delete"`
+	// PollStrategy maps resources to a poll mode, and allows trading creation latency for
+	// certainty that each step succeeded. Resources that are not waited on are referenced
+	// by ID, and any failure to create them surfaces in the steps that depend on them.
+	// This only applies to the sdk creation mode.
+	PollStrategy map[string]PollMode `toml:"poll_strategy"`
+	// VerifyRunnerChecksum makes Linux runners verify the SHA256 checksum of the runner
+	// archive before installing it. Runners that fail the verification never register.
+	VerifyRunnerChecksum bool `toml:"verify_runner_checksum"`
+	// ScriptChecksums maps pre install script names to their SHA256 checksum. When set, pools
+	// can only use pre install scripts that are listed here, with a matching checksum.
+	ScriptChecksums map[string]string `toml:"script_checksums"`
+	// QuotaCheck configures the periodic check of compute and network quota usage.
+	QuotaCheck QuotaCheck `toml:"quota_check"`
+	// GarbageCollection configures the periodic removal of resource groups leaked by
+	// interrupted creates.
+	GarbageCollection GarbageCollection `toml:"garbage_collection"`
+	// CircuitBreaker stops creating runners for a while after repeated failures that
+	// affect every create, like rejected credentials or an Azure outage.
+	CircuitBreaker CircuitBreaker `toml:"circuit_breaker"`
+	// ChaosStudio configures how runners of pools with the chaos_target extra spec are
+	// enrolled in Azure Chaos Studio.
+	ChaosStudio ChaosStudio `toml:"chaos_studio"`
+	// SpotStateDir holds the spot allocation failures of each pool, used to fall back to
+	// regular VMs. It must be shared by all provider processes. Defaults to a directory in
+	// the system temp dir.
+	SpotStateDir string `toml:"spot_state_dir"`
+	// CreateStateDir tracks the instances that are being created, so a delete arriving
+	// during the create can cancel it. It must be shared by all provider processes.
+	// Defaults to a directory in the system temp dir.
+	CreateStateDir string `toml:"create_state_dir"`
+	// MaintenanceStateDir records when the runners of each pool were last checked in the
+	// background. It must be shared by all provider processes. Defaults to a directory in
+	// the system temp dir.
+	MaintenanceStateDir string `toml:"maintenance_state_dir"`
+	// GitHubMetaURL is the GitHub meta endpoint the IP ranges of the github-only egress
+	// profile are fetched from. Defaults to the github.com meta endpoint. GitHub Enterprise
+	// Server users should point this to https://<server>/api/v3/meta.
+	GitHubMetaURL string `toml:"github_meta_url"`
+	// GitHubMetaCacheFile caches the GitHub IP ranges. It must be shared by all provider
+	// processes. Defaults to a file in the system temp dir.
+	GitHubMetaCacheFile string `toml:"github_meta_cache_file"`
+	// GitHubMetaRefreshMinutes is how often the GitHub IP ranges are fetched. Defaults to
+	// 60 minutes.
+	GitHubMetaRefreshMinutes int `toml:"github_meta_refresh_minutes"`
+	// BurstablePolicy controls what happens when a pool uses a burstable (B-series) VM
+	// size. Burstable VMs are throttled to a baseline once they run out of CPU credits,
+	// which makes CI timings erratic. Defaults to warn. Pools can override this with the
+	// allow_burstable extra spec.
+	BurstablePolicy BurstablePolicy `toml:"burstable_policy"`
+	// TrustedLaunchPolicy controls whether runners are created with trusted launch (secure
+	// boot and a virtual TPM). Defaults to opt-in. Confidential VMs always use their own
+	// security type, which includes both.
+	TrustedLaunchPolicy TrustedLaunchPolicy `toml:"trusted_launch_policy"`
+	// SKUCacheFile caches the VM sizes of the location. It must be shared by all provider
+	// processes. Defaults to a file in the system temp dir.
+	SKUCacheFile string `toml:"sku_cache_file"`
+	// SKUCacheMinutes is how long the cached VM sizes are used. Defaults to 60 minutes.
+	SKUCacheMinutes int `toml:"sku_cache_minutes"`
+	// VMSizeBandwidthMbps is the expected network bandwidth of VM sizes, as published in the
+	// Azure docs. The VM size SKUs don't include it. Pools that set min_network_bandwidth_mbps
+	// are checked against it.
+	VMSizeBandwidthMbps map[string]uint `toml:"vm_size_bandwidth_mbps"`
+	// PrefetchVMSizes are the VM sizes checked by the prefetch command.
+	PrefetchVMSizes []string `toml:"prefetch_vm_sizes"`
+	// DedicatedHosts places all runners on Azure Dedicated Hosts, which the provider
+	// provisions and deprovisions as needed.
+	DedicatedHosts DedicatedHosts `toml:"dedicated_hosts"`
+	// ScaleSets groups the runners of each pool in a virtual machine scale set with
+	// Flexible orchestration, which spreads them across fault domains and zones.
+	ScaleSets ScaleSets `toml:"scale_sets"`
+	// NATGateways gives pools with the nat_gateway extra spec a NAT gateway of their own,
+	// so each of them egresses through a dedicated public IP prefix.
+	NATGateways NATGateways `toml:"nat_gateways"`
+	// SharedResourceGroup places the resources of all runners in a single resource group,
+	// instead of a resource group per runner. The resource group is created if it does not
+	// exist. Resources are named after the runner, and deleted one by one.
+	SharedResourceGroup string `toml:"shared_resource_group"`
+	// ScaleHints sets the names of the tags external automation can use to act on runners.
+	ScaleHints ScaleHints `toml:"scale_hints"`
+	// AutoShutdownTag is the name of the tag holding the daily shutdown time of runners
+	// using the auto_shutdown extra spec. Defaults to garm-auto-shutdown.
+	AutoShutdownTag string `toml:"auto_shutdown_tag"`
+	// DNSZone creates an A record for the public IP of each runner in an Azure DNS public
+	// zone, and removes it when the runner is deleted.
+	DNSZone *DNSZone `toml:"dns_zone"`
+	// SUSERegistration registers SLES BYOS runners with SUSEConnect while they boot, so
+	// they can install packages.
+	SUSERegistration *SUSERegistration `toml:"suse_registration"`
+	// ImageAliases maps short names pools can use as their image to marketplace image URNs.
+	// They are merged with the built-in aliases, and an empty URN removes a built-in alias.
+	ImageAliases map[string]string `toml:"image_aliases"`
+	// ImageLifecycle warns about, or refuses, runner images that are old or deprecated.
+	ImageLifecycle ImageLifecycle `toml:"image_lifecycle"`
+	// SecurityPresets are named bundles of security settings pools can select with the
+	// security_preset extra spec. They are merged with the built-in presets.
+	SecurityPresets map[string]SecurityPreset `toml:"security_presets"`
+	// SecureWipe shreds sensitive paths on runners with persistent OS disks, before they
+	// are deleted.
+	SecureWipe SecureWipe `toml:"secure_wipe"`
+	// HourlyPrices are the hourly prices of VM sizes, in any currency. Runners of the listed
+	// sizes are annotated with their age and an estimate of their cost to date.
+	HourlyPrices map[string]float64 `toml:"hourly_prices"`
+	// AlertTemplates are metric alerts pools can create for their runners with the alerts
+	// extra spec. The alerts are scoped to the runner VM, and deleted along with it.
+	AlertTemplates map[string]AlertTemplate `toml:"alert_templates"`
+	// APIVersions pins the ARM API version used for resource types (like
+	// Microsoft.Compute/virtualMachines) or whole namespaces (like Microsoft.Network), for
+	// clouds that don't serve the versions the provider uses by default, like Azure Stack.
+	APIVersions map[string]string `toml:"api_versions"`
+}
+
+// GetHourlyPrice returns the hourly price of a VM size. VM size names are case insensitive.
+func (c *Config) GetHourlyPrice(vmSize string) (float64, bool) {
+	for size, price := range c.HourlyPrices {
+		if strings.EqualFold(size, vmSize) {
+			return price, true
+		}
+	}
+	return 0, false
+}
+
+// GetAPIVersion returns the API version pinned for a resource type, or for its namespace.
+// Resource types are case insensitive.
+func (c *Config) GetAPIVersion(resourceType string) (string, bool) {
+	namespace := strings.SplitN(resourceType, "/", 2)[0]
+	var namespaceVersion string
+	for pinned, version := range c.APIVersions {
+		if strings.EqualFold(pinned, resourceType) {
+			return version, true
+		}
+		if strings.EqualFold(pinned, namespace) {
+			namespaceVersion = version
+		}
+	}
+	return namespaceVersion, namespaceVersion != ""
+}
+
+// GetVMSizeBandwidthMbps returns the expected network bandwidth of a VM size, if it is
+// known.
+func (c *Config) GetVMSizeBandwidthMbps(vmSize string) (uint, bool) {
+	for name, bandwidth := range c.VMSizeBandwidthMbps {
+		if strings.EqualFold(name, vmSize) {
+			return bandwidth, true
+		}
+	}
+	return 0, false
+}
+
+// GetSpotStateDir returns the directory holding the spot allocation failures of each pool.
+func (c *Config) GetSpotStateDir() string {
+	if c.SpotStateDir != "" {
+		return c.SpotStateDir
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-spot")
+}
+
+// GetCreateStateDir returns the directory tracking the instances that are being created.
+func (c *Config) GetCreateStateDir() string {
+	if c.CreateStateDir != "" {
+		return c.CreateStateDir
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-creates")
+}
+
+// GetMaintenanceStateDir returns the directory recording when the runners of each pool
+// were last checked in the background.
+func (c *Config) GetMaintenanceStateDir() string {
+	if c.MaintenanceStateDir != "" {
+		return c.MaintenanceStateDir
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-maintenance")
 }
 
 func (c *Config) Validate() error {
@@ -56,6 +378,29 @@ func (c *Config) Validate() error {
 	if err := c.Credentials.Validate(); err != nil {
 		return fmt.Errorf("failed to validate credentials: %w", err)
 	}
+	if c.NetworkCredentials != nil {
+		if err := c.NetworkCredentials.Validate(); err != nil {
+			return fmt.Errorf("failed to validate network_credentials: %w", err)
+		}
+		// A deployment only targets a single subscription.
+		if c.CreationMode == CreationModeDeployment {
+			return fmt.Errorf("creation_mode %s can't be used with network_credentials", CreationModeDeployment)
+		}
+	}
+	if c.SideEffectCredentials != nil {
+		if err := c.SideEffectCredentials.Validate(); err != nil {
+			return fmt.Errorf("failed to validate side_effect_credentials: %w", err)
+		}
+	}
+
+	// The What-If operation previews the deployment template, which is not what the sdk
+	// creation mode creates.
+	if c.DryRun && c.CreationMode != CreationModeDeployment {
+		return fmt.Errorf("dry_run requires creation_mode %s", CreationModeDeployment)
+	}
+	if c.AsyncCreate && c.CreationMode != CreationModeDeployment {
+		return fmt.Errorf("async_create requires creation_mode %s", CreationModeDeployment)
+	}
 
 	if c.VirtualNetworkCIDR != "" {
 		if _, _, err := net.ParseCIDR(c.VirtualNetworkCIDR); err != nil {
@@ -63,13 +408,242 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.EphemeralDiskMinSizeGB < 0 || c.EphemeralDiskMaxSizeGB < 0 {
+		return fmt.Errorf("invalid ephemeral disk size limits")
+	}
+	if c.EphemeralDiskMaxSizeGB > 0 && c.EphemeralDiskMinSizeGB > c.EphemeralDiskMaxSizeGB {
+		return fmt.Errorf("ephemeral_disk_min_size_gb is larger than ephemeral_disk_max_size_gb")
+	}
+
+	if c.SubnetCIDR != "" {
+		if _, _, err := net.ParseCIDR(c.SubnetCIDR); err != nil {
+			return fmt.Errorf("invalid subnet_cidr: %w", err)
+		}
+	}
+
+	for name, cidr := range c.ExtraSubnets {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid CIDR for extra subnet %s: %w", name, err)
+		}
+	}
+
+	if c.SubnetID != "" && !subnetIDRegex.MatchString(c.SubnetID) {
+		return fmt.Errorf("invalid subnet_id %q", c.SubnetID)
+	}
+
+	switch c.BurstablePolicy {
+	case "", BurstablePolicyAllow, BurstablePolicyWarn, BurstablePolicyRefuse:
+	default:
+		return fmt.Errorf("invalid burstable_policy: %s", c.BurstablePolicy)
+	}
+
+	switch c.TrustedLaunchPolicy {
+	case "", TrustedLaunchPolicyOptIn, TrustedLaunchPolicyDefault, TrustedLaunchPolicyRequired:
+	default:
+		return fmt.Errorf("invalid trusted_launch_policy: %s", c.TrustedLaunchPolicy)
+	}
+
+	switch c.ZonePlacement {
+	case "", ZonePlacementLeastUsed, ZonePlacementRandom:
+	default:
+		return fmt.Errorf("invalid zone_placement: %s", c.ZonePlacement)
+	}
+
+	switch c.CreationMode {
+	case "", CreationModeSDK, CreationModeDeployment:
+	default:
+		return fmt.Errorf("invalid creation_mode: %s", c.CreationMode)
+	}
+
+	for resource, mode := range c.PollStrategy {
+		switch resource {
+		case PollResourceVirtualNetwork, PollResourceSubnet, PollResourcePublicIP,
+			PollResourceNetworkSecurityGroup, PollResourceNetworkInterface, PollResourceVirtualMachine:
+		default:
+			return fmt.Errorf("invalid resource in poll_strategy: %s", resource)
+		}
+		if mode != PollModeWait && mode != PollModeAccepted {
+			return fmt.Errorf("invalid poll mode for %s: %s", resource, mode)
+		}
+	}
+
+	if err := c.DeleteOptions.Validate(); err != nil {
+		return fmt.Errorf("failed to validate delete_options: %w", err)
+	}
+
+	if c.ManagedIdentity != nil {
+		if err := c.ManagedIdentity.Validate(); err != nil {
+			return fmt.Errorf("failed to validate managed_identity: %w", err)
+		}
+	}
+
+	if err := c.CreateQueue.Validate(); err != nil {
+		return fmt.Errorf("failed to validate create_queue: %w", err)
+	}
+
+	seenRegions := map[string]struct{}{}
+	for _, region := range c.Regions {
+		if region.Name == "" {
+			return fmt.Errorf("invalid region with an empty name")
+		}
+		if region.Weight < 0 {
+			return fmt.Errorf("invalid weight %d for region %s", region.Weight, region.Name)
+		}
+		if _, ok := seenRegions[strings.ToLower(region.Name)]; ok {
+			return fmt.Errorf("duplicate region %s", region.Name)
+		}
+		seenRegions[strings.ToLower(region.Name)] = struct{}{}
+	}
+
+	if c.SKUCacheMinutes < 0 {
+		return fmt.Errorf("invalid sku_cache_minutes: %d", c.SKUCacheMinutes)
+	}
+
+	if c.GitHubMetaRefreshMinutes < 0 {
+		return fmt.Errorf("invalid github_meta_refresh_minutes: %d", c.GitHubMetaRefreshMinutes)
+	}
+
+	if err := c.QuotaCheck.Validate(); err != nil {
+		return fmt.Errorf("failed to validate quota_check: %w", err)
+	}
+
+	if err := c.GarbageCollection.Validate(); err != nil {
+		return fmt.Errorf("failed to validate garbage_collection: %w", err)
+	}
+	if err := c.CircuitBreaker.Validate(); err != nil {
+		return fmt.Errorf("failed to validate circuit_breaker: %w", err)
+	}
+	if c.GarbageCollection.IntervalMinutes > 0 && c.SharedResourceGroup != "" {
+		return fmt.Errorf("garbage_collection can't be used with shared_resource_group")
+	}
+
+	if err := c.DedicatedHosts.Validate(); err != nil {
+		return fmt.Errorf("failed to validate dedicated_hosts: %w", err)
+	}
+	if c.DedicatedHosts.Enabled() && len(c.Regions) > 0 {
+		return fmt.Errorf("dedicated_hosts can't be used with multiple regions")
+	}
+
+	if err := c.ScaleSets.Validate(); err != nil {
+		return fmt.Errorf("failed to validate scale_sets: %w", err)
+	}
+	if c.ScaleSets.Enabled() && (c.DedicatedHosts.Enabled() || len(c.Regions) > 0) {
+		return fmt.Errorf("scale_sets can't be used with dedicated_hosts or multiple regions")
+	}
+
+	if err := c.NATGateways.Validate(); err != nil {
+		return fmt.Errorf("failed to validate nat_gateways: %w", err)
+	}
+	if c.NATGateways.Enabled() && len(c.Regions) > 0 {
+		return fmt.Errorf("nat_gateways can't be used with multiple regions")
+	}
+
+	if c.SharedResourceGroup != "" && (c.AsyncCreate || c.LockInstances || c.NetworkCredentials != nil) {
+		return fmt.Errorf("shared_resource_group can't be used with async_create, lock_instances or network_credentials")
+	}
+
+	for alias, urn := range c.ImageAliases {
+		if urn != "" && len(strings.Split(urn, ":")) != 4 {
+			return fmt.Errorf("invalid image_aliases entry %s: %q is not an image URN", alias, urn)
+		}
+	}
+
+	if err := c.ImageLifecycle.Validate(); err != nil {
+		return fmt.Errorf("failed to validate image_lifecycle: %w", err)
+	}
+
+	for name, alert := range c.AlertTemplates {
+		if !alertTemplateNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid alert_templates name %q (letters, digits, dashes, dots and underscores only)", name)
+		}
+		if err := alert.Validate(); err != nil {
+			return fmt.Errorf("failed to validate alert_templates entry %s: %w", name, err)
+		}
+	}
+
+	for resourceType, version := range c.APIVersions {
+		if !apiVersionResourceTypeRegex.MatchString(resourceType) {
+			return fmt.Errorf("invalid api_versions entry %s: expected a resource type or namespace, like Microsoft.Compute/virtualMachines", resourceType)
+		}
+		if !apiVersionRegex.MatchString(version) {
+			return fmt.Errorf("invalid api_versions entry %s: %q is not an API version", resourceType, version)
+		}
+	}
+
+	for size, price := range c.HourlyPrices {
+		if price < 0 {
+			return fmt.Errorf("invalid hourly_prices entry %s: %v is negative", size, price)
+		}
+	}
+
+	if err := c.ScaleHints.Validate(); err != nil {
+		return fmt.Errorf("failed to validate scale_hints: %w", err)
+	}
+	if len(c.AutoShutdownTag) > maxTagNameLength || strings.ContainsAny(c.AutoShutdownTag, invalidTagNameChars) {
+		return fmt.Errorf("invalid auto_shutdown_tag: %q", c.AutoShutdownTag)
+	}
+
+	if c.DNSZone != nil {
+		if err := c.DNSZone.Validate(); err != nil {
+			return fmt.Errorf("failed to validate dns_zone: %w", err)
+		}
+		// Asynchronous creates return before the public IP is known.
+		if c.AsyncCreate {
+			return fmt.Errorf("dns_zone can't be used with async_create")
+		}
+	}
+
+	if c.SUSERegistration != nil {
+		if err := c.SUSERegistration.Validate(); err != nil {
+			return fmt.Errorf("failed to validate suse_registration: %w", err)
+		}
+	}
+
+	for name, checksum := range c.ScriptChecksums {
+		if !IsSHA256Checksum(checksum) {
+			return fmt.Errorf("invalid checksum for script %s in script_checksums", name)
+		}
+	}
+
 	return nil
 }
 
+// IsSHA256Checksum returns true if the value is a hex encoded SHA256 checksum.
+func IsSHA256Checksum(value string) bool {
+	decoded, err := hex.DecodeString(value)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// GetAutoShutdownTag returns the name of the tag holding the daily shutdown time of runners.
+func (c *Config) GetAutoShutdownTag() string {
+	if c.AutoShutdownTag != "" {
+		return c.AutoShutdownTag
+	}
+	return defaultAutoShutdownTag
+}
+
+var (
+	apiVersionResourceTypeRegex = regexp.MustCompile(`^[a-zA-Z0-9]+(\.[a-zA-Z0-9]+)+(/[a-zA-Z0-9]+)*$`)
+	apiVersionRegex             = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}(-preview)?$`)
+)
+
+var subnetIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Network/virtualNetworks/[^/]+/subnets/[^/]+$`)
+
+// GetNetworkCredentials returns the credentials used for network resources.
+func (c *Config) GetNetworkCredentials() Credentials {
+	if c.NetworkCredentials != nil {
+		return *c.NetworkCredentials
+	}
+	return c.Credentials
+}
+
 type Credentials struct {
 	SubscriptionID  string                      `toml:"subscription_id"`
 	SPCredentials   ServicePrincipalCredentials `toml:"service_principal"`
 	ManagedIdentity ManagedIdentityCredentials  `toml:"managed_identity"`
+	// WorkloadIdentity authenticates with a federated token, like the service account
+	// token of an AKS pod using workload identity, instead of a client secret.
+	WorkloadIdentity *WorkloadIdentityCredentials `toml:"workload_identity"`
 	// ClientOptions is the azure identity client options that will be used to authenticate
 	// against an azure cloud. This is a heavy handed approach for now, defining the entire
 	// ClientOptions here, but should allow users to use this provider with AzureStack or any
@@ -93,6 +667,20 @@ func (c Credentials) GetCredentials() (azcore.TokenCredential, error) {
 	creds := []azcore.TokenCredential{}
 	if spCreds, err := c.SPCredentials.Auth(c.ClientOptions); err == nil {
 		creds = append(creds, spCreds)
+	} else if c.SPCredentials.ClientCertificatePath != "" {
+		// A certificate that can't be loaded would otherwise silently fall back to the
+		// managed identity.
+		return nil, fmt.Errorf("failed to get service principal credentials: %w", err)
+	}
+
+	// Unlike the other sources, workload identity is only used when configured, so
+	// a broken setup is reported instead of skipped.
+	if c.WorkloadIdentity != nil {
+		wiCreds, err := c.WorkloadIdentity.Auth(c.ClientOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get workload identity credentials: %w", err)
+		}
+		creds = append(creds, wiCreds)
 	}
 
 	o := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: c.ClientOptions}
@@ -116,10 +704,26 @@ func (c Credentials) GetCredentials() (azcore.TokenCredential, error) {
 	return chain, nil
 }
 
+// maxAdditionalTenants is the maximum number of auxiliary tenants ARM accepts on a request.
+const maxAdditionalTenants = 3
+
 type ServicePrincipalCredentials struct {
 	TenantID     string `toml:"tenant_id"`
 	ClientID     string `toml:"client_id"`
 	ClientSecret string `toml:"client_secret"`
+	// ClientCertificatePath is a PEM or PFX file holding the certificate and the private
+	// key of the service principal, used instead of the client secret.
+	ClientCertificatePath string `toml:"client_certificate_path"`
+	// ClientCertificatePassword decrypts the certificate file, if it is encrypted.
+	ClientCertificatePassword string `toml:"client_certificate_password"`
+	// SendCertificateChain sends the certificate chain along with token requests, which
+	// subject name and issuer authentication requires.
+	SendCertificateChain bool `toml:"send_certificate_chain"`
+	// AdditionalTenants is a list of tenant IDs the service principal is registered in,
+	// besides its home tenant. Tokens for these tenants are sent along with every request,
+	// which allows referencing resources (like images or virtual networks) shared from
+	// those tenants.
+	AdditionalTenants []string `toml:"additional_tenants"`
 }
 
 func (c ServicePrincipalCredentials) Validate() error {
@@ -131,24 +735,73 @@ func (c ServicePrincipalCredentials) Validate() error {
 		return fmt.Errorf("missing client_id")
 	}
 
-	if c.ClientSecret == "" {
-		return fmt.Errorf("missing subscription_id")
+	if c.ClientSecret == "" && c.ClientCertificatePath == "" {
+		return fmt.Errorf("missing client_secret or client_certificate_path")
+	}
+	if c.ClientSecret != "" && c.ClientCertificatePath != "" {
+		return fmt.Errorf("client_secret and client_certificate_path can't be used together")
+	}
+
+	if len(c.AdditionalTenants) > maxAdditionalTenants {
+		return fmt.Errorf("at most %d additional tenants are supported", maxAdditionalTenants)
 	}
 
 	return nil
 }
 
+// AuxiliaryCredentials returns a credential for each of the additional tenants.
+func (c ServicePrincipalCredentials) AuxiliaryCredentials(opts azcore.ClientOptions) ([]azcore.TokenCredential, error) {
+	if len(c.AdditionalTenants) == 0 {
+		return nil, nil
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("validating credentials: %w", err)
+	}
+
+	var ret []azcore.TokenCredential
+	for _, tenantID := range c.AdditionalTenants {
+		cred, err := c.tenantCredential(tenantID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get credentials for tenant %s: %w", tenantID, err)
+		}
+		ret = append(ret, cred)
+	}
+	return ret, nil
+}
+
 func (c ServicePrincipalCredentials) Auth(opts azcore.ClientOptions) (azcore.TokenCredential, error) {
 	if err := c.Validate(); err != nil {
 		return nil, fmt.Errorf("validating credentials: %w", err)
 	}
 
-	o := &azidentity.ClientSecretCredentialOptions{ClientOptions: opts}
-	cred, err := azidentity.NewClientSecretCredential(c.TenantID, c.ClientID, c.ClientSecret, o)
+	return c.tenantCredential(c.TenantID, opts)
+}
+
+// tenantCredential returns a credential of the service principal in a tenant, using the
+// client certificate if one is set, or the client secret.
+func (c ServicePrincipalCredentials) tenantCredential(tenantID string, opts azcore.ClientOptions) (azcore.TokenCredential, error) {
+	if c.ClientCertificatePath == "" {
+		o := &azidentity.ClientSecretCredentialOptions{ClientOptions: opts}
+		return azidentity.NewClientSecretCredential(tenantID, c.ClientID, c.ClientSecret, o)
+	}
+
+	certData, err := os.ReadFile(c.ClientCertificatePath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	var password []byte
+	if c.ClientCertificatePassword != "" {
+		password = []byte(c.ClientCertificatePassword)
+	}
+	certs, key, err := azidentity.ParseCertificates(certData, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	o := &azidentity.ClientCertificateCredentialOptions{
+		ClientOptions:        opts,
+		SendCertificateChain: c.SendCertificateChain,
 	}
-	return cred, nil
+	return azidentity.NewClientCertificateCredential(tenantID, c.ClientID, certs, key, o)
 }
 
 type ManagedIdentityCredentials struct {
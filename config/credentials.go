@@ -0,0 +1,165 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+)
+
+// Environment variables the AKS workload identity webhook sets in pods.
+const (
+	workloadIdentityTenantIDEnv      = "AZURE_TENANT_ID"
+	workloadIdentityClientIDEnv      = "AZURE_CLIENT_ID"
+	workloadIdentityTokenFileEnv     = "AZURE_FEDERATED_TOKEN_FILE"
+	workloadIdentityAuthorityHostEnv = "AZURE_AUTHORITY_HOST"
+)
+
+// WorkloadIdentityCredentials authenticate as an app registration or user assigned
+// identity with a federated credential that trusts the issuer of the token in TokenFile.
+// Fields that are not set default to the environment variables set by the AKS workload
+// identity webhook.
+type WorkloadIdentityCredentials struct {
+	TenantID  string `toml:"tenant_id"`
+	ClientID  string `toml:"client_id"`
+	TokenFile string `toml:"token_file"`
+}
+
+func (c WorkloadIdentityCredentials) withDefaults() WorkloadIdentityCredentials {
+	if c.TenantID == "" {
+		c.TenantID = os.Getenv(workloadIdentityTenantIDEnv)
+	}
+	if c.ClientID == "" {
+		c.ClientID = os.Getenv(workloadIdentityClientIDEnv)
+	}
+	if c.TokenFile == "" {
+		c.TokenFile = os.Getenv(workloadIdentityTokenFileEnv)
+	}
+	return c
+}
+
+func (c WorkloadIdentityCredentials) Validate() error {
+	c = c.withDefaults()
+	if c.TenantID == "" {
+		return fmt.Errorf("missing tenant_id (or %s)", workloadIdentityTenantIDEnv)
+	}
+	if c.ClientID == "" {
+		return fmt.Errorf("missing client_id (or %s)", workloadIdentityClientIDEnv)
+	}
+	if c.TokenFile == "" {
+		return fmt.Errorf("missing token_file (or %s)", workloadIdentityTokenFileEnv)
+	}
+	if _, err := os.Stat(c.TokenFile); err != nil {
+		return fmt.Errorf("failed to access token_file: %w", err)
+	}
+	return nil
+}
+
+func (c WorkloadIdentityCredentials) Auth(opts azcore.ClientOptions) (azcore.TokenCredential, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("validating credentials: %w", err)
+	}
+	c = c.withDefaults()
+
+	authorityHost := opts.Cloud.ActiveDirectoryAuthorityHost
+	if authorityHost == "" {
+		authorityHost = os.Getenv(workloadIdentityAuthorityHostEnv)
+	}
+	if authorityHost == "" {
+		authorityHost = cloud.AzurePublic.ActiveDirectoryAuthorityHost
+	}
+	return &federatedTokenCredential{
+		clientID:  c.ClientID,
+		tokenFile: c.TokenFile,
+		tokenURL:  fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), url.PathEscape(c.TenantID)),
+		// The transport, retry and logging settings of the clients also apply to the
+		// token requests.
+		pipeline: runtime.NewPipeline("garm-provider-azure", "", runtime.PipelineOptions{}, &opts),
+	}, nil
+}
+
+// federatedTokenCredential exchanges the federated token in a file for an access token,
+// with the client credentials flow. The token file is rotated by kubelet, so it is read
+// again on every request. Access tokens are not cached here, the bearer token policy of
+// the clients caches them until they are about to expire.
+type federatedTokenCredential struct {
+	clientID  string
+	tokenFile string
+	tokenURL  string
+	pipeline  runtime.Pipeline
+}
+
+// federatedTokenResponse is the response of the token endpoint.
+type federatedTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (f *federatedTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	assertion, err := os.ReadFile(f.tokenFile)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to read federated token: %w", err)
+	}
+	form := url.Values{
+		"client_id":             {f.clientID},
+		"scope":                 {strings.Join(opts.Scopes, " ")},
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodPost, f.tokenURL)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to create token request: %w", err)
+	}
+	body := streaming.NopCloser(strings.NewReader(form.Encode()))
+	if err := req.SetBody(body, "application/x-www-form-urlencoded"); err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to create token request: %w", err)
+	}
+	resp, err := f.pipeline.Do(req)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to exchange federated token: %w", err)
+	}
+	payload, err := runtime.Payload(resp)
+	if err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var token federatedTokenResponse
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return azcore.AccessToken{}, fmt.Errorf("failed to decode token response (status %d): %w", resp.StatusCode, err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) || token.AccessToken == "" {
+		return azcore.AccessToken{}, fmt.Errorf("failed to exchange federated token: %s: %s", token.Error, token.ErrorDescription)
+	}
+	return azcore.AccessToken{
+		Token:     token.AccessToken,
+		ExpiresOn: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
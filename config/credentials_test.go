@@ -0,0 +1,243 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const testTokenScope = "https://management.azure.com/.default"
+
+// testTokenEndpoint is a token endpoint answering every request with status and body, and
+// recording the form of the last request.
+type testTokenEndpoint struct {
+	status int
+	body   string
+
+	path string
+	form map[string]string
+}
+
+func (e *testTokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.path = r.URL.Path
+	e.form = map[string]string{}
+	if err := r.ParseForm(); err == nil {
+		for key := range r.PostForm {
+			e.form[key] = r.PostForm.Get(key)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	fmt.Fprint(w, e.body)
+}
+
+func TestFederatedTokenCredential(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		noToken    bool
+		status     int
+		body       string
+		wantErr    string
+		wantToken  string
+		wantExpiry time.Duration
+	}{
+		{
+			name:       "exchanged token",
+			token:      "federated-token\n",
+			status:     http.StatusOK,
+			body:       `{"access_token": "access-token", "expires_in": 3600}`,
+			wantToken:  "access-token",
+			wantExpiry: time.Hour,
+		},
+		{
+			name:    "missing token file",
+			noToken: true,
+			status:  http.StatusOK,
+			body:    `{"access_token": "access-token", "expires_in": 3600}`,
+			wantErr: "failed to read federated token",
+		},
+		{
+			name:    "rejected token",
+			token:   "federated-token",
+			status:  http.StatusBadRequest,
+			body:    `{"error": "invalid_client", "error_description": "AADSTS700211: No matching federated identity record found"}`,
+			wantErr: "invalid_client: AADSTS700211",
+		},
+		{
+			name:    "response without an access token",
+			token:   "federated-token",
+			status:  http.StatusOK,
+			body:    `{"expires_in": 3600}`,
+			wantErr: "failed to exchange federated token",
+		},
+		{
+			name:    "invalid response",
+			token:   "federated-token",
+			status:  http.StatusBadGateway,
+			body:    `<html>bad gateway</html>`,
+			wantErr: "failed to decode token response (status 502)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := &testTokenEndpoint{status: tt.status, body: tt.body}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+
+			tokenFile := filepath.Join(t.TempDir(), "token")
+			if !tt.noToken {
+				if err := os.WriteFile(tokenFile, []byte(tt.token), 0o600); err != nil {
+					t.Fatalf("failed to write token file: %s", err)
+				}
+			}
+			creds := WorkloadIdentityCredentials{
+				TenantID:  "tenant",
+				ClientID:  "client",
+				TokenFile: tokenFile,
+			}
+			opts := azcore.ClientOptions{
+				Cloud: cloud.Configuration{ActiveDirectoryAuthorityHost: server.URL + "/"},
+				Retry: policy.RetryOptions{MaxRetries: -1},
+			}
+			// Validate checks the token file, so the credential is built directly when the
+			// test needs it to be missing.
+			var cred azcore.TokenCredential
+			if tt.noToken {
+				cred = &federatedTokenCredential{
+					clientID:  creds.ClientID,
+					tokenFile: creds.TokenFile,
+					tokenURL:  server.URL + "/tenant/oauth2/v2.0/token",
+				}
+			} else {
+				var err error
+				if cred, err = creds.Auth(opts); err != nil {
+					t.Fatalf("Auth() returned an error: %s", err)
+				}
+			}
+
+			start := time.Now()
+			token, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{testTokenScope}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("GetToken() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetToken() returned an error: %s", err)
+			}
+			if token.Token != tt.wantToken {
+				t.Fatalf("got token %q, want %q", token.Token, tt.wantToken)
+			}
+			if expiry := token.ExpiresOn.Sub(start); expiry < tt.wantExpiry || expiry > tt.wantExpiry+time.Minute {
+				t.Fatalf("token expires in %s, want %s", expiry, tt.wantExpiry)
+			}
+
+			if endpoint.path != "/tenant/oauth2/v2.0/token" {
+				t.Fatalf("token requested from %s", endpoint.path)
+			}
+			wantForm := map[string]string{
+				"client_id":             "client",
+				"scope":                 testTokenScope,
+				"grant_type":            "client_credentials",
+				"client_assertion_type": "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+				"client_assertion":      strings.TrimSpace(tt.token),
+			}
+			for key, want := range wantForm {
+				if got := endpoint.form[key]; got != want {
+					t.Fatalf("got %s %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFederatedTokenCredentialRereadsToken(t *testing.T) {
+	endpoint := &testTokenEndpoint{status: http.StatusOK, body: `{"access_token": "access-token", "expires_in": 3600}`}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first"), 0o600); err != nil {
+		t.Fatalf("failed to write token file: %s", err)
+	}
+	creds := WorkloadIdentityCredentials{TenantID: "tenant", ClientID: "client", TokenFile: tokenFile}
+	cred, err := creds.Auth(azcore.ClientOptions{Cloud: cloud.Configuration{ActiveDirectoryAuthorityHost: server.URL}})
+	if err != nil {
+		t.Fatalf("Auth() returned an error: %s", err)
+	}
+	for _, assertion := range []string{"first", "rotated"} {
+		if err := os.WriteFile(tokenFile, []byte(assertion), 0o600); err != nil {
+			t.Fatalf("failed to write token file: %s", err)
+		}
+		if _, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{testTokenScope}}); err != nil {
+			t.Fatalf("GetToken() returned an error: %s", err)
+		}
+		if got := endpoint.form["client_assertion"]; got != assertion {
+			t.Fatalf("got assertion %q, want %q", got, assertion)
+		}
+	}
+}
+
+func TestWorkloadIdentityCredentialsValidate(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0o600); err != nil {
+		t.Fatalf("failed to write token file: %s", err)
+	}
+	for _, env := range []string{workloadIdentityTenantIDEnv, workloadIdentityClientIDEnv, workloadIdentityTokenFileEnv} {
+		t.Setenv(env, "")
+	}
+
+	tests := []struct {
+		name    string
+		creds   WorkloadIdentityCredentials
+		wantErr string
+	}{
+		{name: "valid", creds: WorkloadIdentityCredentials{TenantID: "tenant", ClientID: "client", TokenFile: tokenFile}},
+		{name: "missing tenant", creds: WorkloadIdentityCredentials{ClientID: "client", TokenFile: tokenFile}, wantErr: "missing tenant_id"},
+		{name: "missing client", creds: WorkloadIdentityCredentials{TenantID: "tenant", TokenFile: tokenFile}, wantErr: "missing client_id"},
+		{name: "missing token file", creds: WorkloadIdentityCredentials{TenantID: "tenant", ClientID: "client"}, wantErr: "missing token_file"},
+		{name: "inaccessible token file", creds: WorkloadIdentityCredentials{TenantID: "tenant", ClientID: "client", TokenFile: tokenFile + ".missing"}, wantErr: "failed to access token_file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.creds.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() returned an error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
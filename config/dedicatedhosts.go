@@ -0,0 +1,87 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultHostGroup       = "garm-hosts"
+	defaultHostIdleMinutes = 30
+)
+
+type DedicatedHosts struct {
+	// ResourceGroup holds the host group and its hosts. It is created if missing. Setting
+	// it enables dedicated host mode.
+	ResourceGroup string `toml:"resource_group"`
+	// HostGroup is the name of the host group. Defaults to garm-hosts.
+	HostGroup string `toml:"host_group"`
+	// SKU is the dedicated host SKU, for example DSv3-Type3. All pools must use VM sizes
+	// that fit on it.
+	SKU string `toml:"sku"`
+	// Zone is the availability zone of the host group. Runners are placed in this zone.
+	// The host group is regional if it is empty.
+	Zone string `toml:"zone"`
+	// MinHosts is the number of hosts kept even when idle.
+	MinHosts int `toml:"min_hosts"`
+	// MaxHosts is the maximum number of hosts. Creating a runner fails if all of them are
+	// full. A value of 0 means no limit.
+	MaxHosts int `toml:"max_hosts"`
+	// IdleMinutes is how long a host without runners is kept before it is deleted.
+	// Defaults to 30 minutes.
+	IdleMinutes int `toml:"idle_minutes"`
+}
+
+// Enabled returns true if runners are placed on dedicated hosts.
+func (d DedicatedHosts) Enabled() bool {
+	return d.ResourceGroup != ""
+}
+
+func (d DedicatedHosts) Validate() error {
+	if !d.Enabled() {
+		return nil
+	}
+	if d.SKU == "" {
+		return fmt.Errorf("missing sku")
+	}
+	if d.MinHosts < 0 {
+		return fmt.Errorf("invalid min_hosts: %d", d.MinHosts)
+	}
+	if d.MaxHosts < 0 || (d.MaxHosts > 0 && d.MaxHosts < d.MinHosts) {
+		return fmt.Errorf("invalid max_hosts: %d", d.MaxHosts)
+	}
+	if d.IdleMinutes < 0 {
+		return fmt.Errorf("invalid idle_minutes: %d", d.IdleMinutes)
+	}
+	return nil
+}
+
+// GetHostGroup returns the name of the host group.
+func (d DedicatedHosts) GetHostGroup() string {
+	if d.HostGroup != "" {
+		return d.HostGroup
+	}
+	return defaultHostGroup
+}
+
+// GetIdleTimeout returns how long an idle host is kept.
+func (d DedicatedHosts) GetIdleTimeout() time.Duration {
+	if d.IdleMinutes == 0 {
+		return defaultHostIdleMinutes * time.Minute
+	}
+	return time.Duration(d.IdleMinutes) * time.Minute
+}
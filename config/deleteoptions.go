@@ -0,0 +1,60 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+)
+
+// DeleteOption controls what happens to a resource attached to a VM, when the VM is deleted.
+type DeleteOption string
+
+const (
+	DeleteOptionDelete DeleteOption = "Delete"
+	DeleteOptionDetach DeleteOption = "Detach"
+)
+
+// DeleteOptions holds the delete options for the resources attached to a runner VM. Empty
+// values are left to the next level of defaults.
+type DeleteOptions struct {
+	OSDisk   DeleteOption `toml:"os_disk" json:"os_disk"`
+	NIC      DeleteOption `toml:"nic" json:"nic"`
+	PublicIP DeleteOption `toml:"public_ip" json:"public_ip"`
+}
+
+func (d DeleteOptions) Validate() error {
+	for name, opt := range map[string]DeleteOption{"os_disk": d.OSDisk, "nic": d.NIC, "public_ip": d.PublicIP} {
+		switch opt {
+		case "", DeleteOptionDelete, DeleteOptionDetach:
+		default:
+			return fmt.Errorf("invalid delete option for %s: %s", name, opt)
+		}
+	}
+	return nil
+}
+
+// Merge returns a copy of d, with the values set in other taking precedence.
+func (d DeleteOptions) Merge(other DeleteOptions) DeleteOptions {
+	if other.OSDisk != "" {
+		d.OSDisk = other.OSDisk
+	}
+	if other.NIC != "" {
+		d.NIC = other.NIC
+	}
+	if other.PublicIP != "" {
+		d.PublicIP = other.PublicIP
+	}
+	return d
+}
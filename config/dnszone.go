@@ -0,0 +1,56 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultDNSRecordTTL is the default TTL of runner DNS records, in seconds.
+const defaultDNSRecordTTL = 300
+
+var dnsZoneIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Network/dnsZones/[^/]+$`)
+
+type DNSZone struct {
+	// ID is the resource ID of the Azure DNS public zone.
+	ID string `toml:"id"`
+	// TTL is the TTL of the records, in seconds. Defaults to 300.
+	TTL int `toml:"ttl"`
+}
+
+func (d DNSZone) Validate() error {
+	if !dnsZoneIDRegex.MatchString(d.ID) {
+		return fmt.Errorf("invalid id: %q", d.ID)
+	}
+	if d.TTL < 0 {
+		return fmt.Errorf("invalid ttl: %d", d.TTL)
+	}
+	return nil
+}
+
+// Name returns the name of the zone.
+func (d DNSZone) Name() string {
+	return d.ID[strings.LastIndex(d.ID, "/")+1:]
+}
+
+// GetTTL returns the TTL of runner DNS records.
+func (d DNSZone) GetTTL() int {
+	if d.TTL == 0 {
+		return defaultDNSRecordTTL
+	}
+	return d.TTL
+}
@@ -0,0 +1,65 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultGCMinAgeMinutes is the default age under which orphaned resource groups are kept.
+// It is longer than any create, including the wait of an async create.
+const defaultGCMinAgeMinutes = 120
+
+type GarbageCollection struct {
+	// IntervalMinutes is the minimum time between two collections. The collection runs
+	// when an instance is created, if the previous one is older than this. A value of 0
+	// disables it. The gc command can be used instead.
+	IntervalMinutes int `toml:"interval_minutes"`
+	// MinAgeMinutes is how long a resource group without a healthy VM is kept, measured
+	// from when it entered its current state. Defaults to 120.
+	MinAgeMinutes int `toml:"min_age_minutes"`
+	// StateFile records the time of the last collection. It must be shared by all provider
+	// processes. Defaults to a file in the system temp dir.
+	StateFile string `toml:"state_file"`
+}
+
+func (g GarbageCollection) Validate() error {
+	if g.IntervalMinutes < 0 {
+		return fmt.Errorf("invalid interval_minutes: %d", g.IntervalMinutes)
+	}
+	if g.MinAgeMinutes < 0 {
+		return fmt.Errorf("invalid min_age_minutes: %d", g.MinAgeMinutes)
+	}
+	return nil
+}
+
+// GetMinAge returns how long resource groups without a healthy VM are kept.
+func (g GarbageCollection) GetMinAge() time.Duration {
+	if g.MinAgeMinutes == 0 {
+		return defaultGCMinAgeMinutes * time.Minute
+	}
+	return time.Duration(g.MinAgeMinutes) * time.Minute
+}
+
+// GetStateFile returns the file holding the time of the last collection.
+func (g GarbageCollection) GetStateFile() string {
+	if g.StateFile != "" {
+		return g.StateFile
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-gc")
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultGitHubMetaRefreshMinutes is the default interval between two GitHub meta fetches.
+const defaultGitHubMetaRefreshMinutes = 60
+
+// GetGitHubMetaURL returns the GitHub meta endpoint.
+func (c *Config) GetGitHubMetaURL() string {
+	if c.GitHubMetaURL != "" {
+		return c.GitHubMetaURL
+	}
+	return "https://api.github.com/meta"
+}
+
+// GetGitHubMetaCacheFile returns the file caching the GitHub IP ranges.
+func (c *Config) GetGitHubMetaCacheFile() string {
+	if c.GitHubMetaCacheFile != "" {
+		return c.GitHubMetaCacheFile
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-github-meta.json")
+}
+
+// GetGitHubMetaRefreshInterval returns how often the GitHub IP ranges are fetched.
+func (c *Config) GetGitHubMetaRefreshInterval() time.Duration {
+	minutes := c.GitHubMetaRefreshMinutes
+	if minutes == 0 {
+		minutes = defaultGitHubMetaRefreshMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// defaultImageAliases are the built-in image aliases. They point to Gen2 images that
+// support NVMe disk controllers. The aliases ending in -arm64 are the Arm64 variants.
+var defaultImageAliases = map[string]string{
+	"ubuntu-22.04":       "Canonical:0001-com-ubuntu-server-jammy:22_04-lts-gen2:latest",
+	"ubuntu-24.04":       "Canonical:ubuntu-24_04-lts:server:latest",
+	"ubuntu-lts":         "Canonical:ubuntu-24_04-lts:server:latest",
+	"windows-2022":       "MicrosoftWindowsServer:WindowsServer:2022-datacenter-azure-edition:latest",
+	"ubuntu-22.04-arm64": "Canonical:0001-com-ubuntu-server-jammy:22_04-lts-arm64:latest",
+	"ubuntu-24.04-arm64": "Canonical:ubuntu-24_04-lts:server-arm64:latest",
+	"ubuntu-lts-arm64":   "Canonical:ubuntu-24_04-lts:server-arm64:latest",
+}
+
+// ResolveImageAlias returns the image URN an alias points to. Arm64 pools get the -arm64
+// variant of the alias, if there is one.
+func (c *Config) ResolveImageAlias(alias string, arch params.OSArch) (string, bool) {
+	if urn, ok := c.ImageAliasVariant(alias, arch); ok {
+		return urn, true
+	}
+	return c.resolveImageAlias(alias)
+}
+
+// ImageAliasVariant returns the image URN of the variant of an alias for an architecture.
+// Unlike ResolveImageAlias, it doesn't fall back to the amd64 variant.
+func (c *Config) ImageAliasVariant(alias string, arch params.OSArch) (string, bool) {
+	if arch == params.Arm64 && !strings.HasSuffix(alias, "-arm64") {
+		return c.resolveImageAlias(alias + "-arm64")
+	}
+	return c.resolveImageAlias(alias)
+}
+
+func (c *Config) resolveImageAlias(alias string) (string, bool) {
+	urn, ok := c.ImageAliases[alias]
+	if !ok {
+		urn, ok = defaultImageAliases[alias]
+	}
+	return urn, ok && urn != ""
+}
@@ -0,0 +1,44 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+)
+
+type ImageLifecycle struct {
+	// MaxAgeDays is the age, in days, after which an image version is considered out of
+	// date. The age is taken from the date in the version number. A value of 0 disables the
+	// check.
+	MaxAgeDays int `toml:"max_age_days"`
+	// CheckDeprecation checks if the publisher deprecated the image version, or scheduled
+	// it for deprecation.
+	CheckDeprecation bool `toml:"check_deprecation"`
+	// Block refuses to create runners from out of date or deprecated image versions,
+	// instead of logging a warning. Images scheduled for deprecation only get a warning.
+	Block bool `toml:"block"`
+}
+
+func (i ImageLifecycle) Validate() error {
+	if i.MaxAgeDays < 0 {
+		return fmt.Errorf("invalid max_age_days: %d", i.MaxAgeDays)
+	}
+	return nil
+}
+
+// Enabled returns true if any image lifecycle check is enabled.
+func (i ImageLifecycle) Enabled() bool {
+	return i.MaxAgeDays > 0 || i.CheckDeprecation
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var userAssignedIdentityRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.ManagedIdentity/userAssignedIdentities/[^/]+$`)
+
+// ManagedIdentity assigns managed identities to runner VMs, so jobs can authenticate to
+// Azure through the instance metadata service, without stored credentials.
+type ManagedIdentity struct {
+	// SystemAssigned enables the system assigned identity of each VM, which is created and
+	// deleted along with it.
+	SystemAssigned bool `toml:"system_assigned" json:"system_assigned"`
+	// UserAssigned are the resource IDs of user assigned identities.
+	UserAssigned []string `toml:"user_assigned" json:"user_assigned"`
+}
+
+func (m ManagedIdentity) Validate() error {
+	for _, id := range m.UserAssigned {
+		if !userAssignedIdentityRegex.MatchString(id) {
+			return fmt.Errorf("invalid user_assigned identity %q (expected the resource ID of a user assigned managed identity)", id)
+		}
+	}
+	return nil
+}
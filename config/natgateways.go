@@ -0,0 +1,71 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+)
+
+const (
+	// defaultNATPrefixLength gives each pool a /31 public IP prefix, 2 addresses.
+	defaultNATPrefixLength = 31
+	defaultNATIdleTimeout  = 4
+)
+
+type NATGateways struct {
+	// ResourceGroup holds the NAT gateways, public IP prefixes and virtual networks of the
+	// pools. It is created if missing. Setting it enables the nat_gateway extra spec.
+	ResourceGroup string `toml:"resource_group"`
+	// PrefixLength is the length of the public IP prefix of each pool, from 28 (16
+	// addresses) to 31 (2 addresses). Defaults to 31.
+	PrefixLength int `toml:"prefix_length"`
+	// IdleTimeoutMinutes is the idle timeout of outbound connections, from 4 to 120.
+	// Defaults to 4.
+	IdleTimeoutMinutes int `toml:"idle_timeout_minutes"`
+}
+
+// Enabled returns true if pools can have NAT gateways of their own.
+func (n NATGateways) Enabled() bool {
+	return n.ResourceGroup != ""
+}
+
+func (n NATGateways) Validate() error {
+	if !n.Enabled() {
+		return nil
+	}
+	if n.PrefixLength != 0 && (n.PrefixLength < 28 || n.PrefixLength > 31) {
+		return fmt.Errorf("invalid prefix_length: %d (expected 28 to 31)", n.PrefixLength)
+	}
+	if n.IdleTimeoutMinutes != 0 && (n.IdleTimeoutMinutes < 4 || n.IdleTimeoutMinutes > 120) {
+		return fmt.Errorf("invalid idle_timeout_minutes: %d (expected 4 to 120)", n.IdleTimeoutMinutes)
+	}
+	return nil
+}
+
+// GetPrefixLength returns the length of the public IP prefix of each pool.
+func (n NATGateways) GetPrefixLength() int32 {
+	if n.PrefixLength == 0 {
+		return defaultNATPrefixLength
+	}
+	return int32(n.PrefixLength)
+}
+
+// GetIdleTimeoutMinutes returns the idle timeout of outbound connections.
+func (n NATGateways) GetIdleTimeoutMinutes() int32 {
+	if n.IdleTimeoutMinutes == 0 {
+		return defaultNATIdleTimeout
+	}
+	return int32(n.IdleTimeoutMinutes)
+}
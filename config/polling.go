@@ -0,0 +1,50 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+// PollMode controls whether the provider waits for the creation of a resource to finish.
+type PollMode string
+
+const (
+	// PollModeWait waits until the resource is provisioned.
+	PollModeWait PollMode = "wait"
+	// PollModeAccepted returns as soon as Azure accepted the request to create the resource.
+	PollModeAccepted PollMode = "accepted"
+)
+
+// Resources which can have their poll mode set in the poll_strategy config section.
+const (
+	PollResourceVirtualNetwork       = "virtual_network"
+	PollResourceSubnet               = "subnet"
+	PollResourcePublicIP             = "public_ip"
+	PollResourceNetworkSecurityGroup = "network_security_group"
+	PollResourceNetworkInterface     = "network_interface"
+	PollResourceVirtualMachine       = "virtual_machine"
+)
+
+// defaultPollModes holds the poll mode of resources that don't need to be waited on by
+// default. VMs are reported as running as soon as the request to create them goes through.
+var defaultPollModes = map[string]PollMode{
+	PollResourceVirtualMachine: PollModeAccepted,
+}
+
+// WaitFor returns true if the provider should wait for the creation of the resource to finish.
+func (c *Config) WaitFor(resource string) bool {
+	mode, ok := c.PollStrategy[resource]
+	if !ok {
+		mode, ok = defaultPollModes[resource]
+	}
+	return !ok || mode == PollModeWait
+}
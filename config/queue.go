@@ -0,0 +1,61 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type CreateQueue struct {
+	// MaxConcurrent is the maximum number of instances that are created at the same time.
+	// Any other create waits in the queue. A value of 0 disables the queue.
+	MaxConcurrent int `toml:"max_concurrent"`
+	// StateDir is the directory holding the queue state. It must be shared by all provider
+	// processes. Defaults to a directory in the system temp dir.
+	StateDir string `toml:"state_dir"`
+	// PoolWeights maps garm pool IDs to a weight. Waiting creates are ordered by weight,
+	// multiplied by the time they have been waiting. Pools not listed have a weight of 1.
+	PoolWeights map[string]int `toml:"pool_weights"`
+}
+
+func (c CreateQueue) Validate() error {
+	if c.MaxConcurrent < 0 {
+		return fmt.Errorf("invalid max_concurrent: %d", c.MaxConcurrent)
+	}
+	for pool, weight := range c.PoolWeights {
+		if weight <= 0 {
+			return fmt.Errorf("invalid weight for pool %s: %d", pool, weight)
+		}
+	}
+	return nil
+}
+
+// GetStateDir returns the queue state directory.
+func (c CreateQueue) GetStateDir() string {
+	if c.StateDir != "" {
+		return c.StateDir
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-queue")
+}
+
+// GetWeight returns the weight of a pool.
+func (c CreateQueue) GetWeight(poolID string) int {
+	if weight, ok := c.PoolWeights[poolID]; ok {
+		return weight
+	}
+	return 1
+}
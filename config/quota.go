@@ -0,0 +1,64 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultQuotaWarnPercent is the default headroom, in percent of the limit, under which
+// quota usage is logged as a warning.
+const defaultQuotaWarnPercent = 10
+
+type QuotaCheck struct {
+	// IntervalMinutes is the minimum time between two quota checks. The check runs when
+	// an instance is created, if the previous check is older than this. A value of 0
+	// disables the check.
+	IntervalMinutes int `toml:"interval_minutes"`
+	// WarnPercent is the headroom, in percent of the limit, under which a quota is
+	// reported as a warning. Defaults to 10.
+	WarnPercent int `toml:"warn_percent"`
+	// StateFile records the time of the last check. It must be shared by all provider
+	// processes. Defaults to a file in the system temp dir.
+	StateFile string `toml:"state_file"`
+}
+
+func (q QuotaCheck) Validate() error {
+	if q.IntervalMinutes < 0 {
+		return fmt.Errorf("invalid interval_minutes: %d", q.IntervalMinutes)
+	}
+	if q.WarnPercent < 0 || q.WarnPercent > 100 {
+		return fmt.Errorf("invalid warn_percent: %d", q.WarnPercent)
+	}
+	return nil
+}
+
+// GetWarnPercent returns the headroom percentage under which quotas are reported.
+func (q QuotaCheck) GetWarnPercent() int {
+	if q.WarnPercent == 0 {
+		return defaultQuotaWarnPercent
+	}
+	return q.WarnPercent
+}
+
+// GetStateFile returns the file holding the time of the last quota check.
+func (q QuotaCheck) GetStateFile() string {
+	if q.StateFile != "" {
+		return q.StateFile
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-quota-check")
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultPoolIDTag        = "garm-pool-id"
+	defaultIdleAfterTag     = "garm-idle-after"
+	defaultWorkloadClassTag = "garm-workload-class"
+	defaultAutoShutdownTag  = "garm-auto-shutdown"
+
+	// maxTagNameLength is the maximum length of tag names on resource groups and VMs.
+	maxTagNameLength = 512
+	// invalidTagNameChars are not allowed in tag names.
+	invalidTagNameChars = "<>%&\\?/"
+)
+
+// ScaleHints are the names of the tags holding the scale hints of runners. Runners are
+// always tagged with their pool ID, while the idle time and workload class are only set
+// for pools using the scale_hints extra spec.
+type ScaleHints struct {
+	// PoolIDTag holds the garm pool ID. Defaults to garm-pool-id.
+	PoolIDTag string `toml:"pool_id_tag"`
+	// IdleAfterTag holds the time (RFC3339) after which the runner is expected to be idle.
+	// Defaults to garm-idle-after.
+	IdleAfterTag string `toml:"idle_after_tag"`
+	// WorkloadClassTag holds the workload class of the pool. Defaults to
+	// garm-workload-class.
+	WorkloadClassTag string `toml:"workload_class_tag"`
+}
+
+func (s ScaleHints) Validate() error {
+	for option, name := range map[string]string{
+		"pool_id_tag":        s.PoolIDTag,
+		"idle_after_tag":     s.IdleAfterTag,
+		"workload_class_tag": s.WorkloadClassTag,
+	} {
+		if len(name) > maxTagNameLength || strings.ContainsAny(name, invalidTagNameChars) {
+			return fmt.Errorf("invalid %s: %q", option, name)
+		}
+	}
+	return nil
+}
+
+// GetPoolIDTag returns the name of the tag holding the pool ID.
+func (s ScaleHints) GetPoolIDTag() string {
+	if s.PoolIDTag != "" {
+		return s.PoolIDTag
+	}
+	return defaultPoolIDTag
+}
+
+// GetIdleAfterTag returns the name of the tag holding the expected idle time.
+func (s ScaleHints) GetIdleAfterTag() string {
+	if s.IdleAfterTag != "" {
+		return s.IdleAfterTag
+	}
+	return defaultIdleAfterTag
+}
+
+// GetWorkloadClassTag returns the name of the tag holding the workload class.
+func (s ScaleHints) GetWorkloadClassTag() string {
+	if s.WorkloadClassTag != "" {
+		return s.WorkloadClassTag
+	}
+	return defaultWorkloadClassTag
+}
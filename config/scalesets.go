@@ -0,0 +1,55 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+)
+
+const defaultScaleSetFaultDomains = 1
+
+// ScaleSets configures the scale sets runners are grouped in. Each pool gets a scale set
+// with Flexible orchestration, and its runners are created as VMs of that scale set.
+type ScaleSets struct {
+	// ResourceGroup holds the scale sets. It is created if missing. Setting it enables
+	// scale set mode.
+	ResourceGroup string `toml:"resource_group"`
+	// PlatformFaultDomainCount is the number of fault domains runners are spread across.
+	// Defaults to 1, which lets Azure spread them across as many as it can.
+	PlatformFaultDomainCount int `toml:"platform_fault_domain_count"`
+}
+
+// Enabled returns true if runners are grouped in scale sets.
+func (s ScaleSets) Enabled() bool {
+	return s.ResourceGroup != ""
+}
+
+func (s ScaleSets) Validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+	if s.PlatformFaultDomainCount < 0 || s.PlatformFaultDomainCount > 3 {
+		return fmt.Errorf("invalid platform_fault_domain_count: %d (expected 1 to 3)", s.PlatformFaultDomainCount)
+	}
+	return nil
+}
+
+// GetPlatformFaultDomainCount returns the number of fault domains of the scale sets.
+func (s ScaleSets) GetPlatformFaultDomainCount() int32 {
+	if s.PlatformFaultDomainCount == 0 {
+		return defaultScaleSetFaultDomains
+	}
+	return int32(s.PlatformFaultDomainCount)
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/cloudbase/garm-provider-common/defaults"
+)
+
+type SecureWipe struct {
+	// Enabled wipes the runners of all pools. Pools can override this with the
+	// secure_wipe extra spec.
+	Enabled bool `toml:"enabled"`
+	// LinuxPaths are the paths wiped on Linux runners. Defaults to the runner work
+	// directory and the docker volumes.
+	LinuxPaths []string `toml:"linux_paths"`
+	// WindowsPaths are the paths wiped on Windows runners. Defaults to the runner work
+	// directory.
+	WindowsPaths []string `toml:"windows_paths"`
+}
+
+// GetLinuxPaths returns the paths wiped on Linux runners.
+func (s SecureWipe) GetLinuxPaths() []string {
+	if len(s.LinuxPaths) > 0 {
+		return s.LinuxPaths
+	}
+	return []string{
+		fmt.Sprintf("/home/%s/actions-runner/_work", defaults.DefaultUser),
+		"/var/lib/docker/volumes",
+	}
+}
+
+// GetWindowsPaths returns the paths wiped on Windows runners.
+func (s SecureWipe) GetWindowsPaths() []string {
+	if len(s.WindowsPaths) > 0 {
+		return s.WindowsPaths
+	}
+	return []string{`C:\runner\_work`}
+}
@@ -0,0 +1,53 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+// defaultSecurityPresets are the built-in security presets.
+var defaultSecurityPresets = map[string]SecurityPreset{
+	"standard": {
+		TrustedLaunch: true,
+	},
+	"hardened": {
+		TrustedLaunch:    true,
+		EncryptionAtHost: true,
+		NoPublicIP:       true,
+		NoInboundPorts:   true,
+	},
+}
+
+// GetSecurityPreset returns a security preset by name.
+func (c *Config) GetSecurityPreset(name string) (SecurityPreset, bool) {
+	preset, ok := c.SecurityPresets[name]
+	if !ok {
+		preset, ok = defaultSecurityPresets[name]
+	}
+	return preset, ok
+}
+
+// SecurityPreset is a named bundle of security settings. The settings are enforced on the
+// pools that select the preset, and conflicting extra specs are refused.
+type SecurityPreset struct {
+	// TrustedLaunch creates the VMs with secure boot and a virtual TPM.
+	TrustedLaunch bool `toml:"trusted_launch"`
+	// EncryptionAtHost encrypts the temp disk and the disk caches on the VM host.
+	EncryptionAtHost bool `toml:"encryption_at_host"`
+	// NoPublicIP refuses pools that allocate a public IP.
+	NoPublicIP bool `toml:"no_public_ip"`
+	// NoInboundPorts refuses pools that open inbound ports in the security group.
+	NoInboundPorts bool `toml:"no_inbound_ports"`
+	// EgressProfile restricts the outbound traffic of the runners. It takes the same values
+	// as the egress_profile extra spec.
+	EgressProfile string `toml:"egress_profile"`
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultSKUCacheMinutes is the default time the VM sizes of a location are cached.
+const defaultSKUCacheMinutes = 60
+
+// GetSKUCacheFile returns the file caching the VM sizes of the location.
+func (c *Config) GetSKUCacheFile() string {
+	if c.SKUCacheFile != "" {
+		return c.SKUCacheFile
+	}
+	return filepath.Join(os.TempDir(), "garm-provider-azure-skus.json")
+}
+
+// GetSKUCacheTTL returns how long the cached VM sizes are used.
+func (c *Config) GetSKUCacheTTL() time.Duration {
+	minutes := c.SKUCacheMinutes
+	if minutes == 0 {
+		minutes = defaultSKUCacheMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
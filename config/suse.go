@@ -0,0 +1,45 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// SUSERegistration holds the SUSEConnect settings SLES BYOS runners are registered with.
+type SUSERegistration struct {
+	// RegistrationCode is the SUSE Customer Center registration code. It is not needed
+	// when registering with an RMT server.
+	RegistrationCode string `toml:"registration_code"`
+	// Email is the email address the registration is associated with.
+	Email string `toml:"email"`
+	// URL is the URL of an RMT server to register with, instead of the SUSE Customer
+	// Center.
+	URL string `toml:"url"`
+}
+
+func (s SUSERegistration) Validate() error {
+	if s.RegistrationCode == "" && s.URL == "" {
+		return fmt.Errorf("registration_code or url is required")
+	}
+	if s.URL != "" {
+		parsed, err := url.Parse(s.URL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("invalid url: %q", s.URL)
+		}
+	}
+	return nil
+}
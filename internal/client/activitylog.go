@@ -0,0 +1,95 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+)
+
+const (
+	// There is no monitor client in the vendored SDK, so the activity log is queried
+	// through a pipeline of our own.
+	activityLogAPIVersion = "2015-04-01"
+	// activityLogSelect limits the returned event fields to the ones we use.
+	activityLogSelect = "eventTimestamp,operationName,status,resourceId,properties"
+)
+
+// ActivityLogEvent is an event of the subscription activity log.
+type ActivityLogEvent struct {
+	Timestamp  time.Time              `json:"eventTimestamp"`
+	ResourceID string                 `json:"resourceId"`
+	Properties map[string]interface{} `json:"properties"`
+	Operation  struct {
+		Value string `json:"value"`
+	} `json:"operationName"`
+	Status struct {
+		Value string `json:"value"`
+	} `json:"status"`
+}
+
+// ListActivityLog returns the events of the subscription activity log between from and to.
+// Azure keeps the activity log for 90 days.
+func (a *AzureCli) ListActivityLog(ctx context.Context, from, to time.Time) ([]ActivityLogEvent, error) {
+	endpoint, pl, err := a.rawPipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	urlPath := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.Insights/eventtypes/management/values", url.PathEscape(a.cfg.Credentials.SubscriptionID))
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(endpoint, urlPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", activityLogAPIVersion)
+	query.Set("$filter", fmt.Sprintf("eventTimestamp ge '%s' and eventTimestamp le '%s'", from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)))
+	query.Set("$select", activityLogSelect)
+	req.Raw().URL.RawQuery = query.Encode()
+
+	var events []ActivityLogEvent
+	for {
+		req.Raw().Header["Accept"] = []string{"application/json"}
+		resp, err := pl.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list activity log: %w", err)
+		}
+		if !runtime.HasStatusCode(resp, http.StatusOK) {
+			return nil, runtime.NewResponseError(resp)
+		}
+
+		var page struct {
+			Value    []ActivityLogEvent `json:"value"`
+			NextLink string             `json:"nextLink"`
+		}
+		if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+			return nil, fmt.Errorf("failed to decode activity log: %w", err)
+		}
+		events = append(events, page.Value...)
+
+		if page.NextLink == "" {
+			return events, nil
+		}
+		req, err = runtime.NewRequest(ctx, http.MethodGet, page.NextLink)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+)
+
+// primaryNetworkInterfaceID returns the ID of the primary NIC of a VM, or of its only NIC.
+func primaryNetworkInterfaceID(vm armcompute.VirtualMachine) string {
+	if vm.Properties == nil || vm.Properties.NetworkProfile == nil {
+		return ""
+	}
+	var id string
+	for _, nic := range vm.Properties.NetworkProfile.NetworkInterfaces {
+		if nic == nil || nic.ID == nil {
+			continue
+		}
+		if id == "" || (nic.Properties != nil && nic.Properties.Primary != nil && *nic.Properties.Primary) {
+			id = *nic.ID
+		}
+	}
+	return id
+}
+
+// InstanceAddresses returns the current private and public IP addresses of the primary NIC
+// of a VM. Dynamic addresses are released when a VM is deallocated, so they may change
+// every time it is started. Either address is empty if the NIC has none.
+func (a *AzureCli) InstanceAddresses(ctx context.Context, vm armcompute.VirtualMachine) (private, public string, err error) {
+	nicID := primaryNetworkInterfaceID(vm)
+	if nicID == "" {
+		return "", "", fmt.Errorf("VM has no network interface")
+	}
+	resourceID, err := arm.ParseResourceID(nicID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse NIC ID: %w", err)
+	}
+	nic, err := a.nicCli.Get(ctx, resourceID.ResourceGroupName, resourceID.Name, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get network interface: %w", err)
+	}
+	if nic.Properties == nil {
+		return "", "", nil
+	}
+
+	var publicIPID string
+	for _, ipConfig := range nic.Properties.IPConfigurations {
+		if ipConfig == nil || ipConfig.Properties == nil {
+			continue
+		}
+		primary := ipConfig.Properties.Primary == nil || *ipConfig.Properties.Primary
+		if !primary {
+			continue
+		}
+		if ipConfig.Properties.PrivateIPAddress != nil {
+			private = *ipConfig.Properties.PrivateIPAddress
+		}
+		if ipConfig.Properties.PublicIPAddress != nil && ipConfig.Properties.PublicIPAddress.ID != nil {
+			publicIPID = *ipConfig.Properties.PublicIPAddress.ID
+		}
+		break
+	}
+	if publicIPID == "" {
+		return private, "", nil
+	}
+
+	pubIPResourceID, err := arm.ParseResourceID(publicIPID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse public IP ID: %w", err)
+	}
+	pubIP, err := a.GetPublicIP(ctx, pubIPResourceID.ResourceGroupName, pubIPResourceID.Name)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get public IP: %w", err)
+	}
+	if pubIP.Properties != nil && pubIP.Properties.IPAddress != nil {
+		public = *pubIP.Properties.IPAddress
+	}
+	return private, public, nil
+}
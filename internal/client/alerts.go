@@ -0,0 +1,98 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+)
+
+// There is no monitor client in the vendored SDK, so metric alerts are managed through the
+// generic resources client.
+const metricAlertAPIVersion = "2018-03-01"
+
+// metricAlertName returns the name of the metric alert of a runner created from a template.
+func metricAlertName(name, template string) string {
+	return fmt.Sprintf("%s-%s", name, template)
+}
+
+// metricAlertID returns the ID of a metric alert of a runner. It lives in the resource
+// group of the runner, so it is deleted along with it.
+func (a *AzureCli) metricAlertID(name, template string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Insights/metricAlerts/%s", a.cfg.Credentials.SubscriptionID, a.InstanceResourceGroup(name), metricAlertName(name, template))
+}
+
+// CreateMetricAlerts creates the metric alerts of a runner, from the alert templates in
+// the config.
+func (a *AzureCli) CreateMetricAlerts(ctx context.Context, name string, templates []string) error {
+	vmID := a.virtualMachineID(name)
+	for _, templateName := range templates {
+		template, ok := a.cfg.AlertTemplates[templateName]
+		if !ok {
+			return fmt.Errorf("unknown alert template %q", templateName)
+		}
+		if err := a.createMetricAlert(ctx, a.metricAlertID(name, templateName), vmID, template); err != nil {
+			return fmt.Errorf("failed to create alert %s: %w", templateName, err)
+		}
+	}
+	return nil
+}
+
+func (a *AzureCli) createMetricAlert(ctx context.Context, alertID, scope string, template config.AlertTemplate) error {
+	actions := []map[string]string{}
+	for _, actionGroupID := range template.ActionGroupIDs {
+		actions = append(actions, map[string]string{"actionGroupId": actionGroupID})
+	}
+	alert := armresources.GenericResource{
+		Location: to.Ptr("global"),
+		Properties: map[string]interface{}{
+			"description":         template.Description,
+			"severity":            template.GetSeverity(),
+			"enabled":             true,
+			"scopes":              []string{scope},
+			"evaluationFrequency": fmt.Sprintf("PT%dM", template.GetFrequencyMinutes()),
+			"windowSize":          fmt.Sprintf("PT%dM", template.GetWindowMinutes()),
+			"autoMitigate":        true,
+			"criteria": map[string]interface{}{
+				"odata.type": "Microsoft.Azure.Monitor.SingleResourceMultipleMetricCriteria",
+				"allOf": []map[string]interface{}{
+					{
+						"name":            "criterion",
+						"criterionType":   "StaticThresholdCriterion",
+						"metricNamespace": template.GetMetricNamespace(),
+						"metricName":      template.Metric,
+						"timeAggregation": template.GetAggregation(),
+						"operator":        template.GetOperator(),
+						"threshold":       template.Threshold,
+					},
+				},
+			},
+			"actions": actions,
+		},
+	}
+	poller, err := a.resourcesCli.BeginCreateOrUpdateByID(ctx, alertID, metricAlertAPIVersion, alert, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+)
+
+// apiVersionPolicy replaces the API version of requests for the resource types pinned in
+// the api_versions config option. The vendored azcore version has no way of setting the
+// API version of a client.
+type apiVersionPolicy struct {
+	cfg *config.Config
+}
+
+func (p *apiVersionPolicy) Do(req *policy.Request) (*http.Response, error) {
+	if resourceType := resourceTypeFromPath(req.Raw().URL.Path); resourceType != "" {
+		if version, ok := p.cfg.GetAPIVersion(resourceType); ok {
+			query := req.Raw().URL.Query()
+			query.Set("api-version", version)
+			req.Raw().URL.RawQuery = query.Encode()
+		}
+	}
+	return req.Next()
+}
+
+// resourceTypeFromPath returns the resource type a request URL path refers to, like
+// Microsoft.Network/virtualNetworks/subnets for a subnet, or for the list of subnets
+// of a virtual network.
+func resourceTypeFromPath(urlPath string) string {
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	providers := -1
+	for i, segment := range segments {
+		if strings.EqualFold(segment, "providers") && i+2 < len(segments) {
+			providers = i
+		}
+	}
+	if providers < 0 {
+		// Resource groups are the only resources we use outside of a provider namespace.
+		if len(segments) >= 3 && len(segments) <= 4 && strings.EqualFold(segments[2], "resourceGroups") {
+			return resourceGroupType
+		}
+		return ""
+	}
+
+	types := []string{segments[providers+1]}
+	for i := providers + 2; i < len(segments); i += 2 {
+		types = append(types, segments[i])
+	}
+	return strings.Join(types, "/")
+}
+
+// apiVersion returns the API version pinned for a resource type, or defaultVersion.
+func (a *AzureCli) apiVersion(resourceType, defaultVersion string) string {
+	if version, ok := a.cfg.GetAPIVersion(resourceType); ok {
+		return version
+	}
+	return defaultVersion
+}
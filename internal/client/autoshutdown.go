@@ -0,0 +1,74 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+)
+
+// There is no DevTest Labs client in the vendored SDK, so shutdown schedules are managed
+// through the generic resources client.
+const shutdownScheduleAPIVersion = "2018-09-15"
+
+// shutdownScheduleName returns the name of the shutdown schedule of a runner VM.
+func shutdownScheduleName(name string) string {
+	return fmt.Sprintf("shutdown-computevm-%s", name)
+}
+
+// CreateShutdownSchedule creates the daily shutdown schedule of a runner VM. The schedule
+// has the name the portal uses for the auto-shutdown setting of a VM, and lives in the
+// resource group of the runner, so it is deleted along with it.
+func (a *AzureCli) CreateShutdownSchedule(ctx context.Context, name string, shutdown spec.AutoShutdown) error {
+	scheduleID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.DevTestLab/schedules/%s", a.cfg.Credentials.SubscriptionID, a.InstanceResourceGroup(name), shutdownScheduleName(name))
+	vmID := a.virtualMachineID(name)
+
+	notifications := map[string]interface{}{
+		"status": "Disabled",
+	}
+	if shutdown.NotificationEmail != "" {
+		notifications = map[string]interface{}{
+			"status":         "Enabled",
+			"timeInMinutes":  30,
+			"emailRecipient": shutdown.NotificationEmail,
+		}
+	}
+	schedule := armresources.GenericResource{
+		Location: to.Ptr(a.location),
+		Properties: map[string]interface{}{
+			"status":   "Enabled",
+			"taskType": "ComputeVmShutdownTask",
+			"dailyRecurrence": map[string]string{
+				"time": shutdown.Time,
+			},
+			"timeZoneId":           shutdown.TimeZone,
+			"targetResourceId":     vmID,
+			"notificationSettings": notifications,
+		},
+	}
+	poller, err := a.resourcesCli.BeginCreateOrUpdateByID(ctx, scheduleID, shutdownScheduleAPIVersion, schedule, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create shutdown schedule: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to create shutdown schedule: %w", err)
+	}
+	return nil
+}
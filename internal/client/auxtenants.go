@@ -0,0 +1,63 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// auxiliaryAuthHeader is the header ARM uses to authorize cross tenant requests.
+const auxiliaryAuthHeader = "x-ms-authorization-auxiliary"
+
+// auxiliaryTenantsPolicy adds tokens for additional tenants to every request. The vendored
+// azcore version has no built in support for auxiliary tenants.
+type auxiliaryTenantsPolicy struct {
+	creds  []azcore.TokenCredential
+	scopes []string
+}
+
+func newAuxiliaryTenantsPolicy(creds []azcore.TokenCredential, opts azcore.ClientOptions) (*auxiliaryTenantsPolicy, error) {
+	c := cloud.AzurePublic
+	if len(opts.Cloud.Services) > 0 {
+		c = opts.Cloud
+	}
+	conf, ok := c.Services[cloud.ResourceManager]
+	if !ok || conf.Audience == "" {
+		return nil, fmt.Errorf("missing resource manager audience in cloud configuration")
+	}
+	return &auxiliaryTenantsPolicy{
+		creds:  creds,
+		scopes: []string{conf.Audience + "/.default"},
+	}, nil
+}
+
+func (p *auxiliaryTenantsPolicy) Do(req *policy.Request) (*http.Response, error) {
+	tokens := make([]string, 0, len(p.creds))
+	for _, cred := range p.creds {
+		tk, err := cred.GetToken(req.Raw().Context(), policy.TokenRequestOptions{Scopes: p.scopes})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get auxiliary tenant token: %w", err)
+		}
+		tokens = append(tokens, "Bearer "+tk.Token)
+	}
+	req.Raw().Header.Set(auxiliaryAuthHeader, strings.Join(tokens, ", "))
+	return req.Next()
+}
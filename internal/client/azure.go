@@ -16,13 +16,17 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
@@ -32,35 +36,71 @@ import (
 	"github.com/cloudbase/garm-provider-azure/internal/util"
 )
 
-func NewAzCLI(cfg *config.Config) (*AzureCli, error) {
-	creds, err := cfg.Credentials.GetCredentials()
+const vmExtensionName = "CustomScriptExtension"
+
+// clientCredentials returns the token credential and the client options for a set of
+// credentials.
+func clientCredentials(cfg *config.Config, credentials config.Credentials) (azcore.TokenCredential, arm.ClientOptions, error) {
+	creds, err := credentials.GetCredentials()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get client: %w", err)
+		return nil, arm.ClientOptions{}, fmt.Errorf("failed to get client: %w", err)
 	}
 
 	opts := arm.ClientOptions{
-		ClientOptions: cfg.Credentials.ClientOptions,
+		ClientOptions: credentials.ClientOptions,
+	}
+	if len(cfg.APIVersions) > 0 {
+		opts.PerCallPolicies = append(opts.PerCallPolicies, &apiVersionPolicy{cfg: cfg})
+	}
+
+	auxCreds, err := credentials.SPCredentials.AuxiliaryCredentials(credentials.ClientOptions)
+	if err != nil {
+		return nil, arm.ClientOptions{}, fmt.Errorf("failed to get auxiliary credentials: %w", err)
 	}
+	if len(auxCreds) > 0 {
+		auxPolicy, err := newAuxiliaryTenantsPolicy(auxCreds, credentials.ClientOptions)
+		if err != nil {
+			return nil, arm.ClientOptions{}, fmt.Errorf("failed to set up auxiliary tenants: %w", err)
+		}
+		opts.PerRetryPolicies = append(opts.PerRetryPolicies, auxPolicy)
+	}
+	return creds, opts, nil
+}
+
+func NewAzCLI(cfg *config.Config) (*AzureCli, error) {
+	creds, opts, err := clientCredentials(cfg, cfg.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	// Network resources may live in a different subscription, with their own credentials.
+	netCredentials := cfg.GetNetworkCredentials()
+	netSubscriptionID := netCredentials.SubscriptionID
+	netCreds, netOpts, err := clientCredentials(cfg, netCredentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network credentials: %w", err)
+	}
+
 	resourceGroupClient, err := armresources.NewResourceGroupsClient(cfg.Credentials.SubscriptionID, creds, &opts)
 	if err != nil {
 		return nil, err
 	}
-	netCli, err := armnetwork.NewVirtualNetworksClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	netCli, err := armnetwork.NewVirtualNetworksClient(netSubscriptionID, netCreds, &netOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	subnetClient, err := armnetwork.NewSubnetsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	subnetClient, err := armnetwork.NewSubnetsClient(netSubscriptionID, netCreds, &netOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	nsgClient, err := armnetwork.NewSecurityGroupsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	nsgClient, err := armnetwork.NewSecurityGroupsClient(netSubscriptionID, netCreds, &netOpts)
 	if err != nil {
 		return nil, err
 	}
 
-	nicClient, err := armnetwork.NewInterfacesClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	nicClient, err := armnetwork.NewInterfacesClient(netSubscriptionID, netCreds, &netOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -70,7 +110,17 @@ func NewAzCLI(cfg *config.Config) (*AzureCli, error) {
 		return nil, err
 	}
 
-	publicIPcli, err := armnetwork.NewPublicIPAddressesClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	publicIPcli, err := armnetwork.NewPublicIPAddressesClient(netSubscriptionID, netCreds, &netOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	publicIPPrefixClient, err := armnetwork.NewPublicIPPrefixesClient(netSubscriptionID, netCreds, &netOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	natGatewaysClient, err := armnetwork.NewNatGatewaysClient(netSubscriptionID, netCreds, &netOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -80,42 +130,195 @@ func NewAzCLI(cfg *config.Config) (*AzureCli, error) {
 		return nil, err
 	}
 
+	disksClient, err := armcompute.NewDisksClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	hostGroupsClient, err := armcompute.NewDedicatedHostGroupsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	hostsClient, err := armcompute.NewDedicatedHostsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	vmssClient, err := armcompute.NewVirtualMachineScaleSetsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
 	extClient, err := armcompute.NewVirtualMachineExtensionsClient(cfg.Credentials.SubscriptionID, creds, &opts)
 	if err != nil {
 		return nil, err
 	}
+
+	tagsClient, err := armresources.NewTagsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentsClient, err := armresources.NewDeploymentsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	resourcesClient, err := armresources.NewClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Side effects, like DNS records, may use their own, narrowly scoped, credentials.
+	sideEffectsClient := resourcesClient
+	if cfg.SideEffectCredentials != nil {
+		sideEffectCreds, sideEffectOpts, err := clientCredentials(cfg, *cfg.SideEffectCredentials)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get side effect credentials: %w", err)
+		}
+		sideEffectsClient, err = armresources.NewClient(cfg.SideEffectCredentials.SubscriptionID, sideEffectCreds, &sideEffectOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	imagesClient, err := armcompute.NewImagesClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	vmImagesClient, err := armcompute.NewVirtualMachineImagesClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	galleriesClient, err := armcompute.NewGalleriesClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	galleryImagesClient, err := armcompute.NewGalleryImagesClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	galleryImageVersionsClient, err := armcompute.NewGalleryImageVersionsClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	securityRulesClient, err := armnetwork.NewSecurityRulesClient(netSubscriptionID, netCreds, &netOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	usageClient, err := armcompute.NewUsageClient(cfg.Credentials.SubscriptionID, creds, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	networkUsagesClient, err := armnetwork.NewUsagesClient(netSubscriptionID, netCreds, &netOpts)
+	if err != nil {
+		return nil, err
+	}
+	var netRGCli *armresources.ResourceGroupsClient
+	if cfg.NetworkCredentials != nil {
+		netRGCli, err = armresources.NewResourceGroupsClient(netSubscriptionID, netCreds, &netOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	azCli := &AzureCli{
-		cfg:            cfg,
-		cred:           creds,
-		rgCli:          resourceGroupClient,
-		netCli:         netCli,
-		subnetCli:      subnetClient,
-		nsgCli:         nsgClient,
-		nicCli:         nicClient,
-		vmCli:          vmClient,
-		pubIPCli:       publicIPcli,
-		extCli:         extClient,
-		location:       cfg.Location,
-		resourceSKUCli: skuCLI,
+		cfg:                 cfg,
+		cred:                creds,
+		rgCli:               resourceGroupClient,
+		netRGCli:            netRGCli,
+		netCli:              netCli,
+		subnetCli:           subnetClient,
+		nsgCli:              nsgClient,
+		nicCli:              nicClient,
+		vmCli:               vmClient,
+		pubIPCli:            publicIPcli,
+		pubIPPrefixCli:      publicIPPrefixClient,
+		natCli:              natGatewaysClient,
+		extCli:              extClient,
+		disksCli:            disksClient,
+		hostGroupsCli:       hostGroupsClient,
+		hostsCli:            hostsClient,
+		vmssCli:             vmssClient,
+		location:            cfg.Location,
+		sharedResourceGroup: cfg.SharedResourceGroup,
+		resourceSKUCli:      skuCLI,
+		tagsCli:             tagsClient,
+		deploymentsCli:      deploymentsClient,
+		resourcesCli:        resourcesClient,
+		sideEffectsCli:      sideEffectsClient,
+		imagesCli:           imagesClient,
+		vmImagesCli:         vmImagesClient,
+		galleriesCli:        galleriesClient,
+		galleryImgCli:       galleryImagesClient,
+		galleryVerCli:       galleryImageVersionsClient,
+		secRulesCli:         securityRulesClient,
+		usageCli:            usageClient,
+		netUsageCli:         networkUsagesClient,
 	}
 	return azCli, nil
 }
 
+// WithLocation returns a copy of the client that creates resources in another location.
+func (a *AzureCli) WithLocation(location string) *AzureCli {
+	withLocation := *a
+	withLocation.location = location
+	return &withLocation
+}
+
+// Location returns the location the client creates resources in.
+func (a *AzureCli) Location() string {
+	return a.location
+}
+
 type AzureCli struct {
 	cfg  *config.Config
 	cred azcore.TokenCredential
 
-	rgCli          *armresources.ResourceGroupsClient
+	rgCli *armresources.ResourceGroupsClient
+	// netRGCli manages the resource groups holding the network resources, when they are
+	// in a separate subscription. It is nil otherwise.
+	netRGCli       *armresources.ResourceGroupsClient
 	netCli         *armnetwork.VirtualNetworksClient
 	subnetCli      *armnetwork.SubnetsClient
 	nsgCli         *armnetwork.SecurityGroupsClient
 	nicCli         *armnetwork.InterfacesClient
 	vmCli          *armcompute.VirtualMachinesClient
 	pubIPCli       *armnetwork.PublicIPAddressesClient
+	pubIPPrefixCli *armnetwork.PublicIPPrefixesClient
+	natCli         *armnetwork.NatGatewaysClient
 	extCli         *armcompute.VirtualMachineExtensionsClient
+	disksCli       *armcompute.DisksClient
+	hostGroupsCli  *armcompute.DedicatedHostGroupsClient
+	hostsCli       *armcompute.DedicatedHostsClient
+	vmssCli        *armcompute.VirtualMachineScaleSetsClient
 	resourceSKUCli *armcompute.ResourceSKUsClient
+	tagsCli        *armresources.TagsClient
+	deploymentsCli *armresources.DeploymentsClient
+	resourcesCli   *armresources.Client
+	// sideEffectsCli manages resources outside of the runners, like DNS records.
+	sideEffectsCli *armresources.Client
+	imagesCli      *armcompute.ImagesClient
+	vmImagesCli    *armcompute.VirtualMachineImagesClient
+	galleriesCli   *armcompute.GalleriesClient
+	galleryImgCli  *armcompute.GalleryImagesClient
+	galleryVerCli  *armcompute.GalleryImageVersionsClient
+	secRulesCli    *armnetwork.SecurityRulesClient
+	usageCli       *armcompute.UsageClient
+	netUsageCli    *armnetwork.UsagesClient
 
 	location string
+	// sharedResourceGroup holds the resources of the runners in shared resource group
+	// mode. It is the configured one, or the one of the pool of a runner.
+	sharedResourceGroup string
 }
 
 func (a *AzureCli) CreateResourceGroup(ctx context.Context, name string, tags map[string]*string) (*armresources.ResourceGroup, error) {
@@ -129,12 +332,44 @@ func (a *AzureCli) CreateResourceGroup(ctx context.Context, name string, tags ma
 		return nil, err
 	}
 
+	// The network resources go in a resource group with the same name, in the network
+	// subscription.
+	if a.netRGCli != nil {
+		if _, err := a.netRGCli.CreateOrUpdate(ctx, name, parameters, nil); err != nil {
+			return nil, fmt.Errorf("failed to create network resource group: %w", err)
+		}
+	}
+
 	return &resp.ResourceGroup, nil
 }
 
-func (a *AzureCli) CreateVirtualNetwork(ctx context.Context, baseName, spaceCIDR string) (*armnetwork.VirtualNetwork, error) {
-	parameters := armnetwork.VirtualNetwork{
+// GetResourceGroup returns a resource group, or nil if it does not exist.
+func (a *AzureCli) GetResourceGroup(ctx context.Context, name string) (*armresources.ResourceGroup, error) {
+	resp, err := a.rgCli.Get(ctx, name, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &resp.ResourceGroup, nil
+}
+
+// networkResourceID returns the ID of a network resource in a resource group. It is used
+// to reference resources we did not wait for.
+func (a *AzureCli) networkResourceID(rgName string, segments ...string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/%s", a.cfg.GetNetworkCredentials().SubscriptionID, rgName, strings.Join(segments, "/"))
+}
+
+// virtualMachineID returns the ID of the VM of an instance.
+func (a *AzureCli) virtualMachineID(name string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s", a.cfg.Credentials.SubscriptionID, a.InstanceResourceGroup(name), name)
+}
+
+func (a *AzureCli) virtualNetworkParams(spaceCIDR string, tags map[string]*string) armnetwork.VirtualNetwork {
+	return armnetwork.VirtualNetwork{
 		Location: to.Ptr(a.location),
+		Tags:     tags,
 		Properties: &armnetwork.VirtualNetworkPropertiesFormat{
 			AddressSpace: &armnetwork.AddressSpace{
 				AddressPrefixes: []*string{
@@ -143,12 +378,20 @@ func (a *AzureCli) CreateVirtualNetwork(ctx context.Context, baseName, spaceCIDR
 			},
 		},
 	}
+}
 
-	pollerResponse, err := a.netCli.BeginCreateOrUpdate(ctx, baseName, baseName, parameters, nil)
+func (a *AzureCli) CreateVirtualNetwork(ctx context.Context, baseName, spaceCIDR string, tags map[string]*string) (*armnetwork.VirtualNetwork, error) {
+	parameters := a.virtualNetworkParams(spaceCIDR, tags)
+
+	pollerResponse, err := a.netCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(baseName), baseName, parameters, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if !a.cfg.WaitFor(config.PollResourceVirtualNetwork) {
+		return &armnetwork.VirtualNetwork{ID: to.Ptr(a.networkResourceID(a.InstanceResourceGroup(baseName), "virtualNetworks", baseName))}, nil
+	}
+
 	resp, err := pollerResponse.PollUntilDone(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -157,18 +400,26 @@ func (a *AzureCli) CreateVirtualNetwork(ctx context.Context, baseName, spaceCIDR
 	return &resp.VirtualNetwork, nil
 }
 
-func (a *AzureCli) CreateSubnet(ctx context.Context, baseName, subnetCIDR string) (*armnetwork.Subnet, error) {
-	parameters := armnetwork.Subnet{
+func (a *AzureCli) subnetParams(subnetCIDR string) armnetwork.Subnet {
+	return armnetwork.Subnet{
 		Properties: &armnetwork.SubnetPropertiesFormat{
 			AddressPrefix: to.Ptr(subnetCIDR),
 		},
 	}
+}
+
+func (a *AzureCli) CreateSubnet(ctx context.Context, baseName, subnetName, subnetCIDR string) (*armnetwork.Subnet, error) {
+	parameters := a.subnetParams(subnetCIDR)
 
-	pollerResponse, err := a.subnetCli.BeginCreateOrUpdate(ctx, baseName, baseName, baseName, parameters, nil)
+	pollerResponse, err := a.subnetCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(baseName), baseName, subnetName, parameters, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if !a.cfg.WaitFor(config.PollResourceSubnet) {
+		return &armnetwork.Subnet{ID: to.Ptr(a.networkResourceID(a.InstanceResourceGroup(baseName), "virtualNetworks", baseName, "subnets", subnetName))}, nil
+	}
+
 	resp, err := pollerResponse.PollUntilDone(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -177,24 +428,32 @@ func (a *AzureCli) CreateSubnet(ctx context.Context, baseName, subnetCIDR string
 	return &resp.Subnet, nil
 }
 
+func (a *AzureCli) networkSecurityGroupParams(spec *spec.RunnerSpec) armnetwork.SecurityGroup {
+	return armnetwork.SecurityGroup{
+		Location: to.Ptr(a.location),
+		Tags:     spec.NetworkTags,
+		Properties: &armnetwork.SecurityGroupPropertiesFormat{
+			SecurityRules: spec.SecurityRules(),
+		},
+	}
+}
+
 func (a *AzureCli) CreateNetworkSecurityGroup(ctx context.Context, baseName string, spec *spec.RunnerSpec) (*armnetwork.SecurityGroup, error) {
 	if spec == nil {
 		return nil, fmt.Errorf("invalid nil runner spec")
 	}
 
-	rules := spec.SecurityRules()
-	parameters := armnetwork.SecurityGroup{
-		Location: to.Ptr(a.location),
-		Properties: &armnetwork.SecurityGroupPropertiesFormat{
-			SecurityRules: rules,
-		},
-	}
+	parameters := a.networkSecurityGroupParams(spec)
 
-	pollerResponse, err := a.nsgCli.BeginCreateOrUpdate(ctx, baseName, baseName, parameters, nil)
+	pollerResponse, err := a.nsgCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(baseName), baseName, parameters, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if !a.cfg.WaitFor(config.PollResourceNetworkSecurityGroup) {
+		return &armnetwork.SecurityGroup{ID: to.Ptr(a.networkResourceID(a.InstanceResourceGroup(baseName), "networkSecurityGroups", baseName))}, nil
+	}
+
 	resp, err := pollerResponse.PollUntilDone(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -202,7 +461,7 @@ func (a *AzureCli) CreateNetworkSecurityGroup(ctx context.Context, baseName stri
 	return &resp.SecurityGroup, nil
 }
 
-func (a *AzureCli) CreateNetWorkInterface(ctx context.Context, baseName, subnetID, networkSecurityGroupID, publicIPID string, acceletatedNetworking bool) (*armnetwork.Interface, error) {
+func (a *AzureCli) networkInterfaceParams(subnetID, networkSecurityGroupID, publicIPID string, spec *spec.RunnerSpec) armnetwork.Interface {
 	interfaceIPConfig := &armnetwork.InterfaceIPConfigurationPropertiesFormat{
 		PrivateIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodDynamic),
 		Subnet: &armnetwork.Subnet{
@@ -216,27 +475,51 @@ func (a *AzureCli) CreateNetWorkInterface(ctx context.Context, baseName, subnetI
 		}
 	}
 
-	parameters := armnetwork.Interface{
+	var securityGroup *armnetwork.SecurityGroup
+	if networkSecurityGroupID != "" {
+		securityGroup = &armnetwork.SecurityGroup{
+			ID: to.Ptr(networkSecurityGroupID),
+		}
+	}
+
+	var auxiliaryMode *armnetwork.NetworkInterfaceAuxiliaryMode
+	if spec.NICAuxiliaryMode != "" {
+		auxiliaryMode = to.Ptr(spec.NICAuxiliaryMode)
+	}
+
+	return armnetwork.Interface{
 		Location: to.Ptr(a.location),
+		Tags:     spec.NetworkTags,
 		Properties: &armnetwork.InterfacePropertiesFormat{
-			EnableAcceleratedNetworking: to.Ptr(acceletatedNetworking),
+			EnableAcceleratedNetworking: to.Ptr(spec.UseAcceleratedNetworking),
+			AuxiliaryMode:               auxiliaryMode,
 			IPConfigurations: []*armnetwork.InterfaceIPConfiguration{
 				{
 					Name:       to.Ptr("ipConfig"),
 					Properties: interfaceIPConfig,
 				},
 			},
-			NetworkSecurityGroup: &armnetwork.SecurityGroup{
-				ID: to.Ptr(networkSecurityGroupID),
-			},
+			NetworkSecurityGroup: securityGroup,
 		},
 	}
+}
+
+func (a *AzureCli) CreateNetWorkInterface(ctx context.Context, baseName, subnetID, networkSecurityGroupID, publicIPID string, spec *spec.RunnerSpec) (*armnetwork.Interface, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("invalid nil runner spec")
+	}
+
+	parameters := a.networkInterfaceParams(subnetID, networkSecurityGroupID, publicIPID, spec)
 
-	pollerResponse, err := a.nicCli.BeginCreateOrUpdate(ctx, baseName, baseName, parameters, nil)
+	pollerResponse, err := a.nicCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(baseName), baseName, parameters, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if !a.cfg.WaitFor(config.PollResourceNetworkInterface) {
+		return &armnetwork.Interface{ID: to.Ptr(a.networkResourceID(a.InstanceResourceGroup(baseName), "networkInterfaces", baseName))}, nil
+	}
+
 	resp, err := pollerResponse.PollUntilDone(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -245,19 +528,44 @@ func (a *AzureCli) CreateNetWorkInterface(ctx context.Context, baseName, subnetI
 	return &resp.Interface, err
 }
 
-func (a *AzureCli) CreatePublicIP(ctx context.Context, baseName string) (*armnetwork.PublicIPAddress, error) {
-	parameters := armnetwork.PublicIPAddress{
+func (a *AzureCli) publicIPParams(spec *spec.RunnerSpec) armnetwork.PublicIPAddress {
+	params := armnetwork.PublicIPAddress{
 		Location: to.Ptr(a.location),
+		Tags:     spec.NetworkTags,
 		Properties: &armnetwork.PublicIPAddressPropertiesFormat{
 			PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
+			DeleteOption:             to.Ptr(armnetwork.DeleteOptions(spec.DeleteOptions.PublicIP)),
 		},
 	}
+	// Zonal VMs need a standard SKU public IP, in the same zone. Basic SKU public IPs
+	// can't be used in the subnet of a NAT gateway either.
+	if spec.Zone != "" || spec.NATGateway {
+		params.SKU = &armnetwork.PublicIPAddressSKU{
+			Name: to.Ptr(armnetwork.PublicIPAddressSKUNameStandard),
+		}
+	}
+	if spec.Zone != "" {
+		params.Zones = []*string{to.Ptr(spec.Zone)}
+	}
+	return params
+}
+
+func (a *AzureCli) CreatePublicIP(ctx context.Context, baseName string, spec *spec.RunnerSpec) (*armnetwork.PublicIPAddress, error) {
+	if spec == nil {
+		return nil, fmt.Errorf("invalid nil runner spec")
+	}
+
+	parameters := a.publicIPParams(spec)
 
-	pollerResponse, err := a.pubIPCli.BeginCreateOrUpdate(ctx, baseName, baseName, parameters, nil)
+	pollerResponse, err := a.pubIPCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(baseName), baseName, parameters, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	if !a.cfg.WaitFor(config.PollResourcePublicIP) {
+		return &armnetwork.PublicIPAddress{ID: to.Ptr(a.networkResourceID(a.InstanceResourceGroup(baseName), "publicIPAddresses", baseName))}, nil
+	}
+
 	resp, err := pollerResponse.PollUntilDone(ctx, nil)
 	if err != nil {
 		return nil, err
@@ -265,16 +573,36 @@ func (a *AzureCli) CreatePublicIP(ctx context.Context, baseName string) (*armnet
 	return &resp.PublicIPAddress, err
 }
 
-func (a *AzureCli) CreateVirtualMachine(ctx context.Context, spec *spec.RunnerSpec, networkInterfaceID string, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) error {
-	if spec == nil {
-		return fmt.Errorf("invalid nil runner spec")
+func (a *AzureCli) GetPublicIP(ctx context.Context, rgName, name string) (*armnetwork.PublicIPAddress, error) {
+	resp, err := a.pubIPCli.Get(ctx, rgName, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.PublicIPAddress, nil
+}
+
+// GetNetworkInterfaceByID returns a NIC in the network subscription.
+func (a *AzureCli) GetNetworkInterfaceByID(ctx context.Context, id string) (*armnetwork.Interface, error) {
+	resourceID, err := arm.ParseResourceID(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse NIC ID: %w", err)
 	}
+	if subscriptionID := a.cfg.GetNetworkCredentials().SubscriptionID; !strings.EqualFold(resourceID.SubscriptionID, subscriptionID) {
+		return nil, fmt.Errorf("NIC %s is not in the network subscription %s", id, subscriptionID)
+	}
+	resp, err := a.nicCli.Get(ctx, resourceID.ResourceGroupName, resourceID.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Interface, nil
+}
 
+func (a *AzureCli) virtualMachineParams(spec *spec.RunnerSpec, networkInterfaceID string, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) (armcompute.VirtualMachine, error) {
 	properties, err := spec.GetNewVMProperties(networkInterfaceID, sizeSpec)
 	if err != nil {
-		return fmt.Errorf("failed to get new VM properties: %w", err)
+		return armcompute.VirtualMachine{}, fmt.Errorf("failed to get new VM properties: %w", err)
 	}
-	parameters := armcompute.VirtualMachine{
+	vm := armcompute.VirtualMachine{
 		Location: to.Ptr(a.location),
 		Tags:     spec.Tags,
 		Identity: &armcompute.VirtualMachineIdentity{
@@ -282,20 +610,67 @@ func (a *AzureCli) CreateVirtualMachine(ctx context.Context, spec *spec.RunnerSp
 		},
 		Properties: properties,
 	}
+	if spec.Zone != "" {
+		vm.Zones = []*string{to.Ptr(spec.Zone)}
+	}
+	if spec.HostGroupID != "" {
+		vm.Properties.HostGroup = &armcompute.SubResource{
+			ID: to.Ptr(spec.HostGroupID),
+		}
+	}
+	if spec.ScaleSetID != "" {
+		vm.Properties.VirtualMachineScaleSet = &armcompute.SubResource{
+			ID: to.Ptr(spec.ScaleSetID),
+		}
+	}
+	if identities := spec.UserAssignedIdentities(); len(identities) > 0 {
+		identityType := armcompute.ResourceIdentityTypeUserAssigned
+		if spec.SystemAssignedIdentity() {
+			identityType = armcompute.ResourceIdentityTypeSystemAssignedUserAssigned
+		}
+		vm.Identity = &armcompute.VirtualMachineIdentity{
+			Type:                   to.Ptr(identityType),
+			UserAssignedIdentities: map[string]*armcompute.UserAssignedIdentitiesValue{},
+		}
+		for _, id := range identities {
+			vm.Identity.UserAssignedIdentities[id] = &armcompute.UserAssignedIdentitiesValue{}
+		}
+	} else if spec.SystemAssignedIdentity() {
+		vm.Identity.Type = to.Ptr(armcompute.ResourceIdentityTypeSystemAssigned)
+	}
+	return vm, nil
+}
+
+func (a *AzureCli) CreateVirtualMachine(ctx context.Context, spec *spec.RunnerSpec, networkInterfaceID string, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) error {
+	if spec == nil {
+		return fmt.Errorf("invalid nil runner spec")
+	}
+
+	parameters, err := a.virtualMachineParams(spec, networkInterfaceID, sizeSpec)
+	if err != nil {
+		return err
+	}
 
-	_, err = a.vmCli.BeginCreateOrUpdate(ctx, spec.BootstrapParams.Name, spec.BootstrapParams.Name, parameters, nil)
+	poller, err := a.vmCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(spec.BootstrapParams.Name), spec.BootstrapParams.Name, parameters, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create VM: %w", err)
 	}
 
-	extName := "CustomScriptExtension"
-	computeExtension, err := spec.GetVMExtension(a.location, extName)
+	// Spot allocation failures are only reported once the VM create operation finishes, and
+	// the performance tier can only be set once the OS disk exists.
+	if a.cfg.WaitFor(config.PollResourceVirtualMachine) || spec.UseSpot() || spec.DiskPerformanceTier != "" || spec.SpecializedImage {
+		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			return fmt.Errorf("failed to create VM: %w", err)
+		}
+	}
+
+	computeExtension, err := spec.GetVMExtension(a.location, vmExtensionName)
 	if err != nil {
 		return fmt.Errorf("failed to get vm extension: %w", err)
 	}
 
 	if computeExtension != nil {
-		_, err = a.extCli.BeginCreateOrUpdate(ctx, spec.BootstrapParams.Name, spec.BootstrapParams.Name, extName, *computeExtension, nil)
+		_, err = a.extCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(spec.BootstrapParams.Name), spec.BootstrapParams.Name, vmExtensionName, *computeExtension, nil)
 		if err != nil {
 			return fmt.Errorf("failed to create vm extension: %w", err)
 		}
@@ -304,96 +679,330 @@ func (a *AzureCli) CreateVirtualMachine(ctx context.Context, spec *spec.RunnerSp
 	return nil
 }
 
+// SetOSDiskPerformanceTier sets the performance tier of the OS disk of a runner.
+func (a *AzureCli) SetOSDiskPerformanceTier(ctx context.Context, spec *spec.RunnerSpec) error {
+	update := armcompute.DiskUpdate{
+		Properties: &armcompute.DiskUpdateProperties{
+			Tier: to.Ptr(spec.DiskPerformanceTier),
+		},
+	}
+	poller, err := a.disksCli.BeginUpdate(ctx, a.InstanceResourceGroup(spec.BootstrapParams.Name), spec.BootstrapParams.Name, update, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update OS disk: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to update OS disk: %w", err)
+	}
+	return nil
+}
+
 func (a *AzureCli) GetMaxEphemeralDiskSize(ctx context.Context, vmSize string) (spec.VMSizeEphemeralDiskSizeLimits, error) {
-	opts := &armcompute.ResourceSKUsClientListOptions{
-		Filter: to.Ptr(fmt.Sprintf("location eq '%s'", a.location)),
+	size, err := a.GetVMSize(ctx, vmSize)
+	if err != nil {
+		return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("failed to get VM size details: %w", err)
 	}
-	pager := a.resourceSKUCli.NewListPager(opts)
-	for pager.More() {
-		resp, err := pager.NextPage(ctx)
-		if err != nil {
-			return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("failed to get VM size details: %w", err)
-		}
-		if resp.ResourceSKUsResult.Value != nil {
-			for _, val := range resp.ResourceSKUsResult.Value {
-				if *val.ResourceType == "virtualMachines" && *val.Name == vmSize {
-					var ephemeralSupported string
-					var cacheBytes string
-					var resourceDiskMB string
-					for _, capability := range val.Capabilities {
-						switch *capability.Name {
-						case "EphemeralOSDiskSupported":
-							ephemeralSupported = *capability.Value
-						case "CachedDiskBytes":
-							cacheBytes = *capability.Value
-						case "MaxResourceVolumeMB":
-							resourceDiskMB = *capability.Value
-						}
-					}
-					var res spec.VMSizeEphemeralDiskSizeLimits
-					if ephemeralSupported == "True" {
-						if cacheBytes != "" {
-							asInt64, err := strconv.ParseInt(cacheBytes, 10, 64)
-							if err != nil {
-								return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("failed to parse cache bytes: %w", err)
-							}
-							inGB := asInt64 / 1024 / 1024 / 1024
-							res.CacheDiskSizeGB = int32(inGB)
-						}
-
-						if resourceDiskMB != "" {
-							asInt64, err := strconv.ParseInt(resourceDiskMB, 10, 64)
-							if err != nil {
-								return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("failed to parse resource disk MB: %w", err)
-							}
-							inGB := asInt64 / 1024
-							res.ResourceDiskSizeGB = int32(inGB)
-						}
-						if res.CacheDiskSizeGB != 0 || res.ResourceDiskSizeGB != 0 {
-							return res, nil
-						}
-					}
-				}
-			}
+	return size.EphemeralDiskSizeLimits()
+}
+
+// isNotFound returns true if err is an API error with a 404 status code.
+func isNotFound(err error) bool {
+	asRespCode, ok := err.(*azcore.ResponseError)
+	return ok && asRespCode.StatusCode == http.StatusNotFound
+}
+
+// IsNotFound returns true if err wraps an API error with a 404 status code.
+func IsNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// allocationFailureCodes are the error codes Azure returns when there is no capacity for
+// a VM. Spot VMs are much more likely to run into these.
+var allocationFailureCodes = []string{
+	"AllocationFailed",
+	"ZonalAllocationFailed",
+	"OverconstrainedAllocationRequest",
+	"OverconstrainedZonalAllocationRequest",
+	"SkuNotAvailable",
+}
+
+// IsAllocationFailure returns true if err was caused by a lack of capacity for the VM. The
+// code may be nested in the details of a failed operation or deployment, so the whole
+// error message is searched.
+func IsAllocationFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	for _, code := range allocationFailureCodes {
+		if strings.Contains(msg, code) {
+			return true
 		}
 	}
-	return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("failed to get VM size details for %s", vmSize)
+	return false
 }
 
+// FailureCategory groups the failures that are not specific to a single runner, and are
+// likely to fail every create until they are fixed.
+type FailureCategory string
+
+const (
+	// FailureCategoryAuth covers rejected credentials and missing permissions.
+	FailureCategoryAuth FailureCategory = "auth"
+	// FailureCategoryOutage covers Azure failing to serve requests in the location.
+	FailureCategoryOutage FailureCategory = "outage"
+)
+
+// authFailureCodes are the error codes Azure returns when the credentials are rejected, or
+// the subscription can't be used.
+var authFailureCodes = []string{
+	"AuthorizationFailed",
+	"InvalidAuthenticationToken",
+	"ExpiredAuthenticationToken",
+	"AuthenticationFailed",
+	"LinkedAuthorizationFailed",
+	"SubscriptionNotFound",
+	"ReadOnlyDisabledSubscription",
+	"AADSTS",
+}
+
+// outageFailureCodes are the error codes of failed operations and deployments that
+// indicate Azure itself is failing.
+var outageFailureCodes = []string{
+	"InternalServerError",
+	"InternalOperationError",
+	"ServiceUnavailable",
+	"ServerTimeout",
+	"GatewayTimeout",
+}
+
+// ClassifyFailure returns the category of err, or an empty category if err is specific to
+// the runner, or not an Azure failure. Like allocation failures, the codes may be nested in
+// the details of a failed operation, so the whole error message is searched.
+func ClassifyFailure(err error) FailureCategory {
+	if err == nil {
+		return ""
+	}
+	var authErr *azidentity.AuthenticationFailedError
+	if errors.As(err, &authErr) {
+		return FailureCategoryAuth
+	}
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch {
+		case respErr.StatusCode == http.StatusUnauthorized:
+			return FailureCategoryAuth
+		case respErr.StatusCode >= http.StatusInternalServerError:
+			return FailureCategoryOutage
+		}
+	}
+	msg := err.Error()
+	for _, code := range authFailureCodes {
+		if strings.Contains(msg, code) {
+			return FailureCategoryAuth
+		}
+	}
+	for _, code := range outageFailureCodes {
+		if strings.Contains(msg, code) {
+			return FailureCategoryOutage
+		}
+	}
+	return ""
+}
+
+// IsTransientFailure returns true if err is likely to go away when the same request is sent
+// again shortly after: throttling, and Azure failing to serve the request.
+func IsTransientFailure(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return ClassifyFailure(err) == FailureCategoryOutage
+}
+
+// DeleteError is returned when a resource group can't be deleted. It holds the Azure error
+// code, along with a hint on how to fix the problem.
+type DeleteError struct {
+	ResourceGroup string
+	Code          string
+	Hint          string
+	Err           error
+}
+
+func (d *DeleteError) Error() string {
+	return fmt.Sprintf("failed to delete resource group %s (%s): %s; %s", d.ResourceGroup, d.Code, d.Err, d.Hint)
+}
+
+func (d *DeleteError) Unwrap() error {
+	return d.Err
+}
+
+const (
+	deleteMaxAttempts    = 5
+	deleteInitialBackoff = 5 * time.Second
+	deleteMaxBackoff     = 1 * time.Minute
+	// deleteMaxLockAttempts bounds how often locks are removed before giving up, in case
+	// something keeps putting them back.
+	deleteMaxLockAttempts = 3
+)
+
+// DeleteResourceGroup deletes a resource group and everything in it. Conflicts caused by
+// operations in progress are retried with a backoff. Management locks are removed if
+// the provider is configured to do so.
 func (a *AzureCli) DeleteResourceGroup(ctx context.Context, resourceGroup string, forceDelete bool) error {
-	opts := &armresources.ResourceGroupsClientBeginDeleteOptions{}
-	if forceDelete {
-		opts.ForceDeletionTypes = to.Ptr("Microsoft.Compute/virtualMachines,Microsoft.Compute/virtualMachineScaleSets")
+	backoff := deleteInitialBackoff
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to delete resource group: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > deleteMaxBackoff {
+			backoff = deleteMaxBackoff
+		}
+		return nil
 	}
 
-	pollerResponse, err := a.rgCli.BeginDelete(ctx, resourceGroup, opts)
-	if err != nil {
-		asRespCode, ok := err.(*azcore.ResponseError)
-		if ok {
-			if asRespCode.StatusCode == http.StatusNotFound {
-				return nil
-			}
+	var lockAttempts int
+	for attempt := 1; ; attempt++ {
+		err := a.deleteResourceGroup(ctx, resourceGroup, forceDelete)
+		if err == nil {
+			return nil
+		}
+
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) {
+			return fmt.Errorf("failed to delete resource group: %w", err)
+		}
+
+		switch {
+		case respErr.ErrorCode == "UnsupportedForceDeletionResourceTypeInQueryString":
 			// We may not have a VM created yet, so force delete will fail. Retry without force delete.
-			if asRespCode.ErrorCode == "UnsupportedForceDeletionResourceTypeInQueryString" {
-				return a.DeleteResourceGroup(ctx, resourceGroup, false)
+			forceDelete = false
+			continue
+		case respErr.ErrorCode == "ScopeLocked":
+			if !a.cfg.RemoveLocksOnDelete {
+				return &DeleteError{
+					ResourceGroup: resourceGroup,
+					Code:          respErr.ErrorCode,
+					Hint:          "remove the management locks on the resource group, or set remove_locks_on_delete in the provider config",
+					Err:           err,
+				}
+			}
+			lockAttempts++
+			if lockAttempts > deleteMaxLockAttempts {
+				return &DeleteError{
+					ResourceGroup: resourceGroup,
+					Code:          respErr.ErrorCode,
+					Hint:          "the resource group is still locked after removing its locks; check for locks inherited from the subscription, or placed again by a policy",
+					Err:           err,
+				}
 			}
+			removed, lockErr := a.removeResourceGroupLocks(ctx, resourceGroup)
+			if lockErr != nil {
+				return fmt.Errorf("failed to remove locks: %w", lockErr)
+			}
+			if removed == 0 {
+				return &DeleteError{
+					ResourceGroup: resourceGroup,
+					Code:          respErr.ErrorCode,
+					Hint:          "the lock is inherited from the subscription, and is not removed by the provider",
+					Err:           err,
+				}
+			}
+			log.Printf("removed %d management locks on resource group %s, retrying in %s", removed, resourceGroup, backoff)
+			if err := wait(); err != nil {
+				return err
+			}
+		case respErr.ErrorCode == "RequestDisallowedByPolicy":
+			return &DeleteError{
+				ResourceGroup: resourceGroup,
+				Code:          respErr.ErrorCode,
+				Hint:          "an Azure Policy denies the deletion; exempt the garm resource groups from the policy",
+				Err:           err,
+			}
+		case respErr.StatusCode == http.StatusForbidden:
+			return &DeleteError{
+				ResourceGroup: resourceGroup,
+				Code:          respErr.ErrorCode,
+				Hint:          "the provider credentials are not allowed to delete the resource group; check the role assignments",
+				Err:           err,
+			}
+		case respErr.StatusCode == http.StatusConflict:
+			if attempt >= deleteMaxAttempts {
+				return &DeleteError{
+					ResourceGroup: resourceGroup,
+					Code:          respErr.ErrorCode,
+					Hint:          "another operation is still in progress on the resource group; garm will retry the deletion later",
+					Err:           err,
+				}
+			}
+			log.Printf("resource group %s is busy (%s), retrying in %s", resourceGroup, respErr.ErrorCode, backoff)
+			if err := wait(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("failed to delete resource group: %w", err)
 		}
-		return fmt.Errorf("failed to delete resource group: %w", err)
 	}
+}
 
-	_, err = pollerResponse.PollUntilDone(ctx, nil)
-	if err != nil {
+// StartResourceGroupDelete starts deleting a resource group, without waiting for the
+// deletion to finish. With separate network credentials, the network resources can only
+// be removed once the VM is gone, so this waits for the whole deletion instead.
+func (a *AzureCli) StartResourceGroupDelete(ctx context.Context, resourceGroup string) error {
+	if err := a.UnlockResourceGroup(ctx, resourceGroup); err != nil {
+		return fmt.Errorf("failed to unlock resource group: %w", err)
+	}
+	if a.netRGCli != nil {
+		return a.DeleteResourceGroup(ctx, resourceGroup, true)
+	}
+	opts := &armresources.ResourceGroupsClientBeginDeleteOptions{
+		ForceDeletionTypes: to.Ptr("Microsoft.Compute/virtualMachines,Microsoft.Compute/virtualMachineScaleSets"),
+	}
+	if _, err := a.rgCli.BeginDelete(ctx, resourceGroup, opts); err != nil && !isNotFound(err) {
 		return fmt.Errorf("failed to delete resource group: %w", err)
 	}
+	return nil
+}
+
+func (a *AzureCli) deleteResourceGroup(ctx context.Context, resourceGroup string, forceDelete bool) error {
+	opts := &armresources.ResourceGroupsClientBeginDeleteOptions{}
+	if forceDelete {
+		opts.ForceDeletionTypes = to.Ptr("Microsoft.Compute/virtualMachines,Microsoft.Compute/virtualMachineScaleSets")
+	}
 
+	if err := waitResourceGroupDelete(ctx, a.rgCli, resourceGroup, opts); err != nil {
+		return err
+	}
+	// The NIC in the network resource group is released along with the VM, so the network
+	// resource group is deleted last. A failure is retried along with the whole deletion,
+	// as deleting the already removed compute resource group is a no-op.
+	if a.netRGCli != nil {
+		return waitResourceGroupDelete(ctx, a.netRGCli, resourceGroup, nil)
+	}
 	return nil
 }
 
+func waitResourceGroupDelete(ctx context.Context, rgCli *armresources.ResourceGroupsClient, resourceGroup string, opts *armresources.ResourceGroupsClientBeginDeleteOptions) error {
+	pollerResponse, err := rgCli.BeginDelete(ctx, resourceGroup, opts)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = pollerResponse.PollUntilDone(ctx, nil)
+	return err
+}
+
 func (a *AzureCli) GetInstance(ctx context.Context, rgName, vmName string) (armcompute.VirtualMachine, error) {
 	opts := &armcompute.VirtualMachinesClientGetOptions{
 		Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView),
 	}
-	vm, err := a.vmCli.Get(ctx, rgName, rgName, opts)
+	vm, err := a.vmCli.Get(ctx, rgName, vmName, opts)
 	if err != nil {
 		return armcompute.VirtualMachine{}, fmt.Errorf("failed to get VM: %w", err)
 	}
@@ -412,8 +1021,17 @@ func (a *AzureCli) DealocateVM(ctx context.Context, rgName, vmName string) error
 	return nil
 }
 
+// SimulateEviction asks Azure to evict a spot VM, as if the capacity was reclaimed. The
+// eviction happens asynchronously, within about 30 seconds.
+func (a *AzureCli) SimulateEviction(ctx context.Context, rgName, vmName string) error {
+	if _, err := a.vmCli.SimulateEviction(ctx, rgName, vmName, nil); err != nil {
+		return fmt.Errorf("failed to simulate eviction: %w", err)
+	}
+	return nil
+}
+
 func (a *AzureCli) StartVM(ctx context.Context, vmName string) error {
-	poller, err := a.vmCli.BeginStart(ctx, vmName, vmName, nil)
+	poller, err := a.vmCli.BeginStart(ctx, a.InstanceResourceGroup(vmName), vmName, nil)
 	if err != nil {
 		return fmt.Errorf("failed to start VM: %w", err)
 	}
@@ -426,6 +1044,12 @@ func (a *AzureCli) StartVM(ctx context.Context, vmName string) error {
 }
 
 func (a *AzureCli) ListVirtualMachines(ctx context.Context, poolID string) ([]*armcompute.VirtualMachine, error) {
+	return a.ListVirtualMachinesWithTag(ctx, util.PoolIDTagName, poolID)
+}
+
+// ListVirtualMachinesWithTag returns the virtual machines in the subscription that have
+// the tag set to value.
+func (a *AzureCli) ListVirtualMachinesWithTag(ctx context.Context, tagName, value string) ([]*armcompute.VirtualMachine, error) {
 	options := &armcompute.VirtualMachinesClientListAllOptions{}
 	var resp []*armcompute.VirtualMachine
 	pager := a.vmCli.NewListAllPager(options)
@@ -440,8 +1064,8 @@ func (a *AzureCli) ListVirtualMachines(ctx context.Context, poolID string) ([]*a
 				if vm.Tags == nil {
 					continue
 				}
-				tag, ok := vm.Tags[util.PoolIDTagName]
-				if !ok || *tag != poolID {
+				tag, ok := vm.Tags[tagName]
+				if !ok || tag == nil || *tag != value {
 					continue
 				}
 				resp = append(resp, vm)
@@ -450,3 +1074,155 @@ func (a *AzureCli) ListVirtualMachines(ctx context.Context, poolID string) ([]*a
 	}
 	return resp, nil
 }
+
+// UpdateResourceTags merges the supplied tags into the existing tags of the resource
+// identified by resourceID.
+func (a *AzureCli) UpdateResourceTags(ctx context.Context, resourceID string, tags map[string]*string) error {
+	parameters := armresources.TagsPatchResource{
+		Operation: to.Ptr(armresources.TagsPatchOperationMerge),
+		Properties: &armresources.Tags{
+			Tags: tags,
+		},
+	}
+	if _, err := a.tagsCli.UpdateAtScope(ctx, resourceID, parameters, nil); err != nil {
+		return fmt.Errorf("failed to update tags: %w", err)
+	}
+	return nil
+}
+
+// RetagInstance merges the supplied tags into the tags of a runner VM and of its resource
+// group. The resource group is updated last, so a failed update can be retried. The shared
+// resource group is left alone.
+func (a *AzureCli) RetagInstance(ctx context.Context, vm *armcompute.VirtualMachine, tags map[string]*string) error {
+	if vm.ID == nil || vm.Name == nil {
+		return fmt.Errorf("VM has no ID")
+	}
+	if err := a.UpdateResourceTags(ctx, *vm.ID, tags); err != nil {
+		return fmt.Errorf("failed to update VM: %w", err)
+	}
+	if a.cfg.SharedResourceGroup != "" {
+		return nil
+	}
+	rgID := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", a.cfg.Credentials.SubscriptionID, *vm.Name)
+	if err := a.UpdateResourceTags(ctx, rgID, tags); err != nil {
+		return fmt.Errorf("failed to update resource group: %w", err)
+	}
+	return nil
+}
+
+// DeleteVirtualMachine deletes a VM, and waits for it to be gone. A VM that doesn't exist
+// is not an error.
+func (a *AzureCli) DeleteVirtualMachine(ctx context.Context, rgName, vmName string) error {
+	poller, err := a.vmCli.BeginDelete(ctx, rgName, vmName, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete VM: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to delete VM: %w", err)
+	}
+	return nil
+}
+
+// instanceTagScope returns the ID of the resource holding the tags of an instance. This is
+// its resource group, or its VM when it lives in the shared resource group.
+func (a *AzureCli) instanceTagScope(name string) string {
+	if a.cfg.SharedResourceGroup != "" {
+		return a.virtualMachineID(name)
+	}
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", a.cfg.Credentials.SubscriptionID, name)
+}
+
+// SetInstanceTags merges the supplied tags into the tags of the resource group of an
+// instance.
+func (a *AzureCli) SetInstanceTags(ctx context.Context, name string, tags map[string]*string) error {
+	return a.UpdateResourceTags(ctx, a.instanceTagScope(name), tags)
+}
+
+// SetInstanceState records the lifecycle state of an instance on its resource group. It
+// is a no-op if the resource group no longer exists.
+func (a *AzureCli) SetInstanceState(ctx context.Context, name, state string) error {
+	rgID := a.instanceTagScope(name)
+	parameters := armresources.TagsPatchResource{
+		Operation: to.Ptr(armresources.TagsPatchOperationMerge),
+		Properties: &armresources.Tags{
+			Tags: util.InstanceStateTags(state, time.Now()),
+		},
+	}
+	if _, err := a.tagsCli.UpdateAtScope(ctx, rgID, parameters, nil); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to record instance state: %w", err)
+	}
+	return nil
+}
+
+// ListResourceGroupsWithTag returns the resource groups that have a tag set to value.
+func (a *AzureCli) ListResourceGroupsWithTag(ctx context.Context, tagName, value string) ([]*armresources.ResourceGroup, error) {
+	options := &armresources.ResourceGroupsClientListOptions{
+		Filter: to.Ptr(fmt.Sprintf("tagName eq '%s' and tagValue eq '%s'", tagName, value)),
+	}
+	var resp []*armresources.ResourceGroup
+	pager := a.rgCli.NewListPager(options)
+	for pager.More() {
+		nextResult, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resource groups: %w", err)
+		}
+		resp = append(resp, nextResult.Value...)
+	}
+	return resp, nil
+}
+
+// RunShellScript runs the supplied script on a Linux VM using Run Command and
+// returns the combined message of the command.
+func (a *AzureCli) RunShellScript(ctx context.Context, rgName, vmName string, script ...string) (string, error) {
+	return a.runCommand(ctx, rgName, vmName, "RunShellScript", script...)
+}
+
+// RunPowerShellScript runs a PowerShell script on a Windows VM, using Run Command, and
+// returns its output.
+func (a *AzureCli) RunPowerShellScript(ctx context.Context, rgName, vmName string, script ...string) (string, error) {
+	return a.runCommand(ctx, rgName, vmName, "RunPowerShellScript", script...)
+}
+
+func (a *AzureCli) runCommand(ctx context.Context, rgName, vmName, commandID string, script ...string) (string, error) {
+	parameters := armcompute.RunCommandInput{
+		CommandID: to.Ptr(commandID),
+		Script:    to.SliceOfPtrs(script...),
+	}
+	poller, err := a.vmCli.BeginRunCommand(ctx, rgName, vmName, parameters, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to run command: %w", err)
+	}
+
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to run command: %w", err)
+	}
+
+	var ret []string
+	for _, val := range resp.Value {
+		if val != nil && val.Message != nil {
+			ret = append(ret, *val.Message)
+		}
+	}
+	return strings.Join(ret, "\n"), nil
+}
+
+// GetCloudInitStatus returns the cloud-init status reported by the VM, along with the
+// full output of the status command.
+func (a *AzureCli) GetCloudInitStatus(ctx context.Context, rgName, vmName string) (string, string, error) {
+	out, err := a.RunShellScript(ctx, rgName, vmName, "cloud-init status --long || true")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get cloud-init status: %w", err)
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "status:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "status:")), out, nil
+		}
+	}
+	return "", out, fmt.Errorf("failed to parse cloud-init status")
+}
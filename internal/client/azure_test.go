@@ -0,0 +1,104 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// testResponseError returns the error the SDK returns for an ARM response with the given
+// status and error code.
+func testResponseError(t *testing.T, status int, code string) error {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, "https://management.azure.com/subscriptions/sub/resourceGroups/garm-runner", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %s", err)
+	}
+	body := fmt.Sprintf(`{"error": {"code": %q, "message": "test failure"}}`, code)
+	return runtime.NewResponseError(&http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	})
+}
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want FailureCategory
+	}{
+		{name: "no error", err: nil},
+		{name: "credential failure", err: &azidentity.AuthenticationFailedError{}, want: FailureCategoryAuth},
+		{name: "wrapped credential failure", err: fmt.Errorf("failed to create VM: %w", &azidentity.AuthenticationFailedError{}), want: FailureCategoryAuth},
+		{name: "unauthorized", err: testResponseError(t, http.StatusUnauthorized, "InvalidAuthenticationToken"), want: FailureCategoryAuth},
+		{name: "missing permissions", err: testResponseError(t, http.StatusForbidden, "AuthorizationFailed"), want: FailureCategoryAuth},
+		{name: "disabled subscription", err: testResponseError(t, http.StatusConflict, "ReadOnlyDisabledSubscription"), want: FailureCategoryAuth},
+		{name: "entra ID error in a message", err: errors.New("AADSTS7000215: Invalid client secret provided"), want: FailureCategoryAuth},
+		{name: "server error", err: testResponseError(t, http.StatusInternalServerError, "InternalServerError"), want: FailureCategoryOutage},
+		{name: "service unavailable", err: testResponseError(t, http.StatusServiceUnavailable, "ServiceUnavailable"), want: FailureCategoryOutage},
+		{name: "wrapped server error", err: fmt.Errorf("failed to create NIC: %w", testResponseError(t, http.StatusBadGateway, "")), want: FailureCategoryOutage},
+		{name: "failed operation with an internal error", err: errors.New(`failed to create VM: Code="InternalOperationError" Message="An internal execution error occurred."`), want: FailureCategoryOutage},
+		{name: "allocation failure", err: testResponseError(t, http.StatusConflict, "AllocationFailed")},
+		{name: "quota exceeded", err: testResponseError(t, http.StatusConflict, "OperationNotAllowed")},
+		{name: "not found", err: testResponseError(t, http.StatusNotFound, "ResourceNotFound")},
+		{name: "throttled", err: testResponseError(t, http.StatusTooManyRequests, "TooManyRequests")},
+		{name: "other error", err: errors.New("failed to generate userdata")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyFailure(tt.err); got != tt.want {
+				t.Fatalf("ClassifyFailure() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTransientFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil},
+		{name: "throttled", err: testResponseError(t, http.StatusTooManyRequests, "TooManyRequests"), want: true},
+		{name: "wrapped throttled", err: fmt.Errorf("failed to create NIC: %w", testResponseError(t, http.StatusTooManyRequests, "")), want: true},
+		{name: "server error", err: testResponseError(t, http.StatusInternalServerError, "InternalServerError"), want: true},
+		{name: "failed operation with an internal error", err: errors.New(`Code="InternalOperationError"`), want: true},
+		{name: "credential failure", err: &azidentity.AuthenticationFailedError{}},
+		{name: "missing permissions", err: testResponseError(t, http.StatusForbidden, "AuthorizationFailed")},
+		{name: "allocation failure", err: testResponseError(t, http.StatusConflict, "AllocationFailed")},
+		{name: "bad request", err: testResponseError(t, http.StatusBadRequest, "InvalidParameter")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransientFailure(tt.err); got != tt.want {
+				t.Fatalf("IsTransientFailure() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
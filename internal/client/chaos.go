@@ -0,0 +1,138 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	// There is no Chaos Studio client in the vendored SDK, so targets and experiments are
+	// managed through the generic resources client.
+	chaosAPIVersion = "2023-11-01"
+	// chaosVMTargetType is the target type of service-direct faults on virtual machines.
+	chaosVMTargetType = "Microsoft-VirtualMachine"
+)
+
+// ChaosTargetID returns the ID of the Chaos Studio target of a runner VM.
+func (a *AzureCli) ChaosTargetID(name string) string {
+	return fmt.Sprintf("%s/providers/Microsoft.Chaos/targets/%s", a.virtualMachineID(name), chaosVMTargetType)
+}
+
+// EnableChaosTarget enrolls a runner VM as a Chaos Studio target, with the given fault
+// capabilities. The target is an extension resource of the VM, and is removed with it.
+func (a *AzureCli) EnableChaosTarget(ctx context.Context, name string, capabilities []string) error {
+	targetID := a.ChaosTargetID(name)
+	target := armresources.GenericResource{
+		Properties: map[string]interface{}{},
+	}
+	poller, err := a.resourcesCli.BeginCreateOrUpdateByID(ctx, targetID, chaosAPIVersion, target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create chaos target: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to create chaos target: %w", err)
+	}
+
+	for _, capability := range capabilities {
+		capabilityID := fmt.Sprintf("%s/capabilities/%s", targetID, capability)
+		poller, err := a.resourcesCli.BeginCreateOrUpdateByID(ctx, capabilityID, chaosAPIVersion, armresources.GenericResource{Properties: map[string]interface{}{}}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to enable chaos capability %s: %w", capability, err)
+		}
+		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			return fmt.Errorf("failed to enable chaos capability %s: %w", capability, err)
+		}
+	}
+	return nil
+}
+
+// SetChaosExperimentTargets points the list selectors of a Chaos Studio experiment to the
+// given targets. Query selectors are left alone.
+func (a *AzureCli) SetChaosExperimentTargets(ctx context.Context, experimentID string, targetIDs []string) error {
+	resp, err := a.resourcesCli.GetByID(ctx, experimentID, chaosAPIVersion, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get chaos experiment: %w", err)
+	}
+	experiment := resp.GenericResource
+	properties, ok := experiment.Properties.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("chaos experiment %s has no properties", experimentID)
+	}
+	selectors, _ := properties["selectors"].([]interface{})
+
+	targets := make([]interface{}, 0, len(targetIDs))
+	for _, id := range targetIDs {
+		targets = append(targets, map[string]interface{}{
+			"type": "ChaosTarget",
+			"id":   id,
+		})
+	}
+	var updated int
+	for _, selector := range selectors {
+		asMap, ok := selector.(map[string]interface{})
+		if !ok || !strings.EqualFold(fmt.Sprint(asMap["type"]), "List") {
+			continue
+		}
+		asMap["targets"] = targets
+		updated++
+	}
+	if updated == 0 {
+		return fmt.Errorf("chaos experiment %s has no list selector", experimentID)
+	}
+
+	poller, err := a.resourcesCli.BeginCreateOrUpdateByID(ctx, experimentID, chaosAPIVersion, experiment, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update chaos experiment: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to update chaos experiment: %w", err)
+	}
+	return nil
+}
+
+// StartChaosExperiment starts a Chaos Studio experiment, without waiting for it to finish.
+// The generic resources client can't invoke actions, so the request is sent through a
+// pipeline of our own.
+func (a *AzureCli) StartChaosExperiment(ctx context.Context, experimentID string) error {
+	endpoint, pl, err := a.rawPipeline()
+	if err != nil {
+		return err
+	}
+
+	req, err := runtime.NewRequest(ctx, http.MethodPost, runtime.JoinPaths(endpoint, experimentID, "start"))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", chaosAPIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+
+	resp, err := pl.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to start chaos experiment: %w", err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusAccepted) {
+		return runtime.NewResponseError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,444 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+)
+
+const (
+	deploymentTemplateSchema             = "https://schema.management.azure.com/schemas/2019-04-01/deploymentTemplate.json#"
+	subscriptionDeploymentTemplateSchema = "https://schema.management.azure.com/schemas/2018-05-01/subscriptionDeploymentTemplate.json#"
+
+	// These match the API versions used by the SDK packages we vendor, so resources
+	// created through a deployment look the same as the ones created by the SDK.
+	networkAPIVersion   = "2021-08-01"
+	computeAPIVersion   = "2022-03-01"
+	resourcesAPIVersion = "2021-04-01"
+
+	virtualNetworkType = "Microsoft.Network/virtualNetworks"
+	subnetType         = "Microsoft.Network/virtualNetworks/subnets"
+	securityGroupType  = "Microsoft.Network/networkSecurityGroups"
+	publicIPType       = "Microsoft.Network/publicIPAddresses"
+	interfaceType      = "Microsoft.Network/networkInterfaces"
+	virtualMachineType = "Microsoft.Compute/virtualMachines"
+	vmExtensionType    = "Microsoft.Compute/virtualMachines/extensions"
+	resourceGroupType  = "Microsoft.Resources/resourceGroups"
+	deploymentType     = "Microsoft.Resources/deployments"
+)
+
+// resourceIDExpr returns a template expression that resolves to the ID of a resource
+// in the deployment resource group.
+func resourceIDExpr(resourceType string, names ...string) string {
+	expr := fmt.Sprintf("resourceId('%s'", resourceType)
+	for _, name := range names {
+		expr += fmt.Sprintf(", '%s'", name)
+	}
+	return fmt.Sprintf("[%s)]", expr)
+}
+
+// templateResource converts an SDK resource model into a template resource. The SDK
+// models already serialize to the same shape ARM expects, so we only need to add the
+// type, name and dependencies.
+func (a *AzureCli) templateResource(resourceType, apiVersion, name string, model interface{}, dependsOn ...string) (map[string]interface{}, error) {
+	asJs, err := json.Marshal(model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", resourceType, err)
+	}
+
+	ret := map[string]interface{}{}
+	if err := json.Unmarshal(asJs, &ret); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", resourceType, err)
+	}
+
+	ret["type"] = resourceType
+	ret["apiVersion"] = a.apiVersion(resourceType, apiVersion)
+	ret["name"] = name
+	if len(dependsOn) > 0 {
+		ret["dependsOn"] = dependsOn
+	}
+	return ret, nil
+}
+
+// deploymentTemplate returns the ARM template and the template parameters needed to
+// create all the resources of an instance.
+func (a *AzureCli) deploymentTemplate(runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) (map[string]interface{}, map[string]interface{}, error) {
+	name := runnerSpec.BootstrapParams.Name
+	nicID := resourceIDExpr(interfaceType, name)
+	vmID := resourceIDExpr(virtualMachineType, name)
+
+	// Pre-created NICs are used as is, without creating any network resources.
+	var resources []interface{}
+	vmDependencies := []string{nicID}
+	if runnerSpec.NetworkInterfaceID != "" {
+		nicID = runnerSpec.NetworkInterfaceID
+		vmDependencies = nil
+	} else {
+		networkResources, err := a.networkTemplateResources(runnerSpec)
+		if err != nil {
+			return nil, nil, err
+		}
+		resources = append(resources, networkResources...)
+	}
+
+	vmParams, err := a.virtualMachineParams(runnerSpec, nicID, sizeSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The custom data holds the instance token and the admin password is, well, a password.
+	// Pass them in as secure parameters, so they don't end up in the deployment history.
+	templateParams := map[string]interface{}{}
+	if vmParams.Properties.OSProfile != nil {
+		osProfile := vmParams.Properties.OSProfile
+		if osProfile.CustomData != nil {
+			templateParams["customData"] = map[string]interface{}{"value": *osProfile.CustomData}
+			osProfile.CustomData = to.Ptr("[parameters('customData')]")
+		}
+		if osProfile.AdminPassword != nil {
+			templateParams["adminPassword"] = map[string]interface{}{"value": *osProfile.AdminPassword}
+			osProfile.AdminPassword = to.Ptr("[parameters('adminPassword')]")
+		}
+	}
+
+	vm, err := a.templateResource(virtualMachineType, computeAPIVersion, name, vmParams, vmDependencies...)
+	if err != nil {
+		return nil, nil, err
+	}
+	resources = append(resources, vm)
+
+	ext, err := runnerSpec.GetVMExtension(a.location, vmExtensionName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get vm extension: %w", err)
+	}
+	if ext != nil {
+		extResource, err := a.templateResource(vmExtensionType, computeAPIVersion, fmt.Sprintf("%s/%s", name, vmExtensionName), ext, vmID)
+		if err != nil {
+			return nil, nil, err
+		}
+		resources = append(resources, extResource)
+	}
+
+	parameterDefinitions := map[string]interface{}{}
+	for paramName := range templateParams {
+		parameterDefinitions[paramName] = map[string]interface{}{
+			"type": "securestring",
+		}
+	}
+
+	template := map[string]interface{}{
+		"$schema":        deploymentTemplateSchema,
+		"contentVersion": "1.0.0.0",
+		"parameters":     parameterDefinitions,
+		"resources":      resources,
+	}
+	return template, templateParams, nil
+}
+
+// networkTemplateResources returns the template resources of the virtual network, the
+// security group, the public IP and the NIC of an instance.
+func (a *AzureCli) networkTemplateResources(runnerSpec *spec.RunnerSpec) ([]interface{}, error) {
+	name := runnerSpec.BootstrapParams.Name
+
+	// Runners attached to an existing subnet get no virtual network of their own.
+	var resources []interface{}
+	var nicDependencies []string
+	subnetID := runnerSpec.SubnetID
+	if subnetID == "" {
+		subnetResources, err := a.subnetTemplateResources(runnerSpec)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, subnetResources...)
+		subnetID = resourceIDExpr(subnetType, name, name)
+		nicDependencies = append(nicDependencies, subnetID)
+	}
+
+	var nsgID string
+	if !runnerSpec.SkipNetworkSecurityGroup {
+		nsgID = resourceIDExpr(securityGroupType, name)
+		nsg, err := a.templateResource(securityGroupType, networkAPIVersion, name, a.networkSecurityGroupParams(runnerSpec))
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, nsg)
+		nicDependencies = append(nicDependencies, nsgID)
+	}
+
+	var pubIPID string
+	if runnerSpec.AllocatePublicIP {
+		pubIPID = resourceIDExpr(publicIPType, name)
+		pubIP, err := a.templateResource(publicIPType, networkAPIVersion, name, a.publicIPParams(runnerSpec))
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, pubIP)
+		nicDependencies = append(nicDependencies, pubIPID)
+	}
+
+	nic, err := a.templateResource(interfaceType, networkAPIVersion, name, a.networkInterfaceParams(subnetID, nsgID, pubIPID, runnerSpec), nicDependencies...)
+	if err != nil {
+		return nil, err
+	}
+	resources = append(resources, nic)
+
+	return resources, nil
+}
+
+// subnetTemplateResources returns the template resources of the virtual network of an
+// instance and its subnets.
+func (a *AzureCli) subnetTemplateResources(runnerSpec *spec.RunnerSpec) ([]interface{}, error) {
+	name := runnerSpec.BootstrapParams.Name
+	vnetID := resourceIDExpr(virtualNetworkType, name)
+
+	var resources []interface{}
+
+	vnet, err := a.templateResource(virtualNetworkType, networkAPIVersion, name, a.virtualNetworkParams(runnerSpec.VirtualNetworkCIDR, runnerSpec.NetworkTags))
+	if err != nil {
+		return nil, err
+	}
+	resources = append(resources, vnet)
+
+	// Subnets of the same virtual network can't be created in parallel.
+	subnet, err := a.templateResource(subnetType, networkAPIVersion, fmt.Sprintf("%s/%s", name, name), a.subnetParams(runnerSpec.SubnetCIDR), vnetID)
+	if err != nil {
+		return nil, err
+	}
+	resources = append(resources, subnet)
+
+	previousSubnet := resourceIDExpr(subnetType, name, name)
+	for subnetName, cidr := range runnerSpec.ExtraSubnets {
+		extraSubnet, err := a.templateResource(subnetType, networkAPIVersion, fmt.Sprintf("%s/%s", name, subnetName), a.subnetParams(cidr), previousSubnet)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, extraSubnet)
+		previousSubnet = resourceIDExpr(subnetType, name, subnetName)
+	}
+	return resources, nil
+}
+
+// CreateDeployment creates all resources needed by an instance, using a single ARM
+// template deployment. The deployment is named after the instance, and is left in the
+// resource group for auditing purposes.
+func (a *AzureCli) CreateDeployment(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) error {
+	poller, err := a.beginDeployment(ctx, runnerSpec, sizeSpec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to create deployment: %w", err)
+	}
+	return nil
+}
+
+// SubmitDeployment submits the deployment of an instance, without waiting for it to
+// finish. Use WaitDeployment to wait for it.
+func (a *AzureCli) SubmitDeployment(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) error {
+	_, err := a.beginDeployment(ctx, runnerSpec, sizeSpec)
+	return err
+}
+
+func (a *AzureCli) beginDeployment(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) (*runtime.Poller[armresources.DeploymentsClientCreateOrUpdateResponse], error) {
+	if runnerSpec == nil {
+		return nil, fmt.Errorf("invalid nil runner spec")
+	}
+
+	template, templateParams, err := a.deploymentTemplate(runnerSpec, sizeSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate deployment template: %w", err)
+	}
+
+	parameters := armresources.Deployment{
+		Properties: &armresources.DeploymentProperties{
+			Mode:       to.Ptr(armresources.DeploymentModeIncremental),
+			Template:   template,
+			Parameters: templateParams,
+		},
+		Tags: runnerSpec.Tags,
+	}
+
+	name := runnerSpec.BootstrapParams.Name
+	poller, err := a.deploymentsCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(name), name, parameters, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deployment: %w", err)
+	}
+	return poller, nil
+}
+
+// deploymentPollInterval is how often WaitDeployment checks the state of a deployment.
+const deploymentPollInterval = 10 * time.Second
+
+// WaitDeployment waits for the deployment of an instance, submitted by another process,
+// to finish. It returns an error if the deployment failed.
+func (a *AzureCli) WaitDeployment(ctx context.Context, name string) error {
+	ticker := time.NewTicker(deploymentPollInterval)
+	defer ticker.Stop()
+	for {
+		resp, err := a.deploymentsCli.Get(ctx, a.InstanceResourceGroup(name), name, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get deployment: %w", err)
+		}
+		if props := resp.Properties; props != nil && props.ProvisioningState != nil {
+			switch *props.ProvisioningState {
+			case armresources.ProvisioningStateSucceeded:
+				return nil
+			case armresources.ProvisioningStateFailed, armresources.ProvisioningStateCanceled:
+				if props.Error != nil && props.Error.Message != nil {
+					return fmt.Errorf("deployment %s: %s", strings.ToLower(string(*props.ProvisioningState)), *props.Error.Message)
+				}
+				return fmt.Errorf("deployment %s", strings.ToLower(string(*props.ProvisioningState)))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// subscriptionDeploymentTemplate wraps the instance deployment template in a subscription
+// level template that also creates the resource group. What-If needs the target resource
+// group to exist when run at resource group scope, which is not the case before we create
+// an instance. The shared resource group is not part of the wrapper, as deploying it would
+// reset its tags, so it has to exist already.
+func (a *AzureCli) subscriptionDeploymentTemplate(runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) (map[string]interface{}, map[string]interface{}, error) {
+	template, templateParams, err := a.deploymentTemplate(runnerSpec, sizeSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	name := runnerSpec.BootstrapParams.Name
+	rgName := a.InstanceResourceGroup(name)
+	parameterDefinitions := map[string]interface{}{}
+	nestedParams := map[string]interface{}{}
+	for paramName := range templateParams {
+		parameterDefinitions[paramName] = map[string]interface{}{
+			"type": "securestring",
+		}
+		nestedParams[paramName] = map[string]interface{}{
+			"value": fmt.Sprintf("[parameters('%s')]", paramName),
+		}
+	}
+
+	deployment := map[string]interface{}{
+		"type":          deploymentType,
+		"apiVersion":    a.apiVersion(deploymentType, resourcesAPIVersion),
+		"name":          name,
+		"resourceGroup": rgName,
+		"properties": map[string]interface{}{
+			"mode": armresources.DeploymentModeIncremental,
+			"expressionEvaluationOptions": map[string]interface{}{
+				"scope": "inner",
+			},
+			"parameters": nestedParams,
+			"template":   template,
+		},
+	}
+	resources := []interface{}{deployment}
+	if a.cfg.SharedResourceGroup == "" {
+		deployment["dependsOn"] = []string{
+			fmt.Sprintf("[subscriptionResourceId('%s', '%s')]", resourceGroupType, rgName),
+		}
+		resources = append([]interface{}{
+			map[string]interface{}{
+				"type":       resourceGroupType,
+				"apiVersion": a.apiVersion(resourceGroupType, resourcesAPIVersion),
+				"name":       rgName,
+				"location":   a.location,
+				"tags":       runnerSpec.Tags,
+			},
+		}, resources...)
+	}
+
+	subscriptionTemplate := map[string]interface{}{
+		"$schema":        subscriptionDeploymentTemplateSchema,
+		"contentVersion": "1.0.0.0",
+		"parameters":     parameterDefinitions,
+		"resources":      resources,
+	}
+	return subscriptionTemplate, templateParams, nil
+}
+
+// WhatIfDeployment runs a What-If operation for the deployment that would create the
+// instance described by runnerSpec, and returns the predicted changes. Nothing is created.
+func (a *AzureCli) WhatIfDeployment(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) ([]string, error) {
+	if runnerSpec == nil {
+		return nil, fmt.Errorf("invalid nil runner spec")
+	}
+
+	template, templateParams, err := a.subscriptionDeploymentTemplate(runnerSpec, sizeSpec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate deployment template: %w", err)
+	}
+
+	parameters := armresources.DeploymentWhatIf{
+		Location: to.Ptr(a.location),
+		Properties: &armresources.DeploymentWhatIfProperties{
+			Mode:       to.Ptr(armresources.DeploymentModeIncremental),
+			Template:   template,
+			Parameters: templateParams,
+		},
+	}
+
+	poller, err := a.deploymentsCli.BeginWhatIfAtSubscriptionScope(ctx, runnerSpec.BootstrapParams.Name, parameters, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run what-if: %w", err)
+	}
+
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run what-if: %w", err)
+	}
+
+	if resp.Error != nil {
+		var code, msg string
+		if resp.Error.Code != nil {
+			code = *resp.Error.Code
+		}
+		if resp.Error.Message != nil {
+			msg = *resp.Error.Message
+		}
+		return nil, fmt.Errorf("what-if failed (%s): %s", code, msg)
+	}
+
+	var changes []string
+	if resp.Properties != nil {
+		for _, change := range resp.Properties.Changes {
+			if change == nil || change.ChangeType == nil || change.ResourceID == nil {
+				continue
+			}
+			line := fmt.Sprintf("%s %s", *change.ChangeType, *change.ResourceID)
+			if change.UnsupportedReason != nil {
+				line = fmt.Sprintf("%s (%s)", line, *change.UnsupportedReason)
+			}
+			changes = append(changes, line)
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// There is no DNS client in the vendored SDK, so records are managed through the generic
+// resources client.
+const dnsAPIVersion = "2018-05-01"
+
+// dnsRecordName returns the name of the A record of a runner. DNS names are case
+// insensitive, and Azure DNS stores them in lower case.
+func dnsRecordName(name string) string {
+	return strings.ToLower(name)
+}
+
+func (a *AzureCli) dnsRecordID(name string) string {
+	return fmt.Sprintf("%s/A/%s", a.cfg.DNSZone.ID, dnsRecordName(name))
+}
+
+// DNSRecordFQDN returns the fully qualified name of the A record of a runner.
+func (a *AzureCli) DNSRecordFQDN(name string) string {
+	return fmt.Sprintf("%s.%s", dnsRecordName(name), a.cfg.DNSZone.Name())
+}
+
+// CreateDNSRecord creates or replaces the A record of a runner in the configured DNS zone.
+func (a *AzureCli) CreateDNSRecord(ctx context.Context, name, ip string) error {
+	record := armresources.GenericResource{
+		Properties: map[string]interface{}{
+			"TTL": a.cfg.DNSZone.GetTTL(),
+			"ARecords": []map[string]string{
+				{"ipv4Address": ip},
+			},
+		},
+	}
+	poller, err := a.sideEffectsCli.BeginCreateOrUpdateByID(ctx, a.dnsRecordID(name), dnsAPIVersion, record, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to create DNS record: %w", err)
+	}
+	return nil
+}
+
+// DeleteDNSRecord removes the A record of a runner. It is not an error if the record does
+// not exist.
+func (a *AzureCli) DeleteDNSRecord(ctx context.Context, name string) error {
+	poller, err := a.sideEffectsCli.BeginDeleteByID(ctx, a.dnsRecordID(name), dnsAPIVersion, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete DNS record: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to delete DNS record: %w", err)
+	}
+	return nil
+}
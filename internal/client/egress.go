@@ -0,0 +1,57 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// UpdateGitHubEgressRules replaces the GitHub ranges in the security groups of all runners
+// using the github-only egress profile. It returns the number of updated runners.
+func (a *AzureCli) UpdateGitHubEgressRules(ctx context.Context, cidrs []string) (int, error) {
+	vms, err := a.ListVirtualMachinesWithTag(ctx, util.EgressProfileTagName, string(spec.EgressProfileGitHubOnly))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list runners: %w", err)
+	}
+
+	rule := spec.GitHubEgressRule(cidrs)
+	var updated int
+	for _, vm := range vms {
+		if vm.Name == nil {
+			continue
+		}
+		// The security group of a runner is named after it, and lives in its resource
+		// group.
+		name := *vm.Name
+		poller, err := a.secRulesCli.BeginCreateOrUpdate(ctx, a.InstanceResourceGroup(name), name, spec.GitHubEgressRuleName, *rule, nil)
+		if err == nil {
+			_, err = poller.PollUntilDone(ctx, nil)
+		}
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			log.Printf("failed to update github egress rule of %s: %s", name, err)
+			continue
+		}
+		updated++
+	}
+	return updated, nil
+}
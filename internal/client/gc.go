@@ -0,0 +1,118 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// Orphan is the resource group of a runner that has no healthy VM.
+type Orphan struct {
+	ResourceGroup string
+	// State is the lifecycle state recorded on the resource group.
+	State string
+	// Since is when the resource group entered State.
+	Since time.Time
+	// VMState is the provisioning state of the VM, or empty if there is no VM.
+	VMState string
+}
+
+// FindOrphans returns the resource groups of a controller's runners that hold no VM, or a
+// VM that failed to provision, and that entered their current state at least minAge ago.
+// Resource groups created before states were recorded are skipped, as their age is
+// unknown.
+func (a *AzureCli) FindOrphans(ctx context.Context, controllerID string, minAge time.Duration) ([]Orphan, error) {
+	if a.cfg.SharedResourceGroup != "" {
+		return nil, fmt.Errorf("garbage collection is not supported with shared_resource_group")
+	}
+
+	groups, err := a.ListResourceGroupsWithTag(ctx, util.ControllerIDTagName, controllerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list resource groups: %w", err)
+	}
+	vms, err := a.ListVirtualMachinesWithTag(ctx, util.ControllerIDTagName, controllerID)
+	if err != nil {
+		return nil, err
+	}
+	// Resource group names are case insensitive, and VM IDs don't always preserve their case.
+	vmStates := map[string]string{}
+	for _, vm := range vms {
+		if vm.ID == nil {
+			continue
+		}
+		id, err := arm.ParseResourceID(*vm.ID)
+		if err != nil {
+			continue
+		}
+		state := "Unknown"
+		if vm.Properties != nil && vm.Properties.ProvisioningState != nil {
+			state = *vm.Properties.ProvisioningState
+		}
+		vmStates[strings.ToLower(id.ResourceGroupName)] = state
+	}
+
+	var orphans []Orphan
+	for _, rg := range groups {
+		if rg.Name == nil {
+			continue
+		}
+		vmState, hasVM := vmStates[strings.ToLower(*rg.Name)]
+		if hasVM && vmState != "Failed" {
+			continue
+		}
+		state, since := util.InstanceState(rg.Tags)
+		if since.IsZero() || time.Since(since) < minAge {
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			ResourceGroup: *rg.Name,
+			State:         state,
+			Since:         since,
+			VMState:       vmState,
+		})
+	}
+	return orphans, nil
+}
+
+// RemoveOrphan marks an orphaned resource group as deleting, and deletes it along with
+// the DNS record of the runner. Unless wait is set, it returns once the deletion started.
+func (a *AzureCli) RemoveOrphan(ctx context.Context, name string, wait bool) error {
+	if err := a.SetInstanceState(ctx, name, util.InstanceStateDeleting); err != nil {
+		return err
+	}
+	if wait {
+		if err := a.UnlockResourceGroup(ctx, name); err != nil {
+			return fmt.Errorf("failed to unlock resource group: %w", err)
+		}
+		if err := a.DeleteResourceGroup(ctx, name, true); err != nil {
+			return err
+		}
+	} else if err := a.StartResourceGroupDelete(ctx, name); err != nil {
+		return err
+	}
+	if a.cfg.DNSZone != nil {
+		if err := a.DeleteDNSRecord(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// There is no resource health client in the vendored SDK, so service health events are
+// queried through a pipeline of our own.
+const serviceHealthAPIVersion = "2022-10-01"
+
+// ServiceIssue is an active Azure service health incident.
+type ServiceIssue struct {
+	TrackingID string
+	Title      string
+	// Services are the impacted services, by region.
+	Services map[string][]string
+}
+
+// ListActiveServiceIssues returns the active service health incidents that impact the
+// subscription.
+func (a *AzureCli) ListActiveServiceIssues(ctx context.Context) ([]ServiceIssue, error) {
+	endpoint, pl, err := a.rawPipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	urlPath := fmt.Sprintf("/subscriptions/%s/providers/Microsoft.ResourceHealth/events", url.PathEscape(a.cfg.Credentials.SubscriptionID))
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(endpoint, urlPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", serviceHealthAPIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+
+	resp, err := pl.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service health events: %w", err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	var events struct {
+		Value []struct {
+			Name       string `json:"name"`
+			Properties struct {
+				EventType string `json:"eventType"`
+				Status    string `json:"status"`
+				Title     string `json:"title"`
+				Impact    []struct {
+					ImpactedService string `json:"impactedService"`
+					ImpactedRegions []struct {
+						ImpactedRegion string `json:"impactedRegion"`
+						Status         string `json:"status"`
+					} `json:"impactedRegions"`
+				} `json:"impact"`
+			} `json:"properties"`
+		} `json:"value"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &events); err != nil {
+		return nil, fmt.Errorf("failed to decode service health events: %w", err)
+	}
+
+	var issues []ServiceIssue
+	for _, event := range events.Value {
+		if event.Properties.EventType != "ServiceIssue" || event.Properties.Status != "Active" {
+			continue
+		}
+		issue := ServiceIssue{
+			TrackingID: event.Name,
+			Title:      event.Properties.Title,
+			Services:   map[string][]string{},
+		}
+		for _, impact := range event.Properties.Impact {
+			for _, region := range impact.ImpactedRegions {
+				if region.Status == "Resolved" {
+					continue
+				}
+				issue.Services[region.ImpactedRegion] = append(issue.Services[region.ImpactedRegion], impact.ImpactedService)
+			}
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// CheckSubscriptionAccess makes sure the credentials can get a token, and list the resource
+// groups of the subscription, and of the network subscription if it is separate.
+func (a *AzureCli) CheckSubscriptionAccess(ctx context.Context) error {
+	if err := checkResourceGroupAccess(ctx, a.rgCli); err != nil {
+		return err
+	}
+	if a.netRGCli != nil {
+		if err := checkResourceGroupAccess(ctx, a.netRGCli); err != nil {
+			return fmt.Errorf("network credentials: %w", err)
+		}
+	}
+	return nil
+}
+
+func checkResourceGroupAccess(ctx context.Context, rgCli *armresources.ResourceGroupsClient) error {
+	pager := rgCli.NewListPager(&armresources.ResourceGroupsClientListOptions{Top: to.Ptr[int32](1)})
+	if _, err := pager.NextPage(ctx); err != nil {
+		return fmt.Errorf("failed to list resource groups: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// DedicatedHost is a host of the dedicated host group.
+type DedicatedHost struct {
+	Name            string
+	VirtualMachines int
+	// Allocatable maps VM sizes to the number of VMs of that size that still fit on the host.
+	Allocatable map[string]int
+	// IdleSince is the time the host was first seen without runners. It is zero if the
+	// host is in use.
+	IdleSince time.Time
+}
+
+// HostGroupID returns the ID of the dedicated host group.
+func (a *AzureCli) HostGroupID() string {
+	hosts := a.cfg.DedicatedHosts
+	return fmt.Sprintf(
+		"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/hostGroups/%s",
+		a.cfg.Credentials.SubscriptionID, hosts.ResourceGroup, hosts.GetHostGroup())
+}
+
+// EnsureHostGroup creates the resource group and the dedicated host group, if they don't
+// exist. VMs are placed on the hosts of the group automatically.
+func (a *AzureCli) EnsureHostGroup(ctx context.Context) error {
+	hosts := a.cfg.DedicatedHosts
+	_, err := a.hostGroupsCli.Get(ctx, hosts.ResourceGroup, hosts.GetHostGroup(), nil)
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed to get host group: %w", err)
+	}
+
+	if _, err := a.rgCli.CreateOrUpdate(ctx, hosts.ResourceGroup, armresources.ResourceGroup{Location: to.Ptr(a.location)}, nil); err != nil {
+		return fmt.Errorf("failed to create host resource group: %w", err)
+	}
+
+	group := armcompute.DedicatedHostGroup{
+		Location: to.Ptr(a.location),
+		Properties: &armcompute.DedicatedHostGroupProperties{
+			PlatformFaultDomainCount:  to.Ptr[int32](1),
+			SupportAutomaticPlacement: to.Ptr(true),
+		},
+	}
+	if hosts.Zone != "" {
+		group.Zones = []*string{to.Ptr(hosts.Zone)}
+	}
+	if _, err := a.hostGroupsCli.CreateOrUpdate(ctx, hosts.ResourceGroup, hosts.GetHostGroup(), group, nil); err != nil {
+		return fmt.Errorf("failed to create host group: %w", err)
+	}
+	return nil
+}
+
+// ListDedicatedHosts returns the hosts of the dedicated host group, with their remaining
+// capacity.
+func (a *AzureCli) ListDedicatedHosts(ctx context.Context) ([]DedicatedHost, error) {
+	hosts := a.cfg.DedicatedHosts
+	var ret []DedicatedHost
+	pager := a.hostsCli.NewListByHostGroupPager(hosts.ResourceGroup, hosts.GetHostGroup(), nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list dedicated hosts: %w", err)
+		}
+		for _, host := range page.Value {
+			if host == nil || host.Name == nil {
+				continue
+			}
+			// The capacity is only part of the instance view, which is not returned when
+			// listing hosts.
+			resp, err := a.hostsCli.Get(ctx, hosts.ResourceGroup, hosts.GetHostGroup(), *host.Name, &armcompute.DedicatedHostsClientGetOptions{
+				Expand: to.Ptr(armcompute.InstanceViewTypesInstanceView),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to get dedicated host %s: %w", *host.Name, err)
+			}
+			ret = append(ret, dedicatedHostDetails(resp.DedicatedHost))
+		}
+	}
+	return ret, nil
+}
+
+func dedicatedHostDetails(host armcompute.DedicatedHost) DedicatedHost {
+	ret := DedicatedHost{
+		Name:        *host.Name,
+		Allocatable: map[string]int{},
+	}
+	if idleSince, ok := host.Tags[util.HostIdleSinceTagName]; ok && idleSince != nil {
+		if parsed, err := time.Parse(time.RFC3339, *idleSince); err == nil {
+			ret.IdleSince = parsed
+		}
+	}
+	if host.Properties == nil {
+		return ret
+	}
+	ret.VirtualMachines = len(host.Properties.VirtualMachines)
+	if view := host.Properties.InstanceView; view != nil && view.AvailableCapacity != nil {
+		for _, vm := range view.AvailableCapacity.AllocatableVMs {
+			if vm == nil || vm.VMSize == nil || vm.Count == nil {
+				continue
+			}
+			ret.Allocatable[*vm.VMSize] = int(*vm.Count)
+		}
+	}
+	return ret
+}
+
+// CreateDedicatedHost adds a host to the dedicated host group, and waits for it to be
+// provisioned.
+func (a *AzureCli) CreateDedicatedHost(ctx context.Context, name string) error {
+	hosts := a.cfg.DedicatedHosts
+	host := armcompute.DedicatedHost{
+		Location: to.Ptr(a.location),
+		SKU: &armcompute.SKU{
+			Name: to.Ptr(hosts.SKU),
+		},
+		Properties: &armcompute.DedicatedHostProperties{
+			PlatformFaultDomain:  to.Ptr[int32](0),
+			AutoReplaceOnFailure: to.Ptr(true),
+		},
+	}
+	poller, err := a.hostsCli.BeginCreateOrUpdate(ctx, hosts.ResourceGroup, hosts.GetHostGroup(), name, host, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create dedicated host: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to create dedicated host: %w", err)
+	}
+	return nil
+}
+
+// SetDedicatedHostIdleSince records the time a host was first seen without runners. A
+// zero time marks the host as in use.
+func (a *AzureCli) SetDedicatedHostIdleSince(ctx context.Context, name string, since time.Time) error {
+	// Tags can't be removed with an update, so an empty value marks a host in use.
+	var value string
+	if !since.IsZero() {
+		value = since.UTC().Format(time.RFC3339)
+	}
+	hosts := a.cfg.DedicatedHosts
+	update := armcompute.DedicatedHostUpdate{
+		Tags: map[string]*string{
+			util.HostIdleSinceTagName: to.Ptr(value),
+		},
+	}
+	poller, err := a.hostsCli.BeginUpdate(ctx, hosts.ResourceGroup, hosts.GetHostGroup(), name, update, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update dedicated host: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to update dedicated host: %w", err)
+	}
+	return nil
+}
+
+// DeleteDedicatedHost deletes a host of the dedicated host group. Hosts that still run
+// VMs can't be deleted.
+func (a *AzureCli) DeleteDedicatedHost(ctx context.Context, name string) error {
+	hosts := a.cfg.DedicatedHosts
+	poller, err := a.hostsCli.BeginDelete(ctx, hosts.ResourceGroup, hosts.GetHostGroup(), name, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete dedicated host: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to delete dedicated host: %w", err)
+	}
+	return nil
+}
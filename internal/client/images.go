@@ -0,0 +1,514 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	managedImageType        = "Microsoft.Compute/images"
+	galleryImageVersionType = "Microsoft.Compute/galleries/images/versions"
+
+	defaultImageVersion   = "1.0.0"
+	defaultImagePublisher = "garm"
+
+	// imageDeprecationAPIVersion is the first compute API version that reports the
+	// deprecation status of marketplace images.
+	imageDeprecationAPIVersion = "2023-03-01"
+)
+
+// LatestImageVersion returns the newest version of a marketplace image in the location
+// of the client.
+func (a *AzureCli) LatestImageVersion(ctx context.Context, img util.ImageDetails) (string, error) {
+	resp, err := a.vmImagesCli.List(ctx, a.location, img.Publisher, img.Offer, img.SKU, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to list image versions: %w", err)
+	}
+	var latest string
+	for _, version := range resp.VirtualMachineImageResourceArray {
+		if version == nil || version.Name == nil {
+			continue
+		}
+		if latest == "" || compareImageVersions(*version.Name, latest) > 0 {
+			latest = *version.Name
+		}
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no versions of %s:%s:%s found in %s", img.Publisher, img.Offer, img.SKU, a.location)
+	}
+	return latest, nil
+}
+
+// ImageArchitecture returns the CPU architecture of a marketplace image, or of the
+// definition of a gallery image.
+func (a *AzureCli) ImageArchitecture(ctx context.Context, img util.ImageDetails) (armcompute.ArchitectureTypes, error) {
+	var arch *armcompute.ArchitectureTypes
+	if img.ID != "" {
+		imageID, err := arm.ParseResourceID(img.ID)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse image ID: %w", err)
+		}
+		if strings.EqualFold(imageID.ResourceType.String(), galleryImageVersionType) {
+			imageID = imageID.Parent
+		}
+		if imageID == nil || imageID.Parent == nil {
+			return "", fmt.Errorf("invalid gallery image ID %s", img.ID)
+		}
+		resp, err := a.galleryImgCli.Get(ctx, imageID.ResourceGroupName, imageID.Parent.Name, imageID.Name, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to get image definition: %w", err)
+		}
+		if resp.Properties != nil && resp.Properties.Architecture != nil {
+			arch = to.Ptr(armcompute.ArchitectureTypes(*resp.Properties.Architecture))
+		}
+	} else {
+		version := img.Version
+		if strings.EqualFold(version, "latest") {
+			latest, err := a.LatestImageVersion(ctx, img)
+			if err != nil {
+				return "", err
+			}
+			version = latest
+		}
+		resp, err := a.vmImagesCli.Get(ctx, a.location, img.Publisher, img.Offer, img.SKU, version, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to get image version: %w", err)
+		}
+		if resp.Properties != nil {
+			arch = resp.Properties.Architecture
+		}
+	}
+	// Images that predate Arm64 support don't report an architecture.
+	if arch == nil {
+		return armcompute.ArchitectureTypesX64, nil
+	}
+	return *arch, nil
+}
+
+// ImageDeprecationStatus is the deprecation status a publisher set on a marketplace image
+// version.
+type ImageDeprecationStatus struct {
+	// State is Active, ScheduledForDeprecation or Deprecated. It is empty if the publisher
+	// did not set a status.
+	State string
+	// ScheduledAt is when a version scheduled for deprecation is deprecated.
+	ScheduledAt *time.Time
+}
+
+// GetImageDeprecationStatus returns the deprecation status of a marketplace image version.
+// The vendored compute SDK predates deprecation statuses, so the version is fetched
+// through a pipeline of our own.
+func (a *AzureCli) GetImageDeprecationStatus(ctx context.Context, img util.ImageDetails) (ImageDeprecationStatus, error) {
+	endpoint, pl, err := a.rawPipeline()
+	if err != nil {
+		return ImageDeprecationStatus{}, err
+	}
+
+	urlPath := fmt.Sprintf(
+		"/subscriptions/%s/providers/Microsoft.Compute/locations/%s/publishers/%s/artifacttypes/vmimage/offers/%s/skus/%s/versions/%s",
+		url.PathEscape(a.cfg.Credentials.SubscriptionID), url.PathEscape(a.location), url.PathEscape(img.Publisher),
+		url.PathEscape(img.Offer), url.PathEscape(img.SKU), url.PathEscape(img.Version))
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(endpoint, urlPath))
+	if err != nil {
+		return ImageDeprecationStatus{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", imageDeprecationAPIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+
+	resp, err := pl.Do(req)
+	if err != nil {
+		return ImageDeprecationStatus{}, fmt.Errorf("failed to get image version: %w", err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return ImageDeprecationStatus{}, runtime.NewResponseError(resp)
+	}
+
+	var result struct {
+		Properties struct {
+			ImageDeprecationStatus struct {
+				ImageState               string     `json:"imageState"`
+				ScheduledDeprecationTime *time.Time `json:"scheduledDeprecationTime"`
+			} `json:"imageDeprecationStatus"`
+		} `json:"properties"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+		return ImageDeprecationStatus{}, fmt.Errorf("failed to decode image version: %w", err)
+	}
+	status := result.Properties.ImageDeprecationStatus
+	return ImageDeprecationStatus{
+		State:       status.ImageState,
+		ScheduledAt: status.ScheduledDeprecationTime,
+	}, nil
+}
+
+// compareImageVersions compares two dotted image versions numerically. Parts that are not
+// numbers are compared as strings.
+func compareImageVersions(v1, v2 string) int {
+	parts1 := strings.Split(v1, ".")
+	parts2 := strings.Split(v2, ".")
+	for idx := 0; idx < len(parts1) && idx < len(parts2); idx++ {
+		n1, err1 := strconv.ParseUint(parts1[idx], 10, 64)
+		n2, err2 := strconv.ParseUint(parts2[idx], 10, 64)
+		switch {
+		case err1 == nil && err2 == nil && n1 != n2:
+			if n1 < n2 {
+				return -1
+			}
+			return 1
+		case (err1 != nil || err2 != nil) && parts1[idx] != parts2[idx]:
+			return strings.Compare(parts1[idx], parts2[idx])
+		}
+	}
+	return len(parts1) - len(parts2)
+}
+
+// CopyImageParams holds the parameters for copying an image to a gallery in another region.
+type CopyImageParams struct {
+	// SourceID is the ID of a managed image or of a gallery image version.
+	SourceID string
+	// TargetLocation is the region the image is copied to.
+	TargetLocation string
+	// TargetResourceGroup is the resource group of the target gallery. It is created
+	// in the target location if it does not exist.
+	TargetResourceGroup string
+	// TargetGallery is the name of the target gallery. It is created if it does not exist.
+	TargetGallery string
+	// TargetImage is the name of the image definition in the target gallery. Defaults
+	// to the name of the source image definition or managed image.
+	TargetImage string
+	// TargetVersion is the name of the new image version. Defaults to the source version
+	// for gallery images and to 1.0.0 for managed images.
+	TargetVersion string
+}
+
+// CopyImage copies a managed image or a gallery image version into a gallery image
+// version, replicated to the target location. The target resource group, gallery and image
+// definition are created if needed. The ID of the new image version is returned.
+func (a *AzureCli) CopyImage(ctx context.Context, params CopyImageParams) (string, error) {
+	if params.TargetLocation == "" || params.TargetResourceGroup == "" || params.TargetGallery == "" {
+		return "", fmt.Errorf("missing target location, resource group or gallery")
+	}
+
+	sourceID, err := arm.ParseResourceID(params.SourceID)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse source ID: %w", err)
+	}
+
+	var definition armcompute.GalleryImage
+	var version string
+	switch {
+	case strings.EqualFold(sourceID.ResourceType.String(), managedImageType):
+		definition, err = a.galleryImageFromManagedImage(ctx, sourceID)
+		version = defaultImageVersion
+	case strings.EqualFold(sourceID.ResourceType.String(), galleryImageVersionType):
+		definition, err = a.galleryImageFromImageVersion(ctx, sourceID)
+		version = sourceID.Name
+	default:
+		return "", fmt.Errorf("unsupported source type %s", sourceID.ResourceType)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get source image details: %w", err)
+	}
+
+	imageName := *definition.Name
+	if params.TargetImage != "" {
+		imageName = params.TargetImage
+	}
+	if params.TargetVersion != "" {
+		version = params.TargetVersion
+	}
+	definition.Location = to.Ptr(params.TargetLocation)
+
+	if err := a.ensureGallery(ctx, params.TargetResourceGroup, params.TargetGallery, params.TargetLocation); err != nil {
+		return "", fmt.Errorf("failed to create target gallery: %w", err)
+	}
+
+	if err := a.ensureImageDefinition(ctx, params.TargetResourceGroup, params.TargetGallery, imageName, definition); err != nil {
+		return "", err
+	}
+
+	return a.createImageVersion(ctx, params.TargetResourceGroup, params.TargetGallery, imageName, version, params.TargetLocation, params.SourceID)
+}
+
+// ensureImageDefinition creates the image definition in the gallery, if it does not exist.
+func (a *AzureCli) ensureImageDefinition(ctx context.Context, resourceGroup, gallery, name string, definition armcompute.GalleryImage) error {
+	if _, err := a.galleryImgCli.Get(ctx, resourceGroup, gallery, name, nil); err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to get image definition: %w", err)
+		}
+		poller, err := a.galleryImgCli.BeginCreateOrUpdate(ctx, resourceGroup, gallery, name, definition, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create image definition: %w", err)
+		}
+		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			return fmt.Errorf("failed to create image definition: %w", err)
+		}
+	}
+	return nil
+}
+
+// createImageVersion creates an image version from sourceID, replicated to location, and
+// returns its ID.
+func (a *AzureCli) createImageVersion(ctx context.Context, resourceGroup, gallery, image, version, location, sourceID string) (string, error) {
+	imageVersion := armcompute.GalleryImageVersion{
+		Location: to.Ptr(location),
+		Properties: &armcompute.GalleryImageVersionProperties{
+			StorageProfile: &armcompute.GalleryImageVersionStorageProfile{
+				Source: &armcompute.GalleryArtifactVersionSource{
+					ID: to.Ptr(sourceID),
+				},
+			},
+			PublishingProfile: &armcompute.GalleryImageVersionPublishingProfile{
+				TargetRegions: []*armcompute.TargetRegion{
+					{
+						Name: to.Ptr(location),
+					},
+				},
+			},
+		},
+	}
+	poller, err := a.galleryVerCli.BeginCreateOrUpdate(ctx, resourceGroup, gallery, image, version, imageVersion, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create image version: %w", err)
+	}
+	resp, err := poller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create image version: %w", err)
+	}
+	if resp.ID == nil {
+		return "", fmt.Errorf("image version has no ID")
+	}
+	return *resp.ID, nil
+}
+
+// ensureGallery creates the resource group and the gallery, if they don't exist.
+func (a *AzureCli) ensureGallery(ctx context.Context, resourceGroup, gallery, location string) error {
+	if _, err := a.rgCli.Get(ctx, resourceGroup, nil); err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to get resource group: %w", err)
+		}
+		rg := armresources.ResourceGroup{
+			Location: to.Ptr(location),
+		}
+		if _, err := a.rgCli.CreateOrUpdate(ctx, resourceGroup, rg, nil); err != nil {
+			return fmt.Errorf("failed to create resource group: %w", err)
+		}
+	}
+
+	if _, err := a.galleriesCli.Get(ctx, resourceGroup, gallery, nil); err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("failed to get gallery: %w", err)
+		}
+		poller, err := a.galleriesCli.BeginCreateOrUpdate(ctx, resourceGroup, gallery, armcompute.Gallery{Location: to.Ptr(location)}, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create gallery: %w", err)
+		}
+		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			return fmt.Errorf("failed to create gallery: %w", err)
+		}
+	}
+	return nil
+}
+
+// galleryImageFromImageVersion returns a copy of the image definition the version belongs to.
+func (a *AzureCli) galleryImageFromImageVersion(ctx context.Context, versionID *arm.ResourceID) (armcompute.GalleryImage, error) {
+	imageID := versionID.Parent
+	if imageID == nil || imageID.Parent == nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("invalid image version ID %s", versionID)
+	}
+	resp, err := a.galleryImgCli.Get(ctx, imageID.ResourceGroupName, imageID.Parent.Name, imageID.Name, nil)
+	if err != nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("failed to get image definition: %w", err)
+	}
+	if resp.Properties == nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("image definition %s has no properties", imageID)
+	}
+
+	props := resp.Properties
+	return armcompute.GalleryImage{
+		Name: to.Ptr(imageID.Name),
+		Properties: &armcompute.GalleryImageProperties{
+			Identifier:       props.Identifier,
+			OSState:          props.OSState,
+			OSType:           props.OSType,
+			HyperVGeneration: props.HyperVGeneration,
+			Architecture:     props.Architecture,
+			Features:         props.Features,
+			Description:      props.Description,
+		},
+	}, nil
+}
+
+// galleryImageFromManagedImage returns an image definition matching a managed image.
+func (a *AzureCli) galleryImageFromManagedImage(ctx context.Context, imageID *arm.ResourceID) (armcompute.GalleryImage, error) {
+	resp, err := a.imagesCli.Get(ctx, imageID.ResourceGroupName, imageID.Name, nil)
+	if err != nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("failed to get image: %w", err)
+	}
+	if resp.Properties == nil || resp.Properties.StorageProfile == nil || resp.Properties.StorageProfile.OSDisk == nil {
+		return armcompute.GalleryImage{}, fmt.Errorf("image %s has no OS disk", imageID)
+	}
+
+	osDisk := resp.Properties.StorageProfile.OSDisk
+	definition := armcompute.GalleryImage{
+		Name: to.Ptr(imageID.Name),
+		Properties: &armcompute.GalleryImageProperties{
+			Identifier: &armcompute.GalleryImageIdentifier{
+				Publisher: to.Ptr(defaultImagePublisher),
+				Offer:     to.Ptr(imageID.Name),
+				SKU:       to.Ptr(imageID.Name),
+			},
+			OSState: osDisk.OSState,
+			OSType:  osDisk.OSType,
+		},
+	}
+	if resp.Properties.HyperVGeneration != nil {
+		definition.Properties.HyperVGeneration = to.Ptr(armcompute.HyperVGeneration(*resp.Properties.HyperVGeneration))
+	}
+	return definition, nil
+}
+
+// CaptureImageParams holds the parameters for capturing a runner VM into a gallery.
+type CaptureImageParams struct {
+	// Instance is the name of the runner to capture.
+	Instance string
+	// ResourceGroup is the resource group of the gallery. It is created if it does not exist.
+	ResourceGroup string
+	// Gallery is the name of the gallery. It is created if it does not exist.
+	Gallery string
+	// Image is the name of the image definition. It is created if it does not exist.
+	Image string
+	// Version is the name of the new image version.
+	Version string
+}
+
+// CaptureImage generalizes a runner VM and captures it into a gallery image version. The
+// VM is unusable afterwards and should be deleted. The ID of the new image version is returned.
+func (a *AzureCli) CaptureImage(ctx context.Context, params CaptureImageParams) (string, error) {
+	if params.Instance == "" || params.ResourceGroup == "" || params.Gallery == "" || params.Image == "" || params.Version == "" {
+		return "", fmt.Errorf("missing instance, resource group, gallery, image or version")
+	}
+
+	vm, err := a.GetInstance(ctx, a.InstanceResourceGroup(params.Instance), params.Instance)
+	if err != nil {
+		return "", err
+	}
+	if vm.ID == nil || vm.Properties == nil || vm.Properties.StorageProfile == nil || vm.Properties.StorageProfile.OSDisk == nil {
+		return "", fmt.Errorf("VM %s is missing storage details", params.Instance)
+	}
+	osType := vm.Properties.StorageProfile.OSDisk.OSType
+	if osType == nil {
+		return "", fmt.Errorf("VM %s has no OS type", params.Instance)
+	}
+
+	if err := a.generalizeGuest(ctx, params.Instance, *osType); err != nil {
+		return "", fmt.Errorf("failed to generalize guest: %w", err)
+	}
+
+	if err := a.DealocateVM(ctx, a.InstanceResourceGroup(params.Instance), params.Instance); err != nil {
+		return "", err
+	}
+
+	if _, err := a.vmCli.Generalize(ctx, a.InstanceResourceGroup(params.Instance), params.Instance, nil); err != nil {
+		return "", fmt.Errorf("failed to generalize VM: %w", err)
+	}
+
+	if err := a.ensureGallery(ctx, params.ResourceGroup, params.Gallery, a.location); err != nil {
+		return "", fmt.Errorf("failed to create gallery: %w", err)
+	}
+
+	definition := armcompute.GalleryImage{
+		Location: to.Ptr(a.location),
+		Properties: &armcompute.GalleryImageProperties{
+			Identifier: &armcompute.GalleryImageIdentifier{
+				Publisher: to.Ptr(defaultImagePublisher),
+				Offer:     to.Ptr(params.Image),
+				SKU:       to.Ptr(params.Image),
+			},
+			OSState: to.Ptr(armcompute.OperatingSystemStateTypesGeneralized),
+			OSType:  osType,
+		},
+	}
+	if vm.Properties.InstanceView != nil && vm.Properties.InstanceView.HyperVGeneration != nil {
+		definition.Properties.HyperVGeneration = to.Ptr(armcompute.HyperVGeneration(*vm.Properties.InstanceView.HyperVGeneration))
+	}
+	if err := a.ensureImageDefinition(ctx, params.ResourceGroup, params.Gallery, params.Image, definition); err != nil {
+		return "", err
+	}
+
+	return a.createImageVersion(ctx, params.ResourceGroup, params.Gallery, params.Image, params.Version, a.location, *vm.ID)
+}
+
+// generalizeGuest removes machine specific data from inside the VM. Linux VMs are
+// deprovisioned using the Azure Linux agent. Windows VMs are sysprepped, and we wait for
+// sysprep to shut down the VM.
+func (a *AzureCli) generalizeGuest(ctx context.Context, vmName string, osType armcompute.OperatingSystemTypes) error {
+	switch osType {
+	case armcompute.OperatingSystemTypesLinux:
+		if _, err := a.RunShellScript(ctx, a.InstanceResourceGroup(vmName), vmName, "waagent -deprovision+user -force"); err != nil {
+			return err
+		}
+		return nil
+	case armcompute.OperatingSystemTypesWindows:
+		// Sysprep shuts down the VM, so it can't run synchronously under Run Command.
+		script := `Start-Process -FilePath "$env:SystemRoot\System32\Sysprep\sysprep.exe" -ArgumentList "/generalize","/oobe","/shutdown","/quiet","/mode:vm"`
+		if _, err := a.RunPowerShellScript(ctx, a.InstanceResourceGroup(vmName), vmName, script); err != nil {
+			return err
+		}
+		return a.waitForStopped(ctx, vmName)
+	}
+	return fmt.Errorf("unsupported OS type %s", osType)
+}
+
+// waitForStopped polls the VM until it is stopped.
+func (a *AzureCli) waitForStopped(ctx context.Context, vmName string) error {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		vm, err := a.GetInstance(ctx, a.InstanceResourceGroup(vmName), vmName)
+		if err != nil {
+			return err
+		}
+		if vm.Properties != nil && vm.Properties.InstanceView != nil {
+			for _, status := range vm.Properties.InstanceView.Statuses {
+				if status != nil && status.Code != nil && *status.Code == "PowerState/stopped" {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for VM %s to stop: %w", vmName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	armruntime "github.com/Azure/azure-sdk-for-go/sdk/azcore/arm/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+const (
+	// There is no management locks client in the vendored SDK, so locks are managed
+	// through the generic resources client.
+	locksAPIVersion = "2016-09-01"
+	// instanceLockName is the name of the lock the provider places on runner resource groups.
+	instanceLockName = "garm-instance-lock"
+)
+
+func (a *AzureCli) resourceGroupLockID(rgName string) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Authorization/locks/%s", a.cfg.Credentials.SubscriptionID, rgName, instanceLockName)
+}
+
+// LockResourceGroup places a CanNotDelete management lock on a resource group.
+func (a *AzureCli) LockResourceGroup(ctx context.Context, rgName string) error {
+	lock := armresources.GenericResource{
+		Properties: map[string]interface{}{
+			"level": "CanNotDelete",
+			"notes": "Managed by garm. Delete the runner using garm.",
+		},
+	}
+	poller, err := a.resourcesCli.BeginCreateOrUpdateByID(ctx, a.resourceGroupLockID(rgName), locksAPIVersion, lock, nil)
+	if err != nil {
+		return fmt.Errorf("failed to lock resource group: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to lock resource group: %w", err)
+	}
+	return nil
+}
+
+// UnlockResourceGroup removes the management lock placed by LockResourceGroup. It is not
+// an error if the lock does not exist.
+func (a *AzureCli) UnlockResourceGroup(ctx context.Context, rgName string) error {
+	poller, err := a.resourcesCli.BeginDeleteByID(ctx, a.resourceGroupLockID(rgName), locksAPIVersion, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to unlock resource group: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to unlock resource group: %w", err)
+	}
+	return nil
+}
+
+// rawPipeline returns the resource manager endpoint, and a pipeline for requests the
+// vendored SDK has no client for.
+func (a *AzureCli) rawPipeline() (string, runtime.Pipeline, error) {
+	endpoint := cloud.AzurePublic.Services[cloud.ResourceManager].Endpoint
+	if c, ok := a.cfg.Credentials.ClientOptions.Cloud.Services[cloud.ResourceManager]; ok {
+		endpoint = c.Endpoint
+	}
+
+	opts := &arm.ClientOptions{ClientOptions: a.cfg.Credentials.ClientOptions}
+	if len(a.cfg.APIVersions) > 0 {
+		opts.PerCallPolicies = append(opts.PerCallPolicies, &apiVersionPolicy{cfg: a.cfg})
+	}
+	pl, err := armruntime.NewPipeline("garm-provider-azure", "v0.0.0", a.cred, runtime.PipelineOptions{}, opts)
+	if err != nil {
+		return "", runtime.Pipeline{}, fmt.Errorf("failed to create pipeline: %w", err)
+	}
+	return endpoint, pl, nil
+}
+
+// listResourceGroupLocks returns the IDs of all management locks on a resource group and
+// the resources in it. Locks inherited from the subscription are listed as well. The
+// generic resources client can't list resources of a type, so the request is sent through
+// a pipeline of our own.
+func (a *AzureCli) listResourceGroupLocks(ctx context.Context, rgName string) ([]string, error) {
+	endpoint, pl, err := a.rawPipeline()
+	if err != nil {
+		return nil, err
+	}
+
+	urlPath := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Authorization/locks", url.PathEscape(a.cfg.Credentials.SubscriptionID), url.PathEscape(rgName))
+	req, err := runtime.NewRequest(ctx, http.MethodGet, runtime.JoinPaths(endpoint, urlPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	query := req.Raw().URL.Query()
+	query.Set("api-version", locksAPIVersion)
+	req.Raw().URL.RawQuery = query.Encode()
+	req.Raw().Header["Accept"] = []string{"application/json"}
+
+	resp, err := pl.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list locks: %w", err)
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK) {
+		return nil, runtime.NewResponseError(resp)
+	}
+
+	var result struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
+	}
+	if err := runtime.UnmarshalAsJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode locks: %w", err)
+	}
+
+	var ret []string
+	for _, lock := range result.Value {
+		if lock.ID != "" {
+			ret = append(ret, lock.ID)
+		}
+	}
+	return ret, nil
+}
+
+// isResourceGroupLock returns true if a lock is placed on the resource group or on one of
+// the resources in it, rather than inherited from the subscription.
+func (a *AzureCli) isResourceGroupLock(rgName, lockID string) bool {
+	scope := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/", a.cfg.Credentials.SubscriptionID, rgName)
+	return strings.HasPrefix(strings.ToLower(lockID), strings.ToLower(scope))
+}
+
+// removeResourceGroupLocks removes the management locks on a resource group and the
+// resources in it, including locks that were not placed by the provider. Locks inherited
+// from the subscription are left alone. It returns the number of locks removed.
+func (a *AzureCli) removeResourceGroupLocks(ctx context.Context, rgName string) (int, error) {
+	locks, err := a.listResourceGroupLocks(ctx, rgName)
+	if err != nil {
+		return 0, err
+	}
+	var removed int
+	for _, lockID := range locks {
+		if !a.isResourceGroupLock(rgName, lockID) {
+			log.Printf("not removing inherited management lock %s", lockID)
+			continue
+		}
+		log.Printf("removing management lock %s", lockID)
+		poller, err := a.resourcesCli.BeginDeleteByID(ctx, lockID, locksAPIVersion, nil)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return removed, fmt.Errorf("failed to remove lock %s: %w", lockID, err)
+		}
+		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			return removed, fmt.Errorf("failed to remove lock %s: %w", lockID, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
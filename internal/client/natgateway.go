@@ -0,0 +1,150 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// poolSubnetName is the subnet of a pool virtual network that runners are attached to.
+const poolSubnetName = "runners"
+
+// poolNetworkName is the name of the NAT gateway, public IP prefix and virtual network of
+// a pool.
+func poolNetworkName(poolID string) string {
+	return fmt.Sprintf("garm-%s", poolID)
+}
+
+// PoolSubnetID returns the ID of the subnet the runners of a pool with a NAT gateway are
+// attached to.
+func (a *AzureCli) PoolSubnetID(poolID string) string {
+	return a.networkResourceID(a.cfg.NATGateways.ResourceGroup, "virtualNetworks", poolNetworkName(poolID), "subnets", poolSubnetName)
+}
+
+// EnsurePoolNATGateway creates the public IP prefix, the NAT gateway and the virtual
+// network of a pool, if they don't exist, and returns the ID of the subnet its runners are
+// attached to, and the public IP prefix they egress through. The resources are shared by
+// all runners of the pool, and are kept when they are deleted.
+func (a *AzureCli) EnsurePoolNATGateway(ctx context.Context, poolID, vnetCIDR, subnetCIDR string) (string, string, error) {
+	rgName := a.cfg.NATGateways.ResourceGroup
+	name := poolNetworkName(poolID)
+	tags := map[string]*string{util.PoolIDTagName: to.Ptr(poolID)}
+
+	subnet, err := a.subnetCli.Get(ctx, rgName, name, poolSubnetName, nil)
+	if err == nil && subnet.Properties != nil && subnet.Properties.NatGateway != nil {
+		prefix, err := a.poolIPPrefix(ctx, rgName, name)
+		return *subnet.ID, prefix, err
+	}
+	if err != nil && !isNotFound(err) {
+		return "", "", fmt.Errorf("failed to get pool subnet: %w", err)
+	}
+
+	rgCli := a.rgCli
+	if a.netRGCli != nil {
+		rgCli = a.netRGCli
+	}
+	if _, err := rgCli.CreateOrUpdate(ctx, rgName, armresources.ResourceGroup{Location: to.Ptr(a.location)}, nil); err != nil {
+		return "", "", fmt.Errorf("failed to create NAT gateway resource group: %w", err)
+	}
+
+	prefixPoller, err := a.pubIPPrefixCli.BeginCreateOrUpdate(ctx, rgName, name, armnetwork.PublicIPPrefix{
+		Location: to.Ptr(a.location),
+		Tags:     tags,
+		SKU: &armnetwork.PublicIPPrefixSKU{
+			Name: to.Ptr(armnetwork.PublicIPPrefixSKUNameStandard),
+		},
+		Properties: &armnetwork.PublicIPPrefixPropertiesFormat{
+			PrefixLength:           to.Ptr(a.cfg.NATGateways.GetPrefixLength()),
+			PublicIPAddressVersion: to.Ptr(armnetwork.IPVersionIPv4),
+		},
+	}, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create public IP prefix: %w", err)
+	}
+	prefix, err := prefixPoller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create public IP prefix: %w", err)
+	}
+
+	natPoller, err := a.natCli.BeginCreateOrUpdate(ctx, rgName, name, armnetwork.NatGateway{
+		Location: to.Ptr(a.location),
+		Tags:     tags,
+		SKU: &armnetwork.NatGatewaySKU{
+			Name: to.Ptr(armnetwork.NatGatewaySKUNameStandard),
+		},
+		Properties: &armnetwork.NatGatewayPropertiesFormat{
+			IdleTimeoutInMinutes: to.Ptr(a.cfg.NATGateways.GetIdleTimeoutMinutes()),
+			PublicIPPrefixes:     []*armnetwork.SubResource{{ID: prefix.ID}},
+		},
+	}, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create NAT gateway: %w", err)
+	}
+	natGateway, err := natPoller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create NAT gateway: %w", err)
+	}
+
+	// Creating the virtual network with a PUT would remove the subnet of a virtual network
+	// that already exists.
+	if _, err := a.netCli.Get(ctx, rgName, name, nil); err != nil {
+		if !isNotFound(err) {
+			return "", "", fmt.Errorf("failed to get pool virtual network: %w", err)
+		}
+		vnetPoller, err := a.netCli.BeginCreateOrUpdate(ctx, rgName, name, a.virtualNetworkParams(vnetCIDR, tags), nil)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to create pool virtual network: %w", err)
+		}
+		if _, err := vnetPoller.PollUntilDone(ctx, nil); err != nil {
+			return "", "", fmt.Errorf("failed to create pool virtual network: %w", err)
+		}
+	}
+
+	subnetParams := a.subnetParams(subnetCIDR)
+	subnetParams.Properties.NatGateway = &armnetwork.SubResource{ID: natGateway.ID}
+	subnetPoller, err := a.subnetCli.BeginCreateOrUpdate(ctx, rgName, name, poolSubnetName, subnetParams, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create pool subnet: %w", err)
+	}
+	created, err := subnetPoller.PollUntilDone(ctx, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create pool subnet: %w", err)
+	}
+
+	var ipPrefix string
+	if prefix.Properties != nil && prefix.Properties.IPPrefix != nil {
+		ipPrefix = *prefix.Properties.IPPrefix
+	}
+	return *created.ID, ipPrefix, nil
+}
+
+// poolIPPrefix returns the public IP prefix of a pool, like 20.1.2.4/31.
+func (a *AzureCli) poolIPPrefix(ctx context.Context, rgName, name string) (string, error) {
+	prefix, err := a.pubIPPrefixCli.Get(ctx, rgName, name, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get public IP prefix: %w", err)
+	}
+	if prefix.Properties == nil || prefix.Properties.IPPrefix == nil {
+		return "", nil
+	}
+	return *prefix.Properties.IPPrefix, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// QuotaUsage is the usage of a single quota, in the configured location.
+type QuotaUsage struct {
+	// Provider is the resource provider the quota belongs to (compute or network).
+	Provider string
+	Name     string
+	Current  int64
+	Limit    int64
+}
+
+// HeadroomPercent returns the unused part of the quota, in percent of the limit.
+func (q QuotaUsage) HeadroomPercent() float64 {
+	if q.Limit <= 0 {
+		return 0
+	}
+	return float64(q.Limit-q.Current) * 100 / float64(q.Limit)
+}
+
+// ListQuotaUsage returns the compute and network quota usage in the configured location.
+func (a *AzureCli) ListQuotaUsage(ctx context.Context) ([]QuotaUsage, error) {
+	var ret []QuotaUsage
+
+	computePager := a.usageCli.NewListPager(a.location, nil)
+	for computePager.More() {
+		page, err := computePager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list compute usage: %w", err)
+		}
+		for _, usage := range page.Value {
+			if usage == nil || usage.Name == nil || usage.Name.Value == nil || usage.CurrentValue == nil || usage.Limit == nil {
+				continue
+			}
+			ret = append(ret, QuotaUsage{
+				Provider: "compute",
+				Name:     *usage.Name.Value,
+				Current:  int64(*usage.CurrentValue),
+				Limit:    *usage.Limit,
+			})
+		}
+	}
+
+	networkPager := a.netUsageCli.NewListPager(a.location, nil)
+	for networkPager.More() {
+		page, err := networkPager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list network usage: %w", err)
+		}
+		for _, usage := range page.Value {
+			if usage == nil || usage.Name == nil || usage.Name.Value == nil || usage.CurrentValue == nil || usage.Limit == nil {
+				continue
+			}
+			ret = append(ret, QuotaUsage{
+				Provider: "network",
+				Name:     *usage.Name.Value,
+				Current:  *usage.CurrentValue,
+				Limit:    *usage.Limit,
+			})
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+func scaleSetName(poolID string) string {
+	return fmt.Sprintf("garm-%s", poolID)
+}
+
+// ScaleSetID returns the ID of the scale set of a pool.
+func (a *AzureCli) ScaleSetID(poolID string) string {
+	return fmt.Sprintf(
+		"/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s",
+		a.cfg.Credentials.SubscriptionID, a.cfg.ScaleSets.ResourceGroup, scaleSetName(poolID))
+}
+
+// EnsureScaleSet creates the resource group and the scale set of a pool, if they don't
+// exist. The scale set uses Flexible orchestration and has no VM profile, runners are
+// added to it as standalone VMs. A zonal scale set only accepts VMs in its zones, so the
+// zones are only set when the scale set is created.
+func (a *AzureCli) EnsureScaleSet(ctx context.Context, poolID string, zones []string) error {
+	scaleSets := a.cfg.ScaleSets
+	_, err := a.vmssCli.Get(ctx, scaleSets.ResourceGroup, scaleSetName(poolID), nil)
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("failed to get scale set: %w", err)
+	}
+
+	if _, err := a.rgCli.CreateOrUpdate(ctx, scaleSets.ResourceGroup, armresources.ResourceGroup{Location: to.Ptr(a.location)}, nil); err != nil {
+		return fmt.Errorf("failed to create scale set resource group: %w", err)
+	}
+
+	scaleSet := armcompute.VirtualMachineScaleSet{
+		Location: to.Ptr(a.location),
+		Properties: &armcompute.VirtualMachineScaleSetProperties{
+			OrchestrationMode:        to.Ptr(armcompute.OrchestrationModeFlexible),
+			PlatformFaultDomainCount: to.Ptr(scaleSets.GetPlatformFaultDomainCount()),
+		},
+	}
+	for _, zone := range zones {
+		scaleSet.Zones = append(scaleSet.Zones, to.Ptr(zone))
+	}
+	poller, err := a.vmssCli.BeginCreateOrUpdate(ctx, scaleSets.ResourceGroup, scaleSetName(poolID), scaleSet, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create scale set: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to create scale set: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	diskAPIVersion = "2021-12-01"
+
+	diskType             = "Microsoft.Compute/disks"
+	metricAlertType      = "Microsoft.Insights/metricAlerts"
+	shutdownScheduleType = "Microsoft.DevTestLab/schedules"
+)
+
+// sharedResourceDeleteOrder is the order the resources of a runner in the shared resource
+// group are deleted in. A resource can only be deleted once nothing references it.
+var sharedResourceDeleteOrder = []string{
+	interfaceType,
+	publicIPType,
+	securityGroupType,
+	virtualNetworkType,
+	diskType,
+	metricAlertType,
+	shutdownScheduleType,
+}
+
+// sharedResourceAPIVersions are the API versions used to delete the resources of a runner
+// in the shared resource group.
+var sharedResourceAPIVersions = map[string]string{
+	interfaceType:        networkAPIVersion,
+	publicIPType:         networkAPIVersion,
+	securityGroupType:    networkAPIVersion,
+	virtualNetworkType:   networkAPIVersion,
+	diskType:             diskAPIVersion,
+	metricAlertType:      metricAlertAPIVersion,
+	shutdownScheduleType: shutdownScheduleAPIVersion,
+}
+
+// InstanceResourceGroup returns the resource group holding the resources of an instance.
+// This is the shared resource group if one is configured, or the resource group named
+// after the instance.
+func (a *AzureCli) InstanceResourceGroup(name string) string {
+	if a.cfg.SharedResourceGroup != "" {
+		return a.sharedResourceGroup
+	}
+	return name
+}
+
+// WithSharedResourceGroup returns a copy of the client that places the resources of
+// runners in another shared resource group, like the one of their pool.
+func (a *AzureCli) WithSharedResourceGroup(rgName string) *AzureCli {
+	withRG := *a
+	withRG.sharedResourceGroup = rgName
+	return &withRG
+}
+
+// ForInstance returns a copy of the client bound to the shared resource group holding the
+// VM of an instance of the controller, as pools can use a shared resource group of their
+// own. Without a VM, the configured shared resource group is used.
+func (a *AzureCli) ForInstance(ctx context.Context, controllerID, name string) (*AzureCli, error) {
+	if a.cfg.SharedResourceGroup == "" {
+		return a, nil
+	}
+	rgName, err := a.findVirtualMachineResourceGroup(ctx, controllerID, name)
+	if err != nil {
+		return nil, err
+	}
+	if rgName == "" {
+		return a, nil
+	}
+	return a.WithSharedResourceGroup(rgName), nil
+}
+
+// findVirtualMachineResourceGroup returns the resource group of the VM with the given name
+// that is tagged with the controller ID, or an empty string if there is no such VM in the
+// subscription. An empty controller ID matches the VMs of any controller. VM names are
+// only unique within a resource group, so more than one match is an error, rather than
+// acting on the VM of another pool or controller.
+func (a *AzureCli) findVirtualMachineResourceGroup(ctx context.Context, controllerID, name string) (string, error) {
+	opts := &armresources.ClientListOptions{
+		Filter: to.Ptr(fmt.Sprintf("resourceType eq 'Microsoft.Compute/virtualMachines' and name eq '%s'", name)),
+	}
+	var groups []string
+	pager := a.resourcesCli.NewListPager(opts)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up VM %s: %w", name, err)
+		}
+		for _, resource := range page.Value {
+			if resource == nil || resource.ID == nil || resource.Name == nil || !strings.EqualFold(*resource.Name, name) {
+				continue
+			}
+			if tag := resource.Tags[util.ControllerIDTagName]; controllerID != "" && (tag == nil || *tag != controllerID) {
+				continue
+			}
+			id, err := arm.ParseResourceID(*resource.ID)
+			if err != nil {
+				continue
+			}
+			groups = append(groups, id.ResourceGroupName)
+		}
+	}
+	switch len(groups) {
+	case 0:
+		return "", nil
+	case 1:
+		return groups[0], nil
+	}
+	return "", fmt.Errorf("found more than one VM named %s, in resource groups %s", name, strings.Join(groups, ", "))
+}
+
+// EnsureSharedResourceGroup creates the shared resource group, if it does not exist.
+func (a *AzureCli) EnsureSharedResourceGroup(ctx context.Context) error {
+	rg, err := a.GetResourceGroup(ctx, a.sharedResourceGroup)
+	if err != nil {
+		return fmt.Errorf("failed to get shared resource group: %w", err)
+	}
+	if rg != nil {
+		return nil
+	}
+	if _, err := a.rgCli.CreateOrUpdate(ctx, a.sharedResourceGroup, armresources.ResourceGroup{Location: to.Ptr(a.location)}, nil); err != nil {
+		return fmt.Errorf("failed to create shared resource group: %w", err)
+	}
+	return nil
+}
+
+// DeleteInstance deletes the resource group of an instance, or its resources in the shared
+// resource group.
+func (a *AzureCli) DeleteInstance(ctx context.Context, name string) error {
+	if a.cfg.SharedResourceGroup != "" {
+		return a.DeleteInstanceResources(ctx, name)
+	}
+	return a.DeleteResourceGroup(ctx, name, true)
+}
+
+// DeleteInstanceResources deletes the VM of an instance in the shared resource group, and
+// then the rest of its resources, which are found by name. The deployment of the instance
+// is removed as well, as a resource group holds at most 800 deployments.
+func (a *AzureCli) DeleteInstanceResources(ctx context.Context, name string) error {
+	rgName := a.sharedResourceGroup
+	if err := a.DeleteVirtualMachine(ctx, rgName, name); err != nil {
+		return err
+	}
+
+	var resources []*armresources.GenericResourceExpanded
+	pager := a.resourcesCli.NewListByResourceGroupPager(rgName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list resources of %s: %w", name, err)
+		}
+		for _, resource := range page.Value {
+			if resource == nil || resource.ID == nil || resource.Type == nil || resource.Name == nil {
+				continue
+			}
+			if sharedResourceOrder(*resource.Type) < 0 || !a.isInstanceResource(name, *resource.Type, *resource.Name) {
+				continue
+			}
+			resources = append(resources, resource)
+		}
+	}
+	sort.SliceStable(resources, func(i, j int) bool {
+		return sharedResourceOrder(*resources[i].Type) < sharedResourceOrder(*resources[j].Type)
+	})
+
+	for _, resource := range resources {
+		resourceType := canonicalResourceType(*resource.Type)
+		poller, err := a.resourcesCli.BeginDeleteByID(ctx, *resource.ID, a.apiVersion(resourceType, sharedResourceAPIVersions[resourceType]), nil)
+		if err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to delete %s: %w", *resource.ID, err)
+		}
+		if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", *resource.ID, err)
+		}
+	}
+
+	poller, err := a.deploymentsCli.BeginDelete(ctx, rgName, name, nil)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete deployment: %w", err)
+	}
+	if _, err := poller.PollUntilDone(ctx, nil); err != nil {
+		return fmt.Errorf("failed to delete deployment: %w", err)
+	}
+	return nil
+}
+
+// sharedResourceOrder returns the position of a resource type in the delete order, or -1
+// if resources of that type are not created for runners.
+func sharedResourceOrder(resourceType string) int {
+	for idx, val := range sharedResourceDeleteOrder {
+		if strings.EqualFold(val, resourceType) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// canonicalResourceType returns the resource type as spelled in sharedResourceDeleteOrder.
+// Azure does not always preserve the case of resource types.
+func canonicalResourceType(resourceType string) string {
+	if idx := sharedResourceOrder(resourceType); idx >= 0 {
+		return sharedResourceDeleteOrder[idx]
+	}
+	return resourceType
+}
+
+// isInstanceResource returns true if a resource in the shared resource group belongs to
+// the instance. Resources are named after the instance, except for metric alerts, which
+// are suffixed with the name of an alert template, and the auto-shutdown schedule.
+func (a *AzureCli) isInstanceResource(name, resourceType, resourceName string) bool {
+	switch canonicalResourceType(resourceType) {
+	case metricAlertType:
+		for template := range a.cfg.AlertTemplates {
+			if strings.EqualFold(resourceName, metricAlertName(name, template)) {
+				return true
+			}
+		}
+		return false
+	case shutdownScheduleType:
+		return resourceName == shutdownScheduleName(name)
+	default:
+		return resourceName == name
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+)
+
+// VMSize holds the details of a VM size the provider needs, in the configured location.
+type VMSize struct {
+	Name         string            `json:"name"`
+	Capabilities map[string]string `json:"capabilities"`
+	Zones        []string          `json:"zones"`
+	// Restricted is set when the size can't be used in the location at all.
+	Restricted bool `json:"restricted"`
+	// RestrictedZones are the zones the size can't be used in.
+	RestrictedZones []string `json:"restricted_zones"`
+	// RestrictionReason is the reason code of the restrictions, if any.
+	RestrictionReason string `json:"restriction_reason"`
+}
+
+// Validate returns an error if the size can't be used in the location, or in the zone.
+func (v VMSize) Validate(zone string) error {
+	if v.Restricted {
+		return fmt.Errorf("VM size %s is not available in this location (%s)", v.Name, v.RestrictionReason)
+	}
+	if zone == "" {
+		return nil
+	}
+	for _, restricted := range v.RestrictedZones {
+		if restricted == zone {
+			return fmt.Errorf("VM size %s is not available in zone %s (%s)", v.Name, zone, v.RestrictionReason)
+		}
+	}
+	return nil
+}
+
+// CPUArchitecture returns the CPU architecture of the size, x64 or Arm64.
+func (v VMSize) CPUArchitecture() string {
+	if arch, ok := v.Capabilities["CpuArchitectureType"]; ok && arch != "" {
+		return arch
+	}
+	return string(armcompute.ArchitectureTypesX64)
+}
+
+// AcceleratedNetworking returns whether the size supports accelerated networking. Sizes
+// whose SKU doesn't say are assumed to support it.
+func (v VMSize) AcceleratedNetworking() bool {
+	return !strings.EqualFold(v.Capabilities["AcceleratedNetworkingEnabled"], "False")
+}
+
+type vmSizeCache struct {
+	FetchedAt time.Time         `json:"fetched_at"`
+	Location  string            `json:"location"`
+	Sizes     map[string]VMSize `json:"sizes"`
+}
+
+// skuCacheFile returns the SKU cache of the location of the client. Each region a client
+// is used in gets its own cache.
+func (a *AzureCli) skuCacheFile() string {
+	cacheFile := a.cfg.GetSKUCacheFile()
+	if strings.EqualFold(a.location, a.cfg.Location) {
+		return cacheFile
+	}
+	ext := filepath.Ext(cacheFile)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(cacheFile, ext), strings.ToLower(a.location), ext)
+}
+
+// GetVMSize returns the details of a VM size, from the SKU cache.
+func (a *AzureCli) GetVMSize(ctx context.Context, name string) (VMSize, error) {
+	sizes, err := a.GetVMSizes(ctx)
+	if err != nil {
+		return VMSize{}, err
+	}
+	size, ok := sizes[strings.ToLower(name)]
+	if !ok {
+		return VMSize{}, fmt.Errorf("VM size %s does not exist in %s", name, a.location)
+	}
+	return size, nil
+}
+
+// GetVMSizes returns the VM sizes in the configured location, keyed by lower case name.
+// Listing the SKUs of a location is slow, so they are cached on disk and shared by all
+// provider processes.
+func (a *AzureCli) GetVMSizes(ctx context.Context) (map[string]VMSize, error) {
+	cacheFile := a.skuCacheFile()
+	if data, err := os.ReadFile(cacheFile); err == nil {
+		var cached vmSizeCache
+		if err := json.Unmarshal(data, &cached); err != nil {
+			log.Printf("ignoring invalid SKU cache %s: %s", cacheFile, err)
+		} else if cached.Location == a.location && time.Since(cached.FetchedAt) < a.cfg.GetSKUCacheTTL() {
+			return cached.Sizes, nil
+		}
+	}
+	return a.RefreshVMSizes(ctx)
+}
+
+// RefreshVMSizes lists the VM sizes in the configured location, and updates the SKU cache.
+func (a *AzureCli) RefreshVMSizes(ctx context.Context) (map[string]VMSize, error) {
+	opts := &armcompute.ResourceSKUsClientListOptions{
+		Filter: to.Ptr(fmt.Sprintf("location eq '%s'", a.location)),
+	}
+	sizes := map[string]VMSize{}
+	pager := a.resourceSKUCli.NewListPager(opts)
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list VM sizes: %w", err)
+		}
+		for _, sku := range resp.ResourceSKUsResult.Value {
+			if sku == nil || sku.ResourceType == nil || *sku.ResourceType != "virtualMachines" || sku.Name == nil {
+				continue
+			}
+			size := vmSizeFromSKU(sku)
+			sizes[strings.ToLower(size.Name)] = size
+		}
+	}
+
+	cached := vmSizeCache{
+		FetchedAt: time.Now().UTC(),
+		Location:  a.location,
+		Sizes:     sizes,
+	}
+	if err := writeVMSizeCache(a.skuCacheFile(), cached); err != nil {
+		log.Printf("failed to write SKU cache: %s", err)
+	}
+	return sizes, nil
+}
+
+func writeVMSizeCache(path string, cached vmSizeCache) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache: %w", err)
+	}
+	// Write to a temp file first, so concurrent readers never see a partial cache.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func vmSizeFromSKU(sku *armcompute.ResourceSKU) VMSize {
+	size := VMSize{
+		Name:         *sku.Name,
+		Capabilities: map[string]string{},
+	}
+	for _, capability := range sku.Capabilities {
+		if capability != nil && capability.Name != nil && capability.Value != nil {
+			size.Capabilities[*capability.Name] = *capability.Value
+		}
+	}
+	for _, locationInfo := range sku.LocationInfo {
+		if locationInfo == nil {
+			continue
+		}
+		for _, zone := range locationInfo.Zones {
+			if zone != nil {
+				size.Zones = append(size.Zones, *zone)
+			}
+		}
+	}
+	for _, restriction := range sku.Restrictions {
+		if restriction == nil || restriction.Type == nil {
+			continue
+		}
+		if restriction.ReasonCode != nil {
+			size.RestrictionReason = string(*restriction.ReasonCode)
+		}
+		switch *restriction.Type {
+		case armcompute.ResourceSKURestrictionsTypeLocation:
+			size.Restricted = true
+		case armcompute.ResourceSKURestrictionsTypeZone:
+			if restriction.RestrictionInfo == nil {
+				continue
+			}
+			for _, zone := range restriction.RestrictionInfo.Zones {
+				if zone != nil {
+					size.RestrictedZones = append(size.RestrictedZones, *zone)
+				}
+			}
+		}
+	}
+	return size
+}
+
+// EphemeralDiskSizeLimits returns the sizes of the disks an ephemeral OS disk can be placed
+// on, if the size supports ephemeral OS disks.
+func (v VMSize) EphemeralDiskSizeLimits() (spec.VMSizeEphemeralDiskSizeLimits, error) {
+	var res spec.VMSizeEphemeralDiskSizeLimits
+	if v.Capabilities["EphemeralOSDiskSupported"] != "True" {
+		return res, fmt.Errorf("VM size %s does not support ephemeral OS disks", v.Name)
+	}
+	if cacheBytes := v.Capabilities["CachedDiskBytes"]; cacheBytes != "" {
+		asInt64, err := strconv.ParseInt(cacheBytes, 10, 64)
+		if err != nil {
+			return res, fmt.Errorf("failed to parse cache bytes: %w", err)
+		}
+		res.CacheDiskSizeGB = int32(asInt64 / 1024 / 1024 / 1024)
+	}
+	if resourceDiskMB := v.Capabilities["MaxResourceVolumeMB"]; resourceDiskMB != "" {
+		asInt64, err := strconv.ParseInt(resourceDiskMB, 10, 64)
+		if err != nil {
+			return res, fmt.Errorf("failed to parse resource disk MB: %w", err)
+		}
+		res.ResourceDiskSizeGB = int32(asInt64 / 1024)
+	}
+	if res.CacheDiskSizeGB == 0 && res.ResourceDiskSizeGB == 0 {
+		return res, fmt.Errorf("VM size %s has no disk to place an ephemeral OS disk on", v.Name)
+	}
+	return res, nil
+}
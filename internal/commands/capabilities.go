@@ -0,0 +1,88 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/cloudbase/garm-provider-common/params"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// The capabilities command lists the other commands, so it is registered here to avoid an
+// initialization cycle.
+func init() {
+	commands["capabilities"] = command{
+		description: "Print the architectures, OS types, extra specs schema and other features of the provider as JSON",
+		run:         showCapabilities,
+	}
+}
+
+// capabilities describes what this build of the provider supports.
+type capabilities struct {
+	Provider          string                 `json:"provider"`
+	Version           string                 `json:"version"`
+	Architectures     []params.OSArch        `json:"architectures"`
+	OSTypes           []params.OSType        `json:"os_types"`
+	UserDataFormats   []spec.UserDataFormat  `json:"userdata_formats"`
+	CreationModes     []config.CreationMode  `json:"creation_modes"`
+	AuthModes         []string               `json:"auth_modes"`
+	CloudEnvironments []string               `json:"cloud_environments"`
+	Commands          []string               `json:"commands"`
+	ExtraSpecsSchema  map[string]interface{} `json:"extra_specs_schema"`
+}
+
+func showCapabilities(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("capabilities", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	caps := capabilities{
+		Provider:        "azure",
+		Version:         util.ProviderVersion(),
+		Architectures:   spec.SupportedArchitectures,
+		OSTypes:         []params.OSType{params.Linux, params.Windows},
+		UserDataFormats: []spec.UserDataFormat{spec.UserDataFormatCloudInit, spec.UserDataFormatIgnition},
+		CreationModes:   []config.CreationMode{config.CreationModeSDK, config.CreationModeDeployment},
+		AuthModes:       []string{"service_principal", "managed_identity", "workload_identity"},
+		// Other clouds, like Azure Stack, can be used by setting their endpoints in
+		// client_options.
+		CloudEnvironments: []string{"AzurePublic", "AzureChina", "AzureGovernment", "custom"},
+		Commands:          names,
+		ExtraSpecsSchema:  spec.ExtraSpecsSchema(),
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(caps); err != nil {
+		return fmt.Errorf("failed to write capabilities: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// startChaosExperiment starts a Chaos Studio experiment, optionally pointed at the
+// enrolled runners of a pool first.
+func startChaosExperiment(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("chaos")
+	experiment := fs.String("experiment", "", "resource ID of the Chaos Studio experiment to start")
+	pool := fs.String("pool", "", "point the list selectors of the experiment to the enrolled runners of this pool first")
+	dryRun := fs.Bool("dry-run", false, "only list the runners the experiment would target")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(map[string]string{"experiment": *experiment}); err != nil {
+		return err
+	}
+
+	_, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	if *pool != "" {
+		vms, err := azCli.ListVirtualMachines(ctx, *pool)
+		if err != nil {
+			return fmt.Errorf("failed to list runners: %w", err)
+		}
+		var names []string
+		for _, vm := range vms {
+			if vm.Name == nil {
+				continue
+			}
+			if tag, ok := vm.Tags[util.ChaosTargetTagName]; !ok || tag == nil || *tag != "true" {
+				continue
+			}
+			names = append(names, *vm.Name)
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("pool %s has no runners enrolled as chaos targets (chaos_target extra spec)", *pool)
+		}
+		sort.Strings(names)
+
+		targets := make([]string, 0, len(names))
+		for _, name := range names {
+			fmt.Printf("%s: targeted\n", name)
+			targets = append(targets, azCli.ChaosTargetID(name))
+		}
+		if *dryRun {
+			return nil
+		}
+		if err := azCli.SetChaosExperimentTargets(ctx, *experiment, targets); err != nil {
+			return err
+		}
+	} else if *dryRun {
+		return fmt.Errorf("-dry-run requires -pool")
+	}
+
+	if err := azCli.StartChaosExperiment(ctx, *experiment); err != nil {
+		return err
+	}
+	fmt.Printf("started chaos experiment %s\n", *experiment)
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package commands implements the operator facing verbs of the provider binary. garm
+// runs the provider without arguments, so these never interfere with the provider
+// protocol. They use the same config file as the provider.
+package commands
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+type command struct {
+	description string
+	run         func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"copy-image": {
+		description: "Copy a managed image or gallery image version to a gallery in another region",
+		run:         copyImage,
+	},
+	"capture-image": {
+		description: "Generalize a runner VM and capture it into a gallery image version",
+		run:         captureImage,
+	},
+	"chaos": {
+		description: "Start a Chaos Studio experiment, optionally against the enrolled runners of a pool",
+		run:         startChaosExperiment,
+	},
+	"finalize-create": {
+		description: "Wait for an instance created with async_create, and record the result (started by the provider)",
+		run:         finalizeCreate,
+	},
+	"gc": {
+		description: "Remove the resource groups of a controller that hold no healthy VM",
+		run:         collectGarbage,
+	},
+	"healthcheck": {
+		description: "Check the credentials, and the reachability and quota headroom of the configured regions",
+		run:         healthcheck,
+	},
+	"maintain-pool": {
+		description: "Check the cloud-init status, costs and health of the runners of a pool (started by the provider)",
+		run:         maintainPool,
+	},
+	"migrate-controller": {
+		description: "Re-tag the runners of an old garm controller ID with a new one",
+		run:         migrateController,
+	},
+	"prefetch": {
+		description: "Refresh the cached VM sizes of the configured location, and check the configured sizes",
+		run:         prefetch,
+	},
+	"quota": {
+		description: "Show the compute and network quota usage in the configured location",
+		run:         showQuota,
+	},
+	"recover": {
+		description: "Roll back interrupted creates and finish interrupted deletes of a controller's instances",
+		run:         recoverInstances,
+	},
+	"simulate-eviction": {
+		description: "Evict a spot runner, to test the eviction handling of its pool",
+		run:         simulateEviction,
+	},
+	"sync-github-meta": {
+		description: "Refresh the cached GitHub IP ranges, and update the egress rules of github-only runners",
+		run:         syncGitHubMeta,
+	},
+	"usage": {
+		description: "Export the instance hours, VM sizes and spot ratio of each pool over a date range",
+		run:         usageReport,
+	},
+}
+
+// Run runs the verb in args[0], with the rest of args as its flags.
+func Run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+	if args[0] == "version" || args[0] == "-version" || args[0] == "--version" {
+		fmt.Fprintln(os.Stdout, util.VersionInfo())
+		return nil
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage(os.Stderr)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return cmd.run(ctx, args[1:])
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-20s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(w, "  %-20s %s\n", "version", "Print the provider version")
+	fmt.Fprintf(w, "\nRun %s <command> -h for the flags of a command.\n", os.Args[0])
+}
+
+// newFlagSet returns a flag set with the common -config flag.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	cfgFile := fs.String("config", "", "path to the provider config file")
+	return fs, cfgFile
+}
+
+// newClient loads the config file and returns an Azure client.
+func newClient(cfgFile string) (*config.Config, *client.AzureCli, error) {
+	if cfgFile == "" {
+		return nil, nil, fmt.Errorf("missing -config")
+	}
+	cfg, err := config.NewConfig(cfgFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading config: %w", err)
+	}
+	azCli, err := client.NewAzCLI(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get azure CLI: %w", err)
+	}
+	return cfg, azCli, nil
+}
+
+// requireFlags returns an error listing the flags that were not set.
+func requireFlags(flags map[string]string) error {
+	var missing []string
+	for name, val := range flags {
+		if val == "" {
+			missing = append(missing, "-"+name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("missing required flags: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	// evictionWaitTimeout is how long simulate-eviction -wait waits for the VM to go away.
+	evictionWaitTimeout  = 5 * time.Minute
+	evictionPollInterval = 10 * time.Second
+)
+
+// simulateEviction evicts a spot runner, so the eviction handling of a pool can be tested
+// end to end.
+func simulateEviction(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("simulate-eviction")
+	instance := fs.String("instance", "", "name of the spot runner to evict")
+	pool := fs.String("pool", "", "evict a spot runner of this pool, instead of a named one")
+	wait := fs.Bool("wait", false, "wait for the runner to be deallocated or deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if (*instance == "") == (*pool == "") {
+		return fmt.Errorf("exactly one of -instance and -pool is required")
+	}
+
+	_, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	var vm armcompute.VirtualMachine
+	if *instance != "" {
+		instCli, err := azCli.ForInstance(ctx, "", *instance)
+		if err != nil {
+			return err
+		}
+		if vm, err = instCli.GetInstance(ctx, instCli.InstanceResourceGroup(*instance), *instance); err != nil {
+			return err
+		}
+	} else {
+		if vm, err = pickSpotRunner(ctx, azCli, *pool); err != nil {
+			return err
+		}
+	}
+	if vm.ID == nil || vm.Name == nil {
+		return fmt.Errorf("VM is missing its ID")
+	}
+	if vm.Properties == nil || vm.Properties.Priority == nil || *vm.Properties.Priority != armcompute.VirtualMachinePriorityTypesSpot {
+		return fmt.Errorf("%s is not a spot VM", *vm.Name)
+	}
+	id, err := arm.ParseResourceID(*vm.ID)
+	if err != nil {
+		return fmt.Errorf("failed to parse VM ID: %w", err)
+	}
+
+	if err := azCli.SimulateEviction(ctx, id.ResourceGroupName, *vm.Name); err != nil {
+		return err
+	}
+	policy := armcompute.VirtualMachineEvictionPolicyTypesDeallocate
+	if vm.Properties.EvictionPolicy != nil {
+		policy = *vm.Properties.EvictionPolicy
+	}
+	fmt.Printf("%s: eviction requested, the VM will be evicted (eviction policy %s) within about 30 seconds\n", *vm.Name, policy)
+	if !*wait {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, evictionWaitTimeout)
+	defer cancel()
+	for {
+		current, err := azCli.GetInstance(ctx, id.ResourceGroupName, *vm.Name)
+		if err != nil {
+			if client.IsNotFound(err) {
+				fmt.Printf("%s: deleted\n", *vm.Name)
+				return nil
+			}
+			return err
+		}
+		if state := util.AzurePowerStateToGarmPowerState(current); state == "stopped" {
+			fmt.Printf("%s: deallocated\n", *vm.Name)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to be evicted: %w", *vm.Name, ctx.Err())
+		case <-time.After(evictionPollInterval):
+		}
+	}
+}
+
+// pickSpotRunner returns the spot runner of a pool with the first name, with its instance
+// view.
+func pickSpotRunner(ctx context.Context, azCli *client.AzureCli, poolID string) (armcompute.VirtualMachine, error) {
+	vms, err := azCli.ListVirtualMachines(ctx, poolID)
+	if err != nil {
+		return armcompute.VirtualMachine{}, fmt.Errorf("failed to list runners: %w", err)
+	}
+	var names []string
+	rgs := map[string]string{}
+	for _, vm := range vms {
+		if vm.Name == nil || vm.ID == nil || vm.Properties == nil || vm.Properties.Priority == nil {
+			continue
+		}
+		if *vm.Properties.Priority != armcompute.VirtualMachinePriorityTypesSpot {
+			continue
+		}
+		id, err := arm.ParseResourceID(*vm.ID)
+		if err != nil {
+			return armcompute.VirtualMachine{}, fmt.Errorf("failed to parse VM ID: %w", err)
+		}
+		names = append(names, *vm.Name)
+		rgs[*vm.Name] = id.ResourceGroupName
+	}
+	if len(names) == 0 {
+		return armcompute.VirtualMachine{}, fmt.Errorf("pool %s has no spot runners", poolID)
+	}
+	sort.Strings(names)
+	// The list doesn't include the instance view.
+	return azCli.GetInstance(ctx, rgs[names[0]], names[0])
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/cloudbase/garm-provider-common/params"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// finalizeTimeout is how long finalize-create waits for a deployment.
+const finalizeTimeout = time.Hour
+
+// finalizeCreate waits for the deployment of an instance created with async_create, runs
+// the steps that need the VM, and records the result in the tags of the resource group.
+// The provider starts it in the background.
+func finalizeCreate(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("finalize-create")
+	name := fs.String("name", "", "provider ID of the instance")
+	diskTier := fs.String("disk-performance-tier", "", "performance tier to set on the OS disk")
+	alerts := fs.String("alerts", "", "comma separated alert templates to create alerts from")
+	shutdownTime := fs.String("shutdown-time", "", "daily shutdown time of the VM (HHMM)")
+	shutdownTimeZone := fs.String("shutdown-time-zone", "", "time zone of the daily shutdown time")
+	shutdownEmail := fs.String("shutdown-notification-email", "", "address notified before the daily shutdown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(map[string]string{"name": *name}); err != nil {
+		return err
+	}
+
+	cfg, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, finalizeTimeout)
+	defer cancel()
+
+	err = azCli.WaitDeployment(ctx, *name)
+	if err == nil && *diskTier != "" {
+		runnerSpec := &spec.RunnerSpec{
+			BootstrapParams:     params.BootstrapInstance{Name: *name},
+			DiskPerformanceTier: *diskTier,
+		}
+		if err = azCli.SetOSDiskPerformanceTier(ctx, runnerSpec); err != nil {
+			err = fmt.Errorf("failed to set disk performance tier: %w", err)
+		}
+	}
+	if err == nil && *alerts != "" {
+		err = azCli.CreateMetricAlerts(ctx, *name, strings.Split(*alerts, ","))
+	}
+	if err == nil && *shutdownTime != "" {
+		err = azCli.CreateShutdownSchedule(ctx, *name, spec.AutoShutdown{
+			Time:              *shutdownTime,
+			TimeZone:          *shutdownTimeZone,
+			NotificationEmail: *shutdownEmail,
+		})
+	}
+	if err == nil && cfg.LockInstances {
+		if err = azCli.LockResourceGroup(ctx, *name); err != nil {
+			err = fmt.Errorf("failed to lock instance: %w", err)
+		}
+	}
+
+	if recordErr := recordCreateStatus(ctx, azCli, *name, err); recordErr != nil {
+		log.Printf("failed to record create status of %s: %s", *name, recordErr)
+		if err == nil {
+			err = recordErr
+		}
+	}
+	if err != nil {
+		log.Printf("failed to create %s: %s", *name, err)
+		return err
+	}
+	log.Printf("finished creating %s", *name)
+	return nil
+}
+
+// recordCreateStatus tags the resource group of an instance with the result of its create.
+func recordCreateStatus(ctx context.Context, azCli *client.AzureCli, name string, createErr error) error {
+	rg, err := azCli.GetResourceGroup(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to get resource group: %w", err)
+	}
+	if rg == nil || rg.ID == nil {
+		// The instance was deleted while it was being created.
+		return nil
+	}
+
+	tags := map[string]*string{
+		util.CreateStatusTagName: to.Ptr(util.CreateStatusDone),
+	}
+	if createErr != nil {
+		tags[util.CreateStatusTagName] = to.Ptr(util.CreateStatusFailed)
+		tags[util.CreateErrorTagName] = to.Ptr(util.TruncateTagValue(createErr.Error()))
+	}
+	if err := azCli.UpdateResourceTags(ctx, *rg.ID, tags); err != nil {
+		return err
+	}
+	if createErr != nil {
+		return nil
+	}
+	return azCli.SetInstanceState(ctx, name, util.InstanceStateCreated)
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func collectGarbage(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("gc")
+	controllerID := fs.String("controller-id", "", "ID of the garm controller that owns the instances")
+	olderThan := fs.Duration("older-than", 0, "only remove resource groups that entered their current state at least this long ago (defaults to garbage_collection.min_age_minutes)")
+	dryRun := fs.Bool("dry-run", false, "only list the resource groups that would be removed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(map[string]string{"controller-id": *controllerID}); err != nil {
+		return err
+	}
+
+	cfg, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+	minAge := *olderThan
+	if minAge == 0 {
+		minAge = cfg.GarbageCollection.GetMinAge()
+	}
+
+	orphans, err := azCli.FindOrphans(ctx, *controllerID, minAge)
+	if err != nil {
+		return fmt.Errorf("failed to find orphaned resource groups: %w", err)
+	}
+
+	var removed, failed int
+	for _, orphan := range orphans {
+		vmState := orphan.VMState
+		if vmState == "" {
+			vmState = "no VM"
+		}
+		fmt.Printf("%s: %s since %s, %s\n", orphan.ResourceGroup, orphan.State, orphan.Since.Format(time.RFC3339), vmState)
+		if *dryRun {
+			continue
+		}
+		if err := azCli.RemoveOrphan(ctx, orphan.ResourceGroup, true); err != nil {
+			fmt.Printf("%s: %s\n", orphan.ResourceGroup, err)
+			failed++
+			continue
+		}
+		removed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d resource groups", failed)
+	}
+	if !*dryRun {
+		fmt.Printf("removed %d resource groups\n", removed)
+	}
+	return nil
+}
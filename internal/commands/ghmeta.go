@@ -0,0 +1,52 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudbase/garm-provider-azure/internal/ghmeta"
+)
+
+func syncGitHubMeta(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("sync-github-meta")
+	force := fs.Bool("force", false, "update the rules of all runners, even if the ranges did not change")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	cache := ghmeta.NewCache(cfg.GetGitHubMetaCacheFile(), cfg.GetGitHubMetaURL(), cfg.GetGitHubMetaRefreshInterval())
+	ranges, changed, err := cache.Refresh(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to refresh github ranges: %w", err)
+	}
+	fmt.Printf("fetched %d github ranges (changed: %v)\n", len(ranges), changed)
+	if !changed && !*force {
+		return nil
+	}
+
+	updated, err := azCli.UpdateGitHubEgressRules(ctx, ranges)
+	if err != nil {
+		return fmt.Errorf("failed to update egress rules: %w", err)
+	}
+	fmt.Printf("updated the egress rules of %d runners\n", updated)
+	return nil
+}
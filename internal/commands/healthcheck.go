@@ -0,0 +1,164 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	healthOK      = "ok"
+	healthWarning = "warning"
+	healthFailed  = "failed"
+)
+
+// healthCheck is the result of one of the checks of the healthcheck command.
+type healthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthReport is the result of the healthcheck command.
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+func (r *healthReport) add(name, status, message string) {
+	r.Checks = append(r.Checks, healthCheck{Name: name, Status: status, Message: message})
+	switch {
+	case status == healthFailed:
+		r.Status = healthFailed
+	case status == healthWarning && r.Status == healthOK:
+		r.Status = healthWarning
+	}
+}
+
+func healthcheck(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("healthcheck")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	timeout := fs.Duration("timeout", time.Minute, "time allowed for all the checks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	report := &healthReport{Status: healthOK}
+	cfg, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		report.add("config", healthFailed, err.Error())
+	} else {
+		report.add("config", healthOK, "")
+		runHealthChecks(ctx, cfg, azCli, report)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		if err := enc.Encode(report); err != nil {
+			return fmt.Errorf("failed to write health report: %w", err)
+		}
+	} else {
+		for _, check := range report.Checks {
+			line := fmt.Sprintf("%-30s %s", check.Name, check.Status)
+			if check.Message != "" {
+				line += ": " + check.Message
+			}
+			fmt.Println(line)
+		}
+	}
+	if report.Status == healthFailed {
+		return fmt.Errorf("health check failed")
+	}
+	return nil
+}
+
+// runHealthChecks checks the credentials, active Azure incidents, and the quota headroom in
+// each region runners are created in. Listing the quota also shows the region is reachable and enabled for the
+// subscription.
+func runHealthChecks(ctx context.Context, cfg *config.Config, azCli *client.AzureCli, report *healthReport) {
+	if err := azCli.CheckSubscriptionAccess(ctx); err != nil {
+		report.add("credentials", healthFailed, err.Error())
+		// Every other check would fail the same way.
+		return
+	}
+	report.add("credentials", healthOK, "")
+
+	regions := []string{cfg.Location}
+	for _, region := range cfg.Regions {
+		if region.Name != cfg.Location {
+			regions = append(regions, region.Name)
+		}
+	}
+	// Incidents are reported as warnings, creates may still succeed during one.
+	issues, err := azCli.ListActiveServiceIssues(ctx)
+	if err != nil {
+		report.add("service-health", healthWarning, err.Error())
+	}
+	for _, region := range regions {
+		var impacts []string
+		for _, issue := range issues {
+			for impacted, services := range issue.Services {
+				if util.SameRegion(impacted, region) {
+					impacts = append(impacts, fmt.Sprintf("%s (%s, tracking ID %s)", issue.Title, strings.Join(services, ", "), issue.TrackingID))
+				}
+			}
+		}
+		name := fmt.Sprintf("service-health/%s", region)
+		if len(impacts) > 0 {
+			report.add(name, healthWarning, strings.Join(impacts, "; "))
+		} else if err == nil {
+			report.add(name, healthOK, "")
+		}
+	}
+
+	warnPercent := float64(cfg.QuotaCheck.GetWarnPercent())
+	for _, region := range regions {
+		name := fmt.Sprintf("quota/%s", region)
+		usages, err := azCli.WithLocation(region).ListQuotaUsage(ctx)
+		if err != nil {
+			report.add(name, healthFailed, err.Error())
+			continue
+		}
+		var low []client.QuotaUsage
+		for _, usage := range usages {
+			if usage.Current > 0 && usage.HeadroomPercent() < warnPercent {
+				low = append(low, usage)
+			}
+		}
+		if len(low) == 0 {
+			report.add(name, healthOK, "")
+			continue
+		}
+		sort.Slice(low, func(i, j int) bool {
+			return low[i].HeadroomPercent() < low[j].HeadroomPercent()
+		})
+		worst := low[0]
+		report.add(name, healthWarning, fmt.Sprintf("%d quotas below %.0f%% headroom, lowest %s %s at %d/%d", len(low), warnPercent, worst.Provider, worst.Name, worst.Current, worst.Limit))
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+)
+
+func copyImage(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("copy-image")
+	var params client.CopyImageParams
+	fs.StringVar(&params.SourceID, "source", "", "ID of the source managed image or gallery image version")
+	fs.StringVar(&params.TargetLocation, "location", "", "region to copy the image to")
+	fs.StringVar(&params.TargetResourceGroup, "resource-group", "", "resource group of the target gallery (created if missing)")
+	fs.StringVar(&params.TargetGallery, "gallery", "", "name of the target gallery (created if missing)")
+	fs.StringVar(&params.TargetImage, "image", "", "name of the target image definition (defaults to the source image name)")
+	fs.StringVar(&params.TargetVersion, "version", "", "name of the target image version (defaults to the source version, or 1.0.0)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := requireFlags(map[string]string{
+		"source":         params.SourceID,
+		"location":       params.TargetLocation,
+		"resource-group": params.TargetResourceGroup,
+		"gallery":        params.TargetGallery,
+	}); err != nil {
+		return err
+	}
+
+	_, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	versionID, err := azCli.CopyImage(ctx, params)
+	if err != nil {
+		return fmt.Errorf("failed to copy image: %w", err)
+	}
+	fmt.Println(versionID)
+	return nil
+}
+
+func captureImage(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("capture-image")
+	var params client.CaptureImageParams
+	fs.StringVar(&params.Instance, "instance", "", "name of the runner VM to capture")
+	fs.StringVar(&params.ResourceGroup, "resource-group", "", "resource group of the gallery (created if missing)")
+	fs.StringVar(&params.Gallery, "gallery", "", "name of the gallery (created if missing)")
+	fs.StringVar(&params.Image, "image", "", "name of the image definition (created if missing)")
+	fs.StringVar(&params.Version, "version", "", "name of the new image version, e.g. 1.0.0")
+	deleteInstance := fs.Bool("delete", false, "delete the runner after it was captured")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := requireFlags(map[string]string{
+		"instance":       params.Instance,
+		"resource-group": params.ResourceGroup,
+		"gallery":        params.Gallery,
+		"image":          params.Image,
+		"version":        params.Version,
+	}); err != nil {
+		return err
+	}
+
+	_, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	versionID, err := azCli.CaptureImage(ctx, params)
+	if err != nil {
+		return fmt.Errorf("failed to capture image: %w", err)
+	}
+	fmt.Println(versionID)
+
+	if *deleteInstance {
+		if err := azCli.UnlockResourceGroup(ctx, params.Instance); err != nil {
+			return fmt.Errorf("failed to delete instance: %w", err)
+		}
+		if err := azCli.DeleteInstance(ctx, params.Instance); err != nil {
+			return fmt.Errorf("failed to delete instance: %w", err)
+		}
+	}
+	return nil
+}
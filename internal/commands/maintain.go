@@ -0,0 +1,48 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/provider"
+)
+
+// maintainTimeout bounds a background check of a pool, so a stuck Run Command doesn't
+// keep the process around.
+const maintainTimeout = 15 * time.Minute
+
+// maintainPool runs the background checks of the runners of a pool. The provider starts
+// it when garm lists the instances of the pool.
+func maintainPool(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("maintain-pool")
+	controllerID := fs.String("controller-id", "", "ID of the garm controller the runners belong to")
+	poolID := fs.String("pool", "", "ID of the pool")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(map[string]string{"config": *cfgFile, "controller-id": *controllerID, "pool": *poolID}); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, maintainTimeout)
+	defer cancel()
+	if err := provider.MaintainPool(ctx, *cfgFile, *controllerID, *poolID); err != nil {
+		return fmt.Errorf("failed to check pool %s: %w", *poolID, err)
+	}
+	return nil
+}
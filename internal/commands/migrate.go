@@ -0,0 +1,94 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+func migrateController(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("migrate-controller")
+	from := fs.String("from", "", "controller ID the runners are currently tagged with")
+	newID := fs.String("to", "", "controller ID of the new garm installation")
+	pools := fs.String("pools", "", "comma separated old=new pool ID pairs, for pools that were recreated with a new ID")
+	dryRun := fs.Bool("dry-run", false, "only list the runners that would be re-tagged")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(map[string]string{"from": *from, "to": *newID}); err != nil {
+		return err
+	}
+
+	poolMap := map[string]string{}
+	if *pools != "" {
+		for _, pair := range strings.Split(*pools, ",") {
+			oldPool, newPool, ok := strings.Cut(pair, "=")
+			if !ok || oldPool == "" || newPool == "" {
+				return fmt.Errorf("invalid pool mapping %q (expected old=new)", pair)
+			}
+			poolMap[oldPool] = newPool
+		}
+	}
+
+	_, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	vms, err := azCli.ListVirtualMachinesWithTag(ctx, util.ControllerIDTagName, *from)
+	if err != nil {
+		return fmt.Errorf("failed to list runners: %w", err)
+	}
+
+	var failed int
+	for _, vm := range vms {
+		if vm.Name == nil {
+			continue
+		}
+		tags := map[string]*string{
+			util.ControllerIDTagName: to.Ptr(*newID),
+		}
+		msg := fmt.Sprintf("%s: controller %s -> %s", *vm.Name, *from, *newID)
+		if oldPool := vm.Tags[util.PoolIDTagName]; oldPool != nil {
+			if newPool, ok := poolMap[*oldPool]; ok {
+				tags[util.PoolIDTagName] = to.Ptr(newPool)
+				msg += fmt.Sprintf(", pool %s -> %s", *oldPool, newPool)
+			}
+		}
+		if *dryRun {
+			fmt.Println(msg)
+			continue
+		}
+		if err := azCli.RetagInstance(ctx, vm, tags); err != nil {
+			fmt.Printf("%s: %s\n", *vm.Name, err)
+			failed++
+			continue
+		}
+		fmt.Println(msg)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to re-tag %d of %d runners", failed, len(vms))
+	}
+	if !*dryRun {
+		fmt.Printf("re-tagged %d runners\n", len(vms))
+	}
+	return nil
+}
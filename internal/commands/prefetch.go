@@ -0,0 +1,67 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+func prefetch(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("prefetch")
+	sizes := fs.String("sizes", "", "comma separated VM sizes to check (default prefetch_vm_sizes from the config)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	check := cfg.PrefetchVMSizes
+	if *sizes != "" {
+		check = strings.Split(*sizes, ",")
+	}
+
+	available, err := azCli.RefreshVMSizes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to refresh VM sizes: %w", err)
+	}
+	fmt.Printf("cached %d VM sizes in %s\n", len(available), cfg.Location)
+
+	var failed int
+	for _, name := range check {
+		name = strings.TrimSpace(name)
+		size, ok := available[strings.ToLower(name)]
+		switch {
+		case !ok:
+			fmt.Printf("%s: not available\n", name)
+			failed++
+		case size.Validate("") != nil:
+			fmt.Printf("%s: restricted (%s)\n", name, size.RestrictionReason)
+			failed++
+		case len(size.RestrictedZones) > 0:
+			fmt.Printf("%s: ok, restricted in zones %s (%s)\n", name, strings.Join(size.RestrictedZones, ","), size.RestrictionReason)
+		default:
+			fmt.Printf("%s: ok, zones %s\n", name, strings.Join(size.Zones, ","))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the checked VM sizes can't be used", failed)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+func showQuota(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("quota")
+	all := fs.Bool("all", false, "also show quotas that are not in use")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	usages, err := azCli.ListQuotaUsage(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get quota usage: %w", err)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].HeadroomPercent() < usages[j].HeadroomPercent()
+	})
+
+	warnPercent := float64(cfg.QuotaCheck.GetWarnPercent())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROVIDER\tQUOTA\tCURRENT\tLIMIT\tHEADROOM\t")
+	for _, usage := range usages {
+		if !*all && usage.Current == 0 {
+			continue
+		}
+		warning := ""
+		if usage.HeadroomPercent() < warnPercent {
+			warning = "LOW"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.1f%%\t%s\n", usage.Provider, usage.Name, usage.Current, usage.Limit, usage.HeadroomPercent(), warning)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// recoverCandidate is an instance of the controller, with the tags holding its state.
+type recoverCandidate struct {
+	name string
+	tags map[string]*string
+	// sharedResourceGroup is the resource group holding the instance in shared resource
+	// group mode.
+	sharedResourceGroup string
+}
+
+func recoverInstances(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("recover")
+	controllerID := fs.String("controller-id", "", "ID of the garm controller that owns the instances")
+	olderThan := fs.Duration("older-than", 2*finalizeTimeout, "only act on creates and deletes that were interrupted at least this long ago")
+	dryRun := fs.Bool("dry-run", false, "only list the instances that would be removed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(map[string]string{"controller-id": *controllerID}); err != nil {
+		return err
+	}
+
+	cfg, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := recoverCandidates(ctx, cfg, azCli, *controllerID)
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var removed, failed int
+	for _, candidate := range candidates {
+		name := candidate.name
+		// Instances in the created state, or created before states were recorded, are
+		// left to garm.
+		state, since := util.InstanceState(candidate.tags)
+		if state != util.InstanceStateCreating && state != util.InstanceStateDeleting {
+			continue
+		}
+		if since.IsZero() || time.Since(since) < *olderThan {
+			continue
+		}
+
+		msg := fmt.Sprintf("%s: %s since %s", name, state, since.Format(time.RFC3339))
+		if state == util.InstanceStateCreating {
+			msg += ", rolling back create"
+		} else {
+			msg += ", finishing delete"
+		}
+		if *dryRun {
+			fmt.Println(msg)
+			continue
+		}
+		fmt.Println(msg)
+		if err := removeInstance(ctx, cfg, azCli, candidate); err != nil {
+			fmt.Printf("%s: %s\n", name, err)
+			failed++
+			continue
+		}
+		removed++
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to recover %d instances", failed)
+	}
+	if !*dryRun {
+		fmt.Printf("removed %d instances\n", removed)
+	}
+	return nil
+}
+
+// recoverCandidates returns the instances of a controller. These are its resource groups,
+// or its VMs in shared resource group mode, where the state is recorded on the VM.
+func recoverCandidates(ctx context.Context, cfg *config.Config, azCli *client.AzureCli, controllerID string) ([]recoverCandidate, error) {
+	var candidates []recoverCandidate
+	if cfg.SharedResourceGroup != "" {
+		vms, err := azCli.ListVirtualMachinesWithTag(ctx, util.ControllerIDTagName, controllerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, vm := range vms {
+			if vm.Name == nil || vm.ID == nil {
+				continue
+			}
+			id, err := arm.ParseResourceID(*vm.ID)
+			if err != nil {
+				continue
+			}
+			candidates = append(candidates, recoverCandidate{name: *vm.Name, tags: vm.Tags, sharedResourceGroup: id.ResourceGroupName})
+		}
+		return candidates, nil
+	}
+
+	groups, err := azCli.ListResourceGroupsWithTag(ctx, util.ControllerIDTagName, controllerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rg := range groups {
+		if rg.Name != nil {
+			candidates = append(candidates, recoverCandidate{name: *rg.Name, tags: rg.Tags})
+		}
+	}
+	return candidates, nil
+}
+
+// removeInstance marks an instance as being deleted, and removes its resource group, or
+// its resources in the shared resource group.
+func removeInstance(ctx context.Context, cfg *config.Config, azCli *client.AzureCli, candidate recoverCandidate) error {
+	name := candidate.name
+	if candidate.sharedResourceGroup != "" {
+		azCli = azCli.WithSharedResourceGroup(candidate.sharedResourceGroup)
+	}
+	if err := azCli.SetInstanceState(ctx, name, util.InstanceStateDeleting); err != nil {
+		return err
+	}
+	if candidate.sharedResourceGroup != "" {
+		if err := azCli.DeleteInstanceResources(ctx, name); err != nil {
+			return err
+		}
+	} else {
+		if err := azCli.UnlockResourceGroup(ctx, name); err != nil {
+			return err
+		}
+		if err := azCli.DeleteResourceGroup(ctx, name, true); err != nil {
+			return err
+		}
+	}
+	if cfg.DNSZone != nil {
+		if err := azCli.DeleteDNSRecord(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
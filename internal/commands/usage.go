@@ -0,0 +1,115 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package commands
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/internal/usage"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const usageDateFormat = "2006-01-02"
+
+func usageReport(ctx context.Context, args []string) error {
+	fs, cfgFile := newFlagSet("usage")
+	controllerID := fs.String("controller-id", "", "ID of the garm controller the runners belong to")
+	fromFlag := fs.String("from", "", "start of the report, as YYYY-MM-DD (default 30 days ago)")
+	toFlag := fs.String("to", "", "end of the report, as YYYY-MM-DD, exclusive (default now)")
+	format := fs.String("format", "csv", "output format, csv or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := requireFlags(map[string]string{"controller-id": *controllerID}); err != nil {
+		return err
+	}
+	if *format != "csv" && *format != "json" {
+		return fmt.Errorf("invalid -format %q (expected csv or json)", *format)
+	}
+
+	to := time.Now().UTC()
+	if *toFlag != "" {
+		parsed, err := time.Parse(usageDateFormat, *toFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -30)
+	if *fromFlag != "" {
+		parsed, err := time.Parse(usageDateFormat, *fromFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+		from = parsed
+	}
+	if !to.After(from) {
+		return fmt.Errorf("-from must be before -to")
+	}
+
+	_, azCli, err := newClient(*cfgFile)
+	if err != nil {
+		return err
+	}
+
+	events, err := azCli.ListActivityLog(ctx, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to get activity log: %w", err)
+	}
+	vms, err := azCli.ListVirtualMachinesWithTag(ctx, util.ControllerIDTagName, *controllerID)
+	if err != nil {
+		return fmt.Errorf("failed to list runners: %w", err)
+	}
+
+	report := usage.Report(usage.Instances(*controllerID, events, vms), from, to)
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		return enc.Encode(report)
+	}
+
+	w := csv.NewWriter(os.Stdout)
+	if err := w.Write([]string{"pool_id", "instances", "instance_hours", "spot_hours", "spot_ratio", "vm_sizes"}); err != nil {
+		return err
+	}
+	for _, pool := range report {
+		sizes := make([]string, 0, len(pool.SizeHours))
+		for size, hours := range pool.SizeHours {
+			sizes = append(sizes, fmt.Sprintf("%s=%.2f", size, hours))
+		}
+		sort.Strings(sizes)
+		err := w.Write([]string{
+			pool.PoolID,
+			strconv.Itoa(pool.Instances),
+			strconv.FormatFloat(pool.InstanceHours, 'f', 2, 64),
+			strconv.FormatFloat(pool.SpotHours, 'f', 2, 64),
+			strconv.FormatFloat(pool.SpotRatio(), 'f', 3, 64),
+			strings.Join(sizes, ";"),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package ghmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// Cache keeps the GitHub IPv4 ranges on disk, so they are only fetched once per refresh
+// interval, no matter how many provider processes need them.
+type Cache struct {
+	path       string
+	url        string
+	refreshAge time.Duration
+}
+
+type cachedRanges struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Ranges    []string  `json:"ranges"`
+}
+
+// NewCache returns a cache of the ranges published at url, kept in the file at path, and
+// refreshed once they are older than refreshAge.
+func NewCache(path, url string, refreshAge time.Duration) *Cache {
+	return &Cache{
+		path:       path,
+		url:        url,
+		refreshAge: refreshAge,
+	}
+}
+
+func (c *Cache) load() (cachedRanges, error) {
+	var cached cachedRanges
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return cached, err
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return cached, fmt.Errorf("failed to decode cache: %w", err)
+	}
+	return cached, nil
+}
+
+func (c *Cache) save(cached cachedRanges) error {
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache: %w", err)
+	}
+	// Write to a temp file first, so concurrent readers never see a partial cache.
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	return nil
+}
+
+// Ranges returns the cached ranges, refreshing them first if they are too old. If the
+// refresh fails, stale ranges are returned when available. changed is true if the ranges
+// were refreshed, and differ from the cached ones.
+func (c *Cache) Ranges(ctx context.Context) (ranges []string, changed bool, err error) {
+	cached, loadErr := c.load()
+	if loadErr == nil && time.Since(cached.FetchedAt) < c.refreshAge {
+		return cached.Ranges, false, nil
+	}
+
+	ranges, changed, err = c.Refresh(ctx)
+	if err != nil {
+		if loadErr == nil && len(cached.Ranges) > 0 {
+			log.Printf("using github meta ranges fetched at %s: %s", cached.FetchedAt.Format(time.RFC3339), err)
+			return cached.Ranges, false, nil
+		}
+		return nil, false, err
+	}
+	return ranges, changed, nil
+}
+
+// Refresh fetches the ranges and updates the cache, regardless of its age. changed is
+// true if the ranges differ from the cached ones.
+func (c *Cache) Refresh(ctx context.Context) (ranges []string, changed bool, err error) {
+	meta, err := Fetch(ctx, c.url)
+	if err != nil {
+		return nil, false, err
+	}
+	ranges = meta.IPv4Ranges()
+	if len(ranges) == 0 {
+		return nil, false, fmt.Errorf("no IPv4 ranges found in %s", c.url)
+	}
+
+	previous, loadErr := c.load()
+	changed = loadErr == nil && !reflect.DeepEqual(previous.Ranges, ranges)
+	if err := c.save(cachedRanges{FetchedAt: time.Now().UTC(), Ranges: ranges}); err != nil {
+		log.Printf("failed to cache github meta ranges: %s", err)
+	}
+	return ranges, changed, nil
+}
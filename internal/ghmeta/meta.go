@@ -0,0 +1,89 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package ghmeta fetches the IP ranges GitHub publishes in its meta API.
+package ghmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const fetchTimeout = 30 * time.Second
+
+// Meta holds the GitHub IP ranges runners need to reach. The actions ranges are left
+// out on purpose. They cover most of Azure, and don't fit in a security group, so the
+// egress profile allows the AzureCloud service tag instead.
+type Meta struct {
+	Web      []string `json:"web"`
+	API      []string `json:"api"`
+	Git      []string `json:"git"`
+	Packages []string `json:"packages"`
+	Pages    []string `json:"pages"`
+}
+
+// Fetch downloads the GitHub meta ranges from url.
+func Fetch(ctx context.Context, url string) (Meta, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Meta{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Meta{}, fmt.Errorf("failed to fetch github meta: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Meta{}, fmt.Errorf("failed to fetch github meta: unexpected status code %d", resp.StatusCode)
+	}
+
+	var meta Meta
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return Meta{}, fmt.Errorf("failed to decode github meta: %w", err)
+	}
+	return meta, nil
+}
+
+// IPv4Ranges returns the sorted, deduplicated IPv4 ranges of all services. Runner virtual
+// networks are IPv4 only.
+func (m Meta) IPv4Ranges() []string {
+	seen := map[string]struct{}{}
+	var ret []string
+	for _, ranges := range [][]string{m.Web, m.API, m.Git, m.Packages, m.Pages} {
+		for _, cidr := range ranges {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil || ip.To4() == nil {
+				continue
+			}
+			if _, ok := seen[cidr]; ok {
+				continue
+			}
+			seen[cidr] = struct{}{}
+			ret = append(ret, cidr)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,267 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package queue implements a create queue shared by all provider processes on a host.
+// garm runs a new provider process for every operation, so the queue state lives on
+// disk, in a state directory, and is protected by a file lock.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	waitingDir = "waiting"
+	activeDir  = "active"
+	lockFile   = "queue.lock"
+
+	pollInterval = 2 * time.Second
+	// waitingStaleAfter is the time after which a waiting ticket that was not refreshed
+	// is considered abandoned. Waiters refresh their ticket on every poll.
+	waitingStaleAfter = 1 * time.Minute
+	// activeStaleAfter is the time after which an active ticket that was not refreshed
+	// is considered abandoned. Ticket holders refresh their ticket every
+	// activeRefreshInterval until they release it, so creates can take any time, while
+	// the slot of a crashed process is freed after a few minutes.
+	activeStaleAfter      = 5 * time.Minute
+	activeRefreshInterval = 1 * time.Minute
+)
+
+type ticketData struct {
+	Name     string    `json:"name"`
+	Weight   int       `json:"weight"`
+	Enqueued time.Time `json:"enqueued"`
+}
+
+// score returns the priority of a waiting ticket. The weight is multiplied by the time
+// spent waiting, so tickets with a low weight are not starved by a steady stream of
+// tickets with a high weight.
+func (t ticketData) score(now time.Time) float64 {
+	return float64(t.Weight) * (now.Sub(t.Enqueued).Seconds() + 1)
+}
+
+// Queue limits the number of concurrent creates and orders waiting creates by weight.
+type Queue struct {
+	dir           string
+	maxConcurrent int
+}
+
+// Ticket is an acquired create slot. It is refreshed in the background until released.
+type Ticket struct {
+	path string
+	data ticketData
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a new queue that allows maxConcurrent active tickets.
+func New(dir string, maxConcurrent int) (*Queue, error) {
+	if maxConcurrent <= 0 {
+		return nil, fmt.Errorf("invalid max concurrent value: %d", maxConcurrent)
+	}
+	for _, d := range []string{waitingDir, activeDir} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create queue directory: %w", err)
+		}
+	}
+	return &Queue{
+		dir:           dir,
+		maxConcurrent: maxConcurrent,
+	}, nil
+}
+
+// Acquire blocks until a create slot is available for name, or the context is canceled.
+func (q *Queue) Acquire(ctx context.Context, name string, weight int) (*Ticket, error) {
+	if weight <= 0 {
+		weight = 1
+	}
+	data := ticketData{
+		Name:     name,
+		Weight:   weight,
+		Enqueued: time.Now(),
+	}
+	waitingPath := filepath.Join(q.dir, waitingDir, name)
+	if err := writeTicket(waitingPath, data); err != nil {
+		return nil, fmt.Errorf("failed to enqueue %s: %w", name, err)
+	}
+
+	for {
+		acquired, err := q.tryAcquire(data)
+		if err != nil {
+			os.Remove(waitingPath) //nolint
+			return nil, err
+		}
+		if acquired {
+			ticket := &Ticket{
+				path:    filepath.Join(q.dir, activeDir, name),
+				data:    data,
+				stop:    make(chan struct{}),
+				stopped: make(chan struct{}),
+			}
+			go ticket.refresh(activeRefreshInterval)
+			return ticket, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			os.Remove(waitingPath) //nolint
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+
+		now := time.Now()
+		if err := os.Chtimes(waitingPath, now, now); err != nil {
+			// Our ticket was pruned. Write it again.
+			if err := writeTicket(waitingPath, data); err != nil {
+				return nil, fmt.Errorf("failed to refresh ticket for %s: %w", name, err)
+			}
+		}
+	}
+}
+
+// tryAcquire moves the ticket to the active directory, if there is a free slot and
+// the ticket is among the highest priority waiting tickets.
+func (q *Queue) tryAcquire(data ticketData) (bool, error) {
+	unlock, err := LockPath(filepath.Join(q.dir, lockFile))
+	if err != nil {
+		return false, fmt.Errorf("failed to lock queue: %w", err)
+	}
+	defer unlock()
+
+	now := time.Now()
+	active, err := q.readTickets(activeDir, activeStaleAfter)
+	if err != nil {
+		return false, fmt.Errorf("failed to read active tickets: %w", err)
+	}
+	free := q.maxConcurrent - len(active)
+	if free <= 0 {
+		return false, nil
+	}
+
+	waiting, err := q.readTickets(waitingDir, waitingStaleAfter)
+	if err != nil {
+		return false, fmt.Errorf("failed to read waiting tickets: %w", err)
+	}
+	sort.SliceStable(waiting, func(i, j int) bool {
+		si, sj := waiting[i].score(now), waiting[j].score(now)
+		if si != sj {
+			return si > sj
+		}
+		return waiting[i].Enqueued.Before(waiting[j].Enqueued)
+	})
+
+	for idx, ticket := range waiting {
+		if idx >= free {
+			break
+		}
+		if ticket.Name != data.Name {
+			continue
+		}
+		src := filepath.Join(q.dir, waitingDir, data.Name)
+		dst := filepath.Join(q.dir, activeDir, data.Name)
+		if err := os.Rename(src, dst); err != nil {
+			return false, fmt.Errorf("failed to activate ticket: %w", err)
+		}
+		now := time.Now()
+		os.Chtimes(dst, now, now) //nolint
+		return true, nil
+	}
+	return false, nil
+}
+
+// readTickets returns the tickets in subdir, removing the ones that were not updated
+// in the last staleAfter interval.
+func (q *Queue) readTickets(subdir string, staleAfter time.Duration) ([]ticketData, error) {
+	dir := filepath.Join(q.dir, subdir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []ticketData
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > staleAfter {
+			os.Remove(path) //nolint
+			continue
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var data ticketData
+		if err := json.Unmarshal(contents, &data); err != nil {
+			os.Remove(path) //nolint
+			continue
+		}
+		ret = append(ret, data)
+	}
+	return ret, nil
+}
+
+// refresh keeps the ticket from going stale while the create runs, until the ticket is
+// released.
+func (t *Ticket) refresh(interval time.Duration) {
+	defer close(t.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		if err := os.Chtimes(t.path, now, now); err != nil {
+			// Our ticket was pruned. Write it again.
+			writeTicket(t.path, t.data) //nolint
+		}
+	}
+}
+
+// Release frees the create slot held by the ticket.
+func (t *Ticket) Release() error {
+	// Wait for the refresh to stop, so it doesn't write the ticket again once removed.
+	t.stopOnce.Do(func() {
+		if t.stop != nil {
+			close(t.stop)
+			<-t.stopped
+		}
+	})
+	if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release ticket: %w", err)
+	}
+	return nil
+}
+
+func writeTicket(path string, data ticketData) error {
+	contents, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, contents, 0o600)
+}
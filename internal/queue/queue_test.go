@@ -0,0 +1,239 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTicket is a ticket written to the queue directory before a test.
+type testTicket struct {
+	name   string
+	weight int
+	// waited is how long ago the ticket was enqueued.
+	waited time.Duration
+	// age is how long ago the ticket was last refreshed.
+	age time.Duration
+}
+
+func writeTestTicket(t *testing.T, dir string, ticket testTicket) {
+	t.Helper()
+	path := filepath.Join(dir, ticket.name)
+	data := ticketData{
+		Name:     ticket.name,
+		Weight:   ticket.weight,
+		Enqueued: time.Now().Add(-ticket.waited),
+	}
+	if err := writeTicket(path, data); err != nil {
+		t.Fatalf("failed to write ticket: %s", err)
+	}
+	modTime := time.Now().Add(-ticket.age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to set ticket time: %s", err)
+	}
+}
+
+func TestTryAcquire(t *testing.T) {
+	ours := testTicket{name: "ours", weight: 1, waited: time.Minute}
+
+	tests := []struct {
+		name          string
+		maxConcurrent int
+		active        []testTicket
+		waiting       []testTicket
+		want          bool
+		wantActive    int
+	}{
+		{
+			name:          "free slot",
+			maxConcurrent: 1,
+			want:          true,
+			wantActive:    1,
+		},
+		{
+			name:          "no free slot",
+			maxConcurrent: 1,
+			active:        []testTicket{{name: "other", weight: 1}},
+			wantActive:    1,
+		},
+		{
+			name:          "stale active ticket",
+			maxConcurrent: 1,
+			active:        []testTicket{{name: "crashed", weight: 1, age: activeStaleAfter + time.Minute}},
+			want:          true,
+			wantActive:    1,
+		},
+		{
+			name:          "refreshed active ticket of a long create",
+			maxConcurrent: 1,
+			active:        []testTicket{{name: "long", weight: 1, waited: 2 * time.Hour, age: activeRefreshInterval}},
+			wantActive:    1,
+		},
+		{
+			name:          "waiter with a higher weight",
+			maxConcurrent: 1,
+			waiting:       []testTicket{{name: "heavy", weight: 10, waited: time.Minute}},
+		},
+		{
+			name:          "waiter with a higher weight and a free slot left",
+			maxConcurrent: 2,
+			waiting:       []testTicket{{name: "heavy", weight: 10, waited: time.Minute}},
+			want:          true,
+			wantActive:    1,
+		},
+		{
+			name:          "waiter enqueued before us",
+			maxConcurrent: 1,
+			waiting:       []testTicket{{name: "earlier", weight: 1, waited: 2 * time.Minute}},
+		},
+		{
+			name:          "waiter enqueued after us",
+			maxConcurrent: 1,
+			waiting:       []testTicket{{name: "later", weight: 1, waited: time.Second}},
+			want:          true,
+			wantActive:    1,
+		},
+		{
+			name:          "low weight waiter waiting long enough",
+			maxConcurrent: 1,
+			waiting:       []testTicket{{name: "heavy", weight: 10, waited: time.Second}},
+			want:          true,
+			wantActive:    1,
+		},
+		{
+			name:          "stale waiting ticket",
+			maxConcurrent: 1,
+			waiting:       []testTicket{{name: "abandoned", weight: 10, waited: time.Hour, age: waitingStaleAfter + time.Minute}},
+			want:          true,
+			wantActive:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := New(t.TempDir(), tt.maxConcurrent)
+			if err != nil {
+				t.Fatalf("failed to create queue: %s", err)
+			}
+			for _, ticket := range tt.active {
+				writeTestTicket(t, filepath.Join(q.dir, activeDir), ticket)
+			}
+			for _, ticket := range append(tt.waiting, ours) {
+				writeTestTicket(t, filepath.Join(q.dir, waitingDir), ticket)
+			}
+
+			data := ticketData{Name: ours.name, Weight: ours.weight, Enqueued: time.Now().Add(-ours.waited)}
+			got, err := q.tryAcquire(data)
+			if err != nil {
+				t.Fatalf("tryAcquire() returned an error: %s", err)
+			}
+			if got != tt.want {
+				t.Fatalf("tryAcquire() = %v, want %v", got, tt.want)
+			}
+			_, err = os.Stat(filepath.Join(q.dir, activeDir, ours.name))
+			if tt.want != (err == nil) {
+				t.Fatalf("active ticket exists: %v, want %v", err == nil, tt.want)
+			}
+			active, err := q.readTickets(activeDir, activeStaleAfter)
+			if err != nil {
+				t.Fatalf("failed to read active tickets: %s", err)
+			}
+			if len(active) != tt.wantActive {
+				t.Fatalf("got %d active tickets, want %d", len(active), tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestReadTicketsRemovesInvalid(t *testing.T) {
+	q, err := New(t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("failed to create queue: %s", err)
+	}
+	path := filepath.Join(q.dir, waitingDir, "corrupt")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("failed to write ticket: %s", err)
+	}
+	tickets, err := q.readTickets(waitingDir, waitingStaleAfter)
+	if err != nil {
+		t.Fatalf("readTickets() returned an error: %s", err)
+	}
+	if len(tickets) != 0 {
+		t.Fatalf("got %d tickets, want none", len(tickets))
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("invalid ticket was not removed")
+	}
+}
+
+func TestTicketRefresh(t *testing.T) {
+	q, err := New(t.TempDir(), 1)
+	if err != nil {
+		t.Fatalf("failed to create queue: %s", err)
+	}
+	ticket, err := q.Acquire(context.Background(), "ours", 1)
+	if err != nil {
+		t.Fatalf("Acquire() returned an error: %s", err)
+	}
+	// Stop the refresh started by Acquire, and run one with a short interval instead.
+	close(ticket.stop)
+	<-ticket.stopped
+	ticket.stop = make(chan struct{})
+	ticket.stopped = make(chan struct{})
+
+	stale := time.Now().Add(-activeStaleAfter)
+	if err := os.Chtimes(ticket.path, stale, stale); err != nil {
+		t.Fatalf("failed to set ticket time: %s", err)
+	}
+	go ticket.refresh(10 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		info, err := os.Stat(ticket.path)
+		if err == nil && time.Since(info.ModTime()) < activeRefreshInterval {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ticket was not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A pruned ticket is written again.
+	if err := os.Remove(ticket.path); err != nil {
+		t.Fatalf("failed to remove ticket: %s", err)
+	}
+	for {
+		if _, err := os.Stat(ticket.path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pruned ticket was not written again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := ticket.Release(); err != nil {
+		t.Fatalf("Release() returned an error: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(ticket.path); !os.IsNotExist(err) {
+		t.Fatalf("released ticket was written again")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/defaults"
+)
+
+const (
+	acrLoginScriptPath = "/opt/garm/acr-login.sh"
+	acrLoginUnitName   = "garm-acr-login"
+	// acrLoginScript exchanges a token of the managed identity for an ACR refresh token, and
+	// logs docker into each registry with it. The docker config is written to the home of
+	// the runner user, so jobs can pull images without any secrets.
+	acrLoginScript = `#!/bin/sh
+IDENTITY=%[1]s
+DOCKER_CONFIG=/home/%[2]s/.docker
+export DOCKER_CONFIG
+
+if ! command -v docker >/dev/null 2>&1; then
+	echo "docker is not installed, skipping registry login"
+	exit 0
+fi
+
+AAD_TOKEN=$(curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -H Metadata:true -G "http://169.254.169.254/metadata/identity/oauth2/token" --data-urlencode "api-version=2018-02-01" --data-urlencode "resource=https://management.azure.com/" --data-urlencode "msi_res_id=$IDENTITY" | sed -n 's/.*"access_token":"\([^"]*\)".*/\1/p')
+if [ -z "$AAD_TOKEN" ]; then
+	echo "failed to get a managed identity token"
+	exit 1
+fi
+
+mkdir -p "$DOCKER_CONFIG"
+STATUS=0
+for REGISTRY in %[3]s; do
+	REFRESH_TOKEN=$(curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -X POST "https://$REGISTRY/oauth2/exchange" --data-urlencode "grant_type=access_token" --data-urlencode "service=$REGISTRY" --data-urlencode "access_token=$AAD_TOKEN" | sed -n 's/.*"refresh_token":"\([^"]*\)".*/\1/p')
+	if [ -z "$REFRESH_TOKEN" ]; then
+		echo "failed to get a token for $REGISTRY"
+		STATUS=1
+		continue
+	fi
+	echo "$REFRESH_TOKEN" | docker login "$REGISTRY" -u 00000000-0000-0000-0000-000000000000 --password-stdin || STATUS=1
+done
+chown -R %[2]s: "$DOCKER_CONFIG"
+exit $STATUS
+`
+	acrLoginService = `[Unit]
+Description=Log docker into Azure Container Registries
+Wants=network-online.target
+After=network-online.target docker.service
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh %s
+`
+	// acrLoginTimer renews the logins well before the ACR refresh tokens expire, after
+	// about 3 hours.
+	acrLoginTimer = `[Unit]
+Description=Renew the Azure Container Registry logins
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=1h
+
+[Install]
+WantedBy=timers.target
+`
+
+	linuxACRLoginScriptName = "00-garm-acr-login.sh"
+	// linuxACRLoginInstallTemplate installs the login service and its timer, and logs in
+	// once before the runner is installed.
+	linuxACRLoginInstallTemplate = `#!/bin/sh
+mkdir -p /opt/garm
+cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+cat > /etc/systemd/system/%[3]s.service << 'GARM_EOF'
+%[4]sGARM_EOF
+cat > /etc/systemd/system/%[3]s.timer << 'GARM_EOF'
+%[5]sGARM_EOF
+systemctl daemon-reload
+systemctl enable %[3]s.timer
+systemctl start %[3]s.service || echo "failed to log into container registries"
+systemctl start %[3]s.timer
+`
+)
+
+var (
+	registryRegex             = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.-]*$`)
+	userAssignedIdentityRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.ManagedIdentity/userAssignedIdentities/[^/]+$`)
+)
+
+// ACRLogin logs docker into Azure Container Registries at boot, using a managed identity.
+type ACRLogin struct {
+	// Registries are the login servers of the registries, like myregistry.azurecr.io.
+	Registries []string `json:"registries"`
+	// IdentityID is the resource ID of a user assigned managed identity with the AcrPull role
+	// on the registries. It is assigned to the runner VMs.
+	IdentityID string `json:"identity_id"`
+}
+
+func (a ACRLogin) Validate() error {
+	if len(a.Registries) == 0 {
+		return fmt.Errorf("missing registries")
+	}
+	for _, registry := range a.Registries {
+		if !registryRegex.MatchString(registry) {
+			return fmt.Errorf("invalid registry %q (expected a login server, like myregistry.azurecr.io)", registry)
+		}
+	}
+	if !userAssignedIdentityRegex.MatchString(a.IdentityID) {
+		return fmt.Errorf("invalid identity_id %q (expected the resource ID of a user assigned managed identity)", a.IdentityID)
+	}
+	return nil
+}
+
+// acrLoginInstallScript returns the pre install script that sets up the registry logins.
+func (a ACRLogin) acrLoginInstallScript() []byte {
+	registries := make([]string, 0, len(a.Registries))
+	for _, registry := range a.Registries {
+		registries = append(registries, shellQuote(registry))
+	}
+	script := fmt.Sprintf(acrLoginScript, shellQuote(a.IdentityID), defaults.DefaultUser, strings.Join(registries, " "))
+	return []byte(fmt.Sprintf(
+		linuxACRLoginInstallTemplate,
+		acrLoginScriptPath,
+		script,
+		acrLoginUnitName,
+		fmt.Sprintf(acrLoginService, acrLoginScriptPath),
+		acrLoginTimer))
+}
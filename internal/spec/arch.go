@@ -0,0 +1,49 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// SupportedArchitectures are the runner architectures Azure has VM sizes for.
+var SupportedArchitectures = []params.OSArch{params.Amd64, params.Arm64}
+
+// CPUArchitecture returns the architecture of the runner, as Azure names it in VM size
+// capabilities and image definitions.
+func (r RunnerSpec) CPUArchitecture() armcompute.ArchitectureTypes {
+	if r.BootstrapParams.OSArch == params.Arm64 {
+		return armcompute.ArchitectureTypesArm64
+	}
+	return armcompute.ArchitectureTypesX64
+}
+
+func (r RunnerSpec) validateArchitecture() error {
+	switch r.BootstrapParams.OSArch {
+	case params.Amd64:
+		return nil
+	case params.Arm64:
+	default:
+		return fmt.Errorf("invalid architecture %s (supported: %s, %s)", r.BootstrapParams.OSArch, params.Amd64, params.Arm64)
+	}
+	// The Windows container runtimes are only published for x64.
+	if r.WindowsContainers != nil {
+		return fmt.Errorf("windows_containers is not supported on %s runners", params.Arm64)
+	}
+	return nil
+}
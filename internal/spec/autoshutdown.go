@@ -0,0 +1,59 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const defaultAutoShutdownTimeZone = "UTC"
+
+var autoShutdownTimeRegex = regexp.MustCompile(`^([01][0-9]|2[0-3])[0-5][0-9]$`)
+
+// AutoShutdown shuts down the VM of a runner every day at a set time, so runners that are
+// forgotten don't keep running. The VM is deallocated, not deleted.
+type AutoShutdown struct {
+	// Time is the time of day the VM is shut down at, as HHMM.
+	Time string `json:"time"`
+	// TimeZone is the Windows time zone ID the time is in. Defaults to UTC.
+	TimeZone string `json:"time_zone"`
+	// NotificationEmail is sent a notification 30 minutes before the shutdown.
+	NotificationEmail string `json:"notification_email"`
+	// TagOnly only tags the VM with the shutdown time, for automation of the organization
+	// to act on, instead of creating a shutdown schedule.
+	TagOnly bool `json:"tag_only"`
+}
+
+func (s *AutoShutdown) setDefaults() {
+	if s.TimeZone == "" {
+		s.TimeZone = defaultAutoShutdownTimeZone
+	}
+}
+
+func (s AutoShutdown) Validate() error {
+	if !autoShutdownTimeRegex.MatchString(s.Time) {
+		return fmt.Errorf("invalid time %q (expected HHMM)", s.Time)
+	}
+	if s.TagOnly && s.NotificationEmail != "" {
+		return fmt.Errorf("notification_email can't be used with tag_only")
+	}
+	return nil
+}
+
+// TagValue returns the value of the auto shutdown tag, the time and the time zone.
+func (s AutoShutdown) TagValue() string {
+	return fmt.Sprintf("%s %s", s.Time, s.TimeZone)
+}
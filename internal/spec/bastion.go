@@ -0,0 +1,96 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+const (
+	// BastionSubnetName is the name Azure Bastion requires for its subnet.
+	BastionSubnetName = "AzureBastionSubnet"
+	// bastionMaxPrefix is the smallest subnet Azure Bastion can be deployed in.
+	bastionMaxPrefix = 26
+
+	bastionRuleName     = "inbound_bastion"
+	bastionRulePriority = 250
+)
+
+// BastionSettings allows interactive access to runners through Azure Bastion, without
+// public IPs.
+type BastionSettings struct {
+	// SubnetCIDR creates an AzureBastionSubnet with this address range in the virtual
+	// network of the runner, for a Bastion host deployed next to it. It must be a /26 or
+	// larger, inside the virtual network address space.
+	SubnetCIDR string `json:"subnet_cidr"`
+	// SourceCIDR is the address range of the Bastion subnet SSH and RDP are allowed from.
+	// Set it to reuse an existing Bastion, in a peered virtual network. Defaults to SubnetCIDR.
+	SourceCIDR string `json:"source_cidr"`
+}
+
+func (b BastionSettings) Validate() error {
+	if b.SubnetCIDR == "" && b.SourceCIDR == "" {
+		return fmt.Errorf("one of subnet_cidr or source_cidr must be set")
+	}
+	if b.SubnetCIDR != "" {
+		_, subnet, err := net.ParseCIDR(b.SubnetCIDR)
+		if err != nil {
+			return fmt.Errorf("invalid subnet_cidr: %w", err)
+		}
+		if ones, _ := subnet.Mask.Size(); ones > bastionMaxPrefix {
+			return fmt.Errorf("subnet_cidr %s is too small for Azure Bastion (minimum /%d)", b.SubnetCIDR, bastionMaxPrefix)
+		}
+	}
+	if b.SourceCIDR != "" {
+		if _, _, err := net.ParseCIDR(b.SourceCIDR); err != nil {
+			return fmt.Errorf("invalid source_cidr: %w", err)
+		}
+	}
+	return nil
+}
+
+func (b BastionSettings) sourceCIDR() string {
+	if b.SourceCIDR != "" {
+		return b.SourceCIDR
+	}
+	return b.SubnetCIDR
+}
+
+// bastionRule returns the security rule allowing SSH or RDP from the Bastion subnet.
+func (r RunnerSpec) bastionRule() *armnetwork.SecurityRule {
+	port := "22"
+	if r.BootstrapParams.OSType == params.Windows {
+		port = "3389"
+	}
+	return &armnetwork.SecurityRule{
+		Name: to.Ptr(bastionRuleName),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			SourceAddressPrefix:      to.Ptr(r.Bastion.sourceCIDR()),
+			SourcePortRange:          to.Ptr("*"),
+			DestinationAddressPrefix: to.Ptr("*"),
+			DestinationPortRange:     to.Ptr(port),
+			Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+			Access:                   to.Ptr(armnetwork.SecurityRuleAccessAllow),
+			Priority:                 to.Ptr(int32(bastionRulePriority)),
+			Description:              to.Ptr("allow interactive access through Azure Bastion"),
+			Direction:                to.Ptr(armnetwork.SecurityRuleDirectionInbound),
+		},
+	}
+}
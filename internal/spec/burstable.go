@@ -0,0 +1,46 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+)
+
+// burstableSizeRegex matches burstable VM sizes, like Standard_B2s or Standard_B4als_v2.
+var burstableSizeRegex = regexp.MustCompile(`(?i)^Standard_B\d`)
+
+// IsBurstable returns true if the VM size is a burstable (B-series) size.
+func (r RunnerSpec) IsBurstable() bool {
+	return burstableSizeRegex.MatchString(r.VMSize)
+}
+
+// BurstableWarning returns a warning for runners on burstable VM sizes, unless they are
+// explicitly allowed.
+func (r RunnerSpec) BurstableWarning() string {
+	if !r.IsBurstable() || r.BurstablePolicy == config.BurstablePolicyAllow {
+		return ""
+	}
+	return fmt.Sprintf("%s is a burstable VM size; runners start with few CPU credits and are throttled to the baseline CPU performance once they run out, which makes job timings erratic", r.VMSize)
+}
+
+func (r RunnerSpec) validateBurstable() error {
+	if r.IsBurstable() && r.BurstablePolicy == config.BurstablePolicyRefuse {
+		return fmt.Errorf("burstable VM size %s is refused by the burstable_policy; set allow_burstable in the pool extra specs to use it anyway", r.VMSize)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+const (
+	// linuxCallbackTokenTemplate gets an AAD token of the identity from IMDS. It replaces
+	// the instance token in the double quoted assignments of the install scripts, so the
+	// token is fetched when the script runs.
+	linuxCallbackTokenTemplate = `$(curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -H Metadata:true -G http://169.254.169.254/metadata/identity/oauth2/token --data-urlencode api-version=2018-02-01 --data-urlencode resource=%s --data-urlencode msi_res_id=%s | sed -n 's/.*"access_token":"\([^"]*\)".*/\1/p')`
+	// windowsCallbackTokenTemplate is the PowerShell equivalent, used in the double quoted
+	// default of the token parameter of the install script.
+	windowsCallbackTokenTemplate = `$((Invoke-RestMethod -UseBasicParsing -Headers @{Metadata='true'} -Uri 'http://169.254.169.254/metadata/identity/oauth2/token?api-version=2018-02-01&resource=%s&msi_res_id=%s').access_token)`
+)
+
+// callbackAuthValueRegex matches values that are safe to inline in the install scripts.
+var callbackAuthValueRegex = regexp.MustCompile(`^[A-Za-z0-9:/._-]+$`)
+
+// CallbackAuth makes runners authenticate to the garm callback and metadata URLs with an
+// AAD token of a managed identity, so the instance token is not in the userdata.
+type CallbackAuth struct {
+	// IdentityID is the resource ID of the user assigned managed identity the token is
+	// requested for. It is assigned to the runner VMs.
+	IdentityID string `json:"identity_id"`
+	// Audience is the application ID URI garm, or the proxy in front of it, accepts
+	// tokens for.
+	Audience string `json:"audience"`
+}
+
+func (c CallbackAuth) Validate() error {
+	if !userAssignedIdentityRegex.MatchString(c.IdentityID) || !callbackAuthValueRegex.MatchString(c.IdentityID) {
+		return fmt.Errorf("invalid identity_id %q (expected the resource ID of a user assigned managed identity)", c.IdentityID)
+	}
+	if !callbackAuthValueRegex.MatchString(c.Audience) {
+		return fmt.Errorf("invalid audience %q", c.Audience)
+	}
+	return nil
+}
+
+// callbackToken returns the token the runner authenticates to garm with. With callback
+// auth, it is an expression that gets an AAD token when the install script runs.
+func (r RunnerSpec) callbackToken() string {
+	if r.CallbackAuth == nil {
+		return r.BootstrapParams.InstanceToken
+	}
+	if r.BootstrapParams.OSType == params.Windows {
+		return fmt.Sprintf(windowsCallbackTokenTemplate, url.QueryEscape(r.CallbackAuth.Audience), url.QueryEscape(r.CallbackAuth.IdentityID))
+	}
+	return fmt.Sprintf(linuxCallbackTokenTemplate, r.CallbackAuth.Audience, r.CallbackAuth.IdentityID)
+}
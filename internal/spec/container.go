@@ -0,0 +1,218 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// runnerContainerOptionRegex keeps the engine options free of shell syntax, as they end up
+// in a script unquoted.
+var runnerContainerOptionRegex = regexp.MustCompile(`^-[a-zA-Z0-9_.,:=/@+-]*$`)
+
+const (
+	defaultRunnerContainerImage = "ghcr.io/actions/actions-runner:latest"
+
+	RunnerContainerEngineDocker = "docker"
+	RunnerContainerEnginePodman = "podman"
+
+	// runnerContainerTemplate replaces the runner install template of cloudconfig. Instead
+	// of installing the runner on the host, it pulls the runner image and runs it in a
+	// container, with the JIT configuration garm hands out. The container runs in a unit
+	// named after the runner service, so anything looking for the actions.runner.* unit
+	// still finds it. With podman, the container runs rootless, as the runner user.
+	runnerContainerTemplate = `#!/bin/bash
+
+set -e
+set -o pipefail
+
+{{- if .EnableBootDebug }}
+set -x
+{{- end }}
+
+CALLBACK_URL="{{ .CallbackURL }}"
+METADATA_URL="{{ .MetadataURL }}"
+BEARER_TOKEN="{{ .CallbackToken }}"
+ENGINE=%[1]s
+IMAGE=%[2]s
+OPTIONS=%[3]s
+RUNNER_HOME="/home/{{ .RunnerUsername }}"
+ENV_FILE="$RUNNER_HOME/.garm-runner.env"
+WRAPPER="$RUNNER_HOME/garm-runner-container.sh"
+
+function call() {
+	PAYLOAD="$1"
+	[[ $CALLBACK_URL =~ ^(.*)/status(/)?$ ]] || CALLBACK_URL="${CALLBACK_URL}/status"
+	curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -X POST -d "${PAYLOAD}" -H 'Accept: application/json' -H "Authorization: Bearer ${BEARER_TOKEN}" "${CALLBACK_URL}" || echo "failed to call home: exit code ($?)"
+}
+
+function sendStatus() {
+	call "{\"status\": \"installing\", \"message\": \"$1\"}"
+}
+
+function success() {
+	call "{\"status\": \"idle\", \"message\": \"$1\"}"
+}
+
+function fail() {
+	call "{\"status\": \"failed\", \"message\": \"$1\"}"
+	exit 1
+}
+
+function getRunnerFile() {
+	curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s \
+		-X GET -H 'Accept: application/json' \
+		-H "Authorization: Bearer ${BEARER_TOKEN}" \
+		"${METADATA_URL}/$1"
+}
+
+{{- if not .UseJITConfig }}
+fail "container runners need JIT configuration"
+{{- end }}
+
+if ! command -v "$ENGINE" > /dev/null; then
+	sendStatus "installing $ENGINE"
+	if command -v apt-get > /dev/null; then
+		PACKAGE="$ENGINE"
+		[ "$ENGINE" = "docker" ] && PACKAGE="docker.io"
+		sudo DEBIAN_FRONTEND=noninteractive apt-get install -y "$PACKAGE" || fail "failed to install $ENGINE"
+	elif command -v dnf > /dev/null; then
+		PACKAGE="$ENGINE"
+		[ "$ENGINE" = "docker" ] && PACKAGE="moby-engine"
+		sudo dnf install -y "$PACKAGE" || fail "failed to install $ENGINE"
+	else
+		fail "$ENGINE is not installed, and no supported package manager was found"
+	fi
+fi
+
+if [ "$ENGINE" = "podman" ]; then
+	SUDO=""
+	SERVICE_USER="User={{ .RunnerUsername }}"
+	sudo loginctl enable-linger {{ .RunnerUsername }} || fail "failed to enable lingering"
+else
+	SUDO="sudo"
+	SERVICE_USER=""
+fi
+
+sendStatus "pulling $IMAGE"
+$SUDO "$ENGINE" pull "$IMAGE" || fail "failed to pull $IMAGE"
+
+sendStatus "downloading JIT credentials"
+RUNNER=$(getRunnerFile "credentials/runner" | base64 -w0) || fail "failed to get runner file"
+CREDENTIALS=$(getRunnerFile "credentials/credentials" | base64 -w0) || fail "failed to get credentials file"
+RSAPARAMS=$(getRunnerFile "credentials/credentials_rsaparams" | base64 -w0) || fail "failed to get credentials_rsaparams file"
+SVC_NAME=$(getRunnerFile "system/service-name") || fail "failed to get service name"
+SVC_NAME="${SVC_NAME}.service"
+
+# The encoded JIT config the runner accepts is the base64 encoded JSON of its base64
+# encoded configuration files.
+(
+	umask 077
+	printf '{".runner":"%%s",".credentials":"%%s",".credentials_rsaparams":"%%s"}' "$RUNNER" "$CREDENTIALS" "$RSAPARAMS" | base64 -w0 | sed 's/^/GARM_JIT_CONFIG=/' > "$ENV_FILE"
+) || fail "failed to write the runner configuration"
+
+sendStatus "creating runner service"
+cat > "$WRAPPER" << GARM_EOF
+#!/bin/sh
+[ "$ENGINE" = "podman" ] && export XDG_RUNTIME_DIR=/run/user/\$(id -u)
+exec $ENGINE run --rm --name garm-runner --env-file $ENV_FILE $OPTIONS $IMAGE /bin/sh -c './run.sh --jitconfig "\$GARM_JIT_CONFIG"'
+GARM_EOF
+chmod 755 "$WRAPPER"
+
+sudo tee /etc/systemd/system/$SVC_NAME > /dev/null << GARM_EOF
+[Unit]
+Description=GitHub Actions runner in a container
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+$SERVICE_USER
+ExecStart=$WRAPPER
+Restart=no
+
+[Install]
+WantedBy=multi-user.target
+GARM_EOF
+
+sudo systemctl daemon-reload || fail "failed to reload systemd"
+sudo systemctl enable $SVC_NAME
+sudo systemctl start $SVC_NAME || fail "failed to start service"
+success "runner successfully started in a container"
+`
+)
+
+// RunnerContainer runs the runner in a container, instead of installing it on the host.
+type RunnerContainer struct {
+	// Image is the runner image. It must hold the runner in its working directory, like
+	// the official image does. Defaults to ghcr.io/actions/actions-runner:latest.
+	Image string `json:"image"`
+	// Engine is docker (the default) or podman. Podman runs the container rootless.
+	Engine string `json:"engine"`
+	// Options are extra options for the run command of the engine, like --cpus=2.
+	Options []string `json:"options"`
+}
+
+func (c *RunnerContainer) setDefaults() {
+	if c.Image == "" {
+		c.Image = defaultRunnerContainerImage
+	}
+	if c.Engine == "" {
+		c.Engine = RunnerContainerEngineDocker
+	}
+}
+
+func (c RunnerContainer) Validate() error {
+	switch c.Engine {
+	case RunnerContainerEngineDocker, RunnerContainerEnginePodman:
+	default:
+		return fmt.Errorf("invalid engine %q (expected %s or %s)", c.Engine, RunnerContainerEngineDocker, RunnerContainerEnginePodman)
+	}
+	if strings.ContainsAny(c.Image, " \t\n'\"\\$`") {
+		return fmt.Errorf("invalid image %q", c.Image)
+	}
+	for _, option := range c.Options {
+		if !runnerContainerOptionRegex.MatchString(option) {
+			return fmt.Errorf("invalid option %q", option)
+		}
+	}
+	return nil
+}
+
+// installTemplate returns the runner install template that runs the runner in a container.
+func (c RunnerContainer) installTemplate() string {
+	return fmt.Sprintf(runnerContainerTemplate, shellQuote(c.Engine), shellQuote(c.Image), shellQuote(strings.Join(c.Options, " ")))
+}
+
+// withRunnerInstallTemplate returns a copy of the extra specs with the runner install
+// template cloudconfig uses instead of its default one.
+func withRunnerInstallTemplate(extraSpecs json.RawMessage, template string) (json.RawMessage, error) {
+	asMap := map[string]interface{}{}
+	if len(extraSpecs) > 0 {
+		if err := json.Unmarshal(extraSpecs, &asMap); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal extra specs: %w", err)
+		}
+	}
+	// Byte arrays are base64 encoded when marshaled to JSON.
+	asMap["runner_install_template"] = []byte(template)
+
+	ret, err := json.Marshal(asMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal extra specs: %w", err)
+	}
+	return ret, nil
+}
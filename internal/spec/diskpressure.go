@@ -0,0 +1,183 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	providerUtil "github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+var (
+	defaultDiskPressureThresholds = []uint{80, 90, 95}
+	defaultDiskPressurePaths      = []string{"/", "/mnt"}
+)
+
+const (
+	defaultDiskPressureIntervalMinutes = 1
+
+	diskPressureScriptPath = "/opt/garm/disk-pressure.sh"
+	diskPressureUnitName   = "garm-disk-pressure"
+	// diskPressureScript records the highest disk usage threshold crossed by any of the
+	// paths in a tag of the VM, using a token of the managed identity. The VM is only
+	// tagged when a higher threshold is crossed.
+	diskPressureScript = `#!/bin/sh
+IDENTITY=%[1]s
+THRESHOLDS=%[2]s
+PATHS=%[3]s
+STATE=/var/lib/garm/disk-pressure
+IMDS=http://169.254.169.254/metadata
+
+USAGE=0
+for p in $PATHS; do
+	mountpoint -q "$p" 2>/dev/null || [ "$p" = "/" ] || continue
+	u=$(df --output=pcent "$p" | tail -n1 | tr -dc 0-9)
+	[ -n "$u" ] && [ "$u" -gt "$USAGE" ] && USAGE=$u
+done
+LEVEL=0
+for t in $THRESHOLDS; do
+	[ "$USAGE" -ge "$t" ] && LEVEL=$t
+done
+LAST=$(cat "$STATE" 2>/dev/null || echo 0)
+if [ "$LEVEL" -le "$LAST" ]; then
+	exit 0
+fi
+
+TOKEN=$(curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -H Metadata:true -G "$IMDS/identity/oauth2/token" --data-urlencode "api-version=2018-02-01" --data-urlencode "resource=https://management.azure.com/" --data-urlencode "msi_res_id=$IDENTITY" | sed -n 's/.*"access_token":"\([^"]*\)".*/\1/p')
+if [ -z "$TOKEN" ]; then
+	echo "failed to get a managed identity token"
+	exit 1
+fi
+RESOURCE_ID=$(curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -H Metadata:true "$IMDS/instance/compute/resourceId?api-version=2021-02-01&format=text")
+if [ -z "$RESOURCE_ID" ]; then
+	echo "failed to get the resource ID of the VM"
+	exit 1
+fi
+
+NOW=$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)
+curl --retry 5 --retry-delay 5 --fail -s -o /dev/null -X PATCH \
+	-H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+	"https://management.azure.com${RESOURCE_ID}/providers/Microsoft.Resources/tags/default?api-version=2021-04-01" \
+	-d "{\"operation\":\"Merge\",\"properties\":{\"tags\":{\"%[4]s\":\"$LEVEL\",\"%[5]s\":\"$USAGE\",\"%[6]s\":\"$NOW\"}}}" || exit 1
+mkdir -p "$(dirname "$STATE")"
+echo "$LEVEL" > "$STATE"
+echo "disk usage at $USAGE%%, crossed the $LEVEL%% threshold"
+`
+	diskPressureService = `[Unit]
+Description=Record disk pressure of the runner
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh %s
+`
+	diskPressureTimer = `[Unit]
+Description=Check the disk pressure of the runner periodically
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=%dmin
+
+[Install]
+WantedBy=timers.target
+`
+
+	linuxDiskPressureScriptName = "00-garm-disk-pressure.sh"
+	// linuxDiskPressureInstallTemplate installs the disk pressure service and its timer.
+	linuxDiskPressureInstallTemplate = `#!/bin/sh
+mkdir -p /opt/garm
+cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+cat > /etc/systemd/system/%[3]s.service << 'GARM_EOF'
+%[4]sGARM_EOF
+cat > /etc/systemd/system/%[3]s.timer << 'GARM_EOF'
+%[5]sGARM_EOF
+systemctl daemon-reload
+systemctl enable --now %[3]s.timer
+`
+)
+
+// DiskPressure makes the runner tag its VM when its disk usage crosses thresholds, using a
+// managed identity. The provider logs the runners, and their pool, that crossed them.
+type DiskPressure struct {
+	// IdentityID is the resource ID of a user assigned managed identity that is allowed to
+	// update the tags of the runner VMs. It is assigned to the runner VMs.
+	IdentityID string `json:"identity_id"`
+	// Thresholds are disk usage percentages. Defaults to 80, 90 and 95.
+	Thresholds []uint `json:"thresholds"`
+	// Paths are the mount points checked. Defaults to / and the temp disk (/mnt).
+	Paths []string `json:"paths"`
+	// IntervalMinutes is how often the disk usage is checked. Defaults to 1.
+	IntervalMinutes uint `json:"interval_minutes"`
+}
+
+func (d *DiskPressure) setDefaults() {
+	if len(d.Thresholds) == 0 {
+		d.Thresholds = defaultDiskPressureThresholds
+	}
+	if len(d.Paths) == 0 {
+		d.Paths = defaultDiskPressurePaths
+	}
+	if d.IntervalMinutes == 0 {
+		d.IntervalMinutes = defaultDiskPressureIntervalMinutes
+	}
+}
+
+func (d DiskPressure) Validate() error {
+	if !userAssignedIdentityRegex.MatchString(d.IdentityID) {
+		return fmt.Errorf("invalid identity_id %q (expected the resource ID of a user assigned managed identity)", d.IdentityID)
+	}
+	for _, threshold := range d.Thresholds {
+		if threshold == 0 || threshold > 100 {
+			return fmt.Errorf("invalid threshold %d (expected 1 to 100)", threshold)
+		}
+	}
+	for _, path := range d.Paths {
+		if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t\n") {
+			return fmt.Errorf("invalid path %q (expected an absolute path without spaces)", path)
+		}
+	}
+	return nil
+}
+
+// diskPressureInstallScript returns the pre install script that sets up the disk pressure
+// checks.
+func (d DiskPressure) diskPressureInstallScript() []byte {
+	thresholds := append([]uint(nil), d.Thresholds...)
+	sort.Slice(thresholds, func(i, j int) bool { return thresholds[i] < thresholds[j] })
+	asStrings := make([]string, 0, len(thresholds))
+	for _, threshold := range thresholds {
+		asStrings = append(asStrings, strconv.FormatUint(uint64(threshold), 10))
+	}
+	script := fmt.Sprintf(
+		diskPressureScript,
+		shellQuote(d.IdentityID),
+		shellQuote(strings.Join(asStrings, " ")),
+		shellQuote(strings.Join(d.Paths, " ")),
+		providerUtil.DiskPressureTagName,
+		providerUtil.DiskUsageTagName,
+		providerUtil.DiskPressureAtTagName)
+	return []byte(fmt.Sprintf(
+		linuxDiskPressureInstallTemplate,
+		diskPressureScriptPath,
+		script,
+		diskPressureUnitName,
+		fmt.Sprintf(diskPressureService, diskPressureScriptPath),
+		fmt.Sprintf(diskPressureTimer, d.IntervalMinutes)))
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+)
+
+// premiumDiskTiers are the Premium SSD performance tiers, with the largest disk size
+// in GB each of them is the baseline tier for.
+var premiumDiskTiers = []struct {
+	name   string
+	sizeGB int32
+}{
+	{"P1", 4}, {"P2", 8}, {"P3", 16}, {"P4", 32}, {"P6", 64}, {"P10", 128},
+	{"P15", 256}, {"P20", 512}, {"P30", 1024}, {"P40", 2048}, {"P50", 4096},
+	{"P60", 8192}, {"P70", 16384}, {"P80", 32767},
+}
+
+// premiumDiskTierIndex returns the position of a tier in premiumDiskTiers, or -1.
+func premiumDiskTierIndex(tier string) int {
+	for idx, t := range premiumDiskTiers {
+		if strings.EqualFold(t.name, tier) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// validateDiskPerformanceTier makes sure the performance tier can be set on the OS disk.
+// The tier may not be lower than the baseline tier of the disk size.
+func (r RunnerSpec) validateDiskPerformanceTier() error {
+	if r.DiskPerformanceTier == "" {
+		return nil
+	}
+	if r.UseEphemeralStorage {
+		return fmt.Errorf("a disk performance tier can't be set on ephemeral OS disks")
+	}
+	if r.StorageAccountType != armcompute.StorageAccountTypesPremiumLRS && r.StorageAccountType != armcompute.StorageAccountTypesPremiumZRS {
+		return fmt.Errorf("a disk performance tier can only be set on Premium SSD disks (storage type %s)", r.StorageAccountType)
+	}
+	tierIdx := premiumDiskTierIndex(r.DiskPerformanceTier)
+	if tierIdx < 0 {
+		return fmt.Errorf("invalid disk performance tier %s", r.DiskPerformanceTier)
+	}
+	for idx, baseline := range premiumDiskTiers {
+		if r.DiskSizeGB <= baseline.sizeGB {
+			if tierIdx < idx {
+				return fmt.Errorf("disk performance tier %s is below the baseline tier %s of a %d GB disk", r.DiskPerformanceTier, baseline.name, r.DiskSizeGB)
+			}
+			break
+		}
+	}
+	return nil
+}
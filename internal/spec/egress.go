@@ -0,0 +1,164 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// EgressProfile restricts the outbound traffic of runners.
+type EgressProfile string
+
+const (
+	// EgressProfileGitHubOnly only allows outbound traffic to the GitHub meta ranges, to
+	// Azure for the Actions service, and to the garm callback and metadata URLs.
+	EgressProfileGitHubOnly EgressProfile = "github-only"
+
+	// GitHubEgressRuleName is the name of the security rule allowing traffic to GitHub.
+	GitHubEgressRuleName = "outbound_github"
+
+	// actionsServiceTag covers the GitHub Actions service, its artifact and cache storage,
+	// and the Azure package mirrors, which are all hosted on Azure. The actions meta ranges
+	// are too many to fit in a security group.
+	actionsServiceTag = "AzureCloud"
+
+	egressGitHubRulePriority   = 300
+	egressCallbackRulePriority = 310
+	egressActionsRulePriority  = 320
+	egressDenyRulePriority     = 4096
+)
+
+// IsGitHubOnly returns true if outbound traffic is restricted to GitHub and garm.
+func (r RunnerSpec) IsGitHubOnly() bool {
+	return r.EgressProfile == EgressProfileGitHubOnly
+}
+
+func (r RunnerSpec) validateEgressProfile() error {
+	switch r.EgressProfile {
+	case "", EgressProfileGitHubOnly:
+		return nil
+	default:
+		return fmt.Errorf("invalid egress profile: %s", r.EgressProfile)
+	}
+}
+
+// callbackPorts returns the TCP ports of the garm callback and metadata URLs.
+func (r RunnerSpec) callbackPorts() []string {
+	seen := map[string]struct{}{}
+	var ports []string
+	for _, rawURL := range []string{r.BootstrapParams.CallbackURL, r.BootstrapParams.MetadataURL} {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+		port := parsed.Port()
+		if port == "" {
+			port = "80"
+			if parsed.Scheme == "https" {
+				port = "443"
+			}
+		}
+		if _, ok := seen[port]; !ok {
+			seen[port] = struct{}{}
+			ports = append(ports, port)
+		}
+	}
+	return ports
+}
+
+// GitHubEgressRule returns the security rule allowing outbound traffic to the GitHub ranges.
+func GitHubEgressRule(cidrs []string) *armnetwork.SecurityRule {
+	return &armnetwork.SecurityRule{
+		Name: to.Ptr(GitHubEgressRuleName),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			SourceAddressPrefix:        to.Ptr("*"),
+			SourcePortRange:            to.Ptr("*"),
+			DestinationAddressPrefixes: to.SliceOfPtrs(cidrs...),
+			DestinationPortRanges:      to.SliceOfPtrs("22", "80", "443"),
+			Protocol:                   to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+			Access:                     to.Ptr(armnetwork.SecurityRuleAccessAllow),
+			Priority:                   to.Ptr(int32(egressGitHubRulePriority)),
+			Description:                to.Ptr("allow outbound traffic to github"),
+			Direction:                  to.Ptr(armnetwork.SecurityRuleDirectionOutbound),
+		},
+	}
+}
+
+// egressRules returns the outbound security rules of the egress profile.
+func (r RunnerSpec) egressRules() []*armnetwork.SecurityRule {
+	if !r.IsGitHubOnly() {
+		return nil
+	}
+
+	var ret []*armnetwork.SecurityRule
+	if len(r.GitHubEgressCIDRs) > 0 {
+		ret = append(ret, GitHubEgressRule(r.GitHubEgressCIDRs))
+	}
+
+	if len(r.CallbackEgressCIDRs) > 0 {
+		ret = append(ret, &armnetwork.SecurityRule{
+			Name: to.Ptr("outbound_garm"),
+			Properties: &armnetwork.SecurityRulePropertiesFormat{
+				SourceAddressPrefix:        to.Ptr("*"),
+				SourcePortRange:            to.Ptr("*"),
+				DestinationAddressPrefixes: to.SliceOfPtrs(r.CallbackEgressCIDRs...),
+				DestinationPortRanges:      to.SliceOfPtrs(r.callbackPorts()...),
+				Protocol:                   to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+				Access:                     to.Ptr(armnetwork.SecurityRuleAccessAllow),
+				Priority:                   to.Ptr(int32(egressCallbackRulePriority)),
+				Description:                to.Ptr("allow outbound traffic to the garm callback and metadata URLs"),
+				Direction:                  to.Ptr(armnetwork.SecurityRuleDirectionOutbound),
+			},
+		})
+	}
+
+	// Runners can't pick up or report jobs without reaching the Actions service.
+	ret = append(ret, &armnetwork.SecurityRule{
+		Name: to.Ptr("outbound_actions"),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			SourceAddressPrefix:      to.Ptr("*"),
+			SourcePortRange:          to.Ptr("*"),
+			DestinationAddressPrefix: to.Ptr(actionsServiceTag),
+			DestinationPortRanges:    to.SliceOfPtrs("80", "443"),
+			Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolTCP),
+			Access:                   to.Ptr(armnetwork.SecurityRuleAccessAllow),
+			Priority:                 to.Ptr(int32(egressActionsRulePriority)),
+			Description:              to.Ptr("allow outbound traffic to the github actions service and azure package mirrors"),
+			Direction:                to.Ptr(armnetwork.SecurityRuleDirectionOutbound),
+		},
+	})
+
+	// The Azure DNS and instance metadata endpoints are not subject to security rules,
+	// so denying everything else doesn't break name resolution or provisioning.
+	ret = append(ret, &armnetwork.SecurityRule{
+		Name: to.Ptr("outbound_deny_all"),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			SourceAddressPrefix:      to.Ptr("*"),
+			SourcePortRange:          to.Ptr("*"),
+			DestinationAddressPrefix: to.Ptr("*"),
+			DestinationPortRange:     to.Ptr("*"),
+			Protocol:                 to.Ptr(armnetwork.SecurityRuleProtocolAsterisk),
+			Access:                   to.Ptr(armnetwork.SecurityRuleAccessDeny),
+			Priority:                 to.Ptr(int32(egressDenyRulePriority)),
+			Description:              to.Ptr(fmt.Sprintf("deny all other outbound traffic (%s egress profile)", r.EgressProfile)),
+			Direction:                to.Ptr(armnetwork.SecurityRuleDirectionOutbound),
+		},
+	})
+	return ret
+}
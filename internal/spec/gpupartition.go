@@ -0,0 +1,125 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// migSizeRegex matches the VM sizes with GPUs that support MIG (A100 and H100).
+	migSizeRegex = regexp.MustCompile(`(?i)(A100|H100)`)
+	// migProfileRegex matches MIG GPU instance profiles, by name (like 3g.40gb) or ID.
+	migProfileRegex = regexp.MustCompile(`^([0-9]+g\.[0-9]+gb(\+me)?|[0-9]+)$`)
+)
+
+const (
+	gpuPartitionScriptPath = "/opt/garm/gpu-partition.sh"
+	gpuPartitionUnitName   = "garm-gpu-partition.service"
+	// gpuPartitionScript enables MIG on the selected GPUs and creates the GPU instances, each
+	// with a compute instance. GPU instances don't survive a reboot, so this runs on every
+	// boot.
+	gpuPartitionScript = `#!/bin/sh
+GPUS=%[1]s
+PROFILES=%[2]s
+
+if ! command -v nvidia-smi > /dev/null; then
+	echo "nvidia-smi not found, the NVIDIA driver must be installed in the image"
+	exit 1
+fi
+
+for i in $(nvidia-smi --query-gpu=index --format=csv,noheader); do
+	if [ -n "$GPUS" ] && ! echo ",$GPUS," | grep -q ",$i,"; then
+		continue
+	fi
+	nvidia-smi -i "$i" -mig 1 || exit 1
+	if [ "$(nvidia-smi -i "$i" --query-gpu=mig.mode.current --format=csv,noheader)" != "Enabled" ]; then
+		nvidia-smi -i "$i" -r || exit 1
+	fi
+	nvidia-smi mig -i "$i" -dci > /dev/null 2>&1
+	nvidia-smi mig -i "$i" -dgi > /dev/null 2>&1
+	nvidia-smi mig -i "$i" -cgi "$PROFILES" -C || exit 1
+done
+nvidia-smi -L
+`
+	gpuPartitionUnit = `[Unit]
+Description=Partition the GPUs with NVIDIA MIG
+Before=docker.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh %s
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	linuxGPUPartitionScriptName = "00-garm-gpu-partition.sh"
+	// linuxGPUPartitionInstallTemplate installs the partitioning unit and runs it, before the
+	// runner is installed.
+	linuxGPUPartitionInstallTemplate = `#!/bin/sh
+mkdir -p /opt/garm
+cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+cat > /etc/systemd/system/%[3]s << 'GARM_EOF'
+%[4]sGARM_EOF
+systemctl daemon-reload
+systemctl enable --now %[3]s
+`
+)
+
+// GPUPartitioning splits the GPUs of a runner into NVIDIA MIG instances when it boots, so
+// containerized jobs can be given a slice of a GPU.
+type GPUPartitioning struct {
+	// Profiles are the MIG GPU instance profiles created on each GPU, by name (like 3g.40gb)
+	// or ID. They must fit on the GPU together.
+	Profiles []string `json:"profiles"`
+	// GPUs are the indexes of the GPUs to partition. Defaults to all of them.
+	GPUs []uint `json:"gpus"`
+}
+
+func (g GPUPartitioning) Validate(vmSize string) error {
+	if !migSizeRegex.MatchString(vmSize) {
+		return fmt.Errorf("VM size %s has no GPUs that support MIG (A100 or H100)", vmSize)
+	}
+	if len(g.Profiles) == 0 {
+		return fmt.Errorf("at least one profile is required")
+	}
+	for _, profile := range g.Profiles {
+		if !migProfileRegex.MatchString(profile) {
+			return fmt.Errorf("invalid MIG profile %q", profile)
+		}
+	}
+	return nil
+}
+
+// gpuPartitionInstallScript returns the pre install script that sets up the partitioning.
+func (g GPUPartitioning) gpuPartitionInstallScript() []byte {
+	gpus := make([]string, 0, len(g.GPUs))
+	for _, gpu := range g.GPUs {
+		gpus = append(gpus, strconv.FormatUint(uint64(gpu), 10))
+	}
+	script := fmt.Sprintf(gpuPartitionScript, shellQuote(strings.Join(gpus, ",")), shellQuote(strings.Join(g.Profiles, ",")))
+	return []byte(fmt.Sprintf(
+		linuxGPUPartitionInstallTemplate,
+		gpuPartitionScriptPath,
+		script,
+		gpuPartitionUnitName,
+		fmt.Sprintf(gpuPartitionUnit, gpuPartitionScriptPath)))
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+
+	providerUtil "github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	defaultHeartbeatIntervalMinutes = 5
+	defaultHeartbeatTimeoutMinutes  = 20
+
+	heartbeatScriptPath = "/opt/garm/heartbeat.sh"
+	heartbeatUnitName   = "garm-heartbeat"
+	// heartbeatScript records the current time in a tag of the VM, using a token of the
+	// managed identity. Once the runner service is installed, heartbeats are only sent while
+	// it is running, so a runner whose agent died stops sending them.
+	heartbeatScript = `#!/bin/sh
+IDENTITY=%[1]s
+IMDS=http://169.254.169.254/metadata
+
+UNIT=$(systemctl list-units --type=service --all --plain --no-legend 'actions.runner.*' | awk '{print $1}' | head -n1)
+if [ -n "$UNIT" ] && [ "$(systemctl is-active "$UNIT")" != "active" ]; then
+	echo "runner service $UNIT is not running, skipping heartbeat"
+	exit 0
+fi
+
+TOKEN=$(curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -H Metadata:true -G "$IMDS/identity/oauth2/token" --data-urlencode "api-version=2018-02-01" --data-urlencode "resource=https://management.azure.com/" --data-urlencode "msi_res_id=$IDENTITY" | sed -n 's/.*"access_token":"\([^"]*\)".*/\1/p')
+if [ -z "$TOKEN" ]; then
+	echo "failed to get a managed identity token"
+	exit 1
+fi
+RESOURCE_ID=$(curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -H Metadata:true "$IMDS/instance/compute/resourceId?api-version=2021-02-01&format=text")
+if [ -z "$RESOURCE_ID" ]; then
+	echo "failed to get the resource ID of the VM"
+	exit 1
+fi
+
+NOW=$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)
+curl --retry 5 --retry-delay 5 --fail -s -o /dev/null -X PATCH \
+	-H "Authorization: Bearer $TOKEN" -H "Content-Type: application/json" \
+	"https://management.azure.com${RESOURCE_ID}/providers/Microsoft.Resources/tags/default?api-version=2021-04-01" \
+	-d "{\"operation\":\"Merge\",\"properties\":{\"tags\":{\"%[2]s\":\"$NOW\"}}}"
+`
+	heartbeatService = `[Unit]
+Description=Record a heartbeat of the runner
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh %s
+`
+	heartbeatTimer = `[Unit]
+Description=Record a heartbeat of the runner periodically
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=%dmin
+
+[Install]
+WantedBy=timers.target
+`
+
+	linuxHeartbeatScriptName = "00-garm-heartbeat.sh"
+	// linuxHeartbeatInstallTemplate installs the heartbeat service and its timer.
+	linuxHeartbeatInstallTemplate = `#!/bin/sh
+mkdir -p /opt/garm
+cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+cat > /etc/systemd/system/%[3]s.service << 'GARM_EOF'
+%[4]sGARM_EOF
+cat > /etc/systemd/system/%[3]s.timer << 'GARM_EOF'
+%[5]sGARM_EOF
+systemctl daemon-reload
+systemctl enable --now %[3]s.timer
+`
+)
+
+// Heartbeat makes the runner record a heartbeat in a tag of its VM, using a managed
+// identity. Runners that stop sending heartbeats are deleted by the provider.
+type Heartbeat struct {
+	// IdentityID is the resource ID of a user assigned managed identity that is allowed to
+	// update the tags of the runner VMs. It is assigned to the runner VMs.
+	IdentityID string `json:"identity_id"`
+	// IntervalMinutes is how often heartbeats are sent. Defaults to 5.
+	IntervalMinutes uint `json:"interval_minutes"`
+	// TimeoutMinutes is how long a runner may go without a heartbeat before it is
+	// considered hung. Defaults to 20.
+	TimeoutMinutes uint `json:"timeout_minutes"`
+}
+
+func (h *Heartbeat) setDefaults() {
+	if h.IntervalMinutes == 0 {
+		h.IntervalMinutes = defaultHeartbeatIntervalMinutes
+	}
+	if h.TimeoutMinutes == 0 {
+		h.TimeoutMinutes = defaultHeartbeatTimeoutMinutes
+	}
+}
+
+func (h Heartbeat) Validate() error {
+	if !userAssignedIdentityRegex.MatchString(h.IdentityID) {
+		return fmt.Errorf("invalid identity_id %q (expected the resource ID of a user assigned managed identity)", h.IdentityID)
+	}
+	if h.TimeoutMinutes < 2*h.IntervalMinutes {
+		return fmt.Errorf("timeout_minutes (%d) must be at least twice interval_minutes (%d)", h.TimeoutMinutes, h.IntervalMinutes)
+	}
+	return nil
+}
+
+// heartbeatInstallScript returns the pre install script that sets up the heartbeats.
+func (h Heartbeat) heartbeatInstallScript() []byte {
+	script := fmt.Sprintf(heartbeatScript, shellQuote(h.IdentityID), providerUtil.LastHeartbeatTagName)
+	return []byte(fmt.Sprintf(
+		linuxHeartbeatInstallTemplate,
+		heartbeatScriptPath,
+		script,
+		heartbeatUnitName,
+		fmt.Sprintf(heartbeatService, heartbeatScriptPath),
+		fmt.Sprintf(heartbeatTimer, h.IntervalMinutes)))
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	appdefaults "github.com/cloudbase/garm-provider-common/defaults"
+)
+
+const (
+	ignitionVersion = "3.3.0"
+
+	// Flatcar mounts /usr read-only, so anything we drop on disk needs to
+	// live under /opt.
+	ignitionInstallScriptPath = "/opt/garm/install_runner.sh"
+	ignitionInstallUnitName   = "garm-runner-install.service"
+
+	ignitionInstallUnitTemplate = `[Unit]
+Description=Install and configure the GitHub actions runner
+Wants=network-online.target
+After=network-online.target
+ConditionPathExists=%[1]s
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/bin/su -l -c %[1]s %[2]s
+ExecStartPost=/usr/bin/rm -f %[1]s
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	// installdependencies.sh of the runner has no Flatcar support, and Flatcar has no
+	// package manager to install libicu with, so the dependencies are not installed, and
+	// the runner runs without globalization support instead.
+	defaultInstallDependencies = `	sudo ./bin/installdependencies.sh || fail "failed to install dependencies"
+`
+	defaultCachedRunnerLookup = `CACHED_RUNNER=$(getCachedToolsPath)
+`
+	ignitionGlobalizationInvariant = `# Flatcar has no libicu.
+export DOTNET_SYSTEM_GLOBALIZATION_INVARIANT=1
+`
+	// The runner service gets the setting from the default environment of systemd, which
+	// is in place before systemd starts, since Ignition runs in the initramfs.
+	ignitionDotnetEnvPath = "/etc/systemd/system.conf.d/10-garm-dotnet.conf"
+	ignitionDotnetEnv     = `[Manager]
+DefaultEnvironment=DOTNET_SYSTEM_GLOBALIZATION_INVARIANT=1
+`
+
+	// The MTU is set with a systemd link file, which udev applies to every ethernet
+	// interface, including the VF added by accelerated networking.
+	ignitionMTULinkPath     = "/etc/systemd/network/10-garm-mtu.link"
+	ignitionMTULinkTemplate = `[Match]
+Type=ether
+
+[Link]
+MTUBytes=%d
+`
+)
+
+// ignitionConfig is a minimal subset of the Ignition v3 config spec. It only
+// holds the fields we need in order to bootstrap a runner on Flatcar.
+type ignitionConfig struct {
+	Ignition ignitionMeta    `json:"ignition"`
+	Passwd   ignitionPasswd  `json:"passwd,omitempty"`
+	Storage  ignitionStorage `json:"storage,omitempty"`
+	Systemd  ignitionSystemd `json:"systemd,omitempty"`
+}
+
+type ignitionMeta struct {
+	Version string `json:"version"`
+}
+
+type ignitionPasswd struct {
+	Users []ignitionUser `json:"users,omitempty"`
+}
+
+type ignitionUser struct {
+	Name              string   `json:"name"`
+	Groups            []string `json:"groups,omitempty"`
+	Shell             string   `json:"shell,omitempty"`
+	SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+}
+
+type ignitionStorage struct {
+	Files []ignitionFile `json:"files,omitempty"`
+}
+
+type ignitionFile struct {
+	Path      string               `json:"path"`
+	Mode      int                  `json:"mode"`
+	Overwrite bool                 `json:"overwrite"`
+	Contents  ignitionFileContents `json:"contents"`
+}
+
+type ignitionFileContents struct {
+	Source string `json:"source"`
+}
+
+type ignitionSystemd struct {
+	Units []ignitionUnit `json:"units,omitempty"`
+}
+
+type ignitionUnit struct {
+	Name     string `json:"name"`
+	Enabled  bool   `json:"enabled"`
+	Contents string `json:"contents"`
+}
+
+func newIgnitionConfig() *ignitionConfig {
+	return &ignitionConfig{
+		Ignition: ignitionMeta{
+			Version: ignitionVersion,
+		},
+		Passwd: ignitionPasswd{
+			Users: []ignitionUser{
+				{
+					Name: appdefaults.DefaultUser,
+					// Flatcar does not ship most of the groups cloud-init
+					// images have. Stick to the ones that exist there.
+					Groups: []string{"sudo", "docker"},
+					Shell:  appdefaults.DefaultUserShell,
+				},
+			},
+		},
+	}
+}
+
+func (i *ignitionConfig) addSSHKeys(keys ...string) {
+	i.Passwd.Users[0].SSHAuthorizedKeys = append(i.Passwd.Users[0].SSHAuthorizedKeys, keys...)
+}
+
+func (i *ignitionConfig) addFile(contents []byte, path string, mode int) {
+	i.Storage.Files = append(i.Storage.Files, ignitionFile{
+		Path:      path,
+		Mode:      mode,
+		Overwrite: true,
+		Contents: ignitionFileContents{
+			Source: fmt.Sprintf("data:;base64,%s", base64.StdEncoding.EncodeToString(contents)),
+		},
+	})
+}
+
+func (i *ignitionConfig) addUnit(name, contents string) {
+	i.Systemd.Units = append(i.Systemd.Units, ignitionUnit{
+		Name:     name,
+		Enabled:  true,
+		Contents: contents,
+	})
+}
+
+func (i *ignitionConfig) serialize() ([]byte, error) {
+	asJs, err := json.Marshal(i)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ignition config: %w", err)
+	}
+	return asJs, nil
+}
+
+// ignitionInstallTemplate returns the default runner install template, without installing
+// the runner dependencies, and with globalization support disabled for the runner
+// configuration.
+func ignitionInstallTemplate() string {
+	template := strings.Replace(cloudconfig.CloudConfigTemplate, defaultInstallDependencies, "", 1)
+	return strings.Replace(template, defaultCachedRunnerLookup, ignitionGlobalizationInvariant+defaultCachedRunnerLookup, 1)
+}
+
+// composeIgnitionUserData renders the runner install script as an Ignition
+// config, suitable for Flatcar Container Linux images.
+func (r RunnerSpec) composeIgnitionUserData(installScript []byte) ([]byte, error) {
+	cfg := newIgnitionConfig()
+	cfg.addFile([]byte(ignitionDotnetEnv), ignitionDotnetEnvPath, 0644)
+	cfg.addSSHKeys(r.BootstrapParams.SSHKeys...)
+	if len(r.BootstrapParams.CACertBundle) > 0 {
+		cfg.addFile(r.BootstrapParams.CACertBundle, "/etc/ssl/certs/garm-ca-bundle.pem", 0644)
+	}
+	if r.MTU > 0 {
+		cfg.addFile([]byte(fmt.Sprintf(ignitionMTULinkTemplate, r.MTU)), ignitionMTULinkPath, 0644)
+	}
+	for _, mount := range r.NFSMounts {
+		cfg.addUnit(systemdMountUnitName(mount.Path), fmt.Sprintf(ignitionNFSMountUnitTemplate, mount.Source, path.Clean(mount.Path), mount.GetOptions()))
+	}
+	if r.SelfTerminate {
+		cfg.addFile([]byte(selfTerminateScript), selfTerminateScriptPath, 0755)
+		cfg.addUnit(selfTerminateUnitName, fmt.Sprintf(selfTerminateUnit, selfTerminateScriptPath))
+	}
+	cfg.addFile(installScript, ignitionInstallScriptPath, 0755)
+	cfg.addUnit(ignitionInstallUnitName, fmt.Sprintf(ignitionInstallUnitTemplate, ignitionInstallScriptPath, appdefaults.DefaultUser))
+
+	return cfg.serialize()
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+)
+
+const (
+	linuxVerifyRunnerScriptName = "00-garm-verify-runner.sh"
+	// linuxVerifyRunnerScript downloads and verifies the runner archive, and extracts it
+	// where the runner install script looks for a cached runner. When the verification
+	// fails, the install script is removed, so an unverified runner is never installed.
+	linuxVerifyRunnerScript = `#!/bin/bash
+set -o pipefail
+
+CACHED_RUNNER=/opt/cache/actions-runner/latest
+ARCHIVE=$(mktemp)
+
+function fail() {
+	echo "$1"
+	rm -f /install_runner.sh "$ARCHIVE"
+	curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -X POST -d "{\"status\": \"failed\", \"message\": \"$1\"}" -H 'Accept: application/json' -H "Authorization: Bearer %[1]s" %[2]s || echo "failed to call home"
+	exit 1
+}
+
+TEMP_TOKEN=%[3]s
+if [ ! -z "$TEMP_TOKEN" ]; then
+	TEMP_TOKEN="Authorization: Bearer $TEMP_TOKEN"
+fi
+curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -L -H "$TEMP_TOKEN" -o "$ARCHIVE" %[4]s || fail "failed to download runner for verification"
+echo "%[5]s  $ARCHIVE" | sha256sum -c - || fail "runner checksum verification failed"
+
+rm -rf "$CACHED_RUNNER"
+mkdir -p "$CACHED_RUNNER" || fail "failed to create runner cache folder"
+tar xf "$ARCHIVE" -C "$CACHED_RUNNER" || fail "failed to extract verified runner"
+"$CACHED_RUNNER/bin/installdependencies.sh" || fail "failed to install runner dependencies"
+rm -f "$ARCHIVE"
+`
+)
+
+// shellQuote quotes a value for use as a single word in a shell script.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// runnerVerifyScript returns the pre install script that verifies the runner archive.
+func (r RunnerSpec) runnerVerifyScript() []byte {
+	callbackURL := strings.TrimSuffix(r.BootstrapParams.CallbackURL, "/")
+	if !strings.HasSuffix(callbackURL, "/status") {
+		callbackURL += "/status"
+	}
+	return []byte(fmt.Sprintf(
+		linuxVerifyRunnerScript,
+		r.callbackToken(),
+		shellQuote(callbackURL),
+		shellQuote(r.Tools.GetTempDownloadToken()),
+		shellQuote(r.Tools.GetDownloadURL()),
+		r.RunnerSHA256))
+}
+
+// validateIntegrity makes sure runner checksum verification can be done for this runner,
+// and that all user provided pre install scripts match the configured checksums.
+func (r RunnerSpec) validateIntegrity() error {
+	if r.RunnerSHA256 != "" {
+		if !config.IsSHA256Checksum(r.RunnerSHA256) {
+			return fmt.Errorf("invalid runner checksum %q", r.RunnerSHA256)
+		}
+		if err := r.requireLinuxCloudInit("runner_sha256"); err != nil {
+			return err
+		}
+	}
+
+	if len(r.ScriptChecksums) == 0 {
+		return nil
+	}
+
+	cloudConfigSpecs, err := cloudconfig.GetSpecs(r.BootstrapParams)
+	if err != nil {
+		return fmt.Errorf("failed to get pre install scripts: %w", err)
+	}
+	for name, script := range cloudConfigSpecs.PreInstallScripts {
+		expected, ok := r.ScriptChecksums[name]
+		if !ok {
+			return fmt.Errorf("pre install script %s has no configured checksum", name)
+		}
+		checksum := sha256.Sum256(script)
+		if !strings.EqualFold(hex.EncodeToString(checksum[:]), expected) {
+			return fmt.Errorf("checksum mismatch for pre install script %s", name)
+		}
+	}
+	return nil
+}
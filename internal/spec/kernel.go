@@ -0,0 +1,114 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	sysctlKeyRegex        = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_*/-]+)+$`)
+	sysctlValueRegex      = regexp.MustCompile(`^[a-zA-Z0-9 \t_.,:/+-]+$`)
+	kernelCmdlineArgRegex = regexp.MustCompile(`^[a-zA-Z0-9_.,:=/+-]+$`)
+)
+
+const (
+	linuxKernelTuningScriptName = "00-garm-kernel-tuning.sh"
+	linuxSysctlPath             = "/etc/sysctl.d/90-garm.conf"
+	// linuxSysctlTemplate applies the sysctl settings right away, and on every boot.
+	linuxSysctlTemplate = `cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+sysctl -p %[1]s || { echo "failed to apply sysctl settings"; exit 1; }
+`
+	// linuxKernelCmdlineTemplate adds arguments to the kernel command line of all the
+	// installed kernels. They take effect on the next boot.
+	linuxKernelCmdlineTemplate = `ARGS=%[1]s
+if command -v grubby >/dev/null 2>&1; then
+	grubby --update-kernel=ALL --args="$ARGS" || { echo "failed to update the kernel command line"; exit 1; }
+elif command -v update-grub >/dev/null 2>&1; then
+	mkdir -p /etc/default/grub.d
+	echo "GRUB_CMDLINE_LINUX_DEFAULT=\"\$GRUB_CMDLINE_LINUX_DEFAULT $ARGS\"" > /etc/default/grub.d/99-garm.cfg
+	update-grub || { echo "failed to update the kernel command line"; exit 1; }
+else
+	echo "neither grubby nor update-grub were found, can't update the kernel command line"
+	exit 1
+fi
+`
+	// linuxKernelRebootScript reboots once the runner is installed, so the kernel command
+	// line takes effect before the runner picks up jobs. The runner service is only
+	// started by the reboot (see startRunnerOnReboot).
+	linuxKernelRebootScript = `nohup sh -c 'while [ -e /install_runner.sh ]; do sleep 5; done; systemctl reboot' >/dev/null 2>&1 &
+`
+)
+
+// KernelTuning holds sysctl settings and kernel command line arguments for the runner.
+type KernelTuning struct {
+	// Sysctl are sysctl settings, applied before the runner is installed.
+	Sysctl map[string]string `json:"sysctl"`
+	// KernelCmdline are arguments added to the kernel command line, like
+	// systemd.unified_cgroup_hierarchy=1 or hugepages=512. They take effect on the next
+	// boot.
+	KernelCmdline []string `json:"kernel_cmdline"`
+	// Reboot reboots the runner once it is installed, so the kernel command line takes
+	// effect.
+	Reboot bool `json:"reboot"`
+}
+
+func (k KernelTuning) Validate() error {
+	for key, value := range k.Sysctl {
+		if !sysctlKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid sysctl key %q", key)
+		}
+		if !sysctlValueRegex.MatchString(value) {
+			return fmt.Errorf("invalid value %q for sysctl %s", value, key)
+		}
+	}
+	for _, arg := range k.KernelCmdline {
+		if !kernelCmdlineArgRegex.MatchString(arg) {
+			return fmt.Errorf("invalid kernel_cmdline argument %q", arg)
+		}
+	}
+	if k.Reboot && len(k.KernelCmdline) == 0 {
+		return fmt.Errorf("reboot is only needed with kernel_cmdline")
+	}
+	return nil
+}
+
+// kernelTuningScript returns the pre install script that applies the kernel tuning.
+func (k KernelTuning) kernelTuningScript() []byte {
+	script := "#!/bin/sh\n"
+	if len(k.Sysctl) > 0 {
+		keys := make([]string, 0, len(k.Sysctl))
+		for key := range k.Sysctl {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var settings strings.Builder
+		for _, key := range keys {
+			fmt.Fprintf(&settings, "%s = %s\n", key, k.Sysctl[key])
+		}
+		script += fmt.Sprintf(linuxSysctlTemplate, linuxSysctlPath, settings.String())
+	}
+	if len(k.KernelCmdline) > 0 {
+		script += fmt.Sprintf(linuxKernelCmdlineTemplate, shellQuote(strings.Join(k.KernelCmdline, " ")))
+	}
+	if k.Reboot {
+		script += linuxKernelRebootScript
+	}
+	return []byte(script)
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// defaultCertificateStore is the Windows certificate store certificates are installed in.
+const defaultCertificateStore = "My"
+
+var keyVaultIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.KeyVault/vaults/[^/]+$`)
+
+// KeyVaultCertificates are certificates installed on the runner from a Key Vault, through
+// the secrets of the VM OS profile. The vault must be enabled for deployment, and be in
+// the same subscription and location as the runners.
+type KeyVaultCertificates struct {
+	// VaultID is the resource ID of the Key Vault.
+	VaultID string `json:"vault_id"`
+	// CertificateURLs are the versioned URLs of the certificates, as Key Vault secrets, like
+	// https://myvault.vault.azure.net/secrets/mycert/<version>.
+	CertificateURLs []string `json:"certificate_urls"`
+	// CertificateStore is the Windows certificate store the certificates are installed in.
+	// Defaults to My. On Linux, certificates are placed in /var/lib/waagent.
+	CertificateStore string `json:"certificate_store"`
+}
+
+func (k KeyVaultCertificates) Validate(osType params.OSType) error {
+	if !keyVaultIDRegex.MatchString(k.VaultID) {
+		return fmt.Errorf("invalid vault_id %q (expected the resource ID of a Key Vault)", k.VaultID)
+	}
+	if len(k.CertificateURLs) == 0 {
+		return fmt.Errorf("missing certificate_urls for %s", k.VaultID)
+	}
+	for _, certURL := range k.CertificateURLs {
+		parsed, err := url.Parse(certURL)
+		if err != nil || parsed.Scheme != "https" || !strings.HasPrefix(parsed.Path, "/secrets/") {
+			return fmt.Errorf("invalid certificate URL %q (expected https://<vault>.vault.azure.net/secrets/<name>/<version>)", certURL)
+		}
+	}
+	if k.CertificateStore != "" && osType != params.Windows {
+		return fmt.Errorf("certificate_store is only supported on windows")
+	}
+	return nil
+}
+
+// setKeyVaultSecrets adds the Key Vault certificates to the OS profile of the VM.
+func (r RunnerSpec) setKeyVaultSecrets(properties *armcompute.VirtualMachineProperties) {
+	for _, vault := range r.KeyVaultCertificates {
+		secret := &armcompute.VaultSecretGroup{
+			SourceVault: &armcompute.SubResource{
+				ID: to.Ptr(vault.VaultID),
+			},
+		}
+		for _, certURL := range vault.CertificateURLs {
+			cert := &armcompute.VaultCertificate{
+				CertificateURL: to.Ptr(certURL),
+			}
+			if r.BootstrapParams.OSType == params.Windows {
+				store := vault.CertificateStore
+				if store == "" {
+					store = defaultCertificateStore
+				}
+				cert.CertificateStore = to.Ptr(store)
+			}
+			secret.VaultCertificates = append(secret.VaultCertificates, cert)
+		}
+		properties.OSProfile.Secrets = append(properties.OSProfile.Secrets, secret)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+const linuxLocaleScriptName = "00-garm-set-locale.sh"
+
+var (
+	// Linux time zones are IANA names, like Europe/Berlin. Windows time zones are Windows
+	// IDs, like W. Europe Standard Time.
+	linuxTimeZoneRegex   = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+	windowsTimeZoneRegex = regexp.MustCompile(`^[A-Za-z0-9 .()+-]+$`)
+	localeRegex          = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// validateLocale checks the time zone and locale settings of the runner.
+func (r RunnerSpec) validateLocale() error {
+	if r.TimeZone != "" {
+		timeZoneRegex := linuxTimeZoneRegex
+		if r.BootstrapParams.OSType == params.Windows {
+			timeZoneRegex = windowsTimeZoneRegex
+		} else if err := r.requireLinuxCloudInit("time_zone"); err != nil {
+			return err
+		}
+		if !timeZoneRegex.MatchString(r.TimeZone) {
+			return fmt.Errorf("invalid time_zone %q", r.TimeZone)
+		}
+	}
+	if r.Locale != "" {
+		if err := r.requireLinuxCloudInit("locale"); err != nil {
+			return err
+		}
+		if !localeRegex.MatchString(r.Locale) {
+			return fmt.Errorf("invalid locale %q", r.Locale)
+		}
+	}
+	return nil
+}
+
+// localeScript returns the pre install script that sets the time zone and locale of a
+// Linux runner. Locales that are not available are generated first, where the image
+// supports it. systemd is reloaded, so the runner service starts with the new locale.
+func (r RunnerSpec) localeScript() []byte {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	if r.TimeZone != "" {
+		fmt.Fprintf(&script, "timedatectl set-timezone '%s' || echo \"failed to set time zone\"\n", r.TimeZone)
+	}
+	if r.Locale != "" {
+		fmt.Fprintf(&script, "if command -v locale-gen > /dev/null; then locale-gen '%s'; fi\n", r.Locale)
+		fmt.Fprintf(&script, "localectl set-locale 'LANG=%s' || echo \"failed to set locale\"\n", r.Locale)
+		script.WriteString("systemctl daemon-reload\n")
+	}
+	return []byte(script.String())
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import "fmt"
+
+const (
+	maxSwapSizeGB = 1024
+
+	swapScriptPath = "/opt/garm/swap.sh"
+	swapUnitName   = "garm-swap.service"
+	// swapScript creates a swapfile on the temp disk, or on the OS disk for VM sizes
+	// without one. The temp disk may be wiped when the VM is redeployed, so this runs on
+	// every boot.
+	swapScript = `#!/bin/sh
+SIZE_GB=%d
+DIR=/
+if mountpoint -q /mnt && [ -e /dev/disk/azure/resource-part1 ]; then
+	DIR=/mnt
+fi
+SWAPFILE="${DIR%%/}/swapfile"
+if swapon --show=NAME --noheadings | grep -qx "$SWAPFILE"; then
+	exit 0
+fi
+if [ ! -f "$SWAPFILE" ]; then
+	AVAIL_GB=$(df --output=avail -BG "$DIR" | tail -n1 | tr -dc 0-9)
+	if [ "$AVAIL_GB" -le "$SIZE_GB" ]; then
+		echo "not enough space for a ${SIZE_GB}G swapfile on $DIR (${AVAIL_GB}G available)"
+		exit 1
+	fi
+	fallocate -l "${SIZE_GB}G" "$SWAPFILE" || dd if=/dev/zero of="$SWAPFILE" bs=1M count=$((SIZE_GB * 1024)) || exit 1
+	chmod 600 "$SWAPFILE"
+	mkswap "$SWAPFILE" || exit 1
+fi
+swapon "$SWAPFILE"
+`
+	swapUnit = `[Unit]
+Description=Create and enable the runner swapfile
+After=local-fs.target
+Before=docker.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh %s
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	linuxMemoryScriptName = "00-garm-memory.sh"
+	// linuxSwapInstallTemplate installs the swap unit and runs it.
+	linuxSwapInstallTemplate = `mkdir -p /opt/garm
+cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+cat > /etc/systemd/system/%[3]s << 'GARM_EOF'
+%[4]sGARM_EOF
+systemctl daemon-reload
+systemctl enable --now %[3]s || { echo "failed to enable swap"; exit 1; }
+`
+	linuxHugepagesPath = "/etc/sysctl.d/91-garm-hugepages.conf"
+	// linuxHugepagesTemplate reserves huge pages right away, and on every boot. The kernel
+	// may not find enough contiguous memory for all of them, so the result is checked.
+	linuxHugepagesTemplate = `echo "vm.nr_hugepages = %[2]d" > %[1]s
+sysctl -p %[1]s || { echo "failed to reserve huge pages"; exit 1; }
+RESERVED=$(cat /proc/sys/vm/nr_hugepages)
+if [ "$RESERVED" -lt %[2]d ]; then
+	echo "only $RESERVED of %[2]d huge pages could be reserved"
+fi
+`
+)
+
+// memoryScript returns the pre install script that sets up swap and huge pages.
+func (r RunnerSpec) memoryScript() []byte {
+	script := "#!/bin/sh\n"
+	if r.SwapSizeGB > 0 {
+		script += fmt.Sprintf(
+			linuxSwapInstallTemplate,
+			swapScriptPath,
+			fmt.Sprintf(swapScript, r.SwapSizeGB),
+			swapUnitName,
+			fmt.Sprintf(swapUnit, swapScriptPath))
+	}
+	if r.Hugepages > 0 {
+		script += fmt.Sprintf(linuxHugepagesTemplate, linuxHugepagesPath, r.Hugepages)
+	}
+	return []byte(script)
+}
+
+func (r RunnerSpec) validateMemory() error {
+	if r.SwapSizeGB == 0 && r.Hugepages == 0 {
+		return nil
+	}
+	if r.SwapSizeGB > 0 {
+		if err := r.requireLinuxCloudInit("swap_size_gb"); err != nil {
+			return err
+		}
+	}
+	if r.Hugepages > 0 {
+		if err := r.requireLinuxCloudInit("hugepages"); err != nil {
+			return err
+		}
+	}
+	if r.SwapSizeGB > maxSwapSizeGB {
+		return fmt.Errorf("swap_size_gb can't be more than %d", maxSwapSizeGB)
+	}
+	// The read-only root overlay takes over the temp disk.
+	if r.SwapSizeGB > 0 && r.ReadOnlyRoot {
+		return fmt.Errorf("swap_size_gb can't be used with read_only_root")
+	}
+	if r.Hugepages > 0 && r.KernelTuning != nil {
+		if _, ok := r.KernelTuning.Sysctl["vm.nr_hugepages"]; ok {
+			return fmt.Errorf("hugepages can't be used with the vm.nr_hugepages sysctl")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+)
+
+const (
+	// defaultNFSMountOptions follow the Azure NetApp Files recommendations for NFSv3.
+	defaultNFSMountOptions = "rw,hard,rsize=262144,wsize=262144,vers=3,tcp,_netdev"
+
+	linuxNFSScriptName = "00-garm-mount-nfs.sh"
+	linuxNFSScriptHead = `#!/bin/sh
+if ! command -v mount.nfs >/dev/null 2>&1; then
+	if command -v apt-get >/dev/null 2>&1; then
+		DEBIAN_FRONTEND=noninteractive apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y nfs-common
+	elif command -v dnf >/dev/null 2>&1; then
+		dnf install -y nfs-utils
+	elif command -v yum >/dev/null 2>&1; then
+		yum install -y nfs-utils
+	elif command -v zypper >/dev/null 2>&1; then
+		zypper -n install nfs-client
+	fi
+fi
+`
+	// linuxNFSMountTemplate adds the fstab entry and mounts the volume.
+	linuxNFSMountTemplate = `mkdir -p "%[2]s"
+grep -qs " %[2]s " /etc/fstab || echo "%[1]s %[2]s nfs %[3]s 0 0" >> /etc/fstab
+mount "%[2]s" || echo "failed to mount %[1]s on %[2]s"
+`
+
+	ignitionNFSMountUnitTemplate = `[Unit]
+Description=Mount %[1]s
+Wants=network-online.target
+After=network-online.target
+
+[Mount]
+What=%[1]s
+Where=%[2]s
+Type=nfs
+Options=%[3]s
+
+[Install]
+WantedBy=remote-fs.target
+`
+)
+
+// NFSMount is an NFS volume, like an Azure NetApp Files volume, mounted on the runner.
+type NFSMount struct {
+	// Source is the NFS export, in the host:/path format.
+	Source string `json:"source"`
+	// Path is the absolute path the volume is mounted on.
+	Path string `json:"path"`
+	// Options are the mount options. Defaults to the options recommended for Azure NetApp
+	// Files NFSv3 volumes.
+	Options string `json:"options"`
+}
+
+func (n NFSMount) GetOptions() string {
+	if n.Options == "" {
+		return defaultNFSMountOptions
+	}
+	return n.Options
+}
+
+// isUnsafeMountChar returns true for characters that can't be used in fstab entries or
+// mount units, or that would need escaping in the mount script.
+func isUnsafeMountChar(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("\"'\\$`", r)
+}
+
+func (n NFSMount) Validate() error {
+	for name, val := range map[string]string{"source": n.Source, "path": n.Path, "options": n.Options} {
+		if strings.IndexFunc(val, isUnsafeMountChar) >= 0 {
+			return fmt.Errorf("invalid %s %q: whitespace, quotes and shell special characters are not allowed", name, val)
+		}
+	}
+	host, export, found := strings.Cut(n.Source, ":")
+	if !found || host == "" || !strings.HasPrefix(export, "/") {
+		return fmt.Errorf("invalid source %q (expected host:/path)", n.Source)
+	}
+	if !path.IsAbs(n.Path) || path.Clean(n.Path) == "/" {
+		return fmt.Errorf("invalid path %q (must be an absolute path, other than /)", n.Path)
+	}
+	return nil
+}
+
+// nfsMountScript returns the pre install script that mounts the NFS volumes.
+func (r RunnerSpec) nfsMountScript() []byte {
+	script := linuxNFSScriptHead
+	for _, mount := range r.NFSMounts {
+		script += fmt.Sprintf(linuxNFSMountTemplate, mount.Source, path.Clean(mount.Path), mount.GetOptions())
+	}
+	return []byte(script)
+}
+
+// systemdMountUnitName returns the name of the mount unit for a path, escaped like
+// systemd-escape --path does.
+func systemdMountUnitName(mountPath string) string {
+	trimmed := strings.Trim(path.Clean(mountPath), "/")
+	var escaped strings.Builder
+	for i := 0; i < len(trimmed); i++ {
+		c := trimmed[i]
+		switch {
+		case c == '/':
+			escaped.WriteByte('-')
+		case c == '.' && i == 0:
+			fmt.Fprintf(&escaped, `\x%02x`, c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.':
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, `\x%02x`, c)
+		}
+	}
+	return escaped.String() + ".mount"
+}
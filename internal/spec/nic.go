@@ -0,0 +1,48 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+var networkInterfaceIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Network/networkInterfaces/[^/]+$`)
+
+// validateNetworkInterfaces checks the pre-created NICs of the pool. The VM is attached
+// to one of them as is, so settings that need the provider to create the network
+// resources can't be used with them.
+func (r RunnerSpec) validateNetworkInterfaces() error {
+	if len(r.NetworkInterfaceIDs) == 0 {
+		return nil
+	}
+	for _, id := range r.NetworkInterfaceIDs {
+		if !networkInterfaceIDRegex.MatchString(id) {
+			return fmt.Errorf("invalid network interface ID %q", id)
+		}
+	}
+	if r.AllocatePublicIP {
+		return fmt.Errorf("allocate_public_ip can't be used with network_interface_ids")
+	}
+	if len(r.SecurityRules()) > 0 {
+		return fmt.Errorf("open_inbound_ports, egress_profile and bastion need a network security group, and can't be used with network_interface_ids")
+	}
+	if r.NICAuxiliaryMode != "" && r.NICAuxiliaryMode != armnetwork.NetworkInterfaceAuxiliaryModeNone {
+		return fmt.Errorf("nic_auxiliary_mode can't be used with network_interface_ids")
+	}
+	return nil
+}
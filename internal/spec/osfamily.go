@@ -0,0 +1,140 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+)
+
+// OSFamily is the family of the Linux distribution of an image. It decides which package
+// manager the bootstrap scripts use, and how the runner directory is labeled for SELinux.
+type OSFamily string
+
+const (
+	OSFamilyDebian OSFamily = "debian"
+	OSFamilyRHEL   OSFamily = "rhel"
+	OSFamilySUSE   OSFamily = "suse"
+
+	// The OS family scripts sort before the other pre install scripts, which may need the
+	// packages they install.
+	linuxRHELScriptName = "00-garm-0-rhel.sh"
+	// linuxRHELScript installs the tools the runner install script needs. cloud-init installs
+	// them in a single transaction, which fails on images with curl-minimal, as it conflicts
+	// with curl.
+	linuxRHELScript = `#!/bin/sh
+PM=dnf
+command -v dnf >/dev/null 2>&1 || PM=yum
+command -v curl >/dev/null 2>&1 || $PM install -y curl || { echo "failed to install curl"; exit 1; }
+command -v tar >/dev/null 2>&1 || $PM install -y tar || { echo "failed to install tar"; exit 1; }
+`
+
+	linuxSUSEScriptName = "00-garm-0-suse.sh"
+	// linuxSUSEScript registers BYOS images with SUSEConnect, if they are not registered
+	// yet, and installs the tools the runner install script needs.
+	linuxSUSEScript = `#!/bin/sh
+%s
+for tool in curl tar; do
+	command -v "$tool" >/dev/null 2>&1 || zypper -n install "$tool" || { echo "failed to install $tool"; exit 1; }
+done
+`
+	linuxSUSERegisterTemplate = `if ! SUSEConnect --status 2>/dev/null | grep -q '"status":"Registered"'; then
+	SUSEConnect %s || { echo "failed to register with SUSEConnect"; exit 1; }
+fi`
+
+	// defaultSELinuxLabels are the commands of the default runner install template that
+	// label the runner directory. They use the runner user as the SELinux user, which
+	// SELinux rejects, so the install fails on RHEL-family images.
+	defaultSELinuxLabels = `	sudo chcon -h user_u:object_r:bin_t /home/runner/ || fail "failed to change selinux context"
+	sudo chcon -R -h {{ .RunnerUsername }}:object_r:bin_t /home/runner/* || fail "failed to change selinux context"`
+	// rhelSELinuxLabels only change the type of the runner directory, so systemd is allowed
+	// to run the runner service from it.
+	rhelSELinuxLabels = `	sudo chcon -R -t bin_t /home/{{ .RunnerUsername }}/actions-runner || fail "failed to change selinux context"`
+)
+
+// suseFamilyPublishers are the marketplace publishers of SUSE images.
+var suseFamilyPublishers = []string{
+	"SUSE",
+}
+
+// rhelFamilyPublishers are the marketplace publishers of RHEL-family images.
+var rhelFamilyPublishers = []string{
+	"RedHat",
+	"almalinux",
+	"resf",
+	"erockyenterprisesoftwarefoundationinc1653071250513",
+	"OpenLogic",
+	"Oracle",
+}
+
+func (f OSFamily) Validate() error {
+	switch f {
+	case OSFamilyDebian, OSFamilyRHEL, OSFamilySUSE:
+		return nil
+	}
+	return fmt.Errorf("invalid os_family %q (expected %s, %s or %s)", f, OSFamilyDebian, OSFamilyRHEL, OSFamilySUSE)
+}
+
+// detectOSFamily returns the OS family of a marketplace image, based on its publisher.
+// Gallery and managed images have no publisher, and are assumed to be Debian-based.
+func detectOSFamily(publisher string) OSFamily {
+	for _, val := range rhelFamilyPublishers {
+		if strings.EqualFold(val, publisher) {
+			return OSFamilyRHEL
+		}
+	}
+	for _, val := range suseFamilyPublishers {
+		if strings.EqualFold(val, publisher) {
+			return OSFamilySUSE
+		}
+	}
+	return OSFamilyDebian
+}
+
+// isBYOSOffer returns true if a marketplace offer is a bring your own subscription image,
+// which needs to be registered before it can install packages.
+func isBYOSOffer(offer string) bool {
+	return strings.Contains(strings.ToLower(offer), "byos")
+}
+
+// suseScript returns the pre install script of SUSE images, registering them with the
+// supplied settings, if any.
+func suseScript(registration *config.SUSERegistration) []byte {
+	var register string
+	if registration != nil {
+		var args []string
+		if registration.RegistrationCode != "" {
+			args = append(args, "--regcode", shellQuote(registration.RegistrationCode))
+		}
+		if registration.Email != "" {
+			args = append(args, "--email", shellQuote(registration.Email))
+		}
+		if registration.URL != "" {
+			args = append(args, "--url", shellQuote(registration.URL))
+		}
+		register = fmt.Sprintf(linuxSUSERegisterTemplate, strings.Join(args, " "))
+	}
+	return []byte(fmt.Sprintf(linuxSUSEScript, register))
+}
+
+// rhelInstallTemplate returns the default runner install template, with the SELinux
+// labels fixed for RHEL-family images. SUSE images with SELinux enabled use it as well.
+func rhelInstallTemplate() string {
+	return strings.Replace(cloudconfig.CloudConfigTemplate, defaultSELinuxLabels, rhelSELinuxLabels, 1)
+}
@@ -0,0 +1,30 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import "strings"
+
+// linuxRebootIfRequired makes cloud-init reboot after upgrading packages, if the upgrade
+// requires it. cloud-init runs the remaining modules, including the runner install, after
+// the reboot.
+const linuxRebootIfRequired = "package_reboot_if_required: true\n"
+
+// withRebootIfRequired adds a reboot after package upgrades to a cloud-init config.
+func withRebootIfRequired(cloudConfig string) string {
+	if !strings.HasSuffix(cloudConfig, "\n") {
+		cloudConfig += "\n"
+	}
+	return cloudConfig + linuxRebootIfRequired
+}
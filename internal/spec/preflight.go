@@ -0,0 +1,157 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// CallbackReachabilityWarnings inspects the garm callback and metadata URLs, along with
+// the network settings of the runner, and returns a list of reasons why the runner may
+// not be able to reach them. These are heuristics, based only on the configuration. Host
+// names are not resolved, as the provider may not see the same DNS records as the runner.
+func (r RunnerSpec) CallbackReachabilityWarnings() []string {
+	var warnings []string
+	urls := map[string]string{
+		"callback URL": r.BootstrapParams.CallbackURL,
+		"metadata URL": r.BootstrapParams.MetadataURL,
+	}
+	for _, kind := range []string{"callback URL", "metadata URL"} {
+		warnings = append(warnings, r.urlReachabilityWarnings(kind, urls[kind])...)
+	}
+	return warnings
+}
+
+func (r RunnerSpec) urlReachabilityWarnings(kind, rawURL string) []string {
+	if rawURL == "" {
+		return []string{fmt.Sprintf("%s is not set", kind)}
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return []string{fmt.Sprintf("%s %q is not a valid URL", kind, rawURL)}
+	}
+
+	var warnings []string
+	host := parsed.Hostname()
+	if strings.EqualFold(host, "localhost") {
+		warnings = append(warnings, fmt.Sprintf("%s %q points to localhost, which is not reachable from runners", kind, rawURL))
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		switch {
+		case ip.IsLoopback() || ip.IsUnspecified():
+			warnings = append(warnings, fmt.Sprintf("%s %q points to a loopback address, which is not reachable from runners", kind, rawURL))
+		case ip.IsLinkLocalUnicast():
+			warnings = append(warnings, fmt.Sprintf("%s %q points to a link local address, which is not reachable from runners", kind, rawURL))
+		// Runners attached to an existing subnet are usually routed to private addresses
+		// by the existing network.
+		case ip.IsPrivate() && r.SubnetID == "":
+			if _, vnet, err := net.ParseCIDR(r.VirtualNetworkCIDR); err == nil && vnet.Contains(ip) {
+				warnings = append(warnings, fmt.Sprintf("%s %q is inside the runner virtual network %s, and will be routed inside that network", kind, rawURL, r.VirtualNetworkCIDR))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("%s %q points to a private address, which is only reachable from runners if the runner network is peered or connected to it", kind, rawURL))
+			}
+		}
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		port = "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if portNum, err := strconv.Atoi(port); err == nil {
+		rules := r.SecurityRules()
+		for _, rule := range rules {
+			if blocksOutbound(rule, portNum) && !allowedBefore(rules, rule, portNum) {
+				warnings = append(warnings, fmt.Sprintf("security rule %s denies outbound traffic on port %d, needed for %s %q", *rule.Name, portNum, kind, rawURL))
+			}
+		}
+	}
+	return warnings
+}
+
+// blocksOutbound returns true if the rule is an outbound deny rule matching the TCP port.
+func blocksOutbound(rule *armnetwork.SecurityRule, port int) bool {
+	return matchesOutbound(rule, armnetwork.SecurityRuleAccessDeny, port)
+}
+
+// allowedBefore returns true if an outbound allow rule matching the TCP port takes
+// precedence over the deny rule. The destination of the allow rule is not checked.
+func allowedBefore(rules []*armnetwork.SecurityRule, deny *armnetwork.SecurityRule, port int) bool {
+	if deny.Properties.Priority == nil {
+		return false
+	}
+	for _, rule := range rules {
+		if !matchesOutbound(rule, armnetwork.SecurityRuleAccessAllow, port) || rule.Properties.Priority == nil {
+			continue
+		}
+		if *rule.Properties.Priority < *deny.Properties.Priority {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesOutbound returns true if the rule is an outbound rule with the given access,
+// matching the TCP port.
+func matchesOutbound(rule *armnetwork.SecurityRule, access armnetwork.SecurityRuleAccess, port int) bool {
+	if rule == nil || rule.Properties == nil {
+		return false
+	}
+	props := rule.Properties
+	if props.Direction == nil || *props.Direction != armnetwork.SecurityRuleDirectionOutbound {
+		return false
+	}
+	if props.Access == nil || *props.Access != access {
+		return false
+	}
+	if props.Protocol != nil && *props.Protocol != armnetwork.SecurityRuleProtocolAsterisk && *props.Protocol != armnetwork.SecurityRuleProtocolTCP {
+		return false
+	}
+
+	ranges := []string{}
+	if props.DestinationPortRange != nil {
+		ranges = append(ranges, *props.DestinationPortRange)
+	}
+	for _, portRange := range props.DestinationPortRanges {
+		if portRange != nil {
+			ranges = append(ranges, *portRange)
+		}
+	}
+	for _, portRange := range ranges {
+		if portRange == "*" {
+			return true
+		}
+		low, high, found := strings.Cut(portRange, "-")
+		if !found {
+			high = low
+		}
+		lowNum, err1 := strconv.Atoi(low)
+		highNum, err2 := strconv.Atoi(high)
+		if err1 == nil && err2 == nil && port >= lowNum && port <= highNum {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// testSecurityRule returns a security rule for the tests. Without port ranges, the
+// destination port range is left unset.
+func testSecurityRule(direction armnetwork.SecurityRuleDirection, access armnetwork.SecurityRuleAccess, protocol armnetwork.SecurityRuleProtocol, priority int32, portRanges ...string) *armnetwork.SecurityRule {
+	props := &armnetwork.SecurityRulePropertiesFormat{
+		Direction: to.Ptr(direction),
+		Access:    to.Ptr(access),
+		Protocol:  to.Ptr(protocol),
+		Priority:  to.Ptr(priority),
+	}
+	switch len(portRanges) {
+	case 0:
+	case 1:
+		props.DestinationPortRange = to.Ptr(portRanges[0])
+	default:
+		props.DestinationPortRanges = to.SliceOfPtrs(portRanges...)
+	}
+	return &armnetwork.SecurityRule{
+		Name:       to.Ptr("test"),
+		Properties: props,
+	}
+}
+
+func TestBlocksOutbound(t *testing.T) {
+	outbound := armnetwork.SecurityRuleDirectionOutbound
+	inbound := armnetwork.SecurityRuleDirectionInbound
+	deny := armnetwork.SecurityRuleAccessDeny
+	allow := armnetwork.SecurityRuleAccessAllow
+	tcp := armnetwork.SecurityRuleProtocolTCP
+	udp := armnetwork.SecurityRuleProtocolUDP
+	anyProtocol := armnetwork.SecurityRuleProtocolAsterisk
+
+	tests := []struct {
+		name string
+		rule *armnetwork.SecurityRule
+		port int
+		want bool
+	}{
+		{name: "nil rule", rule: nil, port: 443},
+		{name: "rule without properties", rule: &armnetwork.SecurityRule{}, port: 443},
+		{name: "deny single port", rule: testSecurityRule(outbound, deny, tcp, 100, "443"), port: 443, want: true},
+		{name: "deny other port", rule: testSecurityRule(outbound, deny, tcp, 100, "80"), port: 443},
+		{name: "deny all ports", rule: testSecurityRule(outbound, deny, tcp, 100, "*"), port: 443, want: true},
+		{name: "deny any protocol", rule: testSecurityRule(outbound, deny, anyProtocol, 100, "443"), port: 443, want: true},
+		{name: "deny udp", rule: testSecurityRule(outbound, deny, udp, 100, "443"), port: 443},
+		{name: "deny port range", rule: testSecurityRule(outbound, deny, tcp, 100, "400-500"), port: 443, want: true},
+		{name: "deny range start", rule: testSecurityRule(outbound, deny, tcp, 100, "443-500"), port: 443, want: true},
+		{name: "deny range end", rule: testSecurityRule(outbound, deny, tcp, 100, "400-443"), port: 443, want: true},
+		{name: "deny range below", rule: testSecurityRule(outbound, deny, tcp, 100, "1-442"), port: 443},
+		{name: "deny port list", rule: testSecurityRule(outbound, deny, tcp, 100, "22", "443"), port: 443, want: true},
+		{name: "deny port list without the port", rule: testSecurityRule(outbound, deny, tcp, 100, "22", "80"), port: 443},
+		{name: "deny invalid range", rule: testSecurityRule(outbound, deny, tcp, 100, "a-b"), port: 443},
+		{name: "deny without ports", rule: testSecurityRule(outbound, deny, tcp, 100), port: 443},
+		{name: "inbound deny", rule: testSecurityRule(inbound, deny, tcp, 100, "443"), port: 443},
+		{name: "outbound allow", rule: testSecurityRule(outbound, allow, tcp, 100, "443"), port: 443},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blocksOutbound(tt.rule, tt.port); got != tt.want {
+				t.Fatalf("blocksOutbound() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedBefore(t *testing.T) {
+	outbound := armnetwork.SecurityRuleDirectionOutbound
+	deny := armnetwork.SecurityRuleAccessDeny
+	allow := armnetwork.SecurityRuleAccessAllow
+	tcp := armnetwork.SecurityRuleProtocolTCP
+
+	denyRule := testSecurityRule(outbound, deny, tcp, 200, "*")
+	tests := []struct {
+		name  string
+		rules []*armnetwork.SecurityRule
+		want  bool
+	}{
+		{name: "no allow rule", rules: []*armnetwork.SecurityRule{denyRule}},
+		{name: "allow with higher priority", rules: []*armnetwork.SecurityRule{denyRule, testSecurityRule(outbound, allow, tcp, 100, "443")}, want: true},
+		{name: "allow with lower priority", rules: []*armnetwork.SecurityRule{denyRule, testSecurityRule(outbound, allow, tcp, 300, "443")}},
+		{name: "allow of another port", rules: []*armnetwork.SecurityRule{denyRule, testSecurityRule(outbound, allow, tcp, 100, "80")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowedBefore(tt.rules, denyRule, 443); got != tt.want {
+				t.Fatalf("allowedBefore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import "strings"
+
+const (
+	linuxReadOnlyRootScriptName = "00-garm-read-only-root.sh"
+	// linuxReadOnlyRootScript uses the Ubuntu overlayroot package to mount the root
+	// filesystem read-only, with a writable overlay on the temp disk (or in memory, if
+	// the VM size has no temp disk). overlayroot takes effect on the next boot, so the
+	// runner is installed first, and the VM is rebooted once the install script is gone.
+	// The runner service is only started by that reboot (see startRunnerOnReboot), so no
+	// job runs before the root is read-only.
+	linuxReadOnlyRootScript = `#!/bin/sh
+if ! command -v apt-get >/dev/null 2>&1; then
+	echo "a read-only root is only supported on Ubuntu images"
+	exit 1
+fi
+DEBIAN_FRONTEND=noninteractive apt-get install -y overlayroot || { echo "failed to install overlayroot"; exit 1; }
+
+TEMP_DISK=/dev/disk/azure/resource-part1
+if [ -e "$TEMP_DISK" ]; then
+	# The temp disk now holds the overlay, so it must no longer be mounted on /mnt.
+	umount /mnt 2>/dev/null
+	sed -i '\|[[:space:]]/mnt[[:space:]]|d' /etc/fstab
+	echo "overlayroot=\"device:dev=$TEMP_DISK,mkfs=1,recurse=0\"" > /etc/overlayroot.local.conf
+else
+	echo 'overlayroot="tmpfs:recurse=0"' > /etc/overlayroot.local.conf
+fi
+
+nohup sh -c 'while [ -e /install_runner.sh ]; do sleep 5; done; systemctl reboot' >/dev/null 2>&1 &
+`
+
+	// defaultRunnerJITStart and defaultRunnerSvcStart start the runner service in the
+	// runner install templates, after it was enabled.
+	defaultRunnerJITStart = `sudo systemctl start $SVC_NAME || fail "failed to start service"
+`
+	defaultRunnerSvcStart = `sendStatus "starting service"
+sudo ./svc.sh start || fail "failed to start service"
+`
+)
+
+// startRunnerOnReboot returns the runner install template without the start of the runner
+// service. The service is enabled, so it is started on the next boot, and the runner only
+// accepts jobs once the VM rebooted.
+func startRunnerOnReboot(template string) string {
+	template = strings.Replace(template, defaultRunnerJITStart, "", 1)
+	return strings.Replace(template, defaultRunnerSvcStart, "", 1)
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+
+	"github.com/cloudbase/garm-provider-common/defaults"
+)
+
+// RootlessEngine is the container engine set up to run rootless, as the runner user.
+type RootlessEngine string
+
+const (
+	RootlessEngineDocker RootlessEngine = "docker"
+	RootlessEnginePodman RootlessEngine = "podman"
+
+	linuxRootlessScriptName = "00-garm-rootless.sh"
+	// linuxRootlessCommon enables lingering for the runner user, so its systemd user
+	// instance, which runs the rootless engine, is started at boot.
+	linuxRootlessCommon = `#!/bin/sh
+RUNNER_USER=%[1]s
+RUNNER_UID=$(id -u "$RUNNER_USER") || { echo "runner user $RUNNER_USER does not exist"; exit 1; }
+if ! command -v apt-get >/dev/null 2>&1; then
+	echo "rootless containers are only supported on Ubuntu images"
+	exit 1
+fi
+export DEBIAN_FRONTEND=noninteractive
+
+loginctl enable-linger "$RUNNER_USER" || { echo "failed to enable lingering"; exit 1; }
+for i in $(seq 30); do
+	[ -S "/run/user/$RUNNER_UID/bus" ] && break
+	sleep 1
+done
+as_runner() {
+	su - "$RUNNER_USER" -c "export XDG_RUNTIME_DIR=/run/user/$RUNNER_UID DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/$RUNNER_UID/bus; $1"
+}
+`
+	// linuxRootlessDockerScript replaces the system docker daemon with a rootless one of the
+	// runner user. The docker CLI of the runner user is pointed at it with a context.
+	linuxRootlessDockerScript = linuxRootlessCommon + `
+apt-get install -y uidmap dbus-user-session slirp4netns || { echo "failed to install rootless dependencies"; exit 1; }
+if ! apt-get install -y docker-ce-rootless-extras; then
+	apt-get install -y docker.io rootlesskit || { echo "failed to install docker"; exit 1; }
+fi
+SETUPTOOL=$(command -v dockerd-rootless-setuptool.sh || ls /usr/share/docker.io/contrib/dockerd-rootless-setuptool.sh 2>/dev/null)
+[ -n "$SETUPTOOL" ] || { echo "dockerd-rootless-setuptool.sh not found"; exit 1; }
+
+systemctl disable --now docker.service docker.socket 2>/dev/null
+gpasswd -d "$RUNNER_USER" docker 2>/dev/null
+as_runner "PATH=/usr/share/docker.io/contrib:\$PATH $SETUPTOOL install" || { echo "failed to set up rootless docker"; exit 1; }
+as_runner "docker context use rootless" || { echo "failed to select the rootless docker context"; exit 1; }
+`
+	// linuxRootlessPodmanScript installs podman, with its docker compatible CLI, and the
+	// API socket of the runner user for tools that talk to the docker socket.
+	linuxRootlessPodmanScript = linuxRootlessCommon + `
+systemctl disable --now docker.service docker.socket 2>/dev/null
+gpasswd -d "$RUNNER_USER" docker 2>/dev/null
+apt-get install -y uidmap dbus-user-session slirp4netns podman podman-docker || { echo "failed to install podman"; exit 1; }
+touch /etc/containers/nodocker
+as_runner "systemctl --user enable --now podman.socket" || { echo "failed to enable the podman socket"; exit 1; }
+`
+)
+
+func (e RootlessEngine) Validate() error {
+	switch e {
+	case RootlessEngineDocker, RootlessEnginePodman:
+		return nil
+	}
+	return fmt.Errorf("invalid rootless_containers %q (expected %s or %s)", e, RootlessEngineDocker, RootlessEnginePodman)
+}
+
+// rootlessScript returns the pre install script that sets up the rootless engine.
+func (e RootlessEngine) rootlessScript() []byte {
+	script := linuxRootlessDockerScript
+	if e == RootlessEnginePodman {
+		script = linuxRootlessPodmanScript
+	}
+	return []byte(fmt.Sprintf(script, defaults.DefaultUser))
+}
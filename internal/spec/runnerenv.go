@@ -0,0 +1,94 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	providerUtil "github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	linuxRunnerEnvScriptName = "00-garm-runner-env.sh"
+	// runnerEnvFile holds the runner metadata, in a format that can be sourced by a shell.
+	runnerEnvFile = "/etc/garm-runner.env"
+	// linuxRunnerEnvTemplate writes the runner metadata to runnerEnvFile, and adds it to the
+	// environment of the systemd manager, which passes it to every service started
+	// afterwards, including the runner and the jobs it runs. The drop-in keeps it across
+	// reboots.
+	linuxRunnerEnvTemplate = `#!/bin/sh
+cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+chmod 0644 %[1]s
+mkdir -p /etc/systemd/system.conf.d
+cat > /etc/systemd/system.conf.d/garm-runner-env.conf << 'GARM_EOF'
+[Manager]
+DefaultEnvironment=%[3]s
+GARM_EOF
+systemctl set-environment %[4]s
+`
+)
+
+// runnerMetadata returns the metadata exported to the jobs of a runner.
+func (r RunnerSpec) runnerMetadata() map[string]string {
+	region := r.Location
+	if tag, ok := r.Tags[providerUtil.RegionTagName]; ok && tag != nil {
+		region = *tag
+	}
+	priority := string(armcompute.VirtualMachinePriorityTypesRegular)
+	if tag, ok := r.Tags[providerUtil.PriorityTagName]; ok && tag != nil {
+		priority = *tag
+	}
+	return map[string]string{
+		"GARM_RUNNER_NAME": r.BootstrapParams.Name,
+		"GARM_POOL_ID":     r.BootstrapParams.PoolID,
+		"GARM_LABELS":      strings.Join(r.BootstrapParams.Labels, ","),
+		"GARM_IMAGE":       r.BootstrapParams.Image,
+		"GARM_VM_SIZE":     r.VMSize,
+		"GARM_REGION":      region,
+		"GARM_ZONE":        r.Zone,
+		"GARM_PRIORITY":    priority,
+	}
+}
+
+// runnerEnvScript returns the pre install script that exports the runner metadata.
+func (r RunnerSpec) runnerEnvScript() []byte {
+	metadata := r.runnerMetadata()
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var envFile strings.Builder
+	var systemdVars, shellVars []string
+	for _, name := range names {
+		value := metadata[name]
+		fmt.Fprintf(&envFile, "%s=%s\n", name, shellQuote(value))
+		// systemd unquotes double quoted words, and doesn't expand anything in them.
+		systemdVars = append(systemdVars, fmt.Sprintf("%q", name+"="+value))
+		shellVars = append(shellVars, shellQuote(name+"="+value))
+	}
+	return []byte(fmt.Sprintf(
+		linuxRunnerEnvTemplate,
+		runnerEnvFile,
+		envFile.String(),
+		strings.Join(systemdVars, " "),
+		strings.Join(shellVars, " ")))
+}
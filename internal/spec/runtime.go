@@ -0,0 +1,138 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ContainerRuntime is a sandboxed OCI runtime set up as the default docker runtime.
+type ContainerRuntime string
+
+const (
+	ContainerRuntimeGVisor ContainerRuntime = "gvisor"
+	ContainerRuntimeKata   ContainerRuntime = "kata"
+
+	defaultKataVersion = "3.2.0"
+)
+
+var (
+	// nestedVirtSizeRegex matches the VM size families that support nested virtualization,
+	// which Kata needs to run its VMs. The Arm64 sizes (with a p in their features, like
+	// D4ps_v5) don't support it.
+	nestedVirtSizeRegex = regexp.MustCompile(`(?i)^Standard_([DE][0-9]+[a-oq-z]*_v[3-6]|F[0-9]+[a-z]*_v2|M[0-9]+[a-z]*)$`)
+	kataVersionRegex    = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+)
+
+const (
+	linuxContainerRuntimeScriptName = "00-garm-container-runtime.sh"
+	// linuxContainerRuntimeCommon installs docker if needed, and sets a runtime as the
+	// default in the docker daemon config.
+	linuxContainerRuntimeCommon = `#!/bin/sh
+if ! command -v docker >/dev/null 2>&1; then
+	if command -v apt-get >/dev/null 2>&1; then
+		DEBIAN_FRONTEND=noninteractive apt-get install -y docker.io || { echo "failed to install docker"; exit 1; }
+	elif command -v zypper >/dev/null 2>&1; then
+		zypper -n install docker || { echo "failed to install docker"; exit 1; }
+		systemctl enable --now docker || { echo "failed to start docker"; exit 1; }
+	else
+		# RHEL-family distros don't ship docker, it comes from the docker repository.
+		PM=dnf
+		command -v dnf >/dev/null 2>&1 || PM=yum
+		curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -L -o /etc/yum.repos.d/docker-ce.repo \
+			https://download.docker.com/linux/centos/docker-ce.repo || { echo "failed to add the docker repository"; exit 1; }
+		$PM install -y docker-ce || { echo "failed to install docker"; exit 1; }
+		systemctl enable --now docker || { echo "failed to start docker"; exit 1; }
+	fi
+fi
+
+set_default_runtime() {
+	mkdir -p /etc/docker
+	python3 - "$1" "$2" << 'GARM_EOF'
+import json, os, sys
+path = "/etc/docker/daemon.json"
+config = {}
+if os.path.exists(path):
+    with open(path) as f:
+        config = json.load(f)
+config.setdefault("runtimes", {})[sys.argv[1]] = json.loads(sys.argv[2])
+config["default-runtime"] = sys.argv[1]
+with open(path, "w") as f:
+    json.dump(config, f, indent=2)
+GARM_EOF
+}
+`
+	// linuxGVisorScript installs the latest gVisor release, verifying its checksums.
+	linuxGVisorScript = linuxContainerRuntimeCommon + `
+URL="https://storage.googleapis.com/gvisor/releases/release/latest/$(uname -m)"
+cd "$(mktemp -d)" || exit 1
+for f in runsc containerd-shim-runsc-v1; do
+	curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -L -O "$URL/$f" -O "$URL/$f.sha512" || { echo "failed to download $f"; exit 1; }
+	sha512sum -c "$f.sha512" || { echo "checksum verification of $f failed"; exit 1; }
+	install -m 755 "$f" /usr/local/bin/ || exit 1
+done
+set_default_runtime runsc '{"path": "/usr/local/bin/runsc"}' || { echo "failed to configure docker"; exit 1; }
+systemctl restart docker || { echo "failed to restart docker"; exit 1; }
+`
+	// linuxKataScript installs a static release of Kata Containers. Kata runs each container
+	// in a lightweight VM, so it needs KVM, which nested virtualization provides.
+	linuxKataScript = linuxContainerRuntimeCommon + `
+VERSION=%[1]s
+if [ ! -e /dev/kvm ]; then
+	echo "/dev/kvm is missing, the VM size must support nested virtualization"
+	exit 1
+fi
+ARCH=$(dpkg --print-architecture 2>/dev/null || uname -m | sed 's/x86_64/amd64/;s/aarch64/arm64/')
+curl --retry 5 --retry-delay 5 --retry-connrefused --fail -s -L -o /tmp/kata-static.tar.xz \
+	"https://github.com/kata-containers/kata-containers/releases/download/$VERSION/kata-static-$VERSION-$ARCH.tar.xz" || { echo "failed to download kata $VERSION"; exit 1; }
+tar -xJf /tmp/kata-static.tar.xz -C / || { echo "failed to extract kata"; exit 1; }
+rm -f /tmp/kata-static.tar.xz
+ln -sf /opt/kata/bin/containerd-shim-kata-v2 /usr/local/bin/containerd-shim-kata-v2
+ln -sf /opt/kata/bin/kata-runtime /usr/local/bin/kata-runtime
+set_default_runtime kata '{"runtimeType": "io.containerd.kata.v2"}' || { echo "failed to configure docker"; exit 1; }
+systemctl restart docker || { echo "failed to restart docker"; exit 1; }
+`
+)
+
+func (c ContainerRuntime) Validate(vmSize, kataVersion string) error {
+	switch c {
+	case ContainerRuntimeGVisor:
+		if kataVersion != "" {
+			return fmt.Errorf("kata_version can only be used with the %s container runtime", ContainerRuntimeKata)
+		}
+	case ContainerRuntimeKata:
+		if !nestedVirtSizeRegex.MatchString(vmSize) {
+			return fmt.Errorf("VM size %s does not support nested virtualization, which %s needs", vmSize, ContainerRuntimeKata)
+		}
+		if kataVersion != "" && !kataVersionRegex.MatchString(kataVersion) {
+			return fmt.Errorf("invalid kata_version %q", kataVersion)
+		}
+	default:
+		return fmt.Errorf("invalid container_runtime %q (expected %s or %s)", c, ContainerRuntimeGVisor, ContainerRuntimeKata)
+	}
+	return nil
+}
+
+// containerRuntimeScript returns the pre install script that sets up the runtime.
+func (c ContainerRuntime) containerRuntimeScript(kataVersion string) []byte {
+	if c == ContainerRuntimeKata {
+		if kataVersion == "" {
+			kataVersion = defaultKataVersion
+		}
+		return []byte(fmt.Sprintf(linuxKataScript, kataVersion))
+	}
+	return []byte(linuxGVisorScript)
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+	providerUtil "github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// ScaleHints describe the runners of a pool to external automation, through tags.
+type ScaleHints struct {
+	// WorkloadClass is a free form class of the jobs the pool runs, for example "build"
+	// or "gpu".
+	WorkloadClass string `json:"workload_class"`
+	// IdleAfterMinutes is how long after creation runners of the pool are expected to be
+	// done with their job.
+	IdleAfterMinutes int `json:"idle_after_minutes"`
+}
+
+func (s ScaleHints) Validate() error {
+	if len(s.WorkloadClass) > providerUtil.MaxTagValueLength {
+		return fmt.Errorf("workload_class is longer than %d characters", providerUtil.MaxTagValueLength)
+	}
+	if s.IdleAfterMinutes < 0 {
+		return fmt.Errorf("invalid idle_after_minutes: %d", s.IdleAfterMinutes)
+	}
+	return nil
+}
+
+// setScaleHints tags the runner with its pool ID, and with the scale hints of its pool,
+// using the tag names of the config.
+func setScaleHints(tags map[string]*string, names config.ScaleHints, poolID string, hints *ScaleHints, now time.Time) {
+	tags[names.GetPoolIDTag()] = to.Ptr(poolID)
+	if hints == nil {
+		return
+	}
+	if hints.WorkloadClass != "" {
+		tags[names.GetWorkloadClassTag()] = to.Ptr(hints.WorkloadClass)
+	}
+	if hints.IdleAfterMinutes > 0 {
+		idleAfter := now.Add(time.Duration(hints.IdleAfterMinutes) * time.Minute)
+		tags[names.GetIdleAfterTag()] = to.Ptr(idleAfter.UTC().Format(time.RFC3339))
+	}
+}
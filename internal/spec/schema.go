@@ -0,0 +1,104 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+)
+
+// extraSpecsEnums are the allowed values of the enum types used in the extra specs.
+var extraSpecsEnums = map[reflect.Type][]string{
+	reflect.TypeOf(UserDataFormat("")):      {string(UserDataFormatCloudInit), string(UserDataFormatIgnition)},
+	reflect.TypeOf(RootlessEngine("")):      {string(RootlessEngineDocker), string(RootlessEnginePodman)},
+	reflect.TypeOf(ContainerRuntime("")):    {string(ContainerRuntimeGVisor), string(ContainerRuntimeKata)},
+	reflect.TypeOf(EgressProfile("")):       {string(EgressProfileGitHubOnly)},
+	reflect.TypeOf(config.DeleteOption("")): {string(config.DeleteOptionDelete), string(config.DeleteOptionDetach)},
+	reflect.TypeOf(armnetwork.SecurityRuleProtocol("")): {
+		string(armnetwork.SecurityRuleProtocolTCP), string(armnetwork.SecurityRuleProtocolUDP),
+	},
+}
+
+func init() {
+	var acctTypes []string
+	for _, acctType := range armcompute.PossibleStorageAccountTypesValues() {
+		acctTypes = append(acctTypes, string(acctType))
+	}
+	extraSpecsEnums[reflect.TypeOf(armcompute.StorageAccountTypes(""))] = acctTypes
+
+	var auxModes []string
+	for _, mode := range armnetwork.PossibleNetworkInterfaceAuxiliaryModeValues() {
+		auxModes = append(auxModes, string(mode))
+	}
+	extraSpecsEnums[reflect.TypeOf(armnetwork.NetworkInterfaceAuxiliaryMode(""))] = auxModes
+}
+
+// ExtraSpecsSchema returns a JSON schema of the extra specs the provider accepts. It is
+// generated from the types the extra specs are decoded into, so it always matches the
+// provider version.
+func ExtraSpecsSchema() map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(extraSpecs{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["$id"] = "http://cloudbase.it/garm-provider-azure/schemas/extra_specs#"
+	schema["description"] = "Schema defining supported extra specs for the Garm Azure Provider"
+	return schema
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if enum, ok := extraSpecsEnums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": enum}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		schema := map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+		if enum, ok := extraSpecsEnums[t.Key()]; ok {
+			schema["propertyNames"] = map[string]interface{}{"enum": enum}
+		}
+		return schema
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			properties[name] = typeSchema(field.Type)
+		}
+		return map[string]interface{}{"type": "object", "properties": properties}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// linuxSecureWipeTemplate stops the runner and docker, so no files are held open,
+	// then overwrites and removes the given paths.
+	linuxSecureWipeTemplate = `#!/bin/sh
+systemctl stop 'actions.runner.*' docker.service docker.socket 2>/dev/null
+for p in %s; do
+	[ -e "$p" ] || continue
+	find "$p" -type f -exec shred -fuz -n1 {} + || exit 1
+	rm -rf "$p" || exit 1
+done
+echo "secure wipe done"
+`
+	// windowsSecureWipeTemplate is the Windows counterpart of linuxSecureWipeTemplate.
+	windowsSecureWipeTemplate = `$ErrorActionPreference = "Stop"
+Get-Service -Name "actions.runner.*" -ErrorAction SilentlyContinue | Stop-Service -Force
+foreach ($p in @(%s)) {
+	if (-not (Test-Path -LiteralPath $p)) { continue }
+	Get-ChildItem -LiteralPath $p -Recurse -File -Force | ForEach-Object {
+		$zeros = New-Object byte[] ([Math]::Min($_.Length, 1MB))
+		$stream = [System.IO.File]::OpenWrite($_.FullName)
+		try {
+			for ($written = 0; $written -lt $_.Length; $written += $zeros.Length) {
+				$stream.Write($zeros, 0, [Math]::Min($zeros.Length, $_.Length - $written))
+			}
+			$stream.Flush($true)
+		} finally {
+			$stream.Close()
+		}
+	}
+	Remove-Item -LiteralPath $p -Recurse -Force
+}
+Write-Output "secure wipe done"
+`
+)
+
+// powerShellQuote quotes a value as a PowerShell string literal.
+func powerShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// LinuxSecureWipeScript returns the Run Command script that wipes paths on a Linux runner.
+func LinuxSecureWipeScript(paths []string) string {
+	quoted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		quoted = append(quoted, shellQuote(p))
+	}
+	return fmt.Sprintf(linuxSecureWipeTemplate, strings.Join(quoted, " "))
+}
+
+// WindowsSecureWipeScript returns the Run Command script that wipes paths on a Windows
+// runner.
+func WindowsSecureWipeScript(paths []string) string {
+	quoted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		quoted = append(quoted, powerShellQuote(p))
+	}
+	return fmt.Sprintf(windowsSecureWipeTemplate, strings.Join(quoted, ", "))
+}
@@ -0,0 +1,61 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/cloudbase/garm-provider-azure/config"
+	providerUtil "github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// securityTypeTrustedLaunch is not defined by the vendored compute SDK, but is accepted by
+// its API version.
+const securityTypeTrustedLaunch = "TrustedLaunch"
+
+// applySecurityPreset enforces the settings of a security preset on the spec. Extra specs
+// that contradict the preset are refused, instead of being silently overridden.
+func (r *RunnerSpec) applySecurityPreset(name string, preset config.SecurityPreset, extraSpecs *extraSpecs) error {
+	if preset.TrustedLaunch {
+		if extraSpecs.TrustedLaunch != nil && !*extraSpecs.TrustedLaunch {
+			return fmt.Errorf("security preset %s requires trusted_launch", name)
+		}
+		// Confidential VMs are a superset of trusted launch.
+		r.TrustedLaunch = !r.Confidential
+	}
+	if preset.EncryptionAtHost {
+		if extraSpecs.EncryptionAtHost != nil && !*extraSpecs.EncryptionAtHost {
+			return fmt.Errorf("security preset %s requires encryption_at_host", name)
+		}
+		r.EncryptionAtHost = true
+	}
+	if preset.NoPublicIP && r.AllocatePublicIP {
+		return fmt.Errorf("security preset %s does not allow allocate_public_ip", name)
+	}
+	if preset.NoInboundPorts && len(r.OpenInboundPorts) > 0 {
+		return fmt.Errorf("security preset %s does not allow open_inbound_ports", name)
+	}
+	if preset.EgressProfile != "" {
+		if r.EgressProfile != "" && r.EgressProfile != EgressProfile(preset.EgressProfile) {
+			return fmt.Errorf("security preset %s requires the %s egress profile", name, preset.EgressProfile)
+		}
+		r.EgressProfile = EgressProfile(preset.EgressProfile)
+		r.Tags[providerUtil.EgressProfileTagName] = to.Ptr(preset.EgressProfile)
+	}
+	r.Tags[providerUtil.SecurityPresetTagName] = to.Ptr(name)
+	return nil
+}
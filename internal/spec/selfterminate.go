@@ -0,0 +1,83 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import "fmt"
+
+const (
+	selfTerminateScriptPath = "/opt/garm/self-terminate.sh"
+	selfTerminateUnitName   = "garm-self-terminate.service"
+	// selfTerminateScript waits for the runner service to start, and powers off the VM
+	// once it stops. Ephemeral runners stop after running a single job.
+	selfTerminateScript = `#!/bin/sh
+runner_unit() {
+	systemctl list-units --type=service --all --plain --no-legend 'actions.runner.*' | awk '{print $1}' | head -n1
+}
+
+UNIT=$(runner_unit)
+while [ -z "$UNIT" ]; do
+	sleep 10
+	UNIT=$(runner_unit)
+done
+
+while [ "$(systemctl is-active "$UNIT")" != "active" ]; do
+	sleep 5
+done
+while [ "$(systemctl is-active "$UNIT")" = "active" ]; do
+	sleep 5
+done
+
+# The runner service is also stopped when the VM reboots or shuts down.
+if [ "$(systemctl is-system-running)" = "stopping" ]; then
+	exit 0
+fi
+echo "runner service $UNIT stopped, powering off"
+systemctl poweroff
+`
+	selfTerminateUnit = `[Unit]
+Description=Power off the runner once its job is done
+After=network-online.target
+
+[Service]
+Type=simple
+ExecStart=/bin/sh %s
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	linuxSelfTerminateScriptName = "00-garm-self-terminate.sh"
+	// linuxSelfTerminateInstallTemplate installs the self termination unit on cloud-init
+	// images. It is started right away, and waits for the runner service to be installed.
+	linuxSelfTerminateInstallTemplate = `#!/bin/sh
+mkdir -p /opt/garm
+cat > %[1]s << 'GARM_EOF'
+%[2]sGARM_EOF
+cat > /etc/systemd/system/%[3]s << 'GARM_EOF'
+%[4]sGARM_EOF
+systemctl daemon-reload
+systemctl enable --now %[3]s
+`
+)
+
+// selfTerminateInstallScript returns the pre install script that sets up self termination.
+func selfTerminateInstallScript() []byte {
+	return []byte(fmt.Sprintf(
+		linuxSelfTerminateInstallTemplate,
+		selfTerminateScriptPath,
+		selfTerminateScript,
+		selfTerminateUnitName,
+		fmt.Sprintf(selfTerminateUnit, selfTerminateScriptPath)))
+}
@@ -21,6 +21,8 @@ import (
 	"net"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
@@ -42,6 +44,34 @@ const (
 	defaultDiskSizeGB             int32  = 127
 	defaultVirtualNetworkCIDR     string = "10.10.0.0/16"
 	defaultEphemeralDiskPlacement string = "ResourceDisk"
+
+	minMTU = 576
+	maxMTU = 9000
+
+	linuxMTUScriptName = "00-garm-set-mtu.sh"
+	linuxMTUScript     = `#!/bin/sh
+for dev in /sys/class/net/*; do
+	name=$(basename "$dev")
+	[ "$name" = "lo" ] && continue
+	ip link set dev "$name" mtu %d || echo "failed to set MTU on $name"
+done
+`
+	windowsMTUCommand = "Get-NetIPInterface -ConnectionState Connected | Where-Object InterfaceAlias -notlike 'Loopback*' | Set-NetIPInterface -NlMtuBytes %d; "
+)
+
+var defaultDeleteOptions = config.DeleteOptions{
+	OSDisk:   config.DeleteOptionDelete,
+	NIC:      config.DeleteOptionDelete,
+	PublicIP: config.DeleteOptionDelete,
+}
+
+type UserDataFormat string
+
+const (
+	UserDataFormatCloudInit UserDataFormat = "cloudinit"
+	// UserDataFormatIgnition renders the userdata as an Ignition config. This is
+	// what Flatcar Container Linux expects to find in custom data.
+	UserDataFormatIgnition UserDataFormat = "ignition"
 )
 
 type VMSizeEphemeralDiskSizeLimits struct {
@@ -78,12 +108,70 @@ type extraSpecs struct {
 	OpenInboundPorts         map[armnetwork.SecurityRuleProtocol][]int `json:"open_inbound_ports"`
 	StorageAccountType       armcompute.StorageAccountTypes            `json:"storage_account_type"`
 	DiskSizeGB               int32                                     `json:"disk_size_gb"`
+	DiskPerformanceTier      string                                    `json:"disk_performance_tier"`
 	ExtraTags                map[string]string                         `json:"extra_tags"`
 	SSHPublicKeys            []string                                  `json:"ssh_public_keys"`
 	Confidential             bool                                      `json:"confidential"`
+	TrustedLaunch            *bool                                     `json:"trusted_launch"`
+	EncryptionAtHost         *bool                                     `json:"encryption_at_host"`
+	SecurityPreset           string                                    `json:"security_preset"`
+	SecureWipe               *bool                                     `json:"secure_wipe"`
 	UseEphemeralStorage      *bool                                     `json:"use_ephemeral_storage"`
+	EphemeralDiskFallback    *bool                                     `json:"ephemeral_disk_fallback"`
+	SkipNetworkSecurityGroup *bool                                     `json:"skip_network_security_group"`
 	VirtualNetworkCIDR       string                                    `json:"virtual_network_cidr"`
 	UseAcceleratedNetworking *bool                                     `json:"use_accelerated_networking"`
+	UserDataFormat           UserDataFormat                            `json:"userdata_format"`
+	CloudInitStatusCheck     *bool                                     `json:"cloud_init_status_check"`
+	SubnetCIDR               string                                    `json:"subnet_cidr"`
+	ExtraSubnets             map[string]string                         `json:"extra_subnets"`
+	NetworkTags              map[string]string                         `json:"network_tags"`
+	MTU                      int                                       `json:"mtu"`
+	NICAuxiliaryMode         armnetwork.NetworkInterfaceAuxiliaryMode  `json:"nic_auxiliary_mode"`
+	NetworkInterfaceIDs      []string                                  `json:"network_interface_ids"`
+	SubnetID                 string                                    `json:"subnet_id"`
+	NATGateway               bool                                      `json:"nat_gateway"`
+	SharedResourceGroup      string                                    `json:"shared_resource_group"`
+	DeleteOptions            config.DeleteOptions                      `json:"delete_options"`
+	VerifyRunnerChecksum     *bool                                     `json:"verify_runner_checksum"`
+	RunnerSHA256             string                                    `json:"runner_sha256"`
+	Zones                    []string                                  `json:"zones"`
+	ZonePlacement            config.ZonePlacement                      `json:"zone_placement"`
+	Spot                     *SpotSettings                             `json:"spot"`
+	NFSMounts                []NFSMount                                `json:"nfs_mounts"`
+	ReadOnlyRoot             bool                                      `json:"read_only_root"`
+	EgressProfile            EgressProfile                             `json:"egress_profile"`
+	SelfTerminate            bool                                      `json:"self_terminate"`
+	AllowBurstable           bool                                      `json:"allow_burstable"`
+	ACRLogin                 *ACRLogin                                 `json:"acr_login"`
+	Heartbeat                *Heartbeat                                `json:"heartbeat"`
+	AutoShutdown             *AutoShutdown                             `json:"auto_shutdown"`
+	CallbackAuth             *CallbackAuth                             `json:"callback_auth"`
+	GPUPartitioning          *GPUPartitioning                          `json:"gpu_partitioning"`
+	Alerts                   []string                                  `json:"alerts"`
+	RunnerContainer          *RunnerContainer                          `json:"runner_container"`
+	RootlessContainers       RootlessEngine                            `json:"rootless_containers"`
+	ContainerRuntime         ContainerRuntime                          `json:"container_runtime"`
+	KataVersion              string                                    `json:"kata_version"`
+	KernelTuning             *KernelTuning                             `json:"kernel_tuning"`
+	SwapSizeGB               uint                                      `json:"swap_size_gb"`
+	Hugepages                uint                                      `json:"hugepages"`
+	DiskPressure             *DiskPressure                             `json:"disk_pressure"`
+	WindowsContainers        *WindowsContainers                        `json:"windows_containers"`
+	WSL                      *WSL                                      `json:"wsl"`
+	SpecializedImage         bool                                      `json:"specialized_image"`
+	MinNetworkBandwidthMbps  uint                                      `json:"min_network_bandwidth_mbps"`
+	KeyVaultCertificates     []KeyVaultCertificates                    `json:"key_vault_certificates"`
+	Bastion                  *BastionSettings                          `json:"bastion"`
+	ScaleHints               *ScaleHints                               `json:"scale_hints"`
+	OSUpdateOnBoot           *bool                                     `json:"os_update_on_boot"`
+	TimeZone                 string                                    `json:"time_zone"`
+	Locale                   string                                    `json:"locale"`
+	OSFamily                 OSFamily                                  `json:"os_family"`
+	SUSERegister             *bool                                     `json:"suse_register"`
+	RunnerMetadataEnv        bool                                      `json:"runner_metadata_env"`
+	ManagedIdentity          *config.ManagedIdentity                   `json:"managed_identity"`
+	ChaosTarget              bool                                      `json:"chaos_target"`
 }
 
 func (e *extraSpecs) cleanInboundPorts() {
@@ -129,6 +217,10 @@ func (e *extraSpecs) ensureValidExtraSpec() {
 	if e.ExtraTags == nil {
 		e.ExtraTags = map[string]string{}
 	}
+
+	if e.UserDataFormat == "" {
+		e.UserDataFormat = UserDataFormatCloudInit
+	}
 }
 
 func GetRunnerSpecFromBootstrapParams(data params.BootstrapInstance, controllerID string, cfg *config.Config) (*RunnerSpec, error) {
@@ -159,15 +251,73 @@ func GetRunnerSpecFromBootstrapParams(data params.BootstrapInstance, controllerI
 		virtualNetworkCIDR = extraSpecs.VirtualNetworkCIDR
 	}
 
+	// The runner subnet defaults to the entire virtual network address space, unless
+	// a subnet CIDR is set in config or extra specs.
+	subnetCIDR := virtualNetworkCIDR
+	if cfg.SubnetCIDR != "" {
+		subnetCIDR = cfg.SubnetCIDR
+	}
+	if extraSpecs.SubnetCIDR != "" {
+		subnetCIDR = extraSpecs.SubnetCIDR
+	}
+
+	extraSubnets := cfg.ExtraSubnets
+	if extraSpecs.ExtraSubnets != nil {
+		extraSubnets = extraSpecs.ExtraSubnets
+	}
+	if extraSpecs.Bastion != nil && extraSpecs.Bastion.SubnetCIDR != "" {
+		// Copy the subnets, so the config is left untouched.
+		withBastion := map[string]string{}
+		for name, cidr := range extraSubnets {
+			withBastion[name] = cidr
+		}
+		withBastion[BastionSubnetName] = extraSpecs.Bastion.SubnetCIDR
+		extraSubnets = withBastion
+	}
+
 	tags, err := providerUtil.TagsFromBootstrapParams(data, controllerID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tags: %w", err)
 	}
 
+	// Azure resources are named after the runner. Names Azure would reject are normalized,
+	// and the garm name is kept in a tag. The runner still registers with the garm name.
+	runnerName := data.Name
+	data.Name = providerUtil.AzureResourceName(runnerName)
+	if data.Name != runnerName {
+		tags[providerUtil.InstanceNameTagName] = to.Ptr(runnerName)
+	}
+
+	// Pools may use an image alias instead of an image URN.
+	var imageAlias string
+	if urn, ok := cfg.ResolveImageAlias(data.Image, data.OSArch); ok {
+		imageAlias = data.Image
+		data.Image = urn
+	}
+
+	if extraSpecs.ScaleHints != nil {
+		if err := extraSpecs.ScaleHints.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid scale_hints: %w", err)
+		}
+	}
+	setScaleHints(tags, cfg.ScaleHints, data.PoolID, extraSpecs.ScaleHints, time.Now())
+
 	for name, val := range extraSpecs.ExtraTags {
 		tags[name] = to.Ptr(val)
 	}
 
+	// Network resources are often charged back to another team than the VMs, so they get
+	// their own tags.
+	var networkTags map[string]*string
+	for _, source := range []map[string]string{cfg.NetworkTags, extraSpecs.NetworkTags} {
+		for name, val := range source {
+			if networkTags == nil {
+				networkTags = map[string]*string{}
+			}
+			networkTags[name] = to.Ptr(val)
+		}
+	}
+
 	spec := &RunnerSpec{
 		VMSize:                   data.Flavor,
 		AllocatePublicIP:         extraSpecs.AllocatePublicIP,
@@ -175,28 +325,267 @@ func GetRunnerSpecFromBootstrapParams(data params.BootstrapInstance, controllerI
 		AdminUsername:            appdefaults.DefaultUser,
 		StorageAccountType:       extraSpecs.StorageAccountType,
 		DiskSizeGB:               extraSpecs.DiskSizeGB,
+		DiskPerformanceTier:      strings.ToUpper(extraSpecs.DiskPerformanceTier),
 		SSHPublicKeys:            extraSpecs.SSHPublicKeys,
 		BootstrapParams:          data,
+		RunnerName:               runnerName,
+		ImageAlias:               imageAlias,
 		Tools:                    tools,
 		Tags:                     tags,
 		Confidential:             extraSpecs.Confidential,
 		UseEphemeralStorage:      cfg.UseEphemeralStorage,
+		EphemeralDiskFallback:    cfg.EphemeralDiskFallback,
+		SkipNetworkSecurityGroup: cfg.SkipNetworkSecurityGroup,
 		VirtualNetworkCIDR:       virtualNetworkCIDR,
+		SubnetCIDR:               subnetCIDR,
+		ExtraSubnets:             extraSubnets,
+		NetworkTags:              networkTags,
 		UseAcceleratedNetworking: cfg.UseAcceleratedNetworking,
+		UserDataFormat:           extraSpecs.UserDataFormat,
+		CloudInitStatusCheck:     cfg.CloudInitStatusCheck,
+		MTU:                      extraSpecs.MTU,
+		NICAuxiliaryMode:         extraSpecs.NICAuxiliaryMode,
+		NetworkInterfaceIDs:      extraSpecs.NetworkInterfaceIDs,
+		SubnetID:                 cfg.SubnetID,
+		NATGateway:               extraSpecs.NATGateway,
+		SharedResourceGroup:      cfg.SharedResourceGroup,
+		DeleteOptions:            defaultDeleteOptions.Merge(cfg.DeleteOptions).Merge(extraSpecs.DeleteOptions),
+		ScriptChecksums:          cfg.ScriptChecksums,
+		Zones:                    cfg.Zones,
+		ZonePlacement:            cfg.ZonePlacement,
+		NFSMounts:                extraSpecs.NFSMounts,
+		ReadOnlyRoot:             extraSpecs.ReadOnlyRoot,
+		EgressProfile:            extraSpecs.EgressProfile,
+		SelfTerminate:            extraSpecs.SelfTerminate,
+		RunnerMetadataEnv:        extraSpecs.RunnerMetadataEnv,
+		ManagedIdentity:          cfg.ManagedIdentity,
+		ChaosTarget:              extraSpecs.ChaosTarget,
+		Location:                 cfg.Location,
+		OSUpdateOnBoot:           extraSpecs.OSUpdateOnBoot,
+		TimeZone:                 extraSpecs.TimeZone,
+		Locale:                   extraSpecs.Locale,
+		OSFamily:                 extraSpecs.OSFamily,
+		BurstablePolicy:          cfg.BurstablePolicy,
+		ACRLogin:                 extraSpecs.ACRLogin,
+		Heartbeat:                extraSpecs.Heartbeat,
+		AutoShutdown:             extraSpecs.AutoShutdown,
+		CallbackAuth:             extraSpecs.CallbackAuth,
+		GPUPartitioning:          extraSpecs.GPUPartitioning,
+		Alerts:                   extraSpecs.Alerts,
+		RunnerContainer:          extraSpecs.RunnerContainer,
+		RootlessContainers:       extraSpecs.RootlessContainers,
+		ContainerRuntime:         extraSpecs.ContainerRuntime,
+		KataVersion:              extraSpecs.KataVersion,
+		KernelTuning:             extraSpecs.KernelTuning,
+		SwapSizeGB:               extraSpecs.SwapSizeGB,
+		Hugepages:                extraSpecs.Hugepages,
+		DiskPressure:             extraSpecs.DiskPressure,
+		WindowsContainers:        extraSpecs.WindowsContainers,
+		WSL:                      extraSpecs.WSL,
+		SpecializedImage:         extraSpecs.SpecializedImage,
+		MinNetworkBandwidthMbps:  extraSpecs.MinNetworkBandwidthMbps,
+		KeyVaultCertificates:     extraSpecs.KeyVaultCertificates,
+		Bastion:                  extraSpecs.Bastion,
+	}
+
+	if spec.BurstablePolicy == "" {
+		spec.BurstablePolicy = config.BurstablePolicyWarn
+	}
+	if extraSpecs.AllowBurstable {
+		spec.BurstablePolicy = config.BurstablePolicyAllow
+	}
+	if spec.VMSize != "" {
+		spec.Tags[providerUtil.VMSizeTagName] = to.Ptr(spec.VMSize)
+	}
+	if spec.IsBurstable() {
+		spec.Tags[providerUtil.BurstableTagName] = to.Ptr("true")
+	}
+
+	if extraSpecs.Zones != nil {
+		spec.Zones = extraSpecs.Zones
+	}
+	if extraSpecs.ZonePlacement != "" {
+		spec.ZonePlacement = extraSpecs.ZonePlacement
+	}
+	if spec.ZonePlacement == "" {
+		spec.ZonePlacement = config.ZonePlacementLeastUsed
+	}
+	// Images that can't be parsed are rejected by the validation.
+	imgDetails, _ := providerUtil.ImageToImageDetails(data.Image)
+	if spec.OSFamily == "" && data.OSType == params.Linux {
+		spec.OSFamily = detectOSFamily(imgDetails.Publisher)
+	}
+	if extraSpecs.SUSERegister != nil && *extraSpecs.SUSERegister && cfg.SUSERegistration == nil {
+		return nil, fmt.Errorf("suse_register requires suse_registration in the provider config")
+	}
+	if spec.OSFamily == OSFamilySUSE && cfg.SUSERegistration != nil {
+		register := isBYOSOffer(imgDetails.Offer)
+		if extraSpecs.SUSERegister != nil {
+			register = *extraSpecs.SUSERegister
+		}
+		if register {
+			spec.SUSERegistration = cfg.SUSERegistration
+		}
+	}
+	if spec.SelfTerminate {
+		spec.Tags[providerUtil.SelfTerminateTagName] = to.Ptr("true")
+	}
+	if spec.ChaosTarget {
+		spec.Tags[providerUtil.ChaosTargetTagName] = to.Ptr("true")
+	}
+	if spec.WindowsContainers != nil {
+		spec.WindowsContainers.setDefaults()
+	}
+	if spec.WSL != nil {
+		spec.WSL.setDefaults()
+	}
+	if spec.DiskPressure != nil {
+		spec.DiskPressure.setDefaults()
+	}
+	if spec.Heartbeat != nil {
+		spec.Heartbeat.setDefaults()
+		spec.Tags[providerUtil.HeartbeatTimeoutTagName] = to.Ptr(strconv.FormatUint(uint64(spec.Heartbeat.TimeoutMinutes), 10))
+	}
+
+	if spec.AutoShutdown != nil {
+		spec.AutoShutdown.setDefaults()
+		spec.Tags[cfg.GetAutoShutdownTag()] = to.Ptr(spec.AutoShutdown.TagValue())
+	}
+
+	if spec.EgressProfile != "" {
+		spec.Tags[providerUtil.EgressProfileTagName] = to.Ptr(string(spec.EgressProfile))
+	}
+
+	if extraSpecs.Spot != nil {
+		spec.Spot = extraSpecs.Spot
+		spec.Spot.setDefaults()
+		spec.Tags[providerUtil.PriorityTagName] = to.Ptr(string(armcompute.VirtualMachinePriorityTypesSpot))
+	}
+
+	if len(spec.Zones) == 1 {
+		spec.SetZone(spec.Zones[0])
 	}
 
 	if extraSpecs.UseEphemeralStorage != nil {
 		spec.UseEphemeralStorage = *extraSpecs.UseEphemeralStorage
 	}
+	if extraSpecs.EphemeralDiskFallback != nil {
+		spec.EphemeralDiskFallback = *extraSpecs.EphemeralDiskFallback
+	}
+	if extraSpecs.SkipNetworkSecurityGroup != nil {
+		spec.SkipNetworkSecurityGroup = *extraSpecs.SkipNetworkSecurityGroup
+	}
 
 	if extraSpecs.UseAcceleratedNetworking != nil {
 		spec.UseAcceleratedNetworking = *extraSpecs.UseAcceleratedNetworking
 	}
+	if extraSpecs.SubnetID != "" {
+		spec.SubnetID = extraSpecs.SubnetID
+	}
+	if extraSpecs.SharedResourceGroup != "" {
+		if cfg.SharedResourceGroup == "" {
+			return nil, fmt.Errorf("shared_resource_group requires shared_resource_group in the provider config")
+		}
+		spec.SharedResourceGroup = extraSpecs.SharedResourceGroup
+	}
+	if spec.NATGateway {
+		if !cfg.NATGateways.Enabled() {
+			return nil, fmt.Errorf("nat_gateway requires nat_gateways in the provider config")
+		}
+		if extraSpecs.SubnetID != "" {
+			return nil, fmt.Errorf("nat_gateway can't be used with subnet_id")
+		}
+		// Runners of the pool are attached to the subnet of its NAT gateway instead.
+		spec.SubnetID = ""
+	}
+	// Pre-created NICs are reused by the next runners, so they are only detached from
+	// deleted VMs.
+	if len(spec.NetworkInterfaceIDs) > 0 {
+		spec.DeleteOptions.NIC = config.DeleteOptionDetach
+	}
+
+	if extraSpecs.CloudInitStatusCheck != nil {
+		spec.CloudInitStatusCheck = *extraSpecs.CloudInitStatusCheck
+	}
+
+	verifyRunnerChecksum := cfg.VerifyRunnerChecksum
+	if extraSpecs.VerifyRunnerChecksum != nil {
+		verifyRunnerChecksum = *extraSpecs.VerifyRunnerChecksum
+	}
+	if spec.RunnerContainer != nil {
+		spec.RunnerContainer.setDefaults()
+		// The runner comes from the image, not from the archive garm hands out.
+		if extraSpecs.RunnerSHA256 != "" {
+			return nil, fmt.Errorf("runner_sha256 can't be used with runner_container")
+		}
+	} else if extraSpecs.RunnerSHA256 != "" {
+		spec.RunnerSHA256 = extraSpecs.RunnerSHA256
+	} else if verifyRunnerChecksum {
+		spec.RunnerSHA256 = tools.GetSHA256Checksum()
+		if spec.RunnerSHA256 == "" {
+			return nil, fmt.Errorf("runner checksum verification is enabled, but garm did not send a checksum for %s", tools.GetFilename())
+		}
+	}
+
+	// Only cloud-init based Linux runners can be checked. Windows and Ignition based
+	// images have nothing to report, and specialized images skip provisioning, so
+	// cloud-init never sees the custom data.
+	if data.OSType != params.Linux || spec.UserDataFormat != UserDataFormatCloudInit || spec.SpecializedImage {
+		spec.CloudInitStatusCheck = false
+	}
+
+	if spec.CloudInitStatusCheck {
+		spec.Tags[providerUtil.CloudInitStatusTagName] = to.Ptr(providerUtil.CloudInitStatusPending)
+	}
 
 	if !spec.UseEphemeralStorage && spec.DiskSizeGB == 0 {
 		spec.DiskSizeGB = defaultDiskSizeGB
 	}
 
+	if extraSpecs.TrustedLaunch != nil {
+		spec.TrustedLaunch = *extraSpecs.TrustedLaunch
+	}
+	if extraSpecs.ManagedIdentity != nil {
+		spec.ManagedIdentity = extraSpecs.ManagedIdentity
+	}
+	if extraSpecs.EncryptionAtHost != nil {
+		spec.EncryptionAtHost = *extraSpecs.EncryptionAtHost
+	}
+	if extraSpecs.SecurityPreset != "" {
+		preset, ok := cfg.GetSecurityPreset(extraSpecs.SecurityPreset)
+		if !ok {
+			return nil, fmt.Errorf("unknown security preset %q", extraSpecs.SecurityPreset)
+		}
+		if err := spec.applySecurityPreset(extraSpecs.SecurityPreset, preset, extraSpecs); err != nil {
+			return nil, err
+		}
+	}
+	switch cfg.TrustedLaunchPolicy {
+	case config.TrustedLaunchPolicyDefault, config.TrustedLaunchPolicyRequired:
+		if extraSpecs.TrustedLaunch == nil && !spec.Confidential {
+			spec.TrustedLaunch = true
+		}
+	}
+	if cfg.TrustedLaunchPolicy == config.TrustedLaunchPolicyRequired && !spec.TrustedLaunch && !spec.Confidential {
+		return nil, fmt.Errorf("trusted launch is required by the provider config, and can't be disabled with trusted_launch")
+	}
+
+	secureWipe := cfg.SecureWipe.Enabled
+	if extraSpecs.SecureWipe != nil {
+		secureWipe = *extraSpecs.SecureWipe
+	}
+	if secureWipe {
+		spec.Tags[providerUtil.SecureWipeTagName] = to.Ptr("true")
+	}
+	for _, alert := range spec.Alerts {
+		if _, ok := cfg.AlertTemplates[alert]; !ok {
+			return nil, fmt.Errorf("unknown alert template %q", alert)
+		}
+	}
+	if price, ok := cfg.GetHourlyPrice(spec.VMSize); ok {
+		spec.Tags[providerUtil.HourlyPriceTagName] = to.Ptr(strconv.FormatFloat(price, 'f', -1, 64))
+	}
+
 	if err := spec.Validate(); err != nil {
 		return nil, fmt.Errorf("error validating spec: %w", err)
 	}
@@ -210,15 +599,92 @@ type RunnerSpec struct {
 	AdminUsername            string
 	StorageAccountType       armcompute.StorageAccountTypes
 	DiskSizeGB               int32
+	DiskPerformanceTier      string
 	OpenInboundPorts         map[armnetwork.SecurityRuleProtocol][]int
 	BootstrapParams          params.BootstrapInstance
 	Tools                    params.RunnerApplicationDownload
 	Tags                     map[string]*string
 	SSHPublicKeys            []string
 	Confidential             bool
+	TrustedLaunch            bool
+	EncryptionAtHost         bool
+	RunnerName               string
+	ImageAlias               string
 	UseEphemeralStorage      bool
+	EphemeralDiskFallback    bool
+	SkipNetworkSecurityGroup bool
 	VirtualNetworkCIDR       string
+	SubnetCIDR               string
+	ExtraSubnets             map[string]string
+	NetworkTags              map[string]*string
 	UseAcceleratedNetworking bool
+	UserDataFormat           UserDataFormat
+	CloudInitStatusCheck     bool
+	MTU                      int
+	NICAuxiliaryMode         armnetwork.NetworkInterfaceAuxiliaryMode
+	NetworkInterfaceIDs      []string
+	SubnetID                 string
+	NATGateway               bool
+	SharedResourceGroup      string
+	NetworkInterfaceID       string
+	DeleteOptions            config.DeleteOptions
+	RunnerSHA256             string
+	ScriptChecksums          map[string]string
+	Zones                    []string
+	ZonePlacement            config.ZonePlacement
+	Zone                     string
+	HostGroupID              string
+	ScaleSetID               string
+	Spot                     *SpotSettings
+	SpotFallback             bool
+	NFSMounts                []NFSMount
+	ReadOnlyRoot             bool
+	EgressProfile            EgressProfile
+	GitHubEgressCIDRs        []string
+	CallbackEgressCIDRs      []string
+	SelfTerminate            bool
+	RunnerMetadataEnv        bool
+	ManagedIdentity          *config.ManagedIdentity
+	ChaosTarget              bool
+	// Location is the configured location. Runners placed in another region are tagged
+	// with it instead.
+	Location                string
+	OSUpdateOnBoot          *bool
+	TimeZone                string
+	Locale                  string
+	OSFamily                OSFamily
+	SUSERegistration        *config.SUSERegistration
+	BurstablePolicy         config.BurstablePolicy
+	ACRLogin                *ACRLogin
+	Heartbeat               *Heartbeat
+	AutoShutdown            *AutoShutdown
+	CallbackAuth            *CallbackAuth
+	GPUPartitioning         *GPUPartitioning
+	Alerts                  []string
+	RunnerContainer         *RunnerContainer
+	RootlessContainers      RootlessEngine
+	ContainerRuntime        ContainerRuntime
+	KataVersion             string
+	KernelTuning            *KernelTuning
+	SwapSizeGB              uint
+	Hugepages               uint
+	DiskPressure            *DiskPressure
+	WindowsContainers       *WindowsContainers
+	WSL                     *WSL
+	SpecializedImage        bool
+	MinNetworkBandwidthMbps uint
+	KeyVaultCertificates    []KeyVaultCertificates
+	Bastion                 *BastionSettings
+}
+
+// requireLinuxCloudInit returns an error naming the extra spec of a feature, unless the
+// runner runs linux with the cloudinit userdata format. Features set up by a pre install
+// script need both.
+func (r RunnerSpec) requireLinuxCloudInit(feature string) error {
+	if r.BootstrapParams.OSType != params.Linux || r.UserDataFormat != UserDataFormatCloudInit {
+		return fmt.Errorf("%s is only supported on linux, with the %s userdata format", feature, UserDataFormatCloudInit)
+	}
+	return nil
 }
 
 func (r RunnerSpec) Validate() error {
@@ -241,10 +707,279 @@ func (r RunnerSpec) Validate() error {
 		return fmt.Errorf("missing tools")
 	}
 
+	if r.SubnetID != "" {
+		if err := r.validateExistingSubnet(); err != nil {
+			return err
+		}
+	} else if err := r.validateSubnets(); err != nil {
+		return fmt.Errorf("invalid subnets: %w", err)
+	}
+	if r.NATGateway {
+		if err := r.validateNATGateway(); err != nil {
+			return err
+		}
+	}
+
 	if r.BootstrapParams.Name == "" || r.BootstrapParams.OSType == "" || r.BootstrapParams.InstanceToken == "" {
 		return fmt.Errorf("invalid bootstrap params")
 	}
 
+	switch r.UserDataFormat {
+	case UserDataFormatCloudInit:
+	case UserDataFormatIgnition:
+		if r.BootstrapParams.OSType != params.Linux {
+			return fmt.Errorf("userdata format %s is only supported on linux", r.UserDataFormat)
+		}
+	default:
+		return fmt.Errorf("invalid userdata format: %s", r.UserDataFormat)
+	}
+
+	if r.MTU != 0 && (r.MTU < minMTU || r.MTU > maxMTU) {
+		return fmt.Errorf("invalid MTU %d (must be between %d and %d)", r.MTU, minMTU, maxMTU)
+	}
+
+	if err := r.DeleteOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid delete options: %w", err)
+	}
+
+	if r.UseEphemeralStorage && r.DeleteOptions.OSDisk != config.DeleteOptionDelete {
+		return fmt.Errorf("ephemeral OS disks can only use the %s delete option", config.DeleteOptionDelete)
+	}
+
+	if err := r.validateNICAuxiliaryMode(); err != nil {
+		return fmt.Errorf("invalid NIC settings: %w", err)
+	}
+	if err := r.validateNetworkInterfaces(); err != nil {
+		return err
+	}
+
+	if r.Spot != nil {
+		if err := r.Spot.Validate(); err != nil {
+			return fmt.Errorf("invalid spot settings: %w", err)
+		}
+		if r.UseEphemeralStorage && r.Spot.EvictionPolicy != armcompute.VirtualMachineEvictionPolicyTypesDelete {
+			return fmt.Errorf("spot VMs with ephemeral OS disks must use the %s eviction policy", armcompute.VirtualMachineEvictionPolicyTypesDelete)
+		}
+	}
+
+	if err := r.validateEgressProfile(); err != nil {
+		return fmt.Errorf("invalid egress settings: %w", err)
+	}
+
+	if err := r.validateBurstable(); err != nil {
+		return err
+	}
+
+	if r.TrustedLaunch && r.Confidential {
+		return fmt.Errorf("confidential VMs already use secure boot and a virtual TPM, and can't be combined with trusted_launch")
+	}
+
+	if r.SkipNetworkSecurityGroup && len(r.SecurityRules()) > 0 {
+		return fmt.Errorf("open_inbound_ports, egress_profile and bastion need a network security group, and can't be used with skip_network_security_group")
+	}
+
+	if r.SelfTerminate && r.BootstrapParams.OSType != params.Linux {
+		return fmt.Errorf("self termination is only supported on linux")
+	}
+
+	if r.ManagedIdentity != nil {
+		if err := r.ManagedIdentity.Validate(); err != nil {
+			return fmt.Errorf("invalid managed_identity: %w", err)
+		}
+	}
+
+	if r.RunnerMetadataEnv {
+		if err := r.requireLinuxCloudInit("runner_metadata_env"); err != nil {
+			return err
+		}
+	}
+
+	if err := r.validateLocale(); err != nil {
+		return err
+	}
+
+	if r.OSUpdateOnBoot != nil && *r.OSUpdateOnBoot {
+		if err := r.requireLinuxCloudInit("os_update_on_boot"); err != nil {
+			return err
+		}
+	}
+
+	if r.ACRLogin != nil {
+		if err := r.requireLinuxCloudInit("acr_login"); err != nil {
+			return err
+		}
+		if err := r.ACRLogin.Validate(); err != nil {
+			return fmt.Errorf("invalid acr_login settings: %w", err)
+		}
+	}
+
+	if r.Heartbeat != nil {
+		if err := r.requireLinuxCloudInit("heartbeat"); err != nil {
+			return err
+		}
+		if err := r.Heartbeat.Validate(); err != nil {
+			return fmt.Errorf("invalid heartbeat settings: %w", err)
+		}
+	}
+
+	if r.AutoShutdown != nil {
+		if err := r.AutoShutdown.Validate(); err != nil {
+			return fmt.Errorf("invalid auto_shutdown settings: %w", err)
+		}
+	}
+
+	if r.CallbackAuth != nil {
+		if err := r.CallbackAuth.Validate(); err != nil {
+			return fmt.Errorf("invalid callback_auth settings: %w", err)
+		}
+	}
+
+	if r.RunnerContainer != nil {
+		if err := r.requireLinuxCloudInit("runner_container"); err != nil {
+			return err
+		}
+		if !r.BootstrapParams.JitConfigEnabled {
+			return fmt.Errorf("container runners need garm to use JIT runner configuration")
+		}
+		if err := r.RunnerContainer.Validate(); err != nil {
+			return fmt.Errorf("invalid runner_container settings: %w", err)
+		}
+	}
+
+	if r.RootlessContainers != "" {
+		if err := r.requireLinuxCloudInit("rootless_containers"); err != nil {
+			return err
+		}
+		if err := r.RootlessContainers.Validate(); err != nil {
+			return err
+		}
+		if r.RunnerContainer != nil {
+			return fmt.Errorf("rootless_containers can't be used with runner_container; use the podman engine instead")
+		}
+	}
+
+	if r.ContainerRuntime != "" {
+		if err := r.requireLinuxCloudInit("container_runtime"); err != nil {
+			return err
+		}
+		if err := r.ContainerRuntime.Validate(r.VMSize, r.KataVersion); err != nil {
+			return err
+		}
+		if r.ContainerRuntime == ContainerRuntimeKata && r.Confidential {
+			return fmt.Errorf("confidential VMs don't support nested virtualization, which %s needs", ContainerRuntimeKata)
+		}
+		if r.RootlessContainers != "" || r.RunnerContainer != nil {
+			return fmt.Errorf("container_runtime can't be used with rootless_containers or runner_container")
+		}
+	} else if r.KataVersion != "" {
+		return fmt.Errorf("kata_version can only be used with the %s container runtime", ContainerRuntimeKata)
+	}
+
+	if r.KernelTuning != nil {
+		if err := r.requireLinuxCloudInit("kernel_tuning"); err != nil {
+			return err
+		}
+		if err := r.KernelTuning.Validate(); err != nil {
+			return fmt.Errorf("invalid kernel_tuning settings: %w", err)
+		}
+	}
+
+	if r.DiskPressure != nil {
+		if err := r.requireLinuxCloudInit("disk_pressure"); err != nil {
+			return err
+		}
+		if err := r.DiskPressure.Validate(); err != nil {
+			return fmt.Errorf("invalid disk_pressure settings: %w", err)
+		}
+	}
+
+	if err := r.validateMemory(); err != nil {
+		return err
+	}
+
+	if err := r.validateWindowsContainers(); err != nil {
+		return err
+	}
+
+	if err := r.validateWSL(); err != nil {
+		return err
+	}
+
+	if err := r.validateSpecializedImage(); err != nil {
+		return err
+	}
+
+	if err := r.validateArchitecture(); err != nil {
+		return err
+	}
+
+	if r.GPUPartitioning != nil {
+		if err := r.requireLinuxCloudInit("gpu_partitioning"); err != nil {
+			return err
+		}
+		if err := r.GPUPartitioning.Validate(r.VMSize); err != nil {
+			return fmt.Errorf("invalid gpu_partitioning settings: %w", err)
+		}
+	}
+
+	if err := r.validateDiskPerformanceTier(); err != nil {
+		return err
+	}
+
+	if r.Bastion != nil {
+		if err := r.Bastion.Validate(); err != nil {
+			return fmt.Errorf("invalid bastion settings: %w", err)
+		}
+	}
+
+	for _, vault := range r.KeyVaultCertificates {
+		if err := vault.Validate(r.BootstrapParams.OSType); err != nil {
+			return fmt.Errorf("invalid key_vault_certificates: %w", err)
+		}
+	}
+
+	if r.ReadOnlyRoot {
+		if err := r.requireLinuxCloudInit("read_only_root"); err != nil {
+			return err
+		}
+	}
+
+	if r.OSFamily != "" {
+		if r.BootstrapParams.OSType != params.Linux {
+			return fmt.Errorf("os_family is only supported on linux")
+		}
+		if err := r.OSFamily.Validate(); err != nil {
+			return err
+		}
+		if r.OSFamily != OSFamilyDebian && (r.ReadOnlyRoot || r.RootlessContainers != "") {
+			return fmt.Errorf("read_only_root and rootless_containers are not supported on %s images", r.OSFamily)
+		}
+	}
+
+	if len(r.NFSMounts) > 0 && r.BootstrapParams.OSType != params.Linux {
+		return fmt.Errorf("NFS mounts are only supported on linux")
+	}
+	for _, mount := range r.NFSMounts {
+		if err := mount.Validate(); err != nil {
+			return fmt.Errorf("invalid NFS mount: %w", err)
+		}
+	}
+
+	for _, zone := range r.Zones {
+		if zone == "" {
+			return fmt.Errorf("invalid empty zone")
+		}
+	}
+	switch r.ZonePlacement {
+	case config.ZonePlacementLeastUsed, config.ZonePlacementRandom:
+	default:
+		return fmt.Errorf("invalid zone_placement: %s", r.ZonePlacement)
+	}
+
+	if err := r.validateIntegrity(); err != nil {
+		return fmt.Errorf("failed to verify bootstrap integrity: %w", err)
+	}
+
 	if len(r.SSHPublicKeys) > 0 {
 		for _, key := range r.SSHPublicKeys {
 			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
@@ -253,6 +988,74 @@ func (r RunnerSpec) Validate() error {
 		}
 	}
 
+	// Composing the userdata is the most expensive check, so it runs last.
+	if err := r.validateUserDataSize(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateSubnets makes sure the runner subnet and any extra subnets fit inside the
+// virtual network address space, and that they don't overlap with each other.
+func (r RunnerSpec) validateSubnets() error {
+	_, vnet, err := net.ParseCIDR(r.VirtualNetworkCIDR)
+	if err != nil {
+		return fmt.Errorf("invalid virtual network CIDR: %w", err)
+	}
+
+	subnets := map[string]string{
+		r.BootstrapParams.Name: r.SubnetCIDR,
+	}
+	for name, cidr := range r.ExtraSubnets {
+		if _, ok := subnets[name]; ok {
+			return fmt.Errorf("duplicate subnet name %s", name)
+		}
+		subnets[name] = cidr
+	}
+
+	parsed := map[string]*net.IPNet{}
+	for name, cidr := range subnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR for subnet %s: %w", name, err)
+		}
+		vnetOnes, _ := vnet.Mask.Size()
+		subnetOnes, _ := subnet.Mask.Size()
+		if !vnet.Contains(subnet.IP) || subnetOnes < vnetOnes {
+			return fmt.Errorf("subnet %s (%s) is not part of the virtual network %s", name, cidr, r.VirtualNetworkCIDR)
+		}
+		for otherName, other := range parsed {
+			if other.Contains(subnet.IP) || subnet.Contains(other.IP) {
+				return fmt.Errorf("subnet %s (%s) overlaps with subnet %s (%s)", name, cidr, otherName, other)
+			}
+		}
+		parsed[name] = subnet
+	}
+	return nil
+}
+
+// validateNICAuxiliaryMode checks the auxiliary mode of the NIC. Any mode other than
+// None requires accelerated networking.
+func (r RunnerSpec) validateNICAuxiliaryMode() error {
+	if r.NICAuxiliaryMode == "" {
+		return nil
+	}
+
+	valid := false
+	for _, mode := range armnetwork.PossibleNetworkInterfaceAuxiliaryModeValues() {
+		if mode == r.NICAuxiliaryMode {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid auxiliary mode: %s", r.NICAuxiliaryMode)
+	}
+
+	if r.NICAuxiliaryMode != armnetwork.NetworkInterfaceAuxiliaryModeNone && !r.UseAcceleratedNetworking {
+		return fmt.Errorf("auxiliary mode %s requires accelerated networking", r.NICAuxiliaryMode)
+	}
 	return nil
 }
 
@@ -260,31 +1063,196 @@ func (r RunnerSpec) ImageDetails() (providerUtil.ImageDetails, error) {
 	if r.BootstrapParams.Image == "" {
 		return providerUtil.ImageDetails{}, fmt.Errorf("no image specified in bootstrap params")
 	}
-	imgDetails, err := providerUtil.URNToImageDetails(r.BootstrapParams.Image)
+	imgDetails, err := providerUtil.ImageToImageDetails(r.BootstrapParams.Image)
 	if err != nil {
 		return providerUtil.ImageDetails{}, fmt.Errorf("failed to get image details: %w", err)
 	}
 	return imgDetails, nil
 }
 
+// UserAssignedIdentities returns the resource IDs of the managed identities that are
+// assigned to the VM.
+func (r RunnerSpec) UserAssignedIdentities() []string {
+	var candidates []string
+	if r.ACRLogin != nil {
+		candidates = append(candidates, r.ACRLogin.IdentityID)
+	}
+	if r.Heartbeat != nil {
+		candidates = append(candidates, r.Heartbeat.IdentityID)
+	}
+	if r.DiskPressure != nil {
+		candidates = append(candidates, r.DiskPressure.IdentityID)
+	}
+	if r.CallbackAuth != nil {
+		candidates = append(candidates, r.CallbackAuth.IdentityID)
+	}
+	if r.ManagedIdentity != nil {
+		candidates = append(candidates, r.ManagedIdentity.UserAssigned...)
+	}
+
+	// Resource IDs are case insensitive, and the same identity may be used for several
+	// features.
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range candidates {
+		if seen[strings.ToLower(id)] {
+			continue
+		}
+		seen[strings.ToLower(id)] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// SystemAssignedIdentity returns true if the system assigned identity of the VM is enabled.
+func (r RunnerSpec) SystemAssignedIdentity() bool {
+	return r.ManagedIdentity != nil && r.ManagedIdentity.SystemAssigned
+}
+
 func (r RunnerSpec) ComposeUserData() ([]byte, error) {
+	if r.UserDataFormat == UserDataFormatIgnition {
+		bootstrapParams := r.BootstrapParams
+		bootstrapParams.InstanceToken = r.callbackToken()
+		extraSpecs, err := withRunnerInstallTemplate(bootstrapParams.ExtraSpecs, ignitionInstallTemplate())
+		if err != nil {
+			return nil, fmt.Errorf("failed to add ignition runner install template: %w", err)
+		}
+		bootstrapParams.ExtraSpecs = extraSpecs
+		installScript, err := cloudconfig.GetRunnerInstallScript(bootstrapParams, r.Tools, r.RunnerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate runner install script: %w", err)
+		}
+		udata, err := r.composeIgnitionUserData(installScript)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ignition userdata: %w", err)
+		}
+		return udata, nil
+	}
+
+	bootstrapParams, err := r.bootstrapParamsWithScripts()
+	if err != nil {
+		return nil, err
+	}
+
 	switch r.BootstrapParams.OSType {
 	case params.Linux, params.Windows:
-		udata, err := cloudconfig.GetCloudConfig(r.BootstrapParams, r.Tools, r.BootstrapParams.Name)
+		udata, err := cloudconfig.GetCloudConfig(bootstrapParams, r.Tools, r.RunnerName)
 		if err != nil {
 			return nil, fmt.Errorf("failed to generate userdata: %w", err)
 		}
+		if r.OSUpdateOnBoot != nil && *r.OSUpdateOnBoot && bootstrapParams.OSType == params.Linux {
+			udata = withRebootIfRequired(udata)
+		}
+		if bootstrap := r.windowsBootstrap(); bootstrapParams.OSType == params.Windows && !bootstrap.empty() {
+			return bootstrap.wrap([]byte(udata)), nil
+		}
 		return []byte(udata), nil
 	}
 	return nil, fmt.Errorf("unsupported OS type for cloud config: %s", r.BootstrapParams.OSType)
 }
 
-func (r RunnerSpec) SecurityRules() []*armnetwork.SecurityRule {
-	if len(r.OpenInboundPorts) == 0 {
-		return nil
+// bootstrapParamsWithScripts returns the bootstrap params with the pre install scripts
+// and runner install template of the enabled features added to the extra specs.
+func (r RunnerSpec) bootstrapParamsWithScripts() (params.BootstrapInstance, error) {
+	bootstrapParams := r.BootstrapParams
+	bootstrapParams.InstanceToken = r.callbackToken()
+	if r.OSUpdateOnBoot != nil {
+		bootstrapParams.UserDataOptions.DisableUpdatesOnBoot = !*r.OSUpdateOnBoot
+	}
+	for _, script := range r.preInstallScripts() {
+		if !script.enabled {
+			continue
+		}
+		extraSpecs, err := withPreInstallScript(bootstrapParams.ExtraSpecs, script.name, script.script())
+		if err != nil {
+			return params.BootstrapInstance{}, fmt.Errorf("failed to add %s script: %w", script.description, err)
+		}
+		bootstrapParams.ExtraSpecs = extraSpecs
+	}
+
+	var installTemplate string
+	switch {
+	case r.RunnerContainer != nil:
+		installTemplate = r.RunnerContainer.installTemplate()
+	case r.OSFamily == OSFamilyRHEL || r.OSFamily == OSFamilySUSE:
+		installTemplate = rhelInstallTemplate()
+	}
+	if r.ReadOnlyRoot || (r.KernelTuning != nil && r.KernelTuning.Reboot) {
+		if installTemplate == "" {
+			installTemplate = cloudconfig.CloudConfigTemplate
+		}
+		installTemplate = startRunnerOnReboot(installTemplate)
+	}
+	if installTemplate != "" {
+		extraSpecs, err := withRunnerInstallTemplate(bootstrapParams.ExtraSpecs, installTemplate)
+		if err != nil {
+			return params.BootstrapInstance{}, fmt.Errorf("failed to add runner install template: %w", err)
+		}
+		bootstrapParams.ExtraSpecs = extraSpecs
 	}
 
-	var ret []*armnetwork.SecurityRule
+	return bootstrapParams, nil
+}
+
+// preInstallScript is the pre install script of a feature.
+type preInstallScript struct {
+	enabled     bool
+	name        string
+	description string
+	script      func() []byte
+}
+
+// preInstallScripts returns the pre install scripts of all features, in the order they
+// are added to the extra specs. Only the scripts of enabled features are generated.
+func (r RunnerSpec) preInstallScripts() []preInstallScript {
+	return []preInstallScript{
+		{r.OSFamily == OSFamilyRHEL, linuxRHELScriptName, "RHEL", func() []byte { return []byte(linuxRHELScript) }},
+		{r.OSFamily == OSFamilySUSE, linuxSUSEScriptName, "SUSE", func() []byte { return suseScript(r.SUSERegistration) }},
+		{r.MTU > 0 && r.BootstrapParams.OSType == params.Linux, linuxMTUScriptName, "MTU", func() []byte { return []byte(fmt.Sprintf(linuxMTUScript, r.MTU)) }},
+		{(r.TimeZone != "" || r.Locale != "") && r.BootstrapParams.OSType == params.Linux, linuxLocaleScriptName, "locale", r.localeScript},
+		{len(r.NFSMounts) > 0 && r.BootstrapParams.OSType == params.Linux, linuxNFSScriptName, "NFS mount", r.nfsMountScript},
+		{r.ReadOnlyRoot, linuxReadOnlyRootScriptName, "read-only root", func() []byte { return []byte(linuxReadOnlyRootScript) }},
+		{r.SelfTerminate, linuxSelfTerminateScriptName, "self termination", selfTerminateInstallScript},
+		{r.RunnerMetadataEnv, linuxRunnerEnvScriptName, "runner metadata", r.runnerEnvScript},
+		{r.RootlessContainers != "", linuxRootlessScriptName, "rootless containers", r.RootlessContainers.rootlessScript},
+		{r.KernelTuning != nil, linuxKernelTuningScriptName, "kernel tuning", func() []byte { return r.KernelTuning.kernelTuningScript() }},
+		{r.SwapSizeGB > 0 || r.Hugepages > 0, linuxMemoryScriptName, "swap and huge pages", r.memoryScript},
+		{r.ContainerRuntime != "", linuxContainerRuntimeScriptName, "container runtime", func() []byte { return r.ContainerRuntime.containerRuntimeScript(r.KataVersion) }},
+		{r.GPUPartitioning != nil, linuxGPUPartitionScriptName, "gpu partitioning", func() []byte { return r.GPUPartitioning.gpuPartitionInstallScript() }},
+		{r.ACRLogin != nil, linuxACRLoginScriptName, "registry login", func() []byte { return r.ACRLogin.acrLoginInstallScript() }},
+		{r.Heartbeat != nil, linuxHeartbeatScriptName, "heartbeat", func() []byte { return r.Heartbeat.heartbeatInstallScript() }},
+		{r.DiskPressure != nil, linuxDiskPressureScriptName, "disk pressure", func() []byte { return r.DiskPressure.diskPressureInstallScript() }},
+		{r.RunnerSHA256 != "", linuxVerifyRunnerScriptName, "runner verification", r.runnerVerifyScript},
+	}
+}
+
+// withPreInstallScript returns a copy of the extra specs with an additional pre install
+// script, which cloudconfig will add to the cloud-init config.
+func withPreInstallScript(extraSpecs json.RawMessage, name string, script []byte) (json.RawMessage, error) {
+	asMap := map[string]interface{}{}
+	if len(extraSpecs) > 0 {
+		if err := json.Unmarshal(extraSpecs, &asMap); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal extra specs: %w", err)
+		}
+	}
+
+	scripts, ok := asMap["pre_install_scripts"].(map[string]interface{})
+	if !ok {
+		scripts = map[string]interface{}{}
+	}
+	// Byte arrays are base64 encoded when marshaled to JSON.
+	scripts[name] = base64.StdEncoding.EncodeToString(script)
+	asMap["pre_install_scripts"] = scripts
+
+	ret, err := json.Marshal(asMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal extra specs: %w", err)
+	}
+	return ret, nil
+}
+
+func (r RunnerSpec) SecurityRules() []*armnetwork.SecurityRule {
+	ret := r.egressRules()
 	secGroupPrio := 200
 	for proto, ports := range r.OpenInboundPorts {
 		for idx, port := range ports {
@@ -304,13 +1272,23 @@ func (r RunnerSpec) SecurityRules() []*armnetwork.SecurityRule {
 			})
 		}
 	}
+	if r.Bastion != nil {
+		ret = append(ret, r.bastionRule())
+	}
 	return ret
 }
 
 func (r RunnerSpec) GetVMExtension(location, extName string) (*armcompute.VirtualMachineExtension, error) {
+	if r.SpecializedImage {
+		return nil, nil
+	}
 	switch r.BootstrapParams.OSType {
 	case params.Windows:
-		asBytes, err := util.UTF16EncodedByteArrayFromString(windowsRunScriptTemplate)
+		runScript := windowsRunScriptTemplate
+		if r.MTU > 0 {
+			runScript = fmt.Sprintf(windowsMTUCommand, r.MTU) + runScript
+		}
+		asBytes, err := util.UTF16EncodedByteArrayFromString(runScript)
 		if err != nil {
 			return nil, fmt.Errorf("failed to encode script cmd: %w", err)
 		}
@@ -337,6 +1315,17 @@ func (r RunnerSpec) GetVMExtension(location, extName string) (*armcompute.Virtua
 	return nil, nil
 }
 
+// FallBackToManagedDisk switches the runner to a managed OS disk of the requested size,
+// when its VM size can't hold the ephemeral OS disk. The runner is tagged, so the fallback
+// can be noticed.
+func (r *RunnerSpec) FallBackToManagedDisk() {
+	r.UseEphemeralStorage = false
+	if r.DiskSizeGB == 0 {
+		r.DiskSizeGB = defaultDiskSizeGB
+	}
+	r.Tags[providerUtil.EphemeralFallbackTagName] = to.Ptr("true")
+}
+
 func (r RunnerSpec) ephemeralDiskSettings(placement *armcompute.DiffDiskPlacement) *armcompute.DiffDiskSettings {
 	if !r.UseEphemeralStorage {
 		return nil
@@ -366,19 +1355,34 @@ func (r RunnerSpec) managedDiskSettings() *armcompute.ManagedDiskParameters {
 }
 
 func (r RunnerSpec) securityProfile() *armcompute.SecurityProfile {
+	var securityProfile *armcompute.SecurityProfile
+	switch {
 	// There are limitations based on OS, region and VM size. Too many variables
 	// to sanely permit confidential VMs with ephemeral storage.
-	if !r.Confidential || r.UseEphemeralStorage {
-		return nil
-	}
-	securityProfile := &armcompute.SecurityProfile{
-		SecurityType: to.Ptr(armcompute.SecurityTypesConfidentialVM),
-		UefiSettings: &armcompute.UefiSettings{
-			SecureBootEnabled: to.Ptr(true),
-			VTpmEnabled:       to.Ptr(true),
-		},
+	case r.Confidential && !r.UseEphemeralStorage:
+		securityProfile = &armcompute.SecurityProfile{
+			SecurityType: to.Ptr(armcompute.SecurityTypesConfidentialVM),
+			UefiSettings: &armcompute.UefiSettings{
+				SecureBootEnabled: to.Ptr(true),
+				VTpmEnabled:       to.Ptr(true),
+			},
+		}
+	case r.TrustedLaunch:
+		securityProfile = &armcompute.SecurityProfile{
+			SecurityType: to.Ptr(armcompute.SecurityTypes(securityTypeTrustedLaunch)),
+			UefiSettings: &armcompute.UefiSettings{
+				SecureBootEnabled: to.Ptr(true),
+				VTpmEnabled:       to.Ptr(true),
+			},
+		}
 	}
 
+	if r.EncryptionAtHost {
+		if securityProfile == nil {
+			securityProfile = &armcompute.SecurityProfile{}
+		}
+		securityProfile.EncryptionAtHost = to.Ptr(true)
+	}
 	return securityProfile
 }
 
@@ -402,6 +1406,9 @@ func (r RunnerSpec) GetNewVMProperties(networkInterfaceID string, sizeSpec VMSiz
 	}
 
 	asBase64 := base64.StdEncoding.EncodeToString(customData)
+	if err := checkCustomDataSize(r.BootstrapParams.OSType, asBase64); err != nil {
+		return nil, err
+	}
 
 	if r.VMSize == "" {
 		return nil, fmt.Errorf("missing vm size parameter")
@@ -448,15 +1455,15 @@ func (r RunnerSpec) GetNewVMProperties(networkInterfaceID string, sizeSpec VMSiz
 				ManagedDisk:      managedDiskParams,
 				DiffDiskSettings: diffSettings,
 				DiskSizeGB:       &diskSize,
+				DeleteOption:     to.Ptr(armcompute.DiskDeleteOptionTypes(r.DeleteOptions.OSDisk)),
 			},
 		},
 		HardwareProfile: &armcompute.HardwareProfile{
 			VMSize: to.Ptr(armcompute.VirtualMachineSizeTypes(r.VMSize)),
 		},
 		OSProfile: &armcompute.OSProfile{
-			CustomData: &asBase64,
-			// Windows computer names may not be longer than 15 characters.
-			ComputerName:  to.Ptr(r.BootstrapParams.Name[:15]),
+			CustomData:    &asBase64,
+			ComputerName:  to.Ptr(providerUtil.ComputerName(r.BootstrapParams.Name)),
 			AdminUsername: to.Ptr(r.AdminUsername),
 			AdminPassword: &password,
 		},
@@ -464,11 +1471,16 @@ func (r RunnerSpec) GetNewVMProperties(networkInterfaceID string, sizeSpec VMSiz
 			NetworkInterfaces: []*armcompute.NetworkInterfaceReference{
 				{
 					ID: to.Ptr(networkInterfaceID),
+					Properties: &armcompute.NetworkInterfaceReferenceProperties{
+						DeleteOption: to.Ptr(armcompute.DeleteOptions(r.DeleteOptions.NIC)),
+					},
 				},
 			},
 		},
 		SecurityProfile: securityProfile,
 	}
+	r.setSpotProperties(properties)
+	r.setKeyVaultSecrets(properties)
 
 	if r.BootstrapParams.OSType == params.Linux {
 		pubKeys := []*armcompute.SSHPublicKey{}
@@ -505,5 +1517,21 @@ func (r RunnerSpec) GetNewVMProperties(networkInterfaceID string, sizeSpec VMSiz
 			},
 		}
 	}
+
+	if r.BootstrapParams.OSType == params.Windows && r.TimeZone != "" {
+		properties.OSProfile.WindowsConfiguration = &armcompute.WindowsConfiguration{
+			TimeZone: to.Ptr(r.TimeZone),
+		}
+	}
+	if imgDetails.ID != "" {
+		properties.StorageProfile.ImageReference = &armcompute.ImageReference{
+			ID: to.Ptr(imgDetails.ID),
+		}
+	}
+	// Specialized images keep the OS configuration they were captured with, and the runner
+	// is installed with Run Command instead of custom data.
+	if r.SpecializedImage {
+		properties.OSProfile = nil
+	}
 	return properties, nil
 }
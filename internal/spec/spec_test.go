@@ -0,0 +1,129 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+func TestValidateSubnets(t *testing.T) {
+	tests := []struct {
+		name         string
+		vnetCIDR     string
+		subnetCIDR   string
+		extraSubnets map[string]string
+		wantErr      string
+	}{
+		{
+			name:       "runner subnet only",
+			vnetCIDR:   "10.10.0.0/16",
+			subnetCIDR: "10.10.1.0/24",
+		},
+		{
+			name:         "disjoint extra subnets",
+			vnetCIDR:     "10.10.0.0/16",
+			subnetCIDR:   "10.10.1.0/24",
+			extraSubnets: map[string]string{"services": "10.10.2.0/24", "pods": "10.10.128.0/17"},
+		},
+		{
+			name:       "subnet is the whole virtual network",
+			vnetCIDR:   "10.10.0.0/16",
+			subnetCIDR: "10.10.0.0/16",
+		},
+		{
+			name:       "invalid virtual network CIDR",
+			vnetCIDR:   "10.10.0.0/33",
+			subnetCIDR: "10.10.1.0/24",
+			wantErr:    "invalid virtual network CIDR",
+		},
+		{
+			name:       "invalid subnet CIDR",
+			vnetCIDR:   "10.10.0.0/16",
+			subnetCIDR: "10.10.1.0",
+			wantErr:    "invalid CIDR for subnet",
+		},
+		{
+			name:       "subnet outside the virtual network",
+			vnetCIDR:   "10.10.0.0/16",
+			subnetCIDR: "10.20.1.0/24",
+			wantErr:    "is not part of the virtual network",
+		},
+		{
+			name:       "subnet larger than the virtual network",
+			vnetCIDR:   "10.10.0.0/16",
+			subnetCIDR: "10.10.0.0/8",
+			wantErr:    "is not part of the virtual network",
+		},
+		{
+			name:         "identical subnets",
+			vnetCIDR:     "10.10.0.0/16",
+			subnetCIDR:   "10.10.1.0/24",
+			extraSubnets: map[string]string{"services": "10.10.1.0/24"},
+			wantErr:      "overlaps with subnet",
+		},
+		{
+			name:         "extra subnet inside the runner subnet",
+			vnetCIDR:     "10.10.0.0/16",
+			subnetCIDR:   "10.10.0.0/20",
+			extraSubnets: map[string]string{"services": "10.10.4.0/24"},
+			wantErr:      "overlaps with subnet",
+		},
+		{
+			name:         "runner subnet inside an extra subnet",
+			vnetCIDR:     "10.10.0.0/16",
+			subnetCIDR:   "10.10.4.0/24",
+			extraSubnets: map[string]string{"services": "10.10.0.0/20"},
+			wantErr:      "overlaps with subnet",
+		},
+		{
+			name:         "overlapping extra subnets",
+			vnetCIDR:     "10.10.0.0/16",
+			subnetCIDR:   "10.10.1.0/24",
+			extraSubnets: map[string]string{"services": "10.10.128.0/17", "pods": "10.10.200.0/24"},
+			wantErr:      "overlaps with subnet",
+		},
+		{
+			name:         "extra subnet named after the runner",
+			vnetCIDR:     "10.10.0.0/16",
+			subnetCIDR:   "10.10.1.0/24",
+			extraSubnets: map[string]string{"garm-runner": "10.10.2.0/24"},
+			wantErr:      "duplicate subnet name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := RunnerSpec{
+				VirtualNetworkCIDR: tt.vnetCIDR,
+				SubnetCIDR:         tt.subnetCIDR,
+				ExtraSubnets:       tt.extraSubnets,
+				BootstrapParams:    params.BootstrapInstance{Name: "garm-runner"},
+			}
+			err := r.validateSubnets()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateSubnets() returned an error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateSubnets() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudbase/garm-provider-common/cloudconfig"
+	"github.com/cloudbase/garm-provider-common/defaults"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+const (
+	// linuxSpecializedBootstrapTemplate sets up the runner user, and installs the runner
+	// on VMs booted from a specialized image, which skip provisioning, so cloud-init
+	// never runs the userdata.
+	linuxSpecializedBootstrapTemplate = `#!/bin/sh
+set -e
+trap 'rm -rf /install_runner.sh /garm-bootstrap.sh /garm-pre-install' EXIT
+
+RUNNER_USER=%[1]s
+if ! id "$RUNNER_USER" >/dev/null 2>&1; then
+	useradd --create-home --shell %[2]s "$RUNNER_USER"
+fi
+for group in %[3]s; do
+	if getent group "$group" >/dev/null; then
+		usermod -aG "$group" "$RUNNER_USER"
+	fi
+done
+echo "$RUNNER_USER ALL=(ALL) NOPASSWD:ALL" > /etc/sudoers.d/90-garm-runner
+chmod 440 /etc/sudoers.d/90-garm-runner
+RUNNER_HOME=$(getent passwd "$RUNNER_USER" | cut -d: -f6)
+%[4]s
+mkdir -p /garm-pre-install
+%[5]s
+
+echo %[6]s | base64 -d > /install_runner.sh
+chmod 755 /install_runner.sh
+su -l -c /install_runner.sh "$RUNNER_USER"
+`
+	linuxSpecializedSSHKeysTemplate = `mkdir -p "$RUNNER_HOME/.ssh"
+echo %s | base64 -d >> "$RUNNER_HOME/.ssh/authorized_keys"
+chmod 700 "$RUNNER_HOME/.ssh"
+chmod 600 "$RUNNER_HOME/.ssh/authorized_keys"
+chown -R "$RUNNER_USER" "$RUNNER_HOME/.ssh"
+`
+	linuxSpecializedCABundleTemplate = `if [ -d /usr/local/share/ca-certificates ]; then
+	echo %[1]s | base64 -d > /usr/local/share/ca-certificates/garm-ca-bundle.crt
+	update-ca-certificates
+elif [ -d /etc/pki/ca-trust/source/anchors ]; then
+	echo %[1]s | base64 -d > /etc/pki/ca-trust/source/anchors/garm-ca-bundle.crt
+	update-ca-trust
+fi
+`
+	linuxSpecializedPreInstallTemplate = `echo %[1]s | base64 -d > /garm-pre-install/%[2]s
+chmod 755 /garm-pre-install/%[2]s
+/garm-pre-install/%[2]s
+`
+	// linuxSpecializedRunTemplate writes the bootstrap script, and starts it outside of
+	// the Run Command extension, so the command returns before the runner is installed.
+	linuxSpecializedRunTemplate = `echo %s | base64 -d > /garm-bootstrap.sh
+chmod 700 /garm-bootstrap.sh
+if command -v systemd-run >/dev/null 2>&1; then
+	systemd-run --unit=garm-bootstrap --description="garm runner bootstrap" /bin/sh /garm-bootstrap.sh
+else
+	nohup setsid /bin/sh /garm-bootstrap.sh > /var/log/garm-bootstrap.log 2>&1 &
+fi
+echo "runner bootstrap started"
+`
+	// windowsSpecializedRunTemplate writes the bootstrap script, and starts it from a
+	// scheduled task, so the command returns before the runner is installed.
+	windowsSpecializedRunTemplate = `$ErrorActionPreference = "Stop"
+New-Item -ItemType Directory -Force -Path C:\garm | Out-Null
+[IO.File]::WriteAllBytes("C:\garm\bootstrap.ps1", [Convert]::FromBase64String("%s"))
+$action = New-ScheduledTaskAction -Execute "powershell.exe" -Argument "-NonInteractive -ExecutionPolicy Bypass -File C:\garm\bootstrap.ps1"
+Register-ScheduledTask -Force -TaskName "garm-specialized-bootstrap" -Action $action -User "SYSTEM" -RunLevel Highest | Out-Null
+Start-ScheduledTask -TaskName "garm-specialized-bootstrap"
+Write-Output "runner bootstrap started"
+`
+)
+
+func (r RunnerSpec) validateSpecializedImage() error {
+	if !r.SpecializedImage {
+		return nil
+	}
+	imgDetails, err := r.ImageDetails()
+	if err != nil {
+		return fmt.Errorf("failed to get image details: %w", err)
+	}
+	if imgDetails.ID == "" {
+		return fmt.Errorf("specialized_image requires the resource ID of a compute gallery image as the pool image")
+	}
+	if r.UserDataFormat == UserDataFormatIgnition {
+		return fmt.Errorf("specialized_image is not supported with ignition userdata")
+	}
+	if r.OSUpdateOnBoot != nil && *r.OSUpdateOnBoot {
+		return fmt.Errorf("os_update_on_boot is not supported with specialized_image")
+	}
+	if len(r.KeyVaultCertificates) > 0 {
+		return fmt.Errorf("key_vault_certificates is not supported with specialized_image")
+	}
+	if r.TimeZone != "" && r.BootstrapParams.OSType == params.Windows {
+		return fmt.Errorf("time_zone is not supported with specialized_image on Windows")
+	}
+	return nil
+}
+
+// SpecializedBootstrapScript returns the Run Command script that installs the runner
+// on a VM booted from a specialized image.
+func (r RunnerSpec) SpecializedBootstrapScript() (string, error) {
+	switch r.BootstrapParams.OSType {
+	case params.Linux:
+		script, err := r.linuxSpecializedBootstrap()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(linuxSpecializedRunTemplate, base64.StdEncoding.EncodeToString(script)), nil
+	case params.Windows:
+		bootstrapParams, err := r.bootstrapParamsWithScripts()
+		if err != nil {
+			return "", err
+		}
+		installScript, err := cloudconfig.GetCloudConfig(bootstrapParams, r.Tools, r.RunnerName)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate runner install script: %w", err)
+		}
+		bootstrap := r.windowsBootstrap()
+		if r.MTU > 0 {
+			bootstrap.steps = append([]string{fmt.Sprintf(windowsMTUCommand, r.MTU)}, bootstrap.steps...)
+		}
+		script := bootstrap.wrap([]byte(installScript))
+		return fmt.Sprintf(windowsSpecializedRunTemplate, base64.StdEncoding.EncodeToString(script)), nil
+	}
+	return "", fmt.Errorf("unsupported OS type for specialized images: %s", r.BootstrapParams.OSType)
+}
+
+func (r RunnerSpec) linuxSpecializedBootstrap() ([]byte, error) {
+	bootstrapParams, err := r.bootstrapParamsWithScripts()
+	if err != nil {
+		return nil, err
+	}
+	specs, err := cloudconfig.GetSpecs(bootstrapParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pre install scripts: %w", err)
+	}
+	installScript, err := cloudconfig.GetRunnerInstallScript(bootstrapParams, r.Tools, r.RunnerName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate runner install script: %w", err)
+	}
+
+	var setup strings.Builder
+	if len(bootstrapParams.SSHKeys) > 0 {
+		keys := strings.Join(bootstrapParams.SSHKeys, "\n") + "\n"
+		setup.WriteString(fmt.Sprintf(linuxSpecializedSSHKeysTemplate, base64.StdEncoding.EncodeToString([]byte(keys))))
+	}
+	if len(bootstrapParams.CACertBundle) > 0 {
+		setup.WriteString(fmt.Sprintf(linuxSpecializedCABundleTemplate, base64.StdEncoding.EncodeToString(bootstrapParams.CACertBundle)))
+	}
+
+	// Cloud-init runs the pre install scripts in lexical order, so do the same.
+	names := make([]string, 0, len(specs.PreInstallScripts))
+	for name := range specs.PreInstallScripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var preInstall strings.Builder
+	for _, name := range names {
+		preInstall.WriteString(fmt.Sprintf(
+			linuxSpecializedPreInstallTemplate,
+			base64.StdEncoding.EncodeToString(specs.PreInstallScripts[name]),
+			shellQuote(name)))
+	}
+
+	return []byte(fmt.Sprintf(
+		linuxSpecializedBootstrapTemplate,
+		shellQuote(defaults.DefaultUser),
+		shellQuote(defaults.DefaultUserShell),
+		strings.Join(quoteAll(defaults.DefaultUserGroups), " "),
+		setup.String(),
+		preInstall.String(),
+		base64.StdEncoding.EncodeToString(installScript))), nil
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, shellQuote(value))
+	}
+	return quoted
+}
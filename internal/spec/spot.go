@@ -0,0 +1,103 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	providerUtil "github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	// defaultSpotMaxPrice caps the spot price at the on-demand price.
+	defaultSpotMaxPrice = -1
+	// defaultSpotFallbackCooldownMinutes is the time after the last spot allocation failure
+	// during which runners of the pool keep being created as regular VMs.
+	defaultSpotFallbackCooldownMinutes = 15
+)
+
+// SpotSettings configures runners to be created as Azure Spot VMs.
+type SpotSettings struct {
+	// MaxPrice is the maximum hourly price in US dollars. -1 (the default) caps the price
+	// at the on-demand price of the VM size.
+	MaxPrice float64 `json:"max_price"`
+	// EvictionPolicy is the eviction policy of the VM. Defaults to Delete.
+	EvictionPolicy armcompute.VirtualMachineEvictionPolicyTypes `json:"eviction_policy"`
+	// FallbackAfter is the number of consecutive spot allocation failures in a pool, after
+	// which runners are created as regular VMs. A value of 0 disables the fallback.
+	FallbackAfter int `json:"fallback_after"`
+	// FallbackCooldownMinutes is the time after the last spot allocation failure during
+	// which runners are created as regular VMs. Defaults to 15 minutes.
+	FallbackCooldownMinutes int `json:"fallback_cooldown_minutes"`
+	// RetryAsRegular creates the runner as a regular VM right away, when the spot VM
+	// can't be allocated.
+	RetryAsRegular bool `json:"retry_as_regular"`
+}
+
+func (s *SpotSettings) setDefaults() {
+	if s.MaxPrice == 0 {
+		s.MaxPrice = defaultSpotMaxPrice
+	}
+	if s.EvictionPolicy == "" {
+		s.EvictionPolicy = armcompute.VirtualMachineEvictionPolicyTypesDelete
+	}
+	if s.FallbackCooldownMinutes == 0 {
+		s.FallbackCooldownMinutes = defaultSpotFallbackCooldownMinutes
+	}
+}
+
+func (s SpotSettings) Validate() error {
+	if s.MaxPrice < 0 && s.MaxPrice != defaultSpotMaxPrice {
+		return fmt.Errorf("invalid max_price %v (must be positive, or -1)", s.MaxPrice)
+	}
+	switch s.EvictionPolicy {
+	case armcompute.VirtualMachineEvictionPolicyTypesDelete, armcompute.VirtualMachineEvictionPolicyTypesDeallocate:
+	default:
+		return fmt.Errorf("invalid eviction_policy: %s", s.EvictionPolicy)
+	}
+	if s.FallbackAfter < 0 {
+		return fmt.Errorf("invalid fallback_after: %d", s.FallbackAfter)
+	}
+	if s.FallbackCooldownMinutes < 0 {
+		return fmt.Errorf("invalid fallback_cooldown_minutes: %d", s.FallbackCooldownMinutes)
+	}
+	return nil
+}
+
+// UseSpot returns true if the runner is created as a spot VM.
+func (r RunnerSpec) UseSpot() bool {
+	return r.Spot != nil && !r.SpotFallback
+}
+
+// FallBackToRegular creates the runner of a spot pool as a regular VM.
+func (r *RunnerSpec) FallBackToRegular() {
+	r.SpotFallback = true
+	r.Tags[providerUtil.PriorityTagName] = to.Ptr(string(armcompute.VirtualMachinePriorityTypesRegular))
+}
+
+// setSpotProperties makes the VM a spot VM, if enabled.
+func (r RunnerSpec) setSpotProperties(properties *armcompute.VirtualMachineProperties) {
+	if !r.UseSpot() {
+		return
+	}
+	properties.Priority = to.Ptr(armcompute.VirtualMachinePriorityTypesSpot)
+	properties.EvictionPolicy = to.Ptr(r.Spot.EvictionPolicy)
+	properties.BillingProfile = &armcompute.BillingProfile{
+		MaxPrice: to.Ptr(r.Spot.MaxPrice),
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var subnetIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Network/virtualNetworks/[^/]+/subnets/[^/]+$`)
+
+// validateExistingSubnet checks the existing subnet runners are attached to. The provider
+// doesn't create a virtual network then, so settings that add subnets to it can't be used.
+func (r RunnerSpec) validateExistingSubnet() error {
+	if !subnetIDRegex.MatchString(r.SubnetID) {
+		return fmt.Errorf("invalid subnet ID %q", r.SubnetID)
+	}
+	if len(r.NetworkInterfaceIDs) > 0 {
+		return fmt.Errorf("subnet_id can't be used with network_interface_ids")
+	}
+	if len(r.ExtraSubnets) > 0 {
+		return fmt.Errorf("extra_subnets and the bastion subnet_cidr can't be used with subnet_id")
+	}
+	return nil
+}
+
+// validateNATGateway checks the settings of runners attached to the subnet of their pool
+// NAT gateway. The subnet is shared by all runners of the pool, so it has no room for the
+// subnets of a single runner.
+func (r RunnerSpec) validateNATGateway() error {
+	if len(r.NetworkInterfaceIDs) > 0 {
+		return fmt.Errorf("nat_gateway can't be used with network_interface_ids")
+	}
+	if len(r.ExtraSubnets) > 0 {
+		return fmt.Errorf("extra_subnets and the bastion subnet_cidr can't be used with nat_gateway")
+	}
+	return nil
+}
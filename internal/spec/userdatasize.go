@@ -0,0 +1,55 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// maxCustomDataSize is the largest custom data, before base64 encoding, Azure accepts for
+// each OS type. Larger custom data is rejected by ARM with a generic bad request error.
+var maxCustomDataSize = map[params.OSType]int{
+	params.Linux:   65535,
+	params.Windows: 65535,
+}
+
+// checkCustomDataSize returns an error if the encoded custom data is over the limit of
+// the OS type.
+func checkCustomDataSize(osType params.OSType, encoded string) error {
+	limit, ok := maxCustomDataSize[osType]
+	if !ok {
+		return nil
+	}
+	size := base64.StdEncoding.DecodedLen(len(encoded))
+	if size <= limit {
+		return nil
+	}
+	return fmt.Errorf(
+		"userdata too large by %d bytes (%d bytes, the %s limit is %d); trim the pre install scripts, certificates or other extra specs that add to it",
+		size-limit, size, osType, limit)
+}
+
+// validateUserDataSize composes the userdata and checks that it fits in the custom data
+// of the VM, so oversized userdata is reported before any resource is created.
+func (r RunnerSpec) validateUserDataSize() error {
+	customData, err := r.ComposeUserData()
+	if err != nil {
+		return fmt.Errorf("failed to compose userdata: %w", err)
+	}
+	return checkCustomDataSize(r.BootstrapParams.OSType, base64.StdEncoding.EncodeToString(customData))
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// WindowsContainerRuntime is the container engine installed on Windows runners.
+type WindowsContainerRuntime string
+
+const (
+	WindowsContainerRuntimeMirantis WindowsContainerRuntime = "mirantis"
+	WindowsContainerRuntimeDockerCE WindowsContainerRuntime = "docker-ce"
+
+	defaultWindowsDockerCEVersion = "27.3.1"
+
+	// windowsDockerGroup is allowed to use the docker engine. The runner service accounts
+	// are added to it.
+	windowsDockerGroup = "docker-users"
+	// windowsMirantisTemplate installs the Mirantis Container Runtime (formerly Docker EE)
+	// with the Mirantis install script.
+	windowsMirantisTemplate = `if (-not (Get-Service docker -ErrorAction SilentlyContinue)) {
+	Write-Output "installing the Mirantis Container Runtime"
+	$installer = "$env:TEMP\install-mcr.ps1"
+	Invoke-WebRequest -UseBasicParsing -OutFile $installer -Uri "https://get.mirantis.com/install.ps1"
+	& $installer %s
+	Remove-Item -Force $installer
+}
+`
+	// windowsDockerCETemplate installs the static docker binaries, and registers the
+	// engine as a service.
+	windowsDockerCETemplate = `if (-not (Get-Service docker -ErrorAction SilentlyContinue)) {
+	Write-Output "installing docker %[1]s"
+	$archive = "$env:TEMP\docker.zip"
+	Invoke-WebRequest -UseBasicParsing -OutFile $archive -Uri "https://download.docker.com/win/static/stable/x86_64/docker-%[1]s.zip"
+	Expand-Archive -Force -Path $archive -DestinationPath $env:ProgramFiles
+	Remove-Item -Force $archive
+	$machinePath = [Environment]::GetEnvironmentVariable("Path", "Machine")
+	[Environment]::SetEnvironmentVariable("Path", "$machinePath;$env:ProgramFiles\docker", "Machine")
+	& "$env:ProgramFiles\docker\dockerd.exe" --register-service
+	if ($LASTEXITCODE -ne 0) {
+		throw "failed to register the docker service"
+	}
+}
+$env:Path += ";$env:ProgramFiles\docker"
+`
+	// windowsDockerAccessTemplate lets the runner service accounts use the engine, and
+	// starts it.
+	windowsDockerAccessTemplate = `if (-not (Get-LocalGroup -Name %[1]s -ErrorAction SilentlyContinue)) {
+	New-LocalGroup -Name %[1]s | Out-Null
+}
+Add-LocalGroupMember -Group %[1]s -Member "NT AUTHORITY\NETWORK SERVICE" -ErrorAction SilentlyContinue
+$daemonConfig = "$env:ProgramData\docker\config\daemon.json"
+New-Item -ItemType Directory -Force -Path (Split-Path $daemonConfig) | Out-Null
+$config = @{}
+if (Test-Path $daemonConfig) {
+	(Get-Content -Raw $daemonConfig | ConvertFrom-Json).PSObject.Properties | ForEach-Object { $config[$_.Name] = $_.Value }
+}
+$config["group"] = %[1]s
+$config | ConvertTo-Json | Set-Content -Encoding ascii $daemonConfig
+Set-Service -Name docker -StartupType Automatic
+Restart-Service -Name docker
+`
+)
+
+var windowsDockerVersionRegex = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// WindowsContainers enables the Windows container features on a Windows runner, installs a
+// container engine, and reboots before the runner is installed.
+type WindowsContainers struct {
+	// Runtime is the container engine, mirantis (the default) or docker-ce.
+	Runtime WindowsContainerRuntime `json:"runtime"`
+	// DockerVersion is the version of the engine. Defaults to the latest Mirantis
+	// Container Runtime, or to 27.3.1 for docker-ce.
+	DockerVersion string `json:"docker_version"`
+	// HyperV enables the Hyper-V feature, for Hyper-V isolated containers. The VM size
+	// must support nested virtualization.
+	HyperV bool `json:"hyperv"`
+}
+
+func (w *WindowsContainers) setDefaults() {
+	if w.Runtime == "" {
+		w.Runtime = WindowsContainerRuntimeMirantis
+	}
+}
+
+func (w WindowsContainers) Validate(vmSize string) error {
+	switch w.Runtime {
+	case WindowsContainerRuntimeMirantis, WindowsContainerRuntimeDockerCE:
+	default:
+		return fmt.Errorf("invalid runtime %q (expected %s or %s)", w.Runtime, WindowsContainerRuntimeMirantis, WindowsContainerRuntimeDockerCE)
+	}
+	if w.DockerVersion != "" && !windowsDockerVersionRegex.MatchString(w.DockerVersion) {
+		return fmt.Errorf("invalid docker_version %q", w.DockerVersion)
+	}
+	if w.HyperV && !nestedVirtSizeRegex.MatchString(vmSize) {
+		return fmt.Errorf("VM size %s does not support nested virtualization, which Hyper-V needs", vmSize)
+	}
+	return nil
+}
+
+// features returns the Windows features the containers need.
+func (w WindowsContainers) features() []string {
+	features := []string{"Containers"}
+	if w.HyperV {
+		features = append(features, "Hyper-V")
+	}
+	return features
+}
+
+// installScript returns the setup step that installs the container engine.
+func (w WindowsContainers) installScript() string {
+	var script string
+	switch w.Runtime {
+	case WindowsContainerRuntimeDockerCE:
+		version := w.DockerVersion
+		if version == "" {
+			version = defaultWindowsDockerCEVersion
+		}
+		script = fmt.Sprintf(windowsDockerCETemplate, version)
+	default:
+		var args string
+		if w.DockerVersion != "" {
+			args = "-DockerVersion " + powerShellQuote(w.DockerVersion)
+		}
+		script = fmt.Sprintf(windowsMirantisTemplate, args)
+	}
+	return script + fmt.Sprintf(windowsDockerAccessTemplate, powerShellQuote(windowsDockerGroup))
+}
+
+func (r RunnerSpec) validateWindowsContainers() error {
+	if r.WindowsContainers == nil {
+		return nil
+	}
+	if r.BootstrapParams.OSType != params.Windows {
+		return fmt.Errorf("windows_containers is only supported on windows")
+	}
+	if err := r.WindowsContainers.Validate(r.VMSize); err != nil {
+		return fmt.Errorf("invalid windows_containers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	// windowsBootstrapTemplate wraps the runner install script of Windows runners that need
+	// setup steps first. The steps enable Windows features, and set $RebootRequired when
+	// the features need a reboot. In that case the script reboots, and runs again from a
+	// scheduled task at startup, before the runner is installed.
+	windowsBootstrapTemplate = `#ps1_sysnative
+$ErrorActionPreference = "Stop"
+$GarmDir = "C:\garm"
+$BootstrapScript = "$GarmDir\bootstrap.ps1"
+$BootstrapTask = "garm-bootstrap"
+New-Item -ItemType Directory -Force -Path $GarmDir | Out-Null
+if ($PSCommandPath -ne $BootstrapScript) {
+	Copy-Item -Force $PSCommandPath $BootstrapScript
+}
+
+$RebootRequired = $false
+%[1]s
+if ($RebootRequired) {
+	Write-Output "rebooting to finish the setup, the runner is installed after the reboot"
+	$action = New-ScheduledTaskAction -Execute "powershell.exe" -Argument "-NonInteractive -ExecutionPolicy Bypass -File $BootstrapScript"
+	$trigger = New-ScheduledTaskTrigger -AtStartup
+	Register-ScheduledTask -Force -TaskName $BootstrapTask -Action $action -Trigger $trigger -User "SYSTEM" -RunLevel Highest | Out-Null
+	shutdown.exe /r /t 15 /c "finishing the garm runner setup"
+	exit 0
+}
+Unregister-ScheduledTask -TaskName $BootstrapTask -Confirm:$false -ErrorAction SilentlyContinue
+%[2]s
+$InstallScript = "$GarmDir\install-runner.ps1"
+[IO.File]::WriteAllBytes($InstallScript, [Convert]::FromBase64String("%[3]s"))
+try {
+	& $InstallScript
+} finally {
+	Remove-Item -Force -ErrorAction SilentlyContinue $InstallScript, $BootstrapScript
+}
+`
+	// windowsFeaturesTemplate installs Windows features, and records whether a reboot is
+	// needed to finish installing them.
+	windowsFeaturesTemplate = `foreach ($feature in @(%s)) {
+	if ((Get-WindowsFeature -Name $feature).InstallState -ne "Installed") {
+		Write-Output "installing the $feature feature"
+		$result = Install-WindowsFeature -Name $feature -IncludeManagementTools
+		if (-not $result.Success) {
+			throw "failed to install the $feature feature"
+		}
+		if ($result.RestartNeeded -eq "Yes") {
+			$RebootRequired = $true
+		}
+	}
+}
+`
+)
+
+// windowsBootstrap holds the setup steps of a Windows runner, run before the runner
+// install script.
+type windowsBootstrap struct {
+	// features are the Windows features to install, before a reboot if they need one.
+	features []string
+	// steps run once the features are installed.
+	steps []string
+}
+
+func (w windowsBootstrap) empty() bool {
+	return len(w.features) == 0 && len(w.steps) == 0
+}
+
+// wrap returns the install script wrapped with the setup steps.
+func (w windowsBootstrap) wrap(installScript []byte) []byte {
+	var features string
+	if len(w.features) > 0 {
+		quoted := make([]string, 0, len(w.features))
+		for _, feature := range w.features {
+			quoted = append(quoted, powerShellQuote(feature))
+		}
+		features = fmt.Sprintf(windowsFeaturesTemplate, strings.Join(quoted, ", "))
+	}
+	return []byte(fmt.Sprintf(
+		windowsBootstrapTemplate,
+		features,
+		strings.Join(w.steps, "\n"),
+		base64.StdEncoding.EncodeToString(installScript)))
+}
+
+// windowsBootstrap returns the setup steps of the Windows runner.
+func (r RunnerSpec) windowsBootstrap() windowsBootstrap {
+	var w windowsBootstrap
+	if r.WindowsContainers != nil {
+		w.features = append(w.features, r.WindowsContainers.features()...)
+		w.steps = append(w.steps, r.WindowsContainers.installScript())
+	}
+	if r.WSL != nil {
+		w.features = append(w.features, r.WSL.features()...)
+		w.steps = append(w.steps, r.WSL.installScript())
+	}
+	return w
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+const (
+	defaultWSLDistro = "Ubuntu-22.04"
+
+	// windowsWSLTemplate updates WSL, and imports the distribution from its root filesystem.
+	// Distributions are registered per user, and the bootstrap runs as SYSTEM, which is also
+	// the account of the runner service.
+	windowsWSLTemplate = `$WslDistro = %[1]s
+$WslRootfsURL = %[2]s
+Write-Output "updating WSL"
+wsl.exe --update --web-download
+if ($LASTEXITCODE -ne 0) {
+	throw "failed to update WSL"
+}
+wsl.exe --set-default-version 2 | Out-Null
+$installed = (wsl.exe --list --quiet) -replace [char]0, "" | Where-Object { $_ -eq $WslDistro }
+if (-not $installed) {
+	Write-Output "importing the $WslDistro WSL distribution"
+	$rootfs = "$env:TEMP\wsl-rootfs.tar.gz"
+	Invoke-WebRequest -UseBasicParsing -OutFile $rootfs -Uri $WslRootfsURL
+	wsl.exe --import $WslDistro "C:\wsl\$WslDistro" $rootfs --version 2
+	if ($LASTEXITCODE -ne 0) {
+		throw "failed to import the $WslDistro WSL distribution"
+	}
+	Remove-Item -Force $rootfs
+}
+wsl.exe --set-default $WslDistro
+wsl.exe --distribution $WslDistro --user root -- uname -a
+if ($LASTEXITCODE -ne 0) {
+	throw "failed to start the $WslDistro WSL distribution"
+}
+`
+)
+
+var (
+	// wslRootfsURLs are the root filesystems of the distributions that don't need a
+	// rootfs_url.
+	wslRootfsURLs = map[string]string{
+		"Ubuntu-22.04": "https://cloud-images.ubuntu.com/wsl/jammy/current/ubuntu-jammy-wsl-amd64-wsl.rootfs.tar.gz",
+		"Ubuntu-24.04": "https://cloud-images.ubuntu.com/wsl/noble/current/ubuntu-noble-wsl-amd64-wsl.rootfs.tar.gz",
+	}
+	wslDistroRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// WSL installs WSL2 and a Linux distribution on a Windows runner.
+type WSL struct {
+	// Distro is the name the distribution is registered with. Defaults to Ubuntu-22.04.
+	Distro string `json:"distro"`
+	// RootfsURL is the URL of the root filesystem tarball of the distribution. Only needed
+	// for distributions other than Ubuntu-22.04 and Ubuntu-24.04.
+	RootfsURL string `json:"rootfs_url"`
+}
+
+func (w *WSL) setDefaults() {
+	if w.Distro == "" {
+		w.Distro = defaultWSLDistro
+	}
+	if w.RootfsURL == "" {
+		w.RootfsURL = wslRootfsURLs[w.Distro]
+	}
+}
+
+func (w WSL) Validate(vmSize string) error {
+	if !wslDistroRegex.MatchString(w.Distro) {
+		return fmt.Errorf("invalid distro %q", w.Distro)
+	}
+	if w.RootfsURL == "" {
+		return fmt.Errorf("rootfs_url is needed for the %s distro", w.Distro)
+	}
+	if parsed, err := url.Parse(w.RootfsURL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("invalid rootfs_url %q (expected an https URL)", w.RootfsURL)
+	}
+	if !nestedVirtSizeRegex.MatchString(vmSize) {
+		return fmt.Errorf("VM size %s does not support nested virtualization, which WSL2 needs", vmSize)
+	}
+	return nil
+}
+
+// features returns the Windows features WSL2 needs.
+func (w WSL) features() []string {
+	return []string{"Microsoft-Windows-Subsystem-Linux", "VirtualMachinePlatform"}
+}
+
+// installScript returns the setup step that installs the distribution.
+func (w WSL) installScript() string {
+	return fmt.Sprintf(windowsWSLTemplate, powerShellQuote(w.Distro), powerShellQuote(w.RootfsURL))
+}
+
+func (r RunnerSpec) validateWSL() error {
+	if r.WSL == nil {
+		return nil
+	}
+	if r.BootstrapParams.OSType != params.Windows {
+		return fmt.Errorf("wsl is only supported on windows")
+	}
+	// The distribution is only registered for SYSTEM, which runs JIT configured runners.
+	if !r.BootstrapParams.JitConfigEnabled {
+		return fmt.Errorf("wsl needs garm to use JIT runner configuration")
+	}
+	if err := r.WSL.Validate(r.VMSize); err != nil {
+		return fmt.Errorf("invalid wsl settings: %w", err)
+	}
+	return nil
+}
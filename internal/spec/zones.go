@@ -0,0 +1,69 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package spec
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	providerUtil "github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// SetZone places the runner in the availability zone, and tags it accordingly.
+func (r *RunnerSpec) SetZone(zone string) {
+	r.Zone = zone
+	r.Tags[providerUtil.ZoneTagName] = to.Ptr(zone)
+}
+
+// RemoveZones drops zones from the allowed zones.
+func (r *RunnerSpec) RemoveZones(zones []string) {
+	var allowed []string
+	for _, zone := range r.Zones {
+		removed := false
+		for _, candidate := range zones {
+			if candidate == zone {
+				removed = true
+			}
+		}
+		if !removed {
+			allowed = append(allowed, zone)
+		}
+	}
+	r.Zones = allowed
+}
+
+// RandomZone returns one of the allowed zones, picked at random. Each operation runs in
+// its own process, so the source is seeded on every call.
+func (r RunnerSpec) RandomZone() string {
+	if len(r.Zones) == 0 {
+		return ""
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano())) //nolint:gosec
+	return r.Zones[rnd.Intn(len(r.Zones))]
+}
+
+// LeastUsedZone returns the allowed zone with the fewest runners, given the number of
+// runners per zone. Ties go to the zone listed first.
+func (r RunnerSpec) LeastUsedZone(counts map[string]int) string {
+	var ret string
+	for _, zone := range r.Zones {
+		if ret == "" || counts[zone] < counts[ret] {
+			ret = zone
+		}
+	}
+	return ret
+}
@@ -0,0 +1,219 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+// Package usage aggregates the lifetimes of runners into a usage report.
+package usage
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	vmWriteOperation  = "Microsoft.Compute/virtualMachines/write"
+	vmDeleteOperation = "Microsoft.Compute/virtualMachines/delete"
+	rgDeleteOperation = "Microsoft.Resources/subscriptions/resourceGroups/delete"
+	succeededStatus   = "Succeeded"
+)
+
+// Instance is the lifetime of a runner. A zero Start means the runner was created before
+// the report period, and a zero End that it still exists.
+type Instance struct {
+	Name         string
+	ControllerID string
+	PoolID       string
+	VMSize       string
+	Spot         bool
+	Start        time.Time
+	End          time.Time
+}
+
+// vmRequest holds the fields we use from the request body of a VM write.
+type vmRequest struct {
+	Tags       map[string]string `json:"tags"`
+	Properties struct {
+		Priority        string `json:"priority"`
+		HardwareProfile struct {
+			VMSize string `json:"vmSize"`
+		} `json:"hardwareProfile"`
+	} `json:"properties"`
+}
+
+func (i *Instance) fill(controllerID, poolID, vmSize, priority string) {
+	if i.ControllerID == "" {
+		i.ControllerID = controllerID
+	}
+	if i.PoolID == "" {
+		i.PoolID = poolID
+	}
+	if i.VMSize == "" {
+		i.VMSize = vmSize
+	}
+	if strings.EqualFold(priority, string(armcompute.VirtualMachinePriorityTypesSpot)) {
+		i.Spot = true
+	}
+}
+
+// Instances returns the runners of a controller, from the activity log and the VMs that
+// still exist. Activity log events are only reliable for runners created by this provider,
+// as the pool and size of deleted VMs are read from the body of their create request.
+func Instances(controllerID string, events []client.ActivityLogEvent, vms []*armcompute.VirtualMachine) []Instance {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+
+	instances := map[string]*Instance{}
+	get := func(name string) *Instance {
+		key := strings.ToLower(name)
+		if _, ok := instances[key]; !ok {
+			instances[key] = &Instance{Name: name}
+		}
+		return instances[key]
+	}
+
+	for _, event := range events {
+		if !strings.EqualFold(event.Status.Value, succeededStatus) || event.ResourceID == "" {
+			continue
+		}
+		name := path.Base(event.ResourceID)
+		switch {
+		case strings.EqualFold(event.Operation.Value, vmWriteOperation):
+			instance := get(name)
+			if instance.Start.IsZero() && instance.End.IsZero() {
+				instance.Start = event.Timestamp
+			}
+			body, _ := event.Properties["requestbody"].(string)
+			var req vmRequest
+			if body != "" && json.Unmarshal([]byte(body), &req) == nil {
+				instance.fill(req.Tags[util.ControllerIDTagName], req.Tags[util.PoolIDTagName], req.Properties.HardwareProfile.VMSize, req.Properties.Priority)
+			}
+		case strings.EqualFold(event.Operation.Value, vmDeleteOperation), strings.EqualFold(event.Operation.Value, rgDeleteOperation):
+			instance := get(name)
+			if instance.End.IsZero() {
+				instance.End = event.Timestamp
+			}
+		}
+	}
+
+	for _, vm := range vms {
+		if vm.Name == nil {
+			continue
+		}
+		instance := get(*vm.Name)
+		var vmSize, priority string
+		if vm.Properties != nil {
+			if vm.Properties.HardwareProfile != nil && vm.Properties.HardwareProfile.VMSize != nil {
+				vmSize = string(*vm.Properties.HardwareProfile.VMSize)
+			}
+			if vm.Properties.Priority != nil {
+				priority = string(*vm.Properties.Priority)
+			}
+		}
+		instance.fill(tagValue(vm.Tags, util.ControllerIDTagName), tagValue(vm.Tags, util.PoolIDTagName), vmSize, priority)
+		// The VM still exists, so any delete event was for a previous runner with the
+		// same name.
+		instance.End = time.Time{}
+	}
+
+	var ret []Instance
+	for _, instance := range instances {
+		if instance.ControllerID == controllerID {
+			ret = append(ret, *instance)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+func tagValue(tags map[string]*string, name string) string {
+	if value, ok := tags[name]; ok && value != nil {
+		return *value
+	}
+	return ""
+}
+
+// PoolUsage is the usage of a pool over the report period.
+type PoolUsage struct {
+	PoolID        string             `json:"pool_id"`
+	Instances     int                `json:"instances"`
+	InstanceHours float64            `json:"instance_hours"`
+	SpotHours     float64            `json:"spot_hours"`
+	SizeHours     map[string]float64 `json:"size_hours"`
+}
+
+// SpotRatio returns the share of the instance hours spent on spot VMs.
+func (p PoolUsage) SpotRatio() float64 {
+	if p.InstanceHours == 0 {
+		return 0
+	}
+	return p.SpotHours / p.InstanceHours
+}
+
+// MarshalJSON adds the spot ratio to the JSON report.
+func (p PoolUsage) MarshalJSON() ([]byte, error) {
+	type poolUsage PoolUsage
+	return json.Marshal(struct {
+		poolUsage
+		SpotRatio float64 `json:"spot_ratio"`
+	}{poolUsage(p), p.SpotRatio()})
+}
+
+// Report aggregates the lifetimes of the instances between from and to, per pool.
+func Report(instances []Instance, from, to time.Time) []PoolUsage {
+	pools := map[string]*PoolUsage{}
+	for _, instance := range instances {
+		start, end := instance.Start, instance.End
+		if start.IsZero() || start.Before(from) {
+			start = from
+		}
+		if end.IsZero() || end.After(to) {
+			end = to
+		}
+		if !end.After(start) {
+			continue
+		}
+		hours := end.Sub(start).Hours()
+
+		pool, ok := pools[instance.PoolID]
+		if !ok {
+			pool = &PoolUsage{PoolID: instance.PoolID, SizeHours: map[string]float64{}}
+			pools[instance.PoolID] = pool
+		}
+		pool.Instances++
+		pool.InstanceHours += hours
+		if instance.Spot {
+			pool.SpotHours += hours
+		}
+		pool.SizeHours[instance.VMSize] += hours
+	}
+
+	ret := make([]PoolUsage, 0, len(pools))
+	for _, pool := range pools {
+		ret = append(ret, *pool)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].PoolID < ret[j].PoolID
+	})
+	return ret
+}
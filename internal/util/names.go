@@ -0,0 +1,93 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+const (
+	// maxResourceNameLength is the longest name all the resources of a runner accept. VM
+	// names are limited to 64 characters.
+	maxResourceNameLength = 64
+	// resourceNameHashLength is the length of the hash suffix of normalized names.
+	resourceNameHashLength = 8
+	// maxComputerNameLength is the longest computer name Windows accepts.
+	maxComputerNameLength = 15
+	// computerNameHashLength is the length of the hash suffix of shortened computer names.
+	computerNameHashLength = 5
+)
+
+var (
+	validResourceNameRegex   = regexp.MustCompile(`^[a-zA-Z0-9_]([a-zA-Z0-9_.-]*[a-zA-Z0-9_])?$`)
+	invalidResourceCharRegex = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+	validComputerNameRegex   = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	invalidComputerCharRegex = regexp.MustCompile(`[^a-zA-Z0-9-]`)
+	numericRegex             = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// nameHash returns the first length hex characters of the SHA256 of a name.
+func nameHash(name string, length int) string {
+	checksum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(checksum[:])[:length]
+}
+
+// AzureResourceName returns a name the resources of a runner can use. Names that are too
+// long, or hold characters Azure does not allow, are sanitized, shortened and suffixed with
+// a hash of the original name, so they remain unique. Valid names are returned unchanged,
+// so normalizing a name twice returns the same result.
+func AzureResourceName(name string) string {
+	if len(name) <= maxResourceNameLength && validResourceNameRegex.MatchString(name) {
+		return name
+	}
+
+	suffix := nameHash(name, resourceNameHashLength)
+
+	sanitized := invalidResourceCharRegex.ReplaceAllString(name, "-")
+	maxPrefix := maxResourceNameLength - resourceNameHashLength - 1
+	if len(sanitized) > maxPrefix {
+		sanitized = sanitized[:maxPrefix]
+	}
+	sanitized = strings.Trim(sanitized, "-.")
+	if sanitized == "" {
+		return "garm-" + suffix
+	}
+	return sanitized + "-" + suffix
+}
+
+// ComputerName returns the computer name of a runner VM. Windows limits computer names to
+// 15 letters, digits and hyphens, which can't all be digits or end with a hyphen. Names
+// that don't fit are sanitized, shortened and suffixed with a hash of the full name, so
+// runners whose names share a prefix still get different computer names.
+func ComputerName(name string) string {
+	if len(name) <= maxComputerNameLength && validComputerNameRegex.MatchString(name) && !numericRegex.MatchString(name) {
+		return name
+	}
+
+	suffix := nameHash(name, computerNameHashLength)
+	sanitized := invalidComputerCharRegex.ReplaceAllString(name, "-")
+	maxPrefix := maxComputerNameLength - computerNameHashLength - 1
+	if len(sanitized) > maxPrefix {
+		sanitized = sanitized[:maxPrefix]
+	}
+	sanitized = strings.Trim(sanitized, "-")
+	if sanitized == "" {
+		return "garm-" + suffix
+	}
+	return sanitized + "-" + suffix
+}
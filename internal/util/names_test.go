@@ -0,0 +1,115 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAzureResourceName(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		unchanged bool
+	}{
+		{name: "valid name", input: "garm-abcdef012345", unchanged: true},
+		{name: "longest valid name", input: strings.Repeat("a", maxResourceNameLength), unchanged: true},
+		{name: "dots and underscores", input: "garm_runner.1", unchanged: true},
+		{name: "too long", input: strings.Repeat("a", maxResourceNameLength+1)},
+		{name: "invalid characters", input: "garm runner/1"},
+		{name: "trailing dot", input: "garm-runner."},
+		{name: "leading hyphen", input: "-garm-runner"},
+		{name: "only invalid characters", input: "///"},
+		{name: "too long with invalid characters", input: strings.Repeat("a b", 40)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AzureResourceName(tt.input)
+			if tt.unchanged && got != tt.input {
+				t.Fatalf("AzureResourceName(%q) = %q, want it unchanged", tt.input, got)
+			}
+			if !tt.unchanged && got == tt.input {
+				t.Fatalf("AzureResourceName(%q) returned the name unchanged", tt.input)
+			}
+			if len(got) > maxResourceNameLength {
+				t.Fatalf("AzureResourceName(%q) = %q, longer than %d characters", tt.input, got, maxResourceNameLength)
+			}
+			if !validResourceNameRegex.MatchString(got) {
+				t.Fatalf("AzureResourceName(%q) = %q, which is not a valid resource name", tt.input, got)
+			}
+			if again := AzureResourceName(got); again != got {
+				t.Fatalf("AzureResourceName(%q) = %q, want the normalized name unchanged", got, again)
+			}
+		})
+	}
+}
+
+func TestAzureResourceNameUnique(t *testing.T) {
+	prefix := strings.Repeat("a", maxResourceNameLength)
+	first := AzureResourceName(prefix + "-1")
+	second := AzureResourceName(prefix + "-2")
+	if first == second {
+		t.Fatalf("names sharing a long prefix were both normalized to %q", first)
+	}
+}
+
+func TestComputerName(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		unchanged bool
+	}{
+		{name: "valid name", input: "garm-runner-1", unchanged: true},
+		{name: "longest valid name", input: strings.Repeat("a", maxComputerNameLength), unchanged: true},
+		{name: "numbers and letters", input: "123abc", unchanged: true},
+		{name: "too long", input: "garm-abcdef0123456789"},
+		{name: "all numeric", input: "123456"},
+		{name: "all numeric and too long", input: strings.Repeat("1", maxComputerNameLength+1)},
+		{name: "invalid characters", input: "garm_runner.1"},
+		{name: "trailing hyphen", input: "garm-runner-"},
+		{name: "only invalid characters", input: "___"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputerName(tt.input)
+			if tt.unchanged && got != tt.input {
+				t.Fatalf("ComputerName(%q) = %q, want it unchanged", tt.input, got)
+			}
+			if !tt.unchanged && got == tt.input {
+				t.Fatalf("ComputerName(%q) returned the name unchanged", tt.input)
+			}
+			if len(got) > maxComputerNameLength {
+				t.Fatalf("ComputerName(%q) = %q, longer than %d characters", tt.input, got, maxComputerNameLength)
+			}
+			if !validComputerNameRegex.MatchString(got) || numericRegex.MatchString(got) {
+				t.Fatalf("ComputerName(%q) = %q, which is not a valid computer name", tt.input, got)
+			}
+			if again := ComputerName(got); again != got {
+				t.Fatalf("ComputerName(%q) = %q, want the shortened name unchanged", got, again)
+			}
+		})
+	}
+}
+
+func TestComputerNameUnique(t *testing.T) {
+	first := ComputerName("garm-runner-pool-1")
+	second := ComputerName("garm-runner-pool-2")
+	if first == second {
+		t.Fatalf("names sharing a long prefix were both shortened to %q", first)
+	}
+}
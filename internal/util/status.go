@@ -0,0 +1,75 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const statusUpdateTimeout = 10 * time.Second
+
+type statusUpdate struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// SendStatusUpdate posts an "installing" status message to the garm instance callback
+// URL, using the instance token. This is the same call the runner install script makes,
+// and the message will show up in the instance status updates.
+func SendStatusUpdate(ctx context.Context, callbackURL, token, message string) error {
+	if callbackURL == "" || token == "" {
+		return fmt.Errorf("missing callback URL or token")
+	}
+
+	if !strings.HasSuffix(strings.TrimSuffix(callbackURL, "/"), "/status") {
+		callbackURL = strings.TrimSuffix(callbackURL, "/") + "/status"
+	}
+
+	body, err := json.Marshal(statusUpdate{
+		Status:  "installing",
+		Message: message,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal status update: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, statusUpdateTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send status update: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to send status update: unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
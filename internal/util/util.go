@@ -18,7 +18,11 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
@@ -30,6 +34,121 @@ import (
 const (
 	ControllerIDTagName = "garm-controller-id"
 	PoolIDTagName       = "garm-pool-id"
+	// LabelsTagName, RepoURLTagName and OwnerTagName hold the runner labels and the github
+	// entity the runner is registered to, so spend can be attributed to teams and repos.
+	LabelsTagName  = "garm-labels"
+	RepoURLTagName = "garm-repo-url"
+	OwnerTagName   = "garm-owner"
+	// ProviderVersionTagName holds the version of the provider build that created a
+	// runner.
+	ProviderVersionTagName = "garm-provider-version"
+
+	// MaxTagValueLength is the maximum length of an Azure tag value.
+	MaxTagValueLength = 256
+	// CloudInitStatusTagName holds the last known cloud-init status of a runner
+	// that has the cloud-init status check enabled.
+	CloudInitStatusTagName = "garm-cloud-init-status"
+
+	// EphemeralDiskSizeTagName holds the ephemeral OS disk size that was automatically
+	// chosen for a runner.
+	EphemeralDiskSizeTagName = "garm-ephemeral-disk-size-gb"
+
+	// InstanceNameTagName holds the garm name of runners whose name had to be normalized to
+	// fit the Azure naming rules.
+	InstanceNameTagName = "garm-instance-name"
+
+	// EphemeralFallbackTagName marks runners that were created with a managed OS disk,
+	// because their VM size could not hold the requested ephemeral OS disk.
+	EphemeralFallbackTagName = "garm-ephemeral-fallback"
+
+	// ZoneTagName holds the availability zone a runner was placed in.
+	ZoneTagName = "garm-zone"
+
+	// VMSizeTagName holds the VM size of a runner.
+	VMSizeTagName = "garm-vm-size"
+
+	// ChaosTargetTagName marks runners that are enrolled as Chaos Studio targets, so
+	// experiments can select them with a query.
+	ChaosTargetTagName = "garm-chaos-target"
+
+	// PriorityTagName holds the priority (Spot or Regular) of runners in spot pools.
+	PriorityTagName = "garm-priority"
+
+	// EgressProfileTagName holds the egress profile of a runner, so its security rules can
+	// be updated when the profile changes.
+	EgressProfileTagName = "garm-egress-profile"
+
+	// SelfTerminateTagName marks runners that power themselves off once their job is done.
+	// The provider deletes these runners when it finds them stopped.
+	SelfTerminateTagName = "garm-self-terminate"
+
+	// HeartbeatTimeoutTagName holds the number of minutes without a heartbeat after which a
+	// runner is considered hung. The runner records its heartbeats in LastHeartbeatTagName.
+	HeartbeatTimeoutTagName = "garm-heartbeat-timeout"
+	LastHeartbeatTagName    = "garm-last-heartbeat"
+
+	// NICClaimedByTagName and NICClaimedAtTagName mark a pre-created NIC as taken by a
+	// runner that is being created, so concurrent creates pick different NICs.
+	NICClaimedByTagName = "garm-claimed-by"
+	NICClaimedAtTagName = "garm-claimed-at"
+
+	// SecurityPresetTagName holds the security preset a runner was created with.
+	SecurityPresetTagName = "garm-security-preset"
+
+	// SecureWipeTagName marks runners whose sensitive paths are wiped before they are
+	// deleted.
+	SecureWipeTagName = "garm-secure-wipe"
+
+	// HourlyPriceTagName holds the configured hourly price of the VM size of a runner.
+	HourlyPriceTagName = "garm-hourly-price"
+	// AgeHoursTagName and CostToDateTagName annotate runners with their age, and the cost
+	// of that age at the hourly price. CostAnnotatedAtTagName holds when they were last
+	// updated.
+	AgeHoursTagName        = "garm-age-hours"
+	CostToDateTagName      = "garm-cost-to-date"
+	CostAnnotatedAtTagName = "garm-cost-annotated-at"
+
+	// DiskPressureTagName holds the highest disk usage threshold a runner crossed, and
+	// DiskUsageTagName and DiskPressureAtTagName the usage and time it was crossed at. They
+	// are set by the runner itself. DiskPressureLoggedTagName holds the threshold the
+	// provider last logged.
+	DiskPressureTagName       = "garm-disk-pressure"
+	DiskUsageTagName          = "garm-disk-usage"
+	DiskPressureAtTagName     = "garm-disk-pressure-at"
+	DiskPressureLoggedTagName = "garm-disk-pressure-logged"
+
+	// BurstableTagName marks runners on burstable (B-series) VM sizes.
+	BurstableTagName = "garm-burstable"
+
+	// RegionTagName holds the region a runner was created in, when runners are distributed
+	// across several regions.
+	RegionTagName = "garm-region"
+
+	// HostIdleSinceTagName holds the time a dedicated host was first seen without runners.
+	HostIdleSinceTagName = "garm-idle-since"
+
+	// CreateStatusTagName holds the state of an asynchronous create on the resource group
+	// of a runner, and CreateErrorTagName the reason it failed.
+	CreateStatusTagName = "garm-create-status"
+	CreateErrorTagName  = "garm-create-error"
+
+	CreateStatusProvisioning = "provisioning"
+	CreateStatusDone         = "done"
+	CreateStatusFailed       = "failed"
+
+	// InstanceStateTagName and InstanceStateSinceTagName record the lifecycle state of an
+	// instance on its resource group, so interrupted creates and deletes can be resumed or
+	// rolled back by a later provider run.
+	InstanceStateTagName      = "garm-state"
+	InstanceStateSinceTagName = "garm-state-since"
+
+	InstanceStateCreating = "creating"
+	InstanceStateCreated  = "created"
+	InstanceStateDeleting = "deleting"
+
+	CloudInitStatusPending = "pending"
+	CloudInitStatusDone    = "done"
+	CloudInitStatusError   = "error"
 )
 
 var (
@@ -54,28 +173,100 @@ var (
 )
 
 func TagsFromBootstrapParams(bootstrapParams params.BootstrapInstance, controllerID string) (map[string]*string, error) {
-	ImageDetails, err := URNToImageDetails(bootstrapParams.Image)
+	ImageDetails, err := ImageToImageDetails(bootstrapParams.Image)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse image: %w", err)
 	}
 
 	ret := map[string]*string{
-		"os_arch":           to.Ptr(string(bootstrapParams.OSArch)),
-		"os_version":        to.Ptr(ImageDetails.Version),
-		"os_name":           to.Ptr(ImageDetails.SKU),
-		"os_type":           to.Ptr(string(bootstrapParams.OSType)),
-		PoolIDTagName:       to.Ptr(bootstrapParams.PoolID),
-		ControllerIDTagName: to.Ptr(controllerID),
+		"os_arch":              to.Ptr(string(bootstrapParams.OSArch)),
+		"os_version":           to.Ptr(ImageDetails.Version),
+		"os_name":              to.Ptr(ImageDetails.SKU),
+		"os_type":              to.Ptr(string(bootstrapParams.OSType)),
+		PoolIDTagName:          to.Ptr(bootstrapParams.PoolID),
+		ControllerIDTagName:    to.Ptr(controllerID),
+		ProviderVersionTagName: to.Ptr(TruncateTagValue(ProviderVersion())),
+	}
+
+	if len(bootstrapParams.Labels) > 0 {
+		ret[LabelsTagName] = to.Ptr(TruncateTagValue(strings.Join(bootstrapParams.Labels, ",")))
+	}
+
+	if bootstrapParams.RepoURL != "" {
+		ret[RepoURLTagName] = to.Ptr(TruncateTagValue(bootstrapParams.RepoURL))
+		if owner := ownerFromRepoURL(bootstrapParams.RepoURL); owner != "" {
+			ret[OwnerTagName] = to.Ptr(TruncateTagValue(owner))
+		}
 	}
 
 	return ret, nil
 }
 
+// ownerFromRepoURL returns the organization or user a repo URL belongs to, or the
+// enterprise, for enterprise URLs.
+func ownerFromRepoURL(repoURL string) string {
+	parsed, err := url.Parse(repoURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "enterprises" {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+// SameRegion compares region names, which Azure returns in lower case, without spaces, or
+// as display names.
+func SameRegion(a, b string) bool {
+	normalize := func(name string) string {
+		return strings.ToLower(strings.ReplaceAll(name, " ", ""))
+	}
+	return normalize(a) == normalize(b)
+}
+
+// TruncateTagValue shortens val to the maximum length of a tag value.
+func TruncateTagValue(val string) string {
+	if len(val) > MaxTagValueLength {
+		return val[:MaxTagValueLength]
+	}
+	return val
+}
+
 type ImageDetails struct {
 	Offer     string
 	Publisher string
 	SKU       string
 	Version   string
+	// ID is the resource ID of an Azure Compute Gallery image, or image version. The
+	// gallery and image definition names are also set as the Offer and SKU.
+	ID string
+}
+
+// galleryImageIDRegex matches the resource IDs of gallery images and image versions.
+var galleryImageIDRegex = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.Compute/galleries/([^/]+)/images/([^/]+)(/versions/([^/]+))?$`)
+
+// ImageToImageDetails parses the image of a pool, which is either a marketplace image URN
+// or the resource ID of a gallery image or image version. Gallery images without a version
+// use their latest version.
+func ImageToImageDetails(image string) (ImageDetails, error) {
+	if !strings.HasPrefix(image, "/") {
+		return URNToImageDetails(image)
+	}
+	match := galleryImageIDRegex.FindStringSubmatch(image)
+	if match == nil {
+		return ImageDetails{}, fmt.Errorf("invalid gallery image ID: %s", image)
+	}
+	version := match[4]
+	if version == "" {
+		version = "latest"
+	}
+	return ImageDetails{
+		Offer:   match[1],
+		SKU:     match[2],
+		Version: version,
+		ID:      image,
+	}, nil
 }
 
 func URNToImageDetails(urn string) (ImageDetails, error) {
@@ -93,6 +284,14 @@ func URNToImageDetails(urn string) (ImageDetails, error) {
 	}, nil
 }
 
+// URN returns the marketplace image URN of the image.
+func (i ImageDetails) URN() string {
+	if i.ID != "" {
+		return i.ID
+	}
+	return strings.Join([]string{i.Publisher, i.Offer, i.SKU, i.Version}, ":")
+}
+
 func AzurePowerStateToGarmPowerState(vm armcompute.VirtualMachine) string {
 	if vm.Properties != nil && vm.Properties.InstanceView != nil && vm.Properties.InstanceView.Statuses != nil {
 		for _, val := range vm.Properties.InstanceView.Statuses {
@@ -134,9 +333,13 @@ func AzureInstanceToParamsInstance(vm armcompute.VirtualMachine) (params.Provide
 	if !ok {
 		return params.ProviderInstance{}, fmt.Errorf("missing os_name tag in VM")
 	}
+	name := *vm.Name
+	if instanceName, ok := vm.Tags[InstanceNameTagName]; ok && instanceName != nil {
+		name = *instanceName
+	}
 	return params.ProviderInstance{
 		ProviderID: *vm.Name,
-		Name:       *vm.Name,
+		Name:       name,
 		OSType:     params.OSType(*os_type),
 		OSArch:     params.OSArch(*os_arch),
 		OSName:     *os_name,
@@ -145,6 +348,25 @@ func AzureInstanceToParamsInstance(vm armcompute.VirtualMachine) (params.Provide
 	}, nil
 }
 
+// InstanceCost returns the age of a VM and its estimated cost to date, from its hourly
+// price tag. ok is false if the VM has no price tag or creation time. Deallocated time is
+// counted as well, so this is an upper bound.
+func InstanceCost(vm armcompute.VirtualMachine, now time.Time) (age time.Duration, cost float64, ok bool) {
+	priceTag, found := vm.Tags[HourlyPriceTagName]
+	if !found || priceTag == nil || vm.Properties == nil || vm.Properties.TimeCreated == nil {
+		return 0, 0, false
+	}
+	price, err := strconv.ParseFloat(*priceTag, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	age = now.Sub(*vm.Properties.TimeCreated)
+	if age < 0 {
+		age = 0
+	}
+	return age, price * age.Hours(), true
+}
+
 // GenerateFakeKey generates a SSH key pair, returns the public key, and
 // discards the private key. This is useful for droplets that don't need a
 // public key, since DO & Azure insists on requiring one.
@@ -159,3 +381,26 @@ func GenerateFakeKey() (string, error) {
 	}
 	return string(ssh.MarshalAuthorizedKey(sshKey)), nil
 }
+
+// InstanceStateTags returns the tags recording that an instance entered a lifecycle state.
+func InstanceStateTags(state string, now time.Time) map[string]*string {
+	return map[string]*string{
+		InstanceStateTagName:      to.Ptr(state),
+		InstanceStateSinceTagName: to.Ptr(now.UTC().Format(time.RFC3339)),
+	}
+}
+
+// InstanceState returns the lifecycle state recorded in the tags of a resource group, and
+// the time it was entered. Resource groups created before states were recorded have no
+// state.
+func InstanceState(tags map[string]*string) (string, time.Time) {
+	state, ok := tags[InstanceStateTagName]
+	if !ok || state == nil {
+		return "", time.Time{}
+	}
+	var since time.Time
+	if value, ok := tags[InstanceStateSinceTagName]; ok && value != nil {
+		since, _ = time.Parse(time.RFC3339, *value)
+	}
+	return *state, since
+}
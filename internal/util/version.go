@@ -0,0 +1,64 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package util
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Version and Commit are set at build time, with:
+//
+//	-ldflags "-X github.com/cloudbase/garm-provider-azure/internal/util.Version=v0.1.0 -X github.com/cloudbase/garm-provider-azure/internal/util.Commit=abc123"
+//
+// When they are not set, they are read from the build info Go embeds in the binary.
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// ProviderVersion returns the version of the provider build, like v0.1.0 or
+// v0.0.0-20240101000000-abc123def456. The commit is appended when it is known and not
+// already part of the version.
+func ProviderVersion() string {
+	version, commit := Version, Commit
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		if commit == "" {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+					commit = setting.Value[:12]
+				}
+			}
+		}
+	}
+	if version == "" {
+		version = "devel"
+	}
+	if commit != "" && !strings.Contains(version, commit) {
+		version = fmt.Sprintf("%s+%s", version, commit)
+	}
+	return version
+}
+
+// VersionInfo returns the provider version, along with the Go version and platform it
+// was built for.
+func VersionInfo() string {
+	return fmt.Sprintf("garm-provider-azure %s (%s, %s/%s)", ProviderVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
@@ -22,6 +22,7 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/cloudbase/garm-provider-azure/internal/commands"
 	"github.com/cloudbase/garm-provider-azure/internal/util"
 	"github.com/cloudbase/garm-provider-azure/provider"
 	"github.com/cloudbase/garm-provider-common/execution"
@@ -38,6 +39,15 @@ func main() {
 
 	util.SetupLogging()
 
+	// garm runs the provider without arguments. Any argument is an operator command.
+	if len(os.Args) > 1 {
+		if err := commands.Run(ctx, os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	executionEnv, err := execution.GetEnvironment()
 	if err != nil {
 		log.Fatal(err)
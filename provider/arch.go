@@ -0,0 +1,51 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// sizeArchitecture returns the architecture runners of a pool are created with. Pools
+// using an image alias with both an amd64 and an arm64 variant follow the architecture of
+// their VM size, so a single pool definition works for both.
+func (a *azureProvider) sizeArchitecture(ctx context.Context, bootstrapParams params.BootstrapInstance) params.OSArch {
+	if _, ok := a.cfg.ImageAliasVariant(bootstrapParams.Image, params.Amd64); !ok {
+		return bootstrapParams.OSArch
+	}
+	if _, ok := a.cfg.ImageAliasVariant(bootstrapParams.Image, params.Arm64); !ok {
+		return bootstrapParams.OSArch
+	}
+	// The size is checked again once the runner spec is ready, so failing to get it here
+	// just keeps the architecture of the pool.
+	vmSize, err := a.azCli.GetVMSize(ctx, bootstrapParams.Flavor)
+	if err != nil {
+		log.Printf("%s: failed to get the architecture of %s: %s", bootstrapParams.Name, bootstrapParams.Flavor, err)
+		return bootstrapParams.OSArch
+	}
+	arch := params.Amd64
+	if strings.EqualFold(vmSize.CPUArchitecture(), string(armcompute.ArchitectureTypesArm64)) {
+		arch = params.Arm64
+	}
+	if arch != bootstrapParams.OSArch {
+		log.Printf("%s: %s is %s, using the %s variant of image alias %s", bootstrapParams.Name, bootstrapParams.Flavor, vmSize.CPUArchitecture(), arch, bootstrapParams.Image)
+	}
+	return arch
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/cloudbase/garm-provider-common/params"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// asyncCreateTags returns the tags of the resource group of an instance created
+// asynchronously, marking the create as in progress.
+func asyncCreateTags(tags map[string]*string) map[string]*string {
+	ret := make(map[string]*string, len(tags)+1)
+	for name, value := range tags {
+		ret[name] = value
+	}
+	ret[util.CreateStatusTagName] = to.Ptr(util.CreateStatusProvisioning)
+	return ret
+}
+
+// createInstanceAsync submits the deployment of an instance, and starts a background
+// process that waits for it to finish and records the result.
+func (a *azureProvider) createInstanceAsync(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) error {
+	a.reportProgress(ctx, runnerSpec, "submitting deployment")
+	if err := a.azCli.SubmitDeployment(ctx, runnerSpec, sizeSpec); err != nil {
+		return fmt.Errorf("failed to submit deployment: %w", err)
+	}
+	// Without a finalizer, the instance would be reported as creating forever.
+	if err := a.startFinalizer(runnerSpec); err != nil {
+		return fmt.Errorf("failed to start create finalizer: %w", err)
+	}
+	return nil
+}
+
+// startFinalizer runs the finalize-create command of this binary in the background. It
+// outlives the provider process.
+func (a *azureProvider) startFinalizer(runnerSpec *spec.RunnerSpec) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find provider binary: %w", err)
+	}
+	args := []string{"finalize-create", "-config", a.configPath, "-name", runnerSpec.BootstrapParams.Name}
+	if runnerSpec.DiskPerformanceTier != "" {
+		args = append(args, "-disk-performance-tier", runnerSpec.DiskPerformanceTier)
+	}
+	if len(runnerSpec.Alerts) > 0 {
+		args = append(args, "-alerts", strings.Join(runnerSpec.Alerts, ","))
+	}
+	if shutdown := runnerSpec.AutoShutdown; shutdown != nil && !shutdown.TagOnly {
+		args = append(args, "-shutdown-time", shutdown.Time, "-shutdown-time-zone", shutdown.TimeZone)
+		if shutdown.NotificationEmail != "" {
+			args = append(args, "-shutdown-notification-email", shutdown.NotificationEmail)
+		}
+	}
+	// The output of the finalizer is left unset, so it doesn't hold on to the pipes garm
+	// reads the provider result from. It logs to syslog.
+	cmd := exec.Command(exe, args...)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// asyncCreateStatus returns the details of an instance whose VM doesn't exist yet, from the
+// create status recorded on its resource group. It returns false if the instance is not
+// being created asynchronously.
+func (a *azureProvider) asyncCreateStatus(ctx context.Context, name string) (params.ProviderInstance, bool) {
+	rg, err := a.azCli.GetResourceGroup(ctx, name)
+	if err != nil || rg == nil {
+		return params.ProviderInstance{}, false
+	}
+	status, ok := rg.Tags[util.CreateStatusTagName]
+	if !ok || status == nil {
+		return params.ProviderInstance{}, false
+	}
+
+	instance := params.ProviderInstance{
+		ProviderID: name,
+		Name:       name,
+	}
+	if runnerName, ok := rg.Tags[util.InstanceNameTagName]; ok && runnerName != nil {
+		instance.Name = *runnerName
+	}
+	switch *status {
+	case util.CreateStatusProvisioning:
+		instance.Status = params.InstanceCreating
+	case util.CreateStatusFailed:
+		instance.Status = params.InstanceError
+		if reason, ok := rg.Tags[util.CreateErrorTagName]; ok && reason != nil {
+			instance.ProviderFault = []byte(*reason)
+		}
+	default:
+		// The create finished, so the VM should exist.
+		return params.ProviderInstance{}, false
+	}
+	return instance, true
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/queue"
+)
+
+const (
+	stepRetryInitialBackoff = 5 * time.Second
+	stepRetryMaxBackoff     = 1 * time.Minute
+)
+
+// circuitState records the consecutive failures of each category. Every create is a
+// separate process, so this is kept on disk.
+type circuitState map[client.FailureCategory]circuitFailures
+
+type circuitFailures struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LastError   string    `json:"last_error"`
+}
+
+func (a *azureProvider) loadCircuitState() circuitState {
+	state := circuitState{}
+	data, err := os.ReadFile(a.cfg.CircuitBreaker.GetStateFile())
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("ignoring invalid circuit breaker state: %s", err)
+		return circuitState{}
+	}
+	return state
+}
+
+// saveCircuitState replaces the state file, so processes reading it without the lock never
+// see a partial write.
+func (a *azureProvider) saveCircuitState(state circuitState) error {
+	stateFile := a.cfg.CircuitBreaker.GetStateFile()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal circuit breaker state: %w", err)
+	}
+	tmpFile := stateFile + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write circuit breaker state: %w", err)
+	}
+	if err := os.Rename(tmpFile, stateFile); err != nil {
+		return fmt.Errorf("failed to write circuit breaker state: %w", err)
+	}
+	return nil
+}
+
+// updateCircuitState applies update to the state file, under a lock shared by all provider
+// processes, so concurrent creates don't lose each other's results.
+func (a *azureProvider) updateCircuitState(update func(circuitState) circuitState) error {
+	stateFile := a.cfg.CircuitBreaker.GetStateFile()
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0o700); err != nil {
+		return fmt.Errorf("failed to create circuit breaker state dir: %w", err)
+	}
+	unlock, err := queue.LockPath(stateFile + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock circuit breaker state: %w", err)
+	}
+	defer unlock()
+	return a.saveCircuitState(update(a.loadCircuitState()))
+}
+
+// checkCircuitBreaker returns an error if creates failed the same way too many times in a
+// row, and the cooldown since the last failure has not passed yet.
+func (a *azureProvider) checkCircuitBreaker() error {
+	threshold := a.cfg.CircuitBreaker.Threshold
+	if threshold == 0 {
+		return nil
+	}
+	cooldown := a.cfg.CircuitBreaker.GetCooldown()
+	for category, failures := range a.loadCircuitState() {
+		if failures.Failures < threshold || time.Since(failures.LastFailure) >= cooldown {
+			continue
+		}
+		retryAt := failures.LastFailure.Add(cooldown).UTC().Format(time.RFC3339)
+		return fmt.Errorf("circuit breaker open after %d consecutive %s failures, creates are refused until %s; last error: %s", failures.Failures, category, retryAt, failures.LastError)
+	}
+	return nil
+}
+
+// recordCircuitResult counts the consecutive failures of each category, and resets them
+// once a create succeeds. Failures specific to a runner are not counted. Failing to record
+// the result is not fatal.
+func (a *azureProvider) recordCircuitResult(createErr error) {
+	if a.cfg.CircuitBreaker.Threshold == 0 {
+		return
+	}
+	var category client.FailureCategory
+	if createErr != nil {
+		category = client.ClassifyFailure(createErr)
+		if category == "" {
+			return
+		}
+	}
+	err := a.updateCircuitState(func(state circuitState) circuitState {
+		if createErr == nil {
+			return circuitState{}
+		}
+		failures := state[category]
+		failures.Failures++
+		failures.LastFailure = time.Now().UTC()
+		failures.LastError = createErr.Error()
+		state[category] = failures
+		log.Printf("create failed with a %s failure (%d in a row)", category, failures.Failures)
+		return state
+	})
+	if err != nil {
+		log.Printf("failed to record circuit breaker state: %s", err)
+	}
+}
+
+// retryStep runs a step of a create, and retries it with a backoff after throttled
+// requests and outages, up to the retry budget of the step. Every step has its own budget,
+// so a flaky step doesn't use up the retries of the others. The steps create or update
+// resources, and can be sent again.
+func (a *azureProvider) retryStep(ctx context.Context, step string, run func() error) error {
+	budget := a.cfg.CircuitBreaker.GetStepRetries()
+	backoff := stepRetryInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := run()
+		if err == nil || attempt > budget || !client.IsTransientFailure(err) {
+			return err
+		}
+		log.Printf("%s failed with a transient error, retrying in %s (retry %d of %d): %s", step, backoff, attempt, budget, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > stepRetryMaxBackoff {
+			backoff = stepRetryMaxBackoff
+		}
+	}
+}
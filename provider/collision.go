@@ -0,0 +1,134 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+	"github.com/cloudbase/garm-provider-common/params"
+)
+
+// resolveNameCollision handles a resource group left behind by a create or delete that
+// crashed before returning. If the leftover belongs to this controller and pool, was not
+// being deleted and holds a VM that was fully provisioned, the VM is adopted and returned.
+// Otherwise the leftovers are removed, so the runner can be created again. Resource groups
+// owned by someone else are never touched.
+func (a *azureProvider) resolveNameCollision(ctx context.Context, runnerSpec *spec.RunnerSpec) (*params.ProviderInstance, error) {
+	if a.cfg.SharedResourceGroup != "" {
+		return a.resolveSharedNameCollision(ctx, runnerSpec)
+	}
+
+	name := runnerSpec.BootstrapParams.Name
+	rg, err := a.azCli.GetResourceGroup(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check for existing resource group: %w", err)
+	}
+	if rg == nil {
+		return nil, nil
+	}
+
+	if tagValue(rg.Tags, util.ControllerIDTagName) != a.controllerID {
+		return nil, fmt.Errorf("resource group %s already exists and is not owned by this controller", name)
+	}
+	if tagValue(rg.Tags, util.PoolIDTagName) != runnerSpec.BootstrapParams.PoolID {
+		return nil, fmt.Errorf("resource group %s already exists and belongs to pool %s", name, tagValue(rg.Tags, util.PoolIDTagName))
+	}
+
+	state, _ := util.InstanceState(rg.Tags)
+	if state != util.InstanceStateDeleting {
+		vm, err := a.azCli.GetInstance(ctx, name, name)
+		if err == nil && isProvisioned(vm) {
+			details, err := util.AzureInstanceToParamsInstance(vm)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert VM details: %w", err)
+			}
+			if err := a.azCli.SetInstanceState(ctx, name, util.InstanceStateCreated); err != nil {
+				return nil, err
+			}
+			log.Printf("%s: adopting existing VM left behind by a previous create", name)
+			return &details, nil
+		}
+	}
+
+	log.Printf("%s: removing resources left behind by a previous operation (state %q)", name, state)
+	a.reportProgress(ctx, runnerSpec, "removing leftovers of a previous create")
+	if err := a.azCli.UnlockResourceGroup(ctx, name); err != nil {
+		return nil, fmt.Errorf("failed to unlock leftover resource group: %w", err)
+	}
+	if err := a.azCli.DeleteResourceGroup(ctx, name, true); err != nil {
+		return nil, fmt.Errorf("failed to remove leftover resource group: %w", err)
+	}
+	return nil, nil
+}
+
+// resolveSharedNameCollision handles the leftovers of a previous operation in the shared
+// resource group. The VM carries the tags of the instance, so ownership is checked on it.
+// A provisioned VM that was not being deleted is adopted. Otherwise the VM and everything
+// else named after the instance is removed.
+func (a *azureProvider) resolveSharedNameCollision(ctx context.Context, runnerSpec *spec.RunnerSpec) (*params.ProviderInstance, error) {
+	name := runnerSpec.BootstrapParams.Name
+	vm, err := a.azCli.GetInstance(ctx, a.azCli.InstanceResourceGroup(name), name)
+	if err != nil {
+		if client.IsNotFound(err) {
+			// Leftover network resources are updated in place by the create.
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to check for existing VM: %w", err)
+	}
+
+	if tagValue(vm.Tags, util.ControllerIDTagName) != a.controllerID {
+		return nil, fmt.Errorf("VM %s already exists and is not owned by this controller", name)
+	}
+	if tagValue(vm.Tags, util.PoolIDTagName) != runnerSpec.BootstrapParams.PoolID {
+		return nil, fmt.Errorf("VM %s already exists and belongs to pool %s", name, tagValue(vm.Tags, util.PoolIDTagName))
+	}
+	if state, _ := util.InstanceState(vm.Tags); state != util.InstanceStateDeleting && isProvisioned(vm) {
+		details, err := util.AzureInstanceToParamsInstance(vm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert VM details: %w", err)
+		}
+		if err := a.azCli.SetInstanceState(ctx, name, util.InstanceStateCreated); err != nil {
+			return nil, err
+		}
+		log.Printf("%s: adopting existing VM left behind by a previous create", name)
+		return &details, nil
+	}
+
+	log.Printf("%s: removing resources left behind by a previous operation", name)
+	a.reportProgress(ctx, runnerSpec, "removing leftovers of a previous create")
+	if err := a.azCli.DeleteInstanceResources(ctx, name); err != nil {
+		return nil, fmt.Errorf("failed to remove leftover resources: %w", err)
+	}
+	return nil, nil
+}
+
+// isProvisioned returns true if the VM was created successfully.
+func isProvisioned(vm armcompute.VirtualMachine) bool {
+	return vm.Properties != nil && vm.Properties.ProvisioningState != nil && *vm.Properties.ProvisioningState == "Succeeded"
+}
+
+// tagValue returns the value of a tag, or an empty string if it is not set.
+func tagValue(tags map[string]*string, name string) string {
+	if value, ok := tags[name]; ok && value != nil {
+		return *value
+	}
+	return ""
+}
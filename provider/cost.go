@@ -0,0 +1,53 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// costAnnotationInterval is how often the age and cost annotations of a runner are
+// updated. garm lists instances often, and each update is a write.
+const costAnnotationInterval = time.Hour
+
+// annotateCost records the age and estimated cost to date of a runner in the tags of its
+// VM, so they show up wherever the tags do. Runners without an hourly price tag are left
+// alone.
+func (a *azureProvider) annotateCost(ctx context.Context, vm armcompute.VirtualMachine) {
+	now := time.Now()
+	age, cost, ok := util.InstanceCost(vm, now)
+	if !ok || vm.ID == nil {
+		return
+	}
+	if annotatedAt, err := time.Parse(time.RFC3339, tagValue(vm.Tags, util.CostAnnotatedAtTagName)); err == nil && now.Sub(annotatedAt) < costAnnotationInterval {
+		return
+	}
+	tags := map[string]*string{
+		util.AgeHoursTagName:        to.Ptr(strconv.FormatFloat(age.Hours(), 'f', 1, 64)),
+		util.CostToDateTagName:      to.Ptr(strconv.FormatFloat(cost, 'f', 2, 64)),
+		util.CostAnnotatedAtTagName: to.Ptr(now.UTC().Format(time.RFC3339)),
+	}
+	if err := a.azCli.UpdateResourceTags(ctx, *vm.ID, tags); err != nil {
+		log.Printf("failed to annotate the cost of %s: %s", *vm.Name, err)
+	}
+}
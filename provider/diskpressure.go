@@ -0,0 +1,60 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// reportDiskPressure logs runners whose disk usage crossed a threshold, along with their
+// pool and disk size, so operators learn which pools need bigger disks. Each threshold is
+// logged once per runner.
+func (a *azureProvider) reportDiskPressure(ctx context.Context, vm armcompute.VirtualMachine) {
+	level, err := strconv.Atoi(tagValue(vm.Tags, util.DiskPressureTagName))
+	if err != nil || vm.ID == nil || vm.Name == nil {
+		return
+	}
+	if logged, err := strconv.Atoi(tagValue(vm.Tags, util.DiskPressureLoggedTagName)); err == nil && logged >= level {
+		return
+	}
+
+	var vmSize string
+	var diskSizeGB int32
+	if vm.Properties != nil {
+		if vm.Properties.HardwareProfile != nil && vm.Properties.HardwareProfile.VMSize != nil {
+			vmSize = string(*vm.Properties.HardwareProfile.VMSize)
+		}
+		if vm.Properties.StorageProfile != nil && vm.Properties.StorageProfile.OSDisk != nil && vm.Properties.StorageProfile.OSDisk.DiskSizeGB != nil {
+			diskSizeGB = *vm.Properties.StorageProfile.OSDisk.DiskSizeGB
+		}
+	}
+	log.Printf("disk pressure: runner %s of pool %s crossed %d%% disk usage (%s%% at %s, VM size %s, OS disk %d GB)",
+		*vm.Name, tagValue(vm.Tags, util.PoolIDTagName), level,
+		tagValue(vm.Tags, util.DiskUsageTagName), tagValue(vm.Tags, util.DiskPressureAtTagName), vmSize, diskSizeGB)
+
+	tags := map[string]*string{
+		util.DiskPressureLoggedTagName: to.Ptr(strconv.Itoa(level)),
+	}
+	if err := a.azCli.UpdateResourceTags(ctx, *vm.ID, tags); err != nil {
+		log.Printf("failed to record the disk pressure of %s as logged: %s", *vm.Name, err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// fitEphemeralDisk checks that the VM size can hold an ephemeral OS disk of the requested
+// size. If no size was requested, the disk is sized to fit the VM size.
+func (a *azureProvider) fitEphemeralDisk(ctx context.Context, runnerSpec *spec.RunnerSpec) (spec.VMSizeEphemeralDiskSizeLimits, error) {
+	sizeSpec, err := a.azCli.GetMaxEphemeralDiskSize(ctx, runnerSpec.VMSize)
+	if err != nil {
+		return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("failed to get max ephemeral disk size: %w", err)
+	}
+
+	diskSize := sizeSpec.CacheDiskSizeGB
+	if diskSize == 0 {
+		diskSize = sizeSpec.ResourceDiskSizeGB
+	}
+
+	// If confidential VMs are used with ephemeral storage, 1 GB is reserved.
+	// See: https://learn.microsoft.com/en-us/azure/virtual-machines/ephemeral-os-disks#confidential-vms-using-ephemeral-os-disks
+	// However, we disable confidential VMs for now, when ephemeral storage is used. We'll leave this recalculation of available
+	// space, in case we enable it in the future.
+	if runnerSpec.Confidential {
+		diskSize = diskSize - 1
+	}
+
+	if diskSize < runnerSpec.DiskSizeGB {
+		return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("maximul ephemeral disk size for %s is %d GB (requested %d)", runnerSpec.VMSize, diskSize, runnerSpec.DiskSizeGB)
+	}
+
+	if runnerSpec.DiskSizeGB == 0 {
+		// No size was requested. Fit the disk to the VM size, within the configured limits.
+		if a.cfg.EphemeralDiskMaxSizeGB > 0 && diskSize > a.cfg.EphemeralDiskMaxSizeGB {
+			diskSize = a.cfg.EphemeralDiskMaxSizeGB
+		}
+		if diskSize < a.cfg.EphemeralDiskMinSizeGB {
+			return spec.VMSizeEphemeralDiskSizeLimits{}, fmt.Errorf("maximum ephemeral disk size for %s is %d GB, below the configured minimum of %d GB", runnerSpec.VMSize, diskSize, a.cfg.EphemeralDiskMinSizeGB)
+		}
+		runnerSpec.DiskSizeGB = diskSize
+		runnerSpec.Tags[util.EphemeralDiskSizeTagName] = to.Ptr(strconv.Itoa(int(diskSize)))
+		log.Printf("%s: using an ephemeral OS disk of %d GB for %s", runnerSpec.BootstrapParams.Name, diskSize, runnerSpec.VMSize)
+	}
+	return sizeSpec, nil
+}
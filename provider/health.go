@@ -0,0 +1,55 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// serviceHealthTimeout bounds the service health check, so it doesn't hold up reporting
+// the create failure.
+const serviceHealthTimeout = 30 * time.Second
+
+// withServiceHealth checks for active Azure incidents in the region of a failed create,
+// and adds them to the error. Creates failing during an incident are then reported as such,
+// instead of looking like configuration errors.
+func (a *azureProvider) withServiceHealth(ctx context.Context, createErr error) error {
+	ctx, cancel := context.WithTimeout(ctx, serviceHealthTimeout)
+	defer cancel()
+
+	issues, err := a.azCli.ListActiveServiceIssues(ctx)
+	if err != nil {
+		log.Printf("failed to check service health: %s", err)
+		return createErr
+	}
+	var impacts []string
+	for _, issue := range issues {
+		for region, services := range issue.Services {
+			if util.SameRegion(region, a.azCli.Location()) {
+				impacts = append(impacts, fmt.Sprintf("%s (%s, tracking ID %s)", issue.Title, strings.Join(services, ", "), issue.TrackingID))
+			}
+		}
+	}
+	if len(impacts) == 0 {
+		return createErr
+	}
+	return fmt.Errorf("azure region %s is degraded: %s: %w", a.azCli.Location(), strings.Join(impacts, "; "), createErr)
+}
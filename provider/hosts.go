@@ -0,0 +1,128 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+)
+
+// placeOnDedicatedHosts places the runner in the dedicated host group, and in the zone of
+// the group.
+func (a *azureProvider) placeOnDedicatedHosts(runnerSpec *spec.RunnerSpec) error {
+	if runnerSpec.Spot != nil {
+		return fmt.Errorf("spot VMs can't be placed on dedicated hosts")
+	}
+	zone := a.cfg.DedicatedHosts.Zone
+	allowed := len(runnerSpec.Zones) == 0
+	for _, candidate := range runnerSpec.Zones {
+		if candidate == zone {
+			allowed = true
+		}
+	}
+	switch {
+	case zone != "" && !allowed:
+		return fmt.Errorf("the dedicated host group is in zone %s, which is not one of the allowed zones", zone)
+	case zone == "" && len(runnerSpec.Zones) > 0:
+		return fmt.Errorf("the dedicated host group is regional, runners can't be placed in zones")
+	}
+	if zone != "" {
+		runnerSpec.Zones = []string{zone}
+		runnerSpec.SetZone(zone)
+	}
+	runnerSpec.HostGroupID = a.azCli.HostGroupID()
+	return nil
+}
+
+// ensureHostCapacity makes sure a VM of the given size fits on one of the dedicated hosts,
+// and provisions a new host if none has room left. Azure places the VM on a host with
+// enough capacity.
+func (a *azureProvider) ensureHostCapacity(ctx context.Context, vmSize string) error {
+	if err := a.azCli.EnsureHostGroup(ctx); err != nil {
+		return err
+	}
+	hosts, err := a.azCli.ListDedicatedHosts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var free int
+	var known bool
+	for _, host := range hosts {
+		for size, count := range host.Allocatable {
+			if strings.EqualFold(size, vmSize) {
+				known = true
+				free += count
+			}
+		}
+	}
+	if free > 0 {
+		return nil
+	}
+	// Hosts report the capacity left for every size they support, even when full.
+	if len(hosts) > 0 && !known {
+		return fmt.Errorf("VM size %s can't be placed on dedicated hosts of SKU %s", vmSize, a.cfg.DedicatedHosts.SKU)
+	}
+
+	if maxHosts := a.cfg.DedicatedHosts.MaxHosts; maxHosts > 0 && len(hosts) >= maxHosts {
+		return fmt.Errorf("all %d dedicated hosts are full", len(hosts))
+	}
+	name := fmt.Sprintf("garm-host-%d", time.Now().Unix())
+	log.Printf("no dedicated host has room for a %s VM, creating host %s", vmSize, name)
+	if err := a.azCli.CreateDedicatedHost(ctx, name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// releaseIdleHosts deletes the dedicated hosts that have been without runners for longer
+// than the idle timeout, keeping at least min_hosts. Azure doesn't record when a host
+// became idle, so idle hosts are tagged the first time they are seen. Failures are only
+// logged.
+func (a *azureProvider) releaseIdleHosts(ctx context.Context) {
+	hosts, err := a.azCli.ListDedicatedHosts(ctx)
+	if err != nil {
+		log.Printf("failed to list dedicated hosts: %s", err)
+		return
+	}
+
+	remaining := len(hosts)
+	for _, host := range hosts {
+		switch {
+		case host.VirtualMachines > 0:
+			if !host.IdleSince.IsZero() {
+				if err := a.azCli.SetDedicatedHostIdleSince(ctx, host.Name, time.Time{}); err != nil {
+					log.Printf("failed to mark dedicated host %s as in use: %s", host.Name, err)
+				}
+			}
+		case host.IdleSince.IsZero():
+			if err := a.azCli.SetDedicatedHostIdleSince(ctx, host.Name, time.Now()); err != nil {
+				log.Printf("failed to mark dedicated host %s as idle: %s", host.Name, err)
+			}
+		case time.Since(host.IdleSince) >= a.cfg.DedicatedHosts.GetIdleTimeout() && remaining > a.cfg.DedicatedHosts.MinHosts:
+			log.Printf("deleting dedicated host %s, idle since %s", host.Name, host.IdleSince.Format(time.RFC3339))
+			if err := a.azCli.DeleteDedicatedHost(ctx, host.Name); err != nil {
+				log.Printf("failed to delete dedicated host %s: %s", host.Name, err)
+				continue
+			}
+			remaining--
+		}
+	}
+}
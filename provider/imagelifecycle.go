@@ -0,0 +1,98 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// imageVersionDate returns the release date encoded in a marketplace image version, like
+// 22.04.202405010 (Ubuntu), 0.20240430.1731 (Debian) or 20348.2461.240510 (Windows).
+func imageVersionDate(version string) (time.Time, bool) {
+	for _, part := range strings.Split(version, ".") {
+		var date time.Time
+		var err error
+		switch {
+		case len(part) >= 8:
+			date, err = time.Parse("20060102", part[:8])
+		case len(part) == 6:
+			date, err = time.Parse("060102", part)
+		default:
+			continue
+		}
+		// Build numbers may look like dates. Only accept dates that make sense.
+		if err == nil && date.Year() >= 2010 && date.Before(time.Now().Add(24*time.Hour)) {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// checkImageLifecycle warns about, or refuses, image versions that are older than the
+// configured age or deprecated by their publisher.
+func (a *azureProvider) checkImageLifecycle(ctx context.Context, runnerSpec *spec.RunnerSpec, img util.ImageDetails) error {
+	name := runnerSpec.BootstrapParams.Name
+	lifecycle := a.cfg.ImageLifecycle
+	if strings.EqualFold(img.Version, "latest") {
+		version, err := a.azCli.LatestImageVersion(ctx, img)
+		if err != nil {
+			log.Printf("WARNING: %s: failed to resolve the latest version of %s, skipping image lifecycle checks: %s", name, img.URN(), err)
+			return nil
+		}
+		img.Version = version
+	}
+
+	var problems []string
+	if lifecycle.MaxAgeDays > 0 {
+		if released, ok := imageVersionDate(img.Version); ok {
+			if age := int(time.Since(released).Hours() / 24); age > lifecycle.MaxAgeDays {
+				problems = append(problems, fmt.Sprintf("%d days old (max_age_days is %d)", age, lifecycle.MaxAgeDays))
+			}
+		}
+	}
+	if lifecycle.CheckDeprecation {
+		status, err := a.azCli.GetImageDeprecationStatus(ctx, img)
+		switch {
+		case err != nil:
+			log.Printf("WARNING: %s: failed to get the deprecation status of %s: %s", name, img.URN(), err)
+		case status.State == "Deprecated":
+			problems = append(problems, "deprecated by the publisher")
+		case status.State == "ScheduledForDeprecation":
+			when := "soon"
+			if status.ScheduledAt != nil {
+				when = "on " + status.ScheduledAt.Format("2006-01-02")
+			}
+			log.Printf("WARNING: %s: image %s will be deprecated %s", name, img.URN(), when)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("image %s is %s", img.URN(), strings.Join(problems, " and "))
+	if lifecycle.Block {
+		return errors.New(msg)
+	}
+	log.Printf("WARNING: %s: %s", name, msg)
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// inflightCheckInterval is how often a create checks whether it was cancelled, and
+	// refreshes its marker.
+	inflightCheckInterval = 5 * time.Second
+	// inflightStaleAfter is the age after which a create marker is considered left behind
+	// by a crashed process.
+	inflightStaleAfter = 4 * inflightCheckInterval
+	// inflightCancelTimeout is how long a delete waits for a cancelled create to roll back.
+	inflightCancelTimeout = 10 * time.Minute
+)
+
+// inflightCreate tracks an instance that is being created. Every provider operation is a
+// separate process, so the create and a concurrent delete coordinate through marker files:
+// the create refreshes a creating marker while it runs, and cancels itself when a delete
+// drops a cancel marker next to it.
+type inflightCreate struct {
+	creatingPath string
+	cancelPath   string
+	cancelled    atomic.Bool
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+func (a *azureProvider) inflightPaths(name string) (string, string) {
+	dir := a.cfg.GetCreateStateDir()
+	return filepath.Join(dir, name+".creating"), filepath.Join(dir, name+".cancel")
+}
+
+// trackCreate marks the instance as being created, and returns a context that is
+// cancelled if the instance is deleted in the meantime. Tracking is best effort: if the
+// marker can't be written, the create goes on without it.
+func (a *azureProvider) trackCreate(ctx context.Context, name string) (context.Context, *inflightCreate) {
+	creatingPath, cancelPath := a.inflightPaths(name)
+	tracker := &inflightCreate{
+		creatingPath: creatingPath,
+		cancelPath:   cancelPath,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	// A cancel marker left behind by an earlier delete must not cancel this create.
+	os.Remove(cancelPath) //nolint
+	if err := os.MkdirAll(filepath.Dir(creatingPath), 0o700); err != nil {
+		log.Printf("failed to create create state dir: %s", err)
+		close(tracker.done)
+		return ctx, tracker
+	}
+	if err := os.WriteFile(creatingPath, []byte(fmt.Sprint(os.Getpid())), 0o600); err != nil {
+		log.Printf("failed to mark %s as being created: %s", name, err)
+		close(tracker.done)
+		return ctx, tracker
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer close(tracker.done)
+		defer cancel()
+		ticker := time.NewTicker(inflightCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-tracker.stop:
+				return
+			case <-ticker.C:
+			}
+			// The marker is refreshed until the rollback of a cancelled create is done, so
+			// the delete waits for it.
+			now := time.Now()
+			os.Chtimes(creatingPath, now, now) //nolint
+			if tracker.cancelled.Load() {
+				continue
+			}
+			if _, err := os.Stat(cancelPath); err == nil {
+				log.Printf("%s was deleted while being created, cancelling the create", name)
+				tracker.cancelled.Store(true)
+				cancel()
+			}
+		}
+	}()
+	return ctx, tracker
+}
+
+// Cancelled returns true if the create was cancelled by a delete.
+func (t *inflightCreate) Cancelled() bool {
+	return t.cancelled.Load()
+}
+
+// Done stops tracking the create, and removes its markers. It must be called once the
+// create, including any rollback, is finished.
+func (t *inflightCreate) Done() {
+	select {
+	case <-t.done:
+	default:
+		close(t.stop)
+		<-t.done
+	}
+	os.Remove(t.creatingPath) //nolint
+	os.Remove(t.cancelPath)   //nolint
+}
+
+// cancelInflightCreate cancels a create of the instance that is still running in another
+// process, and waits for it to roll back. It returns right away if there is none.
+func (a *azureProvider) cancelInflightCreate(ctx context.Context, name string) error {
+	creatingPath, cancelPath := a.inflightPaths(name)
+	if !isFresh(creatingPath) {
+		return nil
+	}
+	log.Printf("%s is still being created, cancelling the create", name)
+	if err := os.WriteFile(cancelPath, nil, 0o600); err != nil {
+		return fmt.Errorf("failed to cancel create: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, inflightCancelTimeout)
+	defer cancel()
+	ticker := time.NewTicker(inflightCheckInterval)
+	defer ticker.Stop()
+	for isFresh(creatingPath) {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for the create to be cancelled: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// isFresh returns true if the marker exists, and was refreshed recently by a running
+// create.
+func isFresh(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) < inflightStaleAfter
+}
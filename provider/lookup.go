@@ -0,0 +1,71 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// findMovedInstance looks for the VM of a runner whose resource group is gone, in case it
+// was moved or its resource group was renamed out of band. VMs of this controller are
+// matched on their name, or on the garm name in their tags. ok is false if the resource
+// group exists, or if no VM was found.
+func (a *azureProvider) findMovedInstance(ctx context.Context, instance string, getErr error) (armcompute.VirtualMachine, bool, error) {
+	// Instances in the shared resource group have no resource group of their own.
+	if !client.IsNotFound(getErr) || a.cfg.SharedResourceGroup != "" {
+		return armcompute.VirtualMachine{}, false, nil
+	}
+	rg, err := a.azCli.GetResourceGroup(ctx, instance)
+	if err != nil {
+		return armcompute.VirtualMachine{}, false, fmt.Errorf("failed to get resource group: %w", err)
+	}
+	if rg != nil {
+		return armcompute.VirtualMachine{}, false, nil
+	}
+
+	// A single list of the subscription, instead of a get per resource group.
+	vms, err := a.azCli.ListVirtualMachinesWithTag(ctx, util.ControllerIDTagName, a.controllerID)
+	if err != nil {
+		return armcompute.VirtualMachine{}, false, fmt.Errorf("failed to search for the instance: %w", err)
+	}
+	for _, candidate := range vms {
+		if candidate.Name == nil || candidate.ID == nil {
+			continue
+		}
+		if *candidate.Name != instance && tagValue(candidate.Tags, util.InstanceNameTagName) != instance {
+			continue
+		}
+		id, err := arm.ParseResourceID(*candidate.ID)
+		if err != nil {
+			return armcompute.VirtualMachine{}, false, fmt.Errorf("failed to parse VM ID: %w", err)
+		}
+		// The list doesn't include the instance view.
+		found, err := a.azCli.GetInstance(ctx, id.ResourceGroupName, *candidate.Name)
+		if err != nil {
+			return armcompute.VirtualMachine{}, false, err
+		}
+		log.Printf("resource group %s is missing, found %s in resource group %s", instance, instance, id.ResourceGroupName)
+		return found, true, nil
+	}
+	return armcompute.VirtualMachine{}, false, nil
+}
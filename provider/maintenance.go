@@ -0,0 +1,157 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/cloudbase/garm-provider-common/params"
+
+	"github.com/cloudbase/garm-provider-azure/internal/queue"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+const (
+	// maintenanceInterval is the minimum time between two background checks of a pool.
+	// Runners whose cloud-init is still running are checked again after it.
+	maintenanceInterval = time.Minute
+	// maintenanceConcurrency bounds the runners checked at once. A Run Command takes tens
+	// of seconds, so checking the runners of a large pool one by one takes too long.
+	maintenanceConcurrency = 8
+)
+
+// needsMaintenance returns true if the runner has something to check or record, which
+// takes more API calls than listing the VMs.
+func needsMaintenance(vm armcompute.VirtualMachine) bool {
+	return needsPowerState(vm) ||
+		tagValue(vm.Tags, util.HourlyPriceTagName) != "" ||
+		tagValue(vm.Tags, util.DiskPressureTagName) != ""
+}
+
+// needsPowerState returns true if the runner has to be checked with its power state,
+// which the list of VMs doesn't include.
+func needsPowerState(vm armcompute.VirtualMachine) bool {
+	return tagValue(vm.Tags, util.SelfTerminateTagName) != "" ||
+		tagValue(vm.Tags, util.HeartbeatTimeoutTagName) != "" ||
+		tagValue(vm.Tags, util.CloudInitStatusTagName) == util.CloudInitStatusPending
+}
+
+// startMaintenance runs the maintain-pool command of this binary in the background, unless
+// the pool was checked in the last maintenanceInterval. It outlives the provider process.
+func (a *azureProvider) startMaintenance(poolID string) error {
+	stateDir := a.cfg.GetMaintenanceStateDir()
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create maintenance state dir: %w", err)
+	}
+	marker := filepath.Join(stateDir, poolID)
+	unlock, err := queue.LockPath(marker + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock maintenance state: %w", err)
+	}
+	defer unlock()
+
+	if info, err := os.Stat(marker); err == nil && time.Since(info.ModTime()) < maintenanceInterval {
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find provider binary: %w", err)
+	}
+	// The output is left unset, so the process doesn't hold on to the pipes garm reads the
+	// provider result from. It logs to syslog.
+	cmd := exec.Command(exe, "maintain-pool", "-config", a.configPath, "-controller-id", a.controllerID, "-pool", poolID)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(marker, nil, 0o600); err != nil {
+		log.Printf("failed to record the background checks of pool %s: %s", poolID, err)
+	}
+	return cmd.Process.Release()
+}
+
+// MaintainPool runs the checks of the runners of a pool that take more than listing them:
+// the cloud-init status checks, reaping self terminated and hung runners, annotating
+// costs and reporting disk pressure. The provider starts it in the background when garm
+// lists the instances of the pool.
+func MaintainPool(ctx context.Context, configPath, controllerID, poolID string) error {
+	a, err := newAzureProvider(configPath, controllerID)
+	if err != nil {
+		return err
+	}
+	vms, err := a.azCli.ListVirtualMachines(ctx, poolID)
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	sem := make(chan struct{}, maintenanceConcurrency)
+	var wg sync.WaitGroup
+	for _, vm := range vms {
+		if vm == nil || vm.Name == nil || !needsMaintenance(*vm) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(vm armcompute.VirtualMachine) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			a.maintainInstance(ctx, vm)
+		}(*vm)
+	}
+	wg.Wait()
+	return nil
+}
+
+// maintainInstance runs the background checks of a single runner. Failures are logged, and
+// the checks are retried the next time the pool is maintained.
+func (a *azureProvider) maintainInstance(ctx context.Context, vm armcompute.VirtualMachine) {
+	a.annotateCost(ctx, vm)
+	a.reportDiskPressure(ctx, vm)
+	if !needsPowerState(vm) {
+		return
+	}
+
+	name := *vm.Name
+	a, err := a.forInstance(ctx, name)
+	if err != nil {
+		log.Printf("failed to find %s: %s", name, err)
+		return
+	}
+	withPowerState, err := a.azCli.GetInstance(ctx, a.azCli.InstanceResourceGroup(name), name)
+	if err != nil {
+		log.Printf("failed to get power state of %s: %s", name, err)
+		return
+	}
+	details, err := util.AzureInstanceToParamsInstance(withPowerState)
+	if err != nil {
+		log.Printf("failed to convert VM details of %s: %s", name, err)
+		return
+	}
+	if details.Status == params.InstanceRunning {
+		a.checkCloudInitStatus(ctx, withPowerState, details)
+	}
+	a.reapSelfTerminated(ctx, withPowerState, details)
+	a.reapHung(ctx, withPowerState, details)
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+)
+
+// checkNetworkBandwidth checks the networking of the pool against the VM size. Without
+// accelerated networking, or on a size with less bandwidth than the pool expects, the
+// network bottlenecks artifact and cache uploads.
+func (a *azureProvider) checkNetworkBandwidth(runnerSpec *spec.RunnerSpec, vmSize client.VMSize) error {
+	name := runnerSpec.BootstrapParams.Name
+	if runnerSpec.UseAcceleratedNetworking && !vmSize.AcceleratedNetworking() {
+		return fmt.Errorf("VM size %s does not support accelerated networking; disable use_accelerated_networking or pick another size", runnerSpec.VMSize)
+	}
+	if !runnerSpec.UseAcceleratedNetworking && vmSize.AcceleratedNetworking() {
+		log.Printf("%s: accelerated networking is disabled, although %s supports it; network throughput is limited by the host CPU", name, runnerSpec.VMSize)
+	}
+
+	if runnerSpec.MinNetworkBandwidthMbps == 0 {
+		return nil
+	}
+	bandwidth, ok := a.cfg.GetVMSizeBandwidthMbps(runnerSpec.VMSize)
+	if !ok {
+		log.Printf("%s: the bandwidth of %s is unknown, add it to vm_size_bandwidth_mbps to check it against min_network_bandwidth_mbps", name, runnerSpec.VMSize)
+		return nil
+	}
+	if bandwidth < runnerSpec.MinNetworkBandwidthMbps {
+		log.Printf("WARNING: %s: %s has a network bandwidth of %d Mbps, below the %d Mbps the pool expects; uploads will be bottlenecked", name, runnerSpec.VMSize, bandwidth, runnerSpec.MinNetworkBandwidthMbps)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// nicClaimTimeout is how long a claim on a NIC holds while the VM is not attached to it
+// yet. Claims left behind by crashed creates expire after it.
+const nicClaimTimeout = 10 * time.Minute
+
+// claimNetworkInterface picks one of the pre-created NICs of the pool that is neither
+// attached to a VM nor claimed by another create, and claims it for the runner.
+func (a *azureProvider) claimNetworkInterface(ctx context.Context, runnerSpec *spec.RunnerSpec) error {
+	name := runnerSpec.BootstrapParams.Name
+	ids := runnerSpec.NetworkInterfaceIDs
+	// Start at a different NIC each time, so concurrent creates rarely compete.
+	offset := int(time.Now().UnixNano() % int64(len(ids)))
+	for idx := range ids {
+		id := ids[(offset+idx)%len(ids)]
+		nic, err := a.azCli.GetNetworkInterfaceByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get NIC %s: %w", id, err)
+		}
+		if nic.Location != nil && !util.SameRegion(*nic.Location, a.azCli.Location()) {
+			return fmt.Errorf("NIC %s is in %s, not in %s", id, *nic.Location, a.azCli.Location())
+		}
+		if nic.Properties != nil && nic.Properties.VirtualMachine != nil {
+			continue
+		}
+		if claimedBy := tagValue(nic.Tags, util.NICClaimedByTagName); claimedBy != "" && claimedBy != name {
+			claimedAt, err := time.Parse(time.RFC3339, tagValue(nic.Tags, util.NICClaimedAtTagName))
+			if err == nil && time.Since(claimedAt) < nicClaimTimeout {
+				continue
+			}
+		}
+
+		tags := map[string]*string{
+			util.NICClaimedByTagName: to.Ptr(name),
+			util.NICClaimedAtTagName: to.Ptr(time.Now().UTC().Format(time.RFC3339)),
+		}
+		if err := a.azCli.UpdateResourceTags(ctx, *nic.ID, tags); err != nil {
+			return fmt.Errorf("failed to claim NIC %s: %w", id, err)
+		}
+		// Tag updates are last writer wins. Read the claim back, in case another create
+		// claimed the NIC at the same time.
+		nic, err = a.azCli.GetNetworkInterfaceByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get NIC %s: %w", id, err)
+		}
+		if tagValue(nic.Tags, util.NICClaimedByTagName) != name {
+			continue
+		}
+		runnerSpec.NetworkInterfaceID = *nic.ID
+		return nil
+	}
+	return fmt.Errorf("all %d NICs of the pool are in use", len(ids))
+}
+
+// releaseNetworkInterface removes the claim of a runner whose create failed, so the NIC
+// can be used right away.
+func (a *azureProvider) releaseNetworkInterface(ctx context.Context, runnerSpec *spec.RunnerSpec) error {
+	tags := map[string]*string{
+		util.NICClaimedByTagName: to.Ptr(""),
+		util.NICClaimedAtTagName: to.Ptr(""),
+	}
+	return a.azCli.UpdateResourceTags(ctx, runnerSpec.NetworkInterfaceID, tags)
+}
@@ -17,9 +17,22 @@ package provider
 import (
 	"context"
 	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 
 	"github.com/cloudbase/garm-provider-azure/config"
 	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/ghmeta"
+	"github.com/cloudbase/garm-provider-azure/internal/queue"
 	"github.com/cloudbase/garm-provider-azure/internal/spec"
 	"github.com/cloudbase/garm-provider-azure/internal/util"
 
@@ -30,6 +43,10 @@ import (
 var _ execution.ExternalProvider = &azureProvider{}
 
 func NewAzureProvider(configPath, controllerID string) (execution.ExternalProvider, error) {
+	return newAzureProvider(configPath, controllerID)
+}
+
+func newAzureProvider(configPath, controllerID string) (*azureProvider, error) {
 	conf, err := config.NewConfig(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("error loading config: %w", err)
@@ -38,88 +55,421 @@ func NewAzureProvider(configPath, controllerID string) (execution.ExternalProvid
 	if err != nil {
 		return nil, fmt.Errorf("failed to get azure CLI: %w", err)
 	}
+	var createQueue *queue.Queue
+	if conf.CreateQueue.MaxConcurrent > 0 {
+		createQueue, err = queue.New(conf.CreateQueue.GetStateDir(), conf.CreateQueue.MaxConcurrent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up create queue: %w", err)
+		}
+	}
 	return &azureProvider{
+		configPath:   configPath,
 		controllerID: controllerID,
 		azCli:        azCli,
 		cfg:          conf,
+		createQueue:  createQueue,
 	}, nil
 }
 
 type azureProvider struct {
+	configPath   string
 	controllerID string
 	azCli        *client.AzureCli
 	cfg          *config.Config
+	createQueue  *queue.Queue
 }
 
 // CreateInstance creates a new compute instance in the provider.
 func (a *azureProvider) CreateInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
-	if bootstrapParams.OSArch != params.Amd64 {
-		// x86_64 only for now. Azure does seem to support arm64, which we will look at at a later time.
-		return params.ProviderInstance{}, fmt.Errorf("invalid architecture %s (supported: %s)", bootstrapParams.OSArch, params.Amd64)
+	if err := a.checkCircuitBreaker(); err != nil {
+		return params.ProviderInstance{}, err
+	}
+	instance, err := a.createInstance(ctx, bootstrapParams)
+	a.recordCircuitResult(err)
+	return instance, err
+}
+
+func (a *azureProvider) createInstance(ctx context.Context, bootstrapParams params.BootstrapInstance) (params.ProviderInstance, error) {
+	if bootstrapParams.OSArch != params.Amd64 && bootstrapParams.OSArch != params.Arm64 {
+		return params.ProviderInstance{}, fmt.Errorf("invalid architecture %s (supported: %s, %s)", bootstrapParams.OSArch, params.Amd64, params.Arm64)
 	}
 
+	bootstrapParams.OSArch = a.sizeArchitecture(ctx, bootstrapParams)
 	runnerSpec, err := spec.GetRunnerSpecFromBootstrapParams(bootstrapParams, a.controllerID, a.cfg)
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to generate spec: %w", err)
 	}
 
+	if len(a.cfg.Regions) > 0 {
+		region, err := a.leastUsedRegion(ctx, runnerSpec)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to pick a region: %w", err)
+		}
+		// All resources of this runner are created in the chosen region.
+		a = a.withLocation(region)
+		runnerSpec.Tags[util.RegionTagName] = to.Ptr(region)
+		log.Printf("%s: creating runner in region %s", runnerSpec.BootstrapParams.Name, region)
+	}
+	if a.cfg.SharedResourceGroup != "" {
+		a = a.withSharedResourceGroup(runnerSpec.SharedResourceGroup)
+	}
+
+	if runnerSpec.IsGitHubOnly() {
+		if err := a.prepareEgressProfile(ctx, runnerSpec); err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to prepare egress profile: %w", err)
+		}
+	}
+
+	for _, warning := range runnerSpec.CallbackReachabilityWarnings() {
+		log.Printf("%s: %s", runnerSpec.BootstrapParams.Name, warning)
+	}
+	if warning := runnerSpec.BurstableWarning(); warning != "" {
+		log.Printf("%s: %s", runnerSpec.BootstrapParams.Name, warning)
+	}
+
 	imgDetails, err := runnerSpec.ImageDetails()
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to get image details: %w", err)
 	}
+	// Image aliases are pinned to the latest version, so the runner reports the version it
+	// actually runs.
+	if runnerSpec.ImageAlias != "" && imgDetails.ID == "" && strings.EqualFold(imgDetails.Version, "latest") {
+		if version, err := a.azCli.LatestImageVersion(ctx, imgDetails); err == nil {
+			imgDetails.Version = version
+			runnerSpec.BootstrapParams.Image = imgDetails.URN()
+			log.Printf("%s: image alias %s resolved to %s", runnerSpec.BootstrapParams.Name, runnerSpec.ImageAlias, runnerSpec.BootstrapParams.Image)
+		} else {
+			log.Printf("WARNING: %s: failed to resolve the latest version of image alias %s: %s", runnerSpec.BootstrapParams.Name, runnerSpec.ImageAlias, err)
+		}
+	}
+	if a.cfg.ImageLifecycle.Enabled() && imgDetails.ID == "" {
+		if err := a.checkImageLifecycle(ctx, runnerSpec, imgDetails); err != nil {
+			return params.ProviderInstance{}, err
+		}
+	}
+
+	// The runner on a specialized image is installed with Run Command, once the VM runs.
+	if runnerSpec.SpecializedImage && a.cfg.AsyncCreate {
+		return params.ProviderInstance{}, fmt.Errorf("specialized_image is not supported with async_create")
+	}
+	// Chaos targets are created on the VM, which async creates don't wait for.
+	if runnerSpec.ChaosTarget && a.cfg.AsyncCreate {
+		return params.ProviderInstance{}, fmt.Errorf("chaos_target is not supported with async_create")
+	}
 
 	var sizeSpec spec.VMSizeEphemeralDiskSizeLimits
 	if runnerSpec.UseEphemeralStorage {
-		sizeSpec, err = a.azCli.GetMaxEphemeralDiskSize(ctx, runnerSpec.VMSize)
+		sizeSpec, err = a.fitEphemeralDisk(ctx, runnerSpec)
 		if err != nil {
-			return params.ProviderInstance{}, fmt.Errorf("failed to get max ephemeral disk size: %w", err)
+			if !runnerSpec.EphemeralDiskFallback {
+				return params.ProviderInstance{}, err
+			}
+			log.Printf("WARNING: %s: %s; falling back to a managed OS disk", runnerSpec.BootstrapParams.Name, err)
+			runnerSpec.FallBackToManagedDisk()
+			sizeSpec = spec.VMSizeEphemeralDiskSizeLimits{}
 		}
+	}
 
-		diskSize := sizeSpec.CacheDiskSizeGB
-		if diskSize == 0 {
-			diskSize = sizeSpec.ResourceDiskSizeGB
+	if a.cfg.DedicatedHosts.Enabled() {
+		if err := a.placeOnDedicatedHosts(runnerSpec); err != nil {
+			return params.ProviderInstance{}, err
 		}
+	}
 
-		// If confidential VMs are used with ephemeral storage, 1 GB is reserved.
-		// See: https://learn.microsoft.com/en-us/azure/virtual-machines/ephemeral-os-disks#confidential-vms-using-ephemeral-os-disks
-		// However, we disable confidential VMs for now, when ephemeral storage is used. We'll leave this recalculation of available
-		// space, in case we enable it in the future.
-		if runnerSpec.Confidential {
-			diskSize = diskSize - 1
-		}
+	if a.cfg.ScaleSets.Enabled() {
+		runnerSpec.ScaleSetID = a.azCli.ScaleSetID(bootstrapParams.PoolID)
+	}
+	if runnerSpec.NATGateway {
+		runnerSpec.SubnetID = a.azCli.PoolSubnetID(bootstrapParams.PoolID)
+	}
 
-		if diskSize < runnerSpec.DiskSizeGB {
-			return params.ProviderInstance{}, fmt.Errorf("maximul ephemeral disk size for %s is %d GB (requested %d)", runnerSpec.VMSize, diskSize, runnerSpec.DiskSizeGB)
+	if len(runnerSpec.Zones) > 1 {
+		zone, err := a.pickZone(ctx, runnerSpec)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to pick a zone: %w", err)
 		}
+		runnerSpec.SetZone(zone)
+		log.Printf("%s: placing runner in zone %s", runnerSpec.BootstrapParams.Name, zone)
 	}
 
-	_, err = a.azCli.CreateResourceGroup(ctx, runnerSpec.BootstrapParams.Name, runnerSpec.Tags)
+	vmSize, err := a.azCli.GetVMSize(ctx, runnerSpec.VMSize)
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create resource group: %w", err)
+		return params.ProviderInstance{}, fmt.Errorf("failed to get VM size details: %w", err)
+	}
+	if err := vmSize.Validate(runnerSpec.Zone); err != nil {
+		return params.ProviderInstance{}, err
+	}
+	if err := a.checkNetworkBandwidth(runnerSpec, vmSize); err != nil {
+		return params.ProviderInstance{}, err
+	}
+	if arch := vmSize.CPUArchitecture(); !strings.EqualFold(arch, string(runnerSpec.CPUArchitecture())) {
+		return params.ProviderInstance{}, fmt.Errorf("VM size %s is %s, but the pool is %s", runnerSpec.VMSize, arch, runnerSpec.BootstrapParams.OSArch)
+	}
+	// Marketplace images of the same offer are published per architecture, so Arm64 pools
+	// easily end up with an x64 SKU of the image.
+	if runnerSpec.BootstrapParams.OSArch == params.Arm64 {
+		arch, err := a.azCli.ImageArchitecture(ctx, imgDetails)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to get image architecture: %w", err)
+		}
+		if arch != armcompute.ArchitectureTypesArm64 {
+			return params.ProviderInstance{}, fmt.Errorf("image %s is %s, but the pool is %s", runnerSpec.BootstrapParams.Image, arch, params.Arm64)
+		}
+	}
+
+	if a.shouldFallBackToRegular(runnerSpec) {
+		runnerSpec.FallBackToRegular()
+		log.Printf("%s: too many spot allocation failures in pool, creating a regular VM", runnerSpec.BootstrapParams.Name)
+	}
+
+	if a.cfg.DryRun {
+		changes, err := a.azCli.WhatIfDeployment(ctx, runnerSpec, sizeSpec)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("dry run failed: %w", err)
+		}
+		plan := strings.Join(changes, "\n")
+		log.Printf("dry run for %s, predicted changes:\n%s", runnerSpec.BootstrapParams.Name, plan)
+		return params.ProviderInstance{}, fmt.Errorf("dry run enabled, no resources were created; predicted changes:\n%s", plan)
+	}
+
+	a.checkQuota(ctx)
+	a.collectGarbage(ctx)
+
+	if a.createQueue != nil {
+		a.reportProgress(ctx, runnerSpec, "waiting in create queue")
+		ticket, err := a.createQueue.Acquire(ctx, runnerSpec.BootstrapParams.Name, a.cfg.CreateQueue.GetWeight(bootstrapParams.PoolID))
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to acquire create slot: %w", err)
+		}
+		defer ticket.Release() //nolint
+	}
+
+	if runnerSpec.HostGroupID != "" {
+		a.reportProgress(ctx, runnerSpec, "checking dedicated host capacity")
+		if err := a.ensureHostCapacity(ctx, runnerSpec.VMSize); err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to reserve dedicated host capacity: %w", err)
+		}
+	}
+
+	if runnerSpec.ScaleSetID != "" {
+		a.reportProgress(ctx, runnerSpec, "ensuring pool scale set")
+		if err := a.azCli.EnsureScaleSet(ctx, bootstrapParams.PoolID, runnerSpec.Zones); err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to create pool scale set: %w", err)
+		}
+	}
+
+	if runnerSpec.NATGateway {
+		a.reportProgress(ctx, runnerSpec, "ensuring pool NAT gateway")
+		subnetID, prefix, err := a.azCli.EnsurePoolNATGateway(ctx, bootstrapParams.PoolID, runnerSpec.VirtualNetworkCIDR, runnerSpec.SubnetCIDR)
+		if err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to create pool NAT gateway: %w", err)
+		}
+		runnerSpec.SubnetID = subnetID
+		log.Printf("%s: egressing through the NAT gateway of pool %s (%s)", runnerSpec.BootstrapParams.Name, bootstrapParams.PoolID, prefix)
+	}
+
+	if adopted, err := a.resolveNameCollision(ctx, runnerSpec); err != nil {
+		return params.ProviderInstance{}, err
+	} else if adopted != nil {
+		return *adopted, nil
+	}
+
+	// A delete arriving while the instance is being created cancels the create, and waits
+	// for it to roll back. The rollback itself must not be cancelled.
+	cleanupCtx := ctx
+	ctx, inflight := a.trackCreate(ctx, runnerSpec.BootstrapParams.Name)
+	defer inflight.Done()
+
+	if err = a.createInstanceResourceGroup(ctx, runnerSpec); err != nil {
+		return params.ProviderInstance{}, err
 	}
 
 	defer func() {
 		if err != nil {
-			a.azCli.DeleteResourceGroup(ctx, runnerSpec.BootstrapParams.Name, true) //nolint
+			a.azCli.DeleteInstance(cleanupCtx, runnerSpec.BootstrapParams.Name) //nolint
+			if a.cfg.DNSZone != nil {
+				a.azCli.DeleteDNSRecord(cleanupCtx, runnerSpec.BootstrapParams.Name) //nolint
+			}
+			if runnerSpec.NetworkInterfaceID != "" {
+				a.releaseNetworkInterface(cleanupCtx, runnerSpec) //nolint
+			}
 		}
 	}()
 
-	_, err = a.azCli.CreateVirtualNetwork(ctx, runnerSpec.BootstrapParams.Name, runnerSpec.VirtualNetworkCIDR)
+	if len(runnerSpec.NetworkInterfaceIDs) > 0 {
+		a.reportProgress(ctx, runnerSpec, "claiming network interface")
+		if err = a.claimNetworkInterface(ctx, runnerSpec); err != nil {
+			return params.ProviderInstance{}, err
+		}
+	}
+
+	if a.cfg.AsyncCreate {
+		if err = a.createInstanceAsync(ctx, runnerSpec, sizeSpec); err != nil {
+			return params.ProviderInstance{}, err
+		}
+		return newProviderInstance(runnerSpec, imgDetails, params.InstanceCreating), nil
+	}
+
+	pubIP, err := a.createInstanceWithMode(ctx, runnerSpec, sizeSpec)
+	a.recordSpotResult(runnerSpec, err)
+	if err != nil && shouldRetryAsRegular(runnerSpec, err) && !inflight.Cancelled() {
+		pubIP, err = a.retryAsRegular(ctx, runnerSpec, sizeSpec, err)
+	}
+	if err != nil {
+		if inflight.Cancelled() {
+			return params.ProviderInstance{}, fmt.Errorf("create cancelled, the instance was deleted: %w", err)
+		}
+		return params.ProviderInstance{}, a.withServiceHealth(ctx, err)
+	}
+
+	if runnerSpec.DiskPerformanceTier != "" {
+		if err = a.azCli.SetOSDiskPerformanceTier(ctx, runnerSpec); err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to set disk performance tier: %w", err)
+		}
+	}
+
+	if runnerSpec.SpecializedImage {
+		a.reportProgress(ctx, runnerSpec, "installing runner with run command")
+		if err = a.bootstrapSpecialized(ctx, runnerSpec); err != nil {
+			return params.ProviderInstance{}, err
+		}
+	}
+
+	if len(runnerSpec.Alerts) > 0 {
+		if err = a.azCli.CreateMetricAlerts(ctx, runnerSpec.BootstrapParams.Name, runnerSpec.Alerts); err != nil {
+			return params.ProviderInstance{}, err
+		}
+	}
+
+	if runnerSpec.AutoShutdown != nil && !runnerSpec.AutoShutdown.TagOnly {
+		if err = a.azCli.CreateShutdownSchedule(ctx, runnerSpec.BootstrapParams.Name, *runnerSpec.AutoShutdown); err != nil {
+			return params.ProviderInstance{}, err
+		}
+	}
+
+	if runnerSpec.ChaosTarget {
+		if err = a.azCli.EnableChaosTarget(ctx, runnerSpec.BootstrapParams.Name, a.cfg.ChaosStudio.GetCapabilities()); err != nil {
+			return params.ProviderInstance{}, err
+		}
+	}
+
+	if a.cfg.DNSZone != nil && pubIP != "" {
+		if err = a.azCli.CreateDNSRecord(ctx, runnerSpec.BootstrapParams.Name, pubIP); err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to create DNS record: %w", err)
+		}
+		log.Printf("%s: created DNS record %s", runnerSpec.BootstrapParams.Name, a.azCli.DNSRecordFQDN(runnerSpec.BootstrapParams.Name))
+	}
+
+	if a.cfg.LockInstances {
+		if err = a.azCli.LockResourceGroup(ctx, runnerSpec.BootstrapParams.Name); err != nil {
+			return params.ProviderInstance{}, fmt.Errorf("failed to lock instance: %w", err)
+		}
+	}
+	if err = a.azCli.SetInstanceState(ctx, runnerSpec.BootstrapParams.Name, util.InstanceStateCreated); err != nil {
+		return params.ProviderInstance{}, err
+	}
+	a.reportProgress(ctx, runnerSpec, "virtual machine created, booting")
+
+	// We're lying here. It takes longer for the client to finish polling than for the VM to
+	// start running the userdata. Just return that the instance is running once the request
+	// to create it goes through.
+	instance := newProviderInstance(runnerSpec, imgDetails, params.InstanceRunning)
+
+	if pubIP != "" {
+		instance.Addresses = append(instance.Addresses, params.Address{
+			Address: pubIP,
+			Type:    params.PublicAddress,
+		})
+	}
+	return instance, nil
+}
+
+// createInstanceResourceGroup creates the resource group of a new instance, tagged as being
+// created. Instances in the shared resource group are tracked by the tags of their VM, so
+// the shared resource group is only created if it is missing.
+func (a *azureProvider) createInstanceResourceGroup(ctx context.Context, runnerSpec *spec.RunnerSpec) error {
+	if a.cfg.SharedResourceGroup != "" {
+		return a.azCli.EnsureSharedResourceGroup(ctx)
+	}
+
+	rgTags := creatingTags(runnerSpec.Tags)
+	if a.cfg.AsyncCreate {
+		rgTags = asyncCreateTags(rgTags)
+	}
+	a.reportProgress(ctx, runnerSpec, "creating resource group")
+	err := a.retryStep(ctx, "creating resource group", func() error {
+		_, err := a.azCli.CreateResourceGroup(ctx, runnerSpec.BootstrapParams.Name, rgTags)
+		return err
+	})
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create virtual network: %w", err)
+		return fmt.Errorf("failed to create resource group: %w", err)
 	}
+	return nil
+}
 
-	subnet, err := a.azCli.CreateSubnet(ctx, runnerSpec.BootstrapParams.Name, runnerSpec.VirtualNetworkCIDR)
+// withSharedResourceGroup returns a copy of the provider that places the resources of
+// runners in another shared resource group.
+func (a *azureProvider) withSharedResourceGroup(rgName string) *azureProvider {
+	withRG := *a
+	withRG.azCli = a.azCli.WithSharedResourceGroup(rgName)
+	return &withRG
+}
+
+// forInstance returns a copy of the provider bound to the shared resource group holding
+// an instance, which may be the one of its pool.
+func (a *azureProvider) forInstance(ctx context.Context, instance string) (*azureProvider, error) {
+	azCli, err := a.azCli.ForInstance(ctx, a.controllerID, instance)
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create subnet: %w", err)
+		return nil, err
+	}
+	forInstance := *a
+	forInstance.azCli = azCli
+	return &forInstance, nil
+}
+
+// newProviderInstance returns the details of a newly created instance.
+func newProviderInstance(runnerSpec *spec.RunnerSpec, imgDetails util.ImageDetails, status params.InstanceStatus) params.ProviderInstance {
+	return params.ProviderInstance{
+		ProviderID: runnerSpec.BootstrapParams.Name,
+		Name:       runnerSpec.RunnerName,
+		OSType:     runnerSpec.BootstrapParams.OSType,
+		OSArch:     runnerSpec.BootstrapParams.OSArch,
+		OSName:     imgDetails.SKU,
+		OSVersion:  imgDetails.Version,
+		Status:     status,
+	}
+}
+
+// createInstanceResources creates the network resources and the VM one by one, using
+// individual API calls.
+func (a *azureProvider) createInstanceResources(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) (string, error) {
+	if runnerSpec.NetworkInterfaceID != "" {
+		a.reportProgress(ctx, runnerSpec, "creating virtual machine")
+		err := a.retryStep(ctx, "creating VM", func() error {
+			return a.azCli.CreateVirtualMachine(ctx, runnerSpec, runnerSpec.NetworkInterfaceID, sizeSpec)
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create VM: %w", err)
+		}
+		return "", nil
+	}
+
+	a.reportProgress(ctx, runnerSpec, "creating network resources")
+	subnetID, err := a.createRunnerSubnet(ctx, runnerSpec)
+	if err != nil {
+		return "", err
 	}
 
 	var pubIPID string
 	var pubIP string
 	if runnerSpec.AllocatePublicIP {
-		publicIP, err := a.azCli.CreatePublicIP(ctx, runnerSpec.BootstrapParams.Name)
+		var publicIP *armnetwork.PublicIPAddress
+		err := a.retryStep(ctx, "creating public IP", func() (err error) {
+			publicIP, err = a.azCli.CreatePublicIP(ctx, runnerSpec.BootstrapParams.Name, runnerSpec)
+			return err
+		})
 		if err != nil {
-			return params.ProviderInstance{}, fmt.Errorf("failed to create public IP: %w", err)
+			return "", fmt.Errorf("failed to create public IP: %w", err)
 		}
 		if publicIP.Properties != nil && publicIP.Properties.IPAddress != nil {
 			pubIP = *publicIP.Properties.IPAddress
@@ -127,64 +477,480 @@ func (a *azureProvider) CreateInstance(ctx context.Context, bootstrapParams para
 		pubIPID = *publicIP.ID
 	}
 
-	nsg, err := a.azCli.CreateNetworkSecurityGroup(ctx, runnerSpec.BootstrapParams.Name, runnerSpec)
+	var nsgID string
+	if !runnerSpec.SkipNetworkSecurityGroup {
+		var nsg *armnetwork.SecurityGroup
+		err := a.retryStep(ctx, "creating network security group", func() (err error) {
+			nsg, err = a.azCli.CreateNetworkSecurityGroup(ctx, runnerSpec.BootstrapParams.Name, runnerSpec)
+			return err
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create network security group: %w", err)
+		}
+		nsgID = *nsg.ID
+	}
+
+	var nic *armnetwork.Interface
+	err = a.retryStep(ctx, "creating NIC", func() (err error) {
+		nic, err = a.azCli.CreateNetWorkInterface(ctx, runnerSpec.BootstrapParams.Name, subnetID, nsgID, pubIPID, runnerSpec)
+		return err
+	})
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create network security group: %w", err)
+		return "", fmt.Errorf("failed to create NIC: %w", err)
 	}
 
-	nic, err := a.azCli.CreateNetWorkInterface(ctx, runnerSpec.BootstrapParams.Name, *subnet.ID, *nsg.ID, pubIPID, runnerSpec.UseAcceleratedNetworking)
+	a.reportProgress(ctx, runnerSpec, "creating virtual machine")
+	err = a.retryStep(ctx, "creating VM", func() error {
+		return a.azCli.CreateVirtualMachine(ctx, runnerSpec, *nic.ID, sizeSpec)
+	})
 	if err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create NIC: %w", err)
+		return "", fmt.Errorf("failed to create VM: %w", err)
 	}
 
-	if err := a.azCli.CreateVirtualMachine(ctx, runnerSpec, *nic.ID, sizeSpec); err != nil {
-		return params.ProviderInstance{}, fmt.Errorf("failed to create VM: %w", err)
+	return pubIP, nil
+}
+
+// createRunnerSubnet creates the virtual network and subnets of a runner, and returns the
+// ID of the subnet the runner is attached to. Runners attached to an existing subnet get
+// no virtual network of their own.
+func (a *azureProvider) createRunnerSubnet(ctx context.Context, runnerSpec *spec.RunnerSpec) (string, error) {
+	if runnerSpec.SubnetID != "" {
+		return runnerSpec.SubnetID, nil
 	}
 
-	// We're lying here. It takes longer for the client to finish polling than for the VM to
-	// start running the userdata. Just return that the instance is running once the request
-	// to create it goes through.
-	instance := params.ProviderInstance{
-		ProviderID: runnerSpec.BootstrapParams.Name,
-		Name:       runnerSpec.BootstrapParams.Name,
-		OSType:     runnerSpec.BootstrapParams.OSType,
-		OSArch:     runnerSpec.BootstrapParams.OSArch,
-		OSName:     imgDetails.SKU,
-		OSVersion:  imgDetails.Version,
-		Status:     "running",
+	err := a.retryStep(ctx, "creating virtual network", func() error {
+		_, err := a.azCli.CreateVirtualNetwork(ctx, runnerSpec.BootstrapParams.Name, runnerSpec.VirtualNetworkCIDR, runnerSpec.NetworkTags)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create virtual network: %w", err)
 	}
 
-	if pubIP != "" {
-		instance.Addresses = append(instance.Addresses, params.Address{
-			Address: pubIP,
-			Type:    params.PublicAddress,
+	var subnet *armnetwork.Subnet
+	err = a.retryStep(ctx, "creating subnet", func() (err error) {
+		subnet, err = a.azCli.CreateSubnet(ctx, runnerSpec.BootstrapParams.Name, runnerSpec.BootstrapParams.Name, runnerSpec.SubnetCIDR)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create subnet: %w", err)
+	}
+
+	for name, cidr := range runnerSpec.ExtraSubnets {
+		err = a.retryStep(ctx, "creating subnet "+name, func() error {
+			_, err := a.azCli.CreateSubnet(ctx, runnerSpec.BootstrapParams.Name, name, cidr)
+			return err
 		})
+		if err != nil {
+			return "", fmt.Errorf("failed to create subnet %s: %w", name, err)
+		}
+	}
+	return *subnet.ID, nil
+}
+
+// createInstanceDeployment creates all resources of an instance using a single ARM
+// deployment.
+func (a *azureProvider) createInstanceDeployment(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) (string, error) {
+	a.reportProgress(ctx, runnerSpec, "creating deployment")
+	err := a.retryStep(ctx, "creating deployment", func() error {
+		return a.azCli.CreateDeployment(ctx, runnerSpec, sizeSpec)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create deployment: %w", err)
+	}
+
+	if !runnerSpec.AllocatePublicIP {
+		return "", nil
+	}
+
+	publicIP, err := a.azCli.GetPublicIP(ctx, a.azCli.InstanceResourceGroup(runnerSpec.BootstrapParams.Name), runnerSpec.BootstrapParams.Name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get public IP: %w", err)
+	}
+	if publicIP.Properties != nil && publicIP.Properties.IPAddress != nil {
+		return *publicIP.Properties.IPAddress, nil
+	}
+	return "", nil
+}
+
+// bootstrapSpecialized installs the runner on a VM booted from a specialized image. These
+// skip provisioning, so the custom data is never run.
+func (a *azureProvider) bootstrapSpecialized(ctx context.Context, runnerSpec *spec.RunnerSpec) error {
+	script, err := runnerSpec.SpecializedBootstrapScript()
+	if err != nil {
+		return fmt.Errorf("failed to generate bootstrap script: %w", err)
+	}
+	name := runnerSpec.BootstrapParams.Name
+	var output string
+	if runnerSpec.BootstrapParams.OSType == params.Windows {
+		output, err = a.azCli.RunPowerShellScript(ctx, a.azCli.InstanceResourceGroup(name), name, script)
+	} else {
+		output, err = a.azCli.RunShellScript(ctx, a.azCli.InstanceResourceGroup(name), name, script)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run bootstrap script: %w", err)
+	}
+	log.Printf("%s: %s", runnerSpec.BootstrapParams.Name, strings.TrimSpace(output))
+	return nil
+}
+
+// createInstanceWithMode creates the resources of the runner with the configured creation
+// mode, and returns its public IP, if it has one.
+func (a *azureProvider) createInstanceWithMode(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits) (string, error) {
+	if a.cfg.CreationMode == config.CreationModeDeployment {
+		return a.createInstanceDeployment(ctx, runnerSpec, sizeSpec)
+	}
+	return a.createInstanceResources(ctx, runnerSpec, sizeSpec)
+}
+
+// reportProgress logs a progress message for an instance that is being created and, if
+// enabled, sends it to the garm callback URL. Failing to send the update is not fatal.
+func (a *azureProvider) reportProgress(ctx context.Context, runnerSpec *spec.RunnerSpec, msg string) {
+	log.Printf("%s: %s", runnerSpec.BootstrapParams.Name, msg)
+	if !a.cfg.ReportProgress {
+		return
+	}
+	if err := util.SendStatusUpdate(ctx, runnerSpec.BootstrapParams.CallbackURL, runnerSpec.BootstrapParams.InstanceToken, msg); err != nil {
+		log.Printf("failed to send status update for %s: %s", runnerSpec.BootstrapParams.Name, err)
+	}
+}
+
+// prepareEgressProfile gets the GitHub IP ranges and resolves the garm callback and
+// metadata URLs, which are the only destinations runners can reach with the github-only
+// egress profile. When a refresh of the cached GitHub ranges finds changes, the rules of
+// existing runners are updated as well.
+func (a *azureProvider) prepareEgressProfile(ctx context.Context, runnerSpec *spec.RunnerSpec) error {
+	cache := ghmeta.NewCache(a.cfg.GetGitHubMetaCacheFile(), a.cfg.GetGitHubMetaURL(), a.cfg.GetGitHubMetaRefreshInterval())
+	ranges, changed, err := cache.Ranges(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get github ranges: %w", err)
+	}
+	runnerSpec.GitHubEgressCIDRs = ranges
+	if changed {
+		updated, err := a.azCli.UpdateGitHubEgressRules(ctx, ranges)
+		if err != nil {
+			log.Printf("failed to update github egress rules of existing runners: %s", err)
+		} else {
+			log.Printf("github ranges changed, updated the egress rules of %d runners", updated)
+		}
+	}
+
+	seen := map[string]struct{}{}
+	for _, rawURL := range []string{runnerSpec.BootstrapParams.CallbackURL, runnerSpec.BootstrapParams.MetadataURL} {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Hostname() == "" {
+			return fmt.Errorf("invalid garm URL %q", rawURL)
+		}
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", parsed.Hostname())
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", parsed.Hostname(), err)
+		}
+		for _, ip := range ips {
+			cidr := ip.String() + "/32"
+			if _, ok := seen[cidr]; !ok {
+				seen[cidr] = struct{}{}
+				runnerSpec.CallbackEgressCIDRs = append(runnerSpec.CallbackEgressCIDRs, cidr)
+			}
+		}
+	}
+	return nil
+}
+
+// pickZone places the runner in one of the allowed zones the VM size is available in,
+// following the zone placement of the pool.
+func (a *azureProvider) pickZone(ctx context.Context, runnerSpec *spec.RunnerSpec) (string, error) {
+	vmSize, err := a.azCli.GetVMSize(ctx, runnerSpec.VMSize)
+	if err != nil {
+		return "", fmt.Errorf("failed to get VM size details: %w", err)
+	}
+	runnerSpec.RemoveZones(vmSize.RestrictedZones)
+	switch len(runnerSpec.Zones) {
+	case 0:
+		return "", fmt.Errorf("VM size %s is not available in any of the allowed zones (%s)", runnerSpec.VMSize, vmSize.RestrictionReason)
+	case 1:
+		return runnerSpec.Zones[0], nil
+	}
+	if runnerSpec.ZonePlacement == config.ZonePlacementRandom {
+		return runnerSpec.RandomZone(), nil
+	}
+	return a.leastUsedZone(ctx, runnerSpec)
+}
+
+// leastUsedZone counts the runners of the pool in each zone, and returns the allowed zone
+// with the fewest runners.
+func (a *azureProvider) leastUsedZone(ctx context.Context, runnerSpec *spec.RunnerSpec) (string, error) {
+	vms, err := a.azCli.ListVirtualMachines(ctx, runnerSpec.BootstrapParams.PoolID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list pool instances: %w", err)
+	}
+	counts := map[string]int{}
+	for _, vm := range vms {
+		if vm.Location != nil && !util.SameRegion(*vm.Location, a.azCli.Location()) {
+			continue
+		}
+		if zone, ok := vm.Tags[util.ZoneTagName]; ok && zone != nil {
+			counts[*zone]++
+		} else if len(vm.Zones) > 0 && vm.Zones[0] != nil {
+			counts[*vm.Zones[0]]++
+		}
+	}
+	return runnerSpec.LeastUsedZone(counts), nil
+}
+
+// checkQuota logs the quota usage in the configured location, if the last check is older
+// than the configured interval. Quotas with little headroom are logged as warnings. The
+// check is best effort, and never fails the operation.
+func (a *azureProvider) checkQuota(ctx context.Context) {
+	interval := time.Duration(a.cfg.QuotaCheck.IntervalMinutes) * time.Minute
+	if interval == 0 {
+		return
+	}
+
+	stateFile := a.cfg.QuotaCheck.GetStateFile()
+	if info, err := os.Stat(stateFile); err == nil && time.Since(info.ModTime()) < interval {
+		return
+	}
+	// Record the check before running it, so concurrent creates don't all run it.
+	if err := os.WriteFile(stateFile, []byte(time.Now().UTC().Format(time.RFC3339)), 0o600); err != nil {
+		log.Printf("failed to record quota check: %s", err)
+	}
+
+	usages, err := a.azCli.ListQuotaUsage(ctx)
+	if err != nil {
+		log.Printf("failed to check quota usage: %s", err)
+		return
+	}
+	warnPercent := float64(a.cfg.QuotaCheck.GetWarnPercent())
+	for _, usage := range usages {
+		if usage.Current == 0 || usage.Limit <= 0 {
+			continue
+		}
+		headroom := usage.HeadroomPercent()
+		if headroom < warnPercent {
+			log.Printf("WARNING: %s quota %s in %s is at %d of %d (%.1f%% headroom), consider requesting an increase", usage.Provider, usage.Name, a.cfg.Location, usage.Current, usage.Limit, headroom)
+			continue
+		}
+		log.Printf("%s quota %s in %s is at %d of %d (%.1f%% headroom)", usage.Provider, usage.Name, a.cfg.Location, usage.Current, usage.Limit, headroom)
+	}
+}
+
+// collectGarbage removes the resource groups of this controller that hold no healthy VM,
+// if the last collection is older than the configured interval. Deletions are started
+// but not waited for, and failures are only logged.
+func (a *azureProvider) collectGarbage(ctx context.Context) {
+	interval := time.Duration(a.cfg.GarbageCollection.IntervalMinutes) * time.Minute
+	if interval == 0 {
+		return
+	}
+
+	stateFile := a.cfg.GarbageCollection.GetStateFile()
+	if info, err := os.Stat(stateFile); err == nil && time.Since(info.ModTime()) < interval {
+		return
+	}
+	// Record the collection before running it, so concurrent creates don't all run it.
+	if err := os.WriteFile(stateFile, []byte(time.Now().UTC().Format(time.RFC3339)), 0o600); err != nil {
+		log.Printf("failed to record garbage collection: %s", err)
+	}
+
+	orphans, err := a.azCli.FindOrphans(ctx, a.controllerID, a.cfg.GarbageCollection.GetMinAge())
+	if err != nil {
+		log.Printf("failed to collect garbage: %s", err)
+		return
+	}
+	for _, orphan := range orphans {
+		log.Printf("removing orphaned resource group %s (%s since %s)", orphan.ResourceGroup, orphan.State, orphan.Since.Format(time.RFC3339))
+		if err := a.azCli.RemoveOrphan(ctx, orphan.ResourceGroup, false); err != nil {
+			log.Printf("failed to remove orphaned resource group %s: %s", orphan.ResourceGroup, err)
+		}
 	}
-	return instance, nil
 }
 
 // Delete instance will delete the instance in a provider.
 func (a *azureProvider) DeleteInstance(ctx context.Context, instance string) error {
-	err := a.azCli.DeleteResourceGroup(ctx, instance, true)
+	// garm may pass the runner name instead of the provider ID, if the create failed.
+	instance = util.AzureResourceName(instance)
+	a, err := a.forInstance(ctx, instance)
 	if err != nil {
 		return fmt.Errorf("failed to delete instance: %w", err)
 	}
+	if err := a.cancelInflightCreate(ctx, instance); err != nil {
+		return fmt.Errorf("failed to delete instance: %w", err)
+	}
+	// Recorded before anything is removed, so an interrupted delete is finished instead of
+	// the leftovers being adopted.
+	if err := a.azCli.SetInstanceState(ctx, instance, util.InstanceStateDeleting); err != nil {
+		return fmt.Errorf("failed to delete instance: %w", err)
+	}
+	if err := a.secureWipe(ctx, instance); err != nil {
+		return fmt.Errorf("failed to delete instance: %w", err)
+	}
+	// Always attempt to remove the lock, in case lock_instances was disabled after the
+	// instance was created.
+	if err := a.azCli.UnlockResourceGroup(ctx, instance); err != nil {
+		return fmt.Errorf("failed to delete instance: %w", err)
+	}
+
+	if err := a.azCli.DeleteInstance(ctx, instance); err != nil {
+		return fmt.Errorf("failed to delete instance: %w", err)
+	}
+
+	if a.cfg.DNSZone != nil {
+		if err := a.azCli.DeleteDNSRecord(ctx, instance); err != nil {
+			return fmt.Errorf("failed to delete instance: %w", err)
+		}
+	}
+
+	if a.cfg.DedicatedHosts.Enabled() {
+		a.releaseIdleHosts(ctx)
+	}
 	return nil
 }
 
 // GetInstance will return details about one instance.
 func (a *azureProvider) GetInstance(ctx context.Context, instance string) (params.ProviderInstance, error) {
-	vm, err := a.azCli.GetInstance(ctx, instance, instance)
+	instance = util.AzureResourceName(instance)
+	a, err := a.forInstance(ctx, instance)
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to get VM details: %w", err)
 	}
+	vm, err := a.azCli.GetInstance(ctx, a.azCli.InstanceResourceGroup(instance), instance)
+	if err != nil {
+		if a.cfg.AsyncCreate {
+			if pending, ok := a.asyncCreateStatus(ctx, instance); ok {
+				return pending, nil
+			}
+		}
+		moved, ok, findErr := a.findMovedInstance(ctx, instance, err)
+		if findErr != nil {
+			log.Printf("failed to search for %s: %s", instance, findErr)
+		}
+		if !ok {
+			return params.ProviderInstance{}, fmt.Errorf("failed to get VM details: %w", err)
+		}
+		vm = moved
+	}
 	details, err := util.AzureInstanceToParamsInstance(vm)
 	if err != nil {
 		return params.ProviderInstance{}, fmt.Errorf("failed to convert VM details: %w", err)
 	}
+
+	details = cloudInitTagStatus(vm, details)
+	if details.Status == params.InstanceRunning {
+		if a.cfg.RefreshAddresses {
+			details = a.withAddresses(ctx, vm, details)
+		}
+	}
+	a.reapSelfTerminated(ctx, vm, details)
+	a.reapHung(ctx, vm, details)
 	return details, nil
 }
 
+// reapSelfTerminated starts deleting runners that powered themselves off after their job.
+// The VM is left stopped if the deletion fails, and retried on the next call.
+func (a *azureProvider) reapSelfTerminated(ctx context.Context, vm armcompute.VirtualMachine, details params.ProviderInstance) {
+	tag, ok := vm.Tags[util.SelfTerminateTagName]
+	if !ok || tag == nil || *tag != "true" || details.Status != params.InstanceStopped {
+		return
+	}
+	log.Printf("%s powered itself off, deleting it", details.Name)
+	if err := a.recycleInstance(ctx, details); err != nil {
+		log.Printf("failed to delete self terminated runner %s: %s", details.Name, err)
+	}
+}
+
+// reapHung starts deleting runners that stopped sending heartbeats, because their agent
+// died or the VM hung. Runners that never sent a heartbeat are measured from the time the
+// VM was created.
+func (a *azureProvider) reapHung(ctx context.Context, vm armcompute.VirtualMachine, details params.ProviderInstance) {
+	timeout, err := strconv.ParseUint(tagValue(vm.Tags, util.HeartbeatTimeoutTagName), 10, 32)
+	if err != nil || details.Status != params.InstanceRunning {
+		return
+	}
+	var last time.Time
+	if heartbeat := tagValue(vm.Tags, util.LastHeartbeatTagName); heartbeat != "" {
+		last, _ = time.Parse(time.RFC3339, heartbeat)
+	}
+	if last.IsZero() && vm.Properties != nil && vm.Properties.TimeCreated != nil {
+		last = *vm.Properties.TimeCreated
+	}
+	if last.IsZero() || time.Since(last) < time.Duration(timeout)*time.Minute {
+		return
+	}
+
+	log.Printf("%s sent no heartbeat since %s, deleting it", details.Name, last.Format(time.RFC3339))
+	if err := a.recycleInstance(ctx, details); err != nil {
+		log.Printf("failed to delete hung runner %s: %s", details.Name, err)
+	}
+}
+
+// recycleInstance starts deleting a runner found unusable, without waiting for the delete
+// to finish. garm replaces the runner once it is gone.
+func (a *azureProvider) recycleInstance(ctx context.Context, details params.ProviderInstance) error {
+	if err := a.azCli.SetInstanceState(ctx, details.ProviderID, util.InstanceStateDeleting); err != nil {
+		return err
+	}
+	if a.cfg.SharedResourceGroup != "" {
+		// The resources of the instance are deleted one by one, there is no single
+		// operation to start.
+		if err := a.azCli.DeleteInstanceResources(ctx, details.ProviderID); err != nil {
+			return err
+		}
+	} else if err := a.azCli.StartResourceGroupDelete(ctx, details.ProviderID); err != nil {
+		return err
+	}
+	if a.cfg.DNSZone != nil {
+		if err := a.azCli.DeleteDNSRecord(ctx, details.ProviderID); err != nil {
+			return fmt.Errorf("failed to delete DNS record: %w", err)
+		}
+	}
+	return nil
+}
+
+// cloudInitTagStatus reports runners whose cloud-init status tag records a failure as
+// errored. The tag is updated by the background checks of the pool.
+func cloudInitTagStatus(vm armcompute.VirtualMachine, details params.ProviderInstance) params.ProviderInstance {
+	if tagValue(vm.Tags, util.CloudInitStatusTagName) == util.CloudInitStatusError {
+		details.Status = params.InstanceError
+	}
+	return details
+}
+
+// checkCloudInitStatus polls cloud-init on runners that were created with the status
+// check enabled. Once cloud-init reports a final status, it is recorded as a tag on the
+// VM, so we don't need to run the command again on subsequent checks. The command takes
+// tens of seconds, so this only runs in the background checks of the pool.
+func (a *azureProvider) checkCloudInitStatus(ctx context.Context, vm armcompute.VirtualMachine, details params.ProviderInstance) {
+	if tagValue(vm.Tags, util.CloudInitStatusTagName) != util.CloudInitStatusPending || vm.ID == nil {
+		return
+	}
+
+	// The name of the runner may have been shortened to fit Azure, the VM and its
+	// resource group are named after the provider ID.
+	status, out, err := a.azCli.GetCloudInitStatus(ctx, a.azCli.InstanceResourceGroup(details.ProviderID), details.ProviderID)
+	if err != nil {
+		log.Printf("failed to get cloud-init status for %s: %s", details.Name, err)
+		return
+	}
+
+	var newTag string
+	switch status {
+	case "done":
+		newTag = util.CloudInitStatusDone
+	case "error", "degraded", "degraded done":
+		newTag = util.CloudInitStatusError
+		log.Printf("cloud-init failed on %s: %s", details.Name, out)
+	default:
+		// Still running.
+		return
+	}
+
+	tags := map[string]*string{
+		util.CloudInitStatusTagName: to.Ptr(newTag),
+	}
+	if err := a.azCli.UpdateResourceTags(ctx, *vm.ID, tags); err != nil {
+		log.Printf("failed to record cloud-init status for %s: %s", details.Name, err)
+	}
+}
+
 // ListInstances will list all instances for a provider.
 func (a *azureProvider) ListInstances(ctx context.Context, poolID string) ([]params.ProviderInstance, error) {
 	instances, err := a.azCli.ListVirtualMachines(ctx, poolID)
@@ -197,6 +963,7 @@ func (a *azureProvider) ListInstances(ctx context.Context, poolID string) ([]par
 	}
 
 	resp := make([]params.ProviderInstance, len(instances))
+	var maintain bool
 	for idx, val := range instances {
 		if val == nil {
 			return nil, fmt.Errorf("nil vm object in response")
@@ -205,22 +972,138 @@ func (a *azureProvider) ListInstances(ctx context.Context, poolID string) ([]par
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert VM details: %w", err)
 		}
-		resp[idx] = details
+		resp[idx] = cloudInitTagStatus(*val, details)
+		maintain = maintain || needsMaintenance(*val)
+	}
+	// Everything that needs more API calls than the list, or writes tags, runs in the
+	// background, so garm doesn't wait for it.
+	if maintain {
+		if err := a.startMaintenance(poolID); err != nil {
+			log.Printf("failed to start the background checks of pool %s: %s", poolID, err)
+		}
 	}
 	return resp, nil
 }
 
-// RemoveAllInstances will remove all instances created by this provider.
+// RemoveAllInstances will remove all instances created by this provider. Instances are
+// found by the controller ID tag of their resource group, or of their VM in the shared
+// resource group. A failure to remove an instance does not stop the removal of the others.
 func (a *azureProvider) RemoveAllInstances(ctx context.Context) error {
+	instances, err := a.controllerInstances(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var failed int
+	for _, instance := range instances {
+		log.Printf("removing instance %s", instance)
+		if err := a.DeleteInstance(ctx, instance); err != nil {
+			log.Printf("failed to remove instance %s: %s", instance, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d of %d instances", failed, len(instances))
+	}
 	return nil
 }
 
+// controllerInstances returns the names of all instances of this controller.
+func (a *azureProvider) controllerInstances(ctx context.Context) ([]string, error) {
+	var instances []string
+	if a.cfg.SharedResourceGroup != "" {
+		vms, err := a.azCli.ListVirtualMachinesWithTag(ctx, util.ControllerIDTagName, a.controllerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, vm := range vms {
+			if vm.Name != nil {
+				instances = append(instances, *vm.Name)
+			}
+		}
+		return instances, nil
+	}
+
+	groups, err := a.azCli.ListResourceGroupsWithTag(ctx, util.ControllerIDTagName, a.controllerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rg := range groups {
+		if rg.Name != nil {
+			instances = append(instances, *rg.Name)
+		}
+	}
+	return instances, nil
+}
+
 // Stop shuts down the instance.
 func (a *azureProvider) Stop(ctx context.Context, instance string, force bool) error {
-	return a.azCli.DealocateVM(ctx, instance, instance)
+	instance = util.AzureResourceName(instance)
+	a, err := a.forInstance(ctx, instance)
+	if err != nil {
+		return err
+	}
+	if err := a.secureWipe(ctx, instance); err != nil {
+		return fmt.Errorf("failed to stop instance: %w", err)
+	}
+	return a.azCli.DealocateVM(ctx, a.azCli.InstanceResourceGroup(instance), instance)
 }
 
 // Start boots up an instance.
 func (a *azureProvider) Start(ctx context.Context, instance string) error {
-	return a.azCli.StartVM(ctx, instance)
+	instance = util.AzureResourceName(instance)
+	a, err := a.forInstance(ctx, instance)
+	if err != nil {
+		return err
+	}
+	if err := a.azCli.StartVM(ctx, instance); err != nil {
+		return err
+	}
+	if a.cfg.DNSZone != nil {
+		if err := a.refreshDNSRecord(ctx, instance); err != nil {
+			return fmt.Errorf("failed to refresh DNS record: %w", err)
+		}
+	}
+	return nil
+}
+
+// refreshDNSRecord points the DNS record of a runner to its current public IP, which may
+// have changed if the runner uses a pre-created NIC with a dynamic public IP.
+func (a *azureProvider) refreshDNSRecord(ctx context.Context, instance string) error {
+	vm, err := a.azCli.GetInstance(ctx, a.azCli.InstanceResourceGroup(instance), instance)
+	if err != nil {
+		return fmt.Errorf("failed to get VM details: %w", err)
+	}
+	_, public, err := a.azCli.InstanceAddresses(ctx, vm)
+	if err != nil {
+		return err
+	}
+	// Runners without a public IP have no DNS record.
+	if public == "" {
+		return nil
+	}
+	return a.azCli.CreateDNSRecord(ctx, instance, public)
+}
+
+// withAddresses adds the current addresses of a running VM to its details. Failing to get
+// them is not fatal.
+func (a *azureProvider) withAddresses(ctx context.Context, vm armcompute.VirtualMachine, details params.ProviderInstance) params.ProviderInstance {
+	private, public, err := a.azCli.InstanceAddresses(ctx, vm)
+	if err != nil {
+		log.Printf("failed to get the addresses of %s: %s", details.Name, err)
+		return details
+	}
+	if public != "" {
+		details.Addresses = append(details.Addresses, params.Address{
+			Address: public,
+			Type:    params.PublicAddress,
+		})
+	}
+	if private != "" {
+		details.Addresses = append(details.Addresses, params.Address{
+			Address: private,
+			Type:    params.PrivateAddress,
+		})
+	}
+	return details
 }
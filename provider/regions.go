@@ -0,0 +1,62 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// withLocation returns a copy of the provider that creates resources in another region.
+func (a *azureProvider) withLocation(location string) *azureProvider {
+	withLocation := *a
+	withLocation.azCli = a.azCli.WithLocation(location)
+	return &withLocation
+}
+
+// leastUsedRegion counts the runners of the pool in each configured region, and returns
+// the region with the fewest runners relative to its weight. Ties go to the region listed
+// first in the config.
+func (a *azureProvider) leastUsedRegion(ctx context.Context, runnerSpec *spec.RunnerSpec) (string, error) {
+	vms, err := a.azCli.ListVirtualMachines(ctx, runnerSpec.BootstrapParams.PoolID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list pool instances: %w", err)
+	}
+
+	counts := make([]int, len(a.cfg.Regions))
+	for _, vm := range vms {
+		if vm.Location == nil {
+			continue
+		}
+		for idx, region := range a.cfg.Regions {
+			if util.SameRegion(*vm.Location, region.Name) {
+				counts[idx]++
+				break
+			}
+		}
+	}
+
+	best := 0
+	for idx, region := range a.cfg.Regions {
+		// Compare counts[idx]/weight to counts[best]/weight of best, without dividing.
+		if counts[idx]*a.cfg.Regions[best].GetWeight() < counts[best]*region.GetWeight() {
+			best = idx
+		}
+	}
+	return a.cfg.Regions[best].Name, nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/cloudbase/garm-provider-common/params"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// secureWipe shreds the sensitive paths of a runner with a persistent OS disk, before it
+// is deleted or deallocated. Ephemeral OS disks are discarded with the VM, so they are not
+// wiped. Run Command needs a running VM, so a stopped VM is started for the wipe. A failed
+// wipe fails the delete or stop, so garm never removes an unwiped disk.
+func (a *azureProvider) secureWipe(ctx context.Context, instance string) error {
+	vm, err := a.azCli.GetInstance(ctx, a.azCli.InstanceResourceGroup(instance), instance)
+	if err != nil {
+		if client.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get VM details: %w", err)
+	}
+	if tagValue(vm.Tags, util.SecureWipeTagName) != "true" {
+		return nil
+	}
+	if vm.Properties != nil && vm.Properties.StorageProfile != nil && vm.Properties.StorageProfile.OSDisk != nil &&
+		vm.Properties.StorageProfile.OSDisk.DiffDiskSettings != nil {
+		return nil
+	}
+	details, err := util.AzureInstanceToParamsInstance(vm)
+	if err != nil {
+		return fmt.Errorf("failed to convert VM details: %w", err)
+	}
+	if details.Status != params.InstanceRunning {
+		log.Printf("%s is not running, starting it for the secure wipe", instance)
+		if err := a.azCli.StartVM(ctx, instance); err != nil {
+			return fmt.Errorf("failed to start %s for the secure wipe: %w", instance, err)
+		}
+	}
+
+	var output string
+	if details.OSType == params.Windows {
+		output, err = a.azCli.RunPowerShellScript(ctx, a.azCli.InstanceResourceGroup(instance), instance, spec.WindowsSecureWipeScript(a.cfg.SecureWipe.GetWindowsPaths()))
+	} else {
+		output, err = a.azCli.RunShellScript(ctx, a.azCli.InstanceResourceGroup(instance), instance, spec.LinuxSecureWipeScript(a.cfg.SecureWipe.GetLinuxPaths()))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to wipe %s: %w", instance, err)
+	}
+	log.Printf("secure wipe of %s: %s", instance, output)
+	return nil
+}
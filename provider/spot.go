@@ -0,0 +1,126 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/internal/client"
+	"github.com/cloudbase/garm-provider-azure/internal/spec"
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// spotState records the consecutive spot allocation failures of a pool. Every create is a
+// separate process, so this is kept on disk.
+type spotState struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+func (a *azureProvider) spotStatePath(poolID string) string {
+	return filepath.Join(a.cfg.GetSpotStateDir(), poolID+".json")
+}
+
+func (a *azureProvider) loadSpotState(poolID string) spotState {
+	var state spotState
+	data, err := os.ReadFile(a.spotStatePath(poolID))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("ignoring invalid spot state for pool %s: %s", poolID, err)
+		return spotState{}
+	}
+	return state
+}
+
+func (a *azureProvider) saveSpotState(poolID string, state spotState) error {
+	if err := os.MkdirAll(a.cfg.GetSpotStateDir(), 0o700); err != nil {
+		return fmt.Errorf("failed to create spot state dir: %w", err)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spot state: %w", err)
+	}
+	if err := os.WriteFile(a.spotStatePath(poolID), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write spot state: %w", err)
+	}
+	return nil
+}
+
+// shouldFallBackToRegular returns true if the pool recently had too many consecutive spot
+// allocation failures, and the runner should be created as a regular VM.
+func (a *azureProvider) shouldFallBackToRegular(runnerSpec *spec.RunnerSpec) bool {
+	if runnerSpec.Spot == nil || runnerSpec.Spot.FallbackAfter == 0 {
+		return false
+	}
+	state := a.loadSpotState(runnerSpec.BootstrapParams.PoolID)
+	cooldown := time.Duration(runnerSpec.Spot.FallbackCooldownMinutes) * time.Minute
+	return state.Failures >= runnerSpec.Spot.FallbackAfter && time.Since(state.LastFailure) < cooldown
+}
+
+// shouldRetryAsRegular returns true if the spot VM of the runner failed to be allocated,
+// and the pool wants it created as a regular VM instead.
+func shouldRetryAsRegular(runnerSpec *spec.RunnerSpec, createErr error) bool {
+	return runnerSpec.UseSpot() && runnerSpec.Spot.RetryAsRegular && client.IsAllocationFailure(createErr)
+}
+
+// retryAsRegular creates the runner as a regular VM, after its spot VM failed to be
+// allocated. The rest of the resources of the runner are kept.
+func (a *azureProvider) retryAsRegular(ctx context.Context, runnerSpec *spec.RunnerSpec, sizeSpec spec.VMSizeEphemeralDiskSizeLimits, spotErr error) (string, error) {
+	name := runnerSpec.BootstrapParams.Name
+	log.Printf("%s: spot allocation failed, retrying as a regular VM: %s", name, spotErr)
+	a.reportProgress(ctx, runnerSpec, "no spot capacity, creating a regular VM")
+
+	// The priority of a VM can't be changed, so the failed spot VM is removed first.
+	if err := a.azCli.DeleteVirtualMachine(ctx, a.azCli.InstanceResourceGroup(name), name); err != nil {
+		return "", fmt.Errorf("failed to remove spot VM (spot allocation failed with: %s): %w", spotErr, err)
+	}
+	runnerSpec.FallBackToRegular()
+	priority := map[string]*string{util.PriorityTagName: runnerSpec.Tags[util.PriorityTagName]}
+	if err := a.azCli.SetInstanceTags(ctx, name, priority); err != nil {
+		return "", err
+	}
+	return a.createInstanceWithMode(ctx, runnerSpec, sizeSpec)
+}
+
+// recordSpotResult counts spot allocation failures, and resets the count once a spot VM
+// is created. Failing to record the result is not fatal.
+func (a *azureProvider) recordSpotResult(runnerSpec *spec.RunnerSpec, createErr error) {
+	if !runnerSpec.UseSpot() || runnerSpec.Spot.FallbackAfter == 0 {
+		return
+	}
+	poolID := runnerSpec.BootstrapParams.PoolID
+	var state spotState
+	switch {
+	case createErr == nil:
+	case client.IsAllocationFailure(createErr):
+		state = a.loadSpotState(poolID)
+		state.Failures++
+		state.LastFailure = time.Now().UTC()
+		log.Printf("spot allocation failed for %s (%d consecutive failures in pool %s)", runnerSpec.BootstrapParams.Name, state.Failures, poolID)
+	default:
+		return
+	}
+	if err := a.saveSpotState(poolID, state); err != nil {
+		log.Printf("failed to record spot allocation result for pool %s: %s", poolID, err)
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2023 Cloudbase Solutions SRL
+//
+//    Licensed under the Apache License, Version 2.0 (the "License"); you may
+//    not use this file except in compliance with the License. You may obtain
+//    a copy of the License at
+//
+//         http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+//    WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+//    License for the specific language governing permissions and limitations
+//    under the License.
+
+package provider
+
+import (
+	"time"
+
+	"github.com/cloudbase/garm-provider-azure/internal/util"
+)
+
+// creatingTags returns the tags of the resource group of a new instance, marking it as
+// being created.
+func creatingTags(tags map[string]*string) map[string]*string {
+	state := util.InstanceStateTags(util.InstanceStateCreating, time.Now())
+	ret := make(map[string]*string, len(tags)+len(state))
+	for name, value := range tags {
+		ret[name] = value
+	}
+	for name, value := range state {
+		ret[name] = value
+	}
+	return ret
+}
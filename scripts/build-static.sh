@@ -11,6 +11,9 @@ USER_ID=${USER_ID:-$UID}
 USER_GROUP=${USER_GROUP:-$(id -g)}
 
 cd $GARM_SOURCE
-go build -mod vendor -o $BIN_DIR/garm-provider-azure -tags osusergo,netgo -ldflags "-linkmode external -extldflags '-static' -s -w" .
+VERSION=${VERSION:-$(git describe --tags --always --dirty)}
+COMMIT=${COMMIT:-$(git rev-parse --short HEAD)}
+VERSION_FLAGS="-X github.com/cloudbase/garm-provider-azure/internal/util.Version=$VERSION -X github.com/cloudbase/garm-provider-azure/internal/util.Commit=$COMMIT"
+go build -mod vendor -o $BIN_DIR/garm-provider-azure -tags osusergo,netgo -ldflags "-linkmode external -extldflags '-static' -s -w $VERSION_FLAGS" .
 
 chown $USER_ID:$USER_GROUP -R "$BIN_DIR"